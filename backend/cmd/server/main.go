@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
+	"mime"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -14,12 +19,17 @@ import (
 
 	"github.com/epw80/chat-analytics-platform/pkg/analytics"
 	"github.com/epw80/chat-analytics-platform/pkg/auth"
+	"github.com/epw80/chat-analytics-platform/pkg/blob"
 	"github.com/epw80/chat-analytics-platform/pkg/client"
 	"github.com/epw80/chat-analytics-platform/pkg/config"
+	"github.com/epw80/chat-analytics-platform/pkg/history"
 	"github.com/epw80/chat-analytics-platform/pkg/hub"
+	"github.com/epw80/chat-analytics-platform/pkg/idempotency"
 	"github.com/epw80/chat-analytics-platform/pkg/message"
 	"github.com/epw80/chat-analytics-platform/pkg/persist"
 	"github.com/epw80/chat-analytics-platform/pkg/ratelimit"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+	"github.com/epw80/chat-analytics-platform/pkg/search"
 	"github.com/epw80/chat-analytics-platform/pkg/storage"
 	"github.com/gorilla/websocket"
 )
@@ -28,8 +38,25 @@ const (
 	// Number of recent messages replayed to a client when it joins a room.
 	defaultHistoryLimit = 50
 
-	// Upper bound on the number of messages a read API request may return.
+	// Upper bound on the number of messages a read API request may return,
+	// and on the messages replayed to a resuming client. It must stay within
+	// the client send buffer, which holds the replay until the write pump
+	// starts.
 	maxHistoryLimit = 200
+
+	// Upper bound on the size of a JSON request body.
+	maxRequestBody = 4096
+
+	// Allowance for the multipart framing around an upload's file, on top
+	// of the configured upload size limit.
+	maxUploadOverhead = 64 << 10
+
+	// Default and maximum number of search results per request.
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+
+	// Minimum gap between typing indicators relayed for a user in a room.
+	typingInterval = 2 * time.Second
 )
 
 // healthResponse is the JSON body returned by the health endpoint.
@@ -41,159 +68,1272 @@ type healthResponse struct {
 
 // messagesResponse is the JSON body returned by the message history endpoints.
 type messagesResponse struct {
-	RoomID   string             `json:"roomId,omitempty"`
-	UserID   string             `json:"userId,omitempty"`
+	RoomID         string             `json:"roomId,omitempty"`
+	UserID         string             `json:"userId,omitempty"`
+	ConversationID string             `json:"conversationId,omitempty"`
+	Parent         *message.Message   `json:"parent,omitempty"`
+	Count          int                `json:"count"`
+	Messages       []*message.Message `json:"messages"`
+	NextCursor     string             `json:"nextCursor,omitempty"`
+}
+
+// searchResponse is the JSON body returned by the search endpoint.
+type searchResponse struct {
+	Query   string          `json:"query"`
+	Count   int             `json:"count"`
+	Results []search.Result `json:"results"`
+}
+
+// roomResponse is a room directory entry enriched with live state: the number
+// of users connected now and the time of the room's latest message.
+type roomResponse struct {
+	*room.Room
+	MemberCount    int        `json:"memberCount"`
+	LastActivityAt *time.Time `json:"lastActivityAt,omitempty"`
+}
+
+// pinsResponse is the JSON body returned by the room pins endpoint.
+type pinsResponse struct {
+	RoomID string        `json:"roomId"`
+	Count  int           `json:"count"`
+	Pins   []pinResponse `json:"pins"`
+}
+
+// pinResponse is one pin with the pinned message as currently stored, if it
+// still is.
+type pinResponse struct {
+	room.Pin
+	Message *message.Message `json:"message,omitempty"`
+}
+
+// presenceResponse is the JSON body returned by the room presence endpoint.
+type presenceResponse struct {
+	RoomID  string         `json:"roomId"`
+	Count   int            `json:"count"`
+	Members []hub.Presence `json:"members"`
+}
+
+// mentionsResponse is the JSON body returned by the mention inbox endpoint.
+type mentionsResponse struct {
+	UserID   string             `json:"userId"`
+	Unread   int                `json:"unread"`
 	Count    int                `json:"count"`
-	Messages []*message.Message `json:"messages"`
+	Mentions []*storage.Mention `json:"mentions"`
+}
+
+// markMentionsReadRequest is the JSON body accepted by the mark-read
+// endpoint. No message IDs marks every mention read.
+type markMentionsReadRequest struct {
+	MessageIDs []string `json:"messageIds"`
+}
+
+// markMentionsReadResponse reports how many mentions were marked read and
+// how many remain unread.
+type markMentionsReadResponse struct {
+	Marked int `json:"marked"`
+	Unread int `json:"unread"`
+}
+
+// unreadResponse is the JSON body returned by the unread counts endpoint.
+type unreadResponse struct {
+	UserID string       `json:"userId"`
+	Total  int          `json:"total"`
+	Rooms  []roomUnread `json:"rooms"`
+}
+
+// roomUnread is one room's unread count, with the user's read marker there
+// if they have one.
+type roomUnread struct {
+	RoomID            string     `json:"roomId"`
+	Unread            int        `json:"unread"`
+	LastReadMessageID string     `json:"lastReadMessageId,omitempty"`
+	LastReadAt        *time.Time `json:"lastReadAt,omitempty"`
+}
+
+// roomsResponse is the JSON body returned by the room listing endpoint.
+type roomsResponse struct {
+	Count int            `json:"count"`
+	Rooms []roomResponse `json:"rooms"`
+}
+
+// createRoomRequest is the JSON body accepted by the room creation endpoint.
+type createRoomRequest struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Topic      string          `json:"topic"`
+	Visibility room.Visibility `json:"visibility"`
 }
 
 type Server struct {
 	hub       *hub.Hub
 	storage   storage.MessageRepository
+	rooms     storage.RoomRepository
+	mentions  storage.MentionRepository
+	markers   storage.ReadMarkerRepository
+	search    search.Index
+	history   *history.Buffer
+	dedupe    *idempotency.Cache
+	typing    *ratelimit.Throttle
 	persister *persist.Writer
+	blobs     blob.Store
 	analytics *analytics.Tracker
 	auth      *auth.Authenticator
 	upgrader  websocket.Upgrader
 	logger    *slog.Logger
 
 	allowedOrigins  []string
+	moderators      map[string]bool
 	rateLimitPerSec float64
 	rateLimitBurst  float64
+	uploadLimits    blob.Limits
 }
 
 func NewServer(logger *slog.Logger, repo storage.MessageRepository, cfg *config.Config) *Server {
 	tracker := analytics.New()
+	recent := history.New(maxHistoryLimit)
 	h := hub.New(logger)
 	h.SetAnalytics(tracker)
+	h.SetRecorder(recent)
 
 	s := &Server{
 		hub:             h,
 		storage:         repo,
+		history:         recent,
+		typing:          ratelimit.NewThrottle(typingInterval),
 		analytics:       tracker,
 		logger:          logger,
 		allowedOrigins:  cfg.AllowedOrigins,
+		moderators:      make(map[string]bool, len(cfg.Moderators)),
 		rateLimitPerSec: cfg.RateLimitPerSec,
 		rateLimitBurst:  cfg.RateLimitBurst,
+		uploadLimits:    blob.Limits{MaxSize: int64(cfg.UploadMaxBytes), AllowedTypes: cfg.UploadAllowedTypes},
+	}
+	for _, id := range cfg.Moderators {
+		s.moderators[id] = true
+	}
+
+	// Resent messages are recognized in memory, and across instances when
+	// the storage backend keeps a shared record of client message IDs.
+	var claims idempotency.Store
+	if store, ok := repo.(idempotency.Store); ok {
+		claims = store
+	}
+	s.dedupe = idempotency.New(cfg.IdempotencyWindow, claims)
+
+	// Persist via a bounded worker pool only when storage is available, and
+	// build the search index from what the pool writes.
+	if repo != nil {
+		s.persister = persist.New(repo, logger, persist.Config{
+			Workers:        cfg.PersistWorkers,
+			BatchSize:      cfg.PersistBatchSize,
+			QueueSize:      cfg.PersistQueueSize,
+			MaxRetries:     cfg.PersistMaxRetries,
+			RetryBaseDelay: cfg.PersistRetryBaseDelay,
+			RetryMaxDelay:  cfg.PersistRetryMaxDelay,
+		})
+		if cfg.PersistWALDir != "" {
+			wal, err := persist.OpenWAL(cfg.PersistWALDir, 0, logger)
+			if err != nil {
+				logger.Error("failed to open persistence WAL, continuing without it",
+					slog.String("dir", cfg.PersistWALDir),
+					slog.String("error", err.Error()))
+			} else {
+				s.persister.SetWAL(wal)
+			}
+		}
+
+		// Dead letters must be durable when the WAL acknowledges them, so
+		// they default to a file next to it.
+		dlqPath := cfg.PersistDeadLetterPath
+		if dlqPath == "" && cfg.PersistWALDir != "" {
+			dlqPath = filepath.Join(cfg.PersistWALDir, "dead-letters.jsonl")
+		}
+		if dlqPath != "" {
+			dlq, err := persist.OpenFileDeadLetterQueue(dlqPath)
+			if err != nil {
+				logger.Error("failed to open dead-letter queue, keeping dead letters in memory",
+					slog.String("path", dlqPath),
+					slog.String("error", err.Error()))
+			} else {
+				s.persister.SetDeadLetterQueue(dlq)
+			}
+		}
+
+		index := search.NewMemoryIndex()
+		s.persister.SetObserver(persist.ObserverFunc(func(msgs []*message.Message) {
+			index.Add(msgs...)
+		}))
+		s.search = index
+		s.storage = indexedRepository{MessageRepository: repo, index: index}
+	}
+
+	// Every storage backend keeps the room directory alongside messages.
+	if rooms, ok := repo.(storage.RoomRepository); ok {
+		s.rooms = rooms
+	}
+	if mentions, ok := repo.(storage.MentionRepository); ok {
+		s.mentions = mentions
+	}
+	if markers, ok := repo.(storage.ReadMarkerRepository); ok {
+		s.markers = markers
+	}
+
+	// Uploads are kept on the local filesystem, and disabled without a
+	// directory.
+	if cfg.UploadDir != "" {
+		blobs, err := blob.OpenLocalStore(cfg.UploadDir)
+		if err != nil {
+			logger.Error("failed to open upload directory, uploads disabled",
+				slog.String("dir", cfg.UploadDir),
+				slog.String("error", err.Error()))
+		} else {
+			s.blobs = blobs
+		}
+	}
+
+	// Enable token auth only when a secret is configured.
+	if cfg.AuthSecret != "" {
+		s.auth = auth.New(cfg.AuthSecret)
+	}
+
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     s.originAllowed,
+	}
+
+	return s
+}
+
+// indexedRepository keeps the search index in step with edits and deletes,
+// which update storage directly rather than through the persistence writer.
+type indexedRepository struct {
+	storage.MessageRepository
+	index search.Index
+}
+
+// EditMessage edits the stored message and re-indexes the new content.
+func (r indexedRepository) EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error) {
+	msg, err := r.MessageRepository.EditMessage(ctx, roomID, messageID, userID, content, editedAt)
+	if err == nil {
+		r.index.Add(msg)
+	}
+	return msg, err
+}
+
+// DeleteMessage tombstones the stored message and drops it from the index.
+func (r indexedRepository) DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error) {
+	msg, err := r.MessageRepository.DeleteMessage(ctx, roomID, messageID, userID, moderator, deletedAt)
+	if err == nil {
+		r.index.Add(msg)
+	}
+	return msg, err
+}
+
+// isModerator reports whether userID may delete other users' messages.
+func (s *Server) isModerator(userID string) bool {
+	return s.moderators[userID]
+}
+
+// canReadRoom reports whether userID may read roomID's messages. A direct
+// message conversation is readable by its two participants only, and a
+// private room by its creator and moderators only. Other rooms, including
+// ones not in the directory, are open to every authenticated user. Read paths
+// that span rooms, like search, go through this check.
+func (s *Server) canReadRoom(userID, roomID string) bool {
+	if userA, userB, ok := message.Participants(roomID); ok {
+		return userID == userA || userID == userB
+	}
+	if s.rooms == nil || s.isModerator(userID) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rm, err := s.rooms.GetRoom(ctx, roomID)
+	if errors.Is(err, storage.ErrRoomNotFound) {
+		return true
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch room for access check",
+			slog.String("roomID", roomID),
+			slog.String("error", err.Error()))
+		return false
+	}
+	return rm.VisibleTo(userID)
+}
+
+// originAllowed reports whether the request's Origin is permitted by the
+// configured allowlist. A "*" entry allows any origin.
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	for _, o := range s.allowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	// Storage is an optional dependency; report its reachability so the
+	// endpoint can be used as a readiness probe rather than a bare liveness ping.
+	storageStatus := "disabled"
+	if s.storage != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := s.storage.HealthCheck(ctx); err != nil {
+			storageStatus = "unavailable"
+		} else {
+			storageStatus = "ok"
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, healthResponse{
+		Status:  "ok",
+		Clients: s.hub.ClientCount(),
+		Storage: storageStatus,
+	})
+}
+
+// handleRoomMessages serves one page of message history for a room.
+func (s *Server) handleRoomMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.storage == nil {
+		http.Error(w, "message history is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	if roomID == "" {
+		http.Error(w, "room id is required", http.StatusBadRequest)
+		return
+	}
+	// Conversations are private; they are served by
+	// /api/conversations/{id}/messages to their participants.
+	if message.IsConversationID(roomID) {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	if !s.canReadRoom(userID, roomID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	q, err := parsePageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	page, err := s.storage.GetRoomMessagesPage(ctx, roomID, q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch room messages",
+			slog.String("roomID", roomID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, messagesResponse{
+		RoomID:     roomID,
+		Count:      len(page.Messages),
+		Messages:   page.Messages,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// handleThreadReplies serves one page of the replies to a message, with the
+// parent message itself so clients can show what the thread is about.
+func (s *Server) handleThreadReplies(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.storage == nil {
+		http.Error(w, "message history is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	roomID, messageID := r.PathValue("id"), r.PathValue("messageId")
+	if message.IsConversationID(roomID) {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return
+	}
+	if !s.canReadRoom(userID, roomID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	q, err := parsePageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	parent, err := s.storage.GetMessage(ctx, roomID, messageID)
+	if errors.Is(err, storage.ErrMessageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch thread parent",
+			slog.String("roomID", roomID),
+			slog.String("messageID", messageID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	page, err := s.storage.GetThreadPage(ctx, roomID, messageID, q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch thread replies",
+			slog.String("roomID", roomID),
+			slog.String("messageID", messageID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, messagesResponse{
+		RoomID:     roomID,
+		Parent:     parent,
+		Count:      len(page.Messages),
+		Messages:   page.Messages,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// handleUserMessages serves one page of message history for a single user,
+// leaving out messages in rooms the caller can't read.
+func (s *Server) handleUserMessages(w http.ResponseWriter, r *http.Request) {
+	callerID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.storage == nil {
+		http.Error(w, "message history is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	userID := r.PathValue("id")
+	if userID == "" {
+		http.Error(w, "user id is required", http.StatusBadRequest)
+		return
+	}
+
+	q, err := parsePageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	page, err := s.storage.GetUserMessagesPage(ctx, userID, q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch user messages",
+			slog.String("userID", userID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	// Direct messages are private to their conversation, and private rooms
+	// to their members. Dropping them can leave a page short, but the cursor
+	// still continues after it.
+	public := make([]*message.Message, 0, len(page.Messages))
+	for _, m := range page.Messages {
+		if !message.IsConversationID(m.RoomID) && s.canReadRoom(callerID, m.RoomID) {
+			public = append(public, m)
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, messagesResponse{
+		UserID:     userID,
+		Count:      len(public),
+		Messages:   public,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// handleConversationMessages serves one page of the direct messages between
+// two users. Only the participants may read a conversation.
+func (s *Server) handleConversationMessages(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.storage == nil {
+		http.Error(w, "message history is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	conversationID := r.PathValue("id")
+	if !message.IsConversationID(conversationID) {
+		http.Error(w, "conversation not found", http.StatusNotFound)
+		return
+	}
+	if !s.canReadRoom(userID, conversationID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	q, err := parsePageQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	page, err := s.storage.GetRoomMessagesPage(ctx, conversationID, q)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch conversation messages",
+			slog.String("conversationID", conversationID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, messagesResponse{
+		ConversationID: conversationID,
+		Count:          len(page.Messages),
+		Messages:       page.Messages,
+		NextCursor:     page.NextCursor,
+	})
+}
+
+// handleDeleteMessage tombstones a message and broadcasts the delete to the
+// room so live clients hide it. Only the author or a moderator may delete.
+func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.storage == nil {
+		http.Error(w, "message storage is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	roomID := r.PathValue("id")
+	messageID := r.PathValue("messageId")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	tomb, err := s.storage.DeleteMessage(ctx, roomID, messageID, userID, s.isModerator(userID), time.Now().UTC())
+	switch {
+	case errors.Is(err, storage.ErrMessageNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, storage.ErrNotAuthor):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		s.logger.Error("failed to delete message",
+			slog.String("roomID", roomID),
+			slog.String("messageID", messageID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to delete message", http.StatusInternalServerError)
+		return
+	}
+
+	if data, err := message.NewDeleteMessage(roomID, messageID, userID, requestUsername(r)).ToJSON(); err == nil {
+		// A direct message's delete goes to both users' connections, as the
+		// message did; nobody joins a conversation as a room.
+		if userA, userB, ok := message.Participants(roomID); ok {
+			s.hub.SendToUsers(data, userA, userB)
+		} else {
+			s.hub.Broadcast(roomID, data)
+		}
+	}
+
+	s.writeJSON(w, http.StatusOK, tomb)
+}
+
+// handleSearch serves full-text search over message history. The query in
+// "q" supports "quoted phrases" and prefix* words; optional "room" and "user"
+// parameters narrow the results, which only cover rooms the caller can read.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.search == nil {
+		http.Error(w, "search is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	q := search.Query{
+		Text:   query.Get("q"),
+		RoomID: query.Get("room"),
+		UserID: query.Get("user"),
+		Allow:  func(roomID string) bool { return s.canReadRoom(userID, roomID) },
+		Limit:  defaultSearchLimit,
+	}
+	if v := query.Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			q.Limit = min(n, maxSearchLimit)
+		}
+	}
+	if q.RoomID != "" && !s.canReadRoom(userID, q.RoomID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	results, err := s.search.Search(q)
+	if errors.Is(err, search.ErrEmptyQuery) {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.logger.Error("search failed",
+			slog.String("query", q.Text),
+			slog.String("error", err.Error()))
+		http.Error(w, "search failed", http.StatusInternalServerError)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, searchResponse{
+		Query:   q.Text,
+		Count:   len(results),
+		Results: results,
+	})
+}
+
+// handleListRooms serves the room directory. Private rooms are only listed
+// for their creator and moderators.
+func (s *Server) handleListRooms(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.rooms == nil {
+		http.Error(w, "room directory is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rooms, err := s.rooms.ListRooms(ctx)
+	if err != nil {
+		s.logger.Error("failed to list rooms", slog.String("error", err.Error()))
+		http.Error(w, "failed to list rooms", http.StatusInternalServerError)
+		return
+	}
+
+	resp := roomsResponse{Rooms: make([]roomResponse, 0, len(rooms))}
+	for _, rm := range rooms {
+		if rm.VisibleTo(userID) || s.isModerator(userID) {
+			resp.Rooms = append(resp.Rooms, s.roomResponse(ctx, rm))
+		}
+	}
+	resp.Count = len(resp.Rooms)
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetRoom serves a single room directory entry. A private room is
+// served to its creator and moderators only, since it carries the room's pins.
+func (s *Server) handleGetRoom(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.rooms == nil {
+		http.Error(w, "room directory is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rm, err := s.rooms.GetRoom(ctx, r.PathValue("id"))
+	if errors.Is(err, storage.ErrRoomNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch room",
+			slog.String("roomID", r.PathValue("id")),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch room", http.StatusInternalServerError)
+		return
+	}
+	if !rm.VisibleTo(userID) && !s.isModerator(userID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, s.roomResponse(ctx, rm))
+}
+
+// handleRoomPins lists a room's pinned messages, oldest pin first, each with
+// the message it pins.
+func (s *Server) handleRoomPins(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.rooms == nil {
+		http.Error(w, "room directory is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	roomID := r.PathValue("id")
+	if !s.canReadRoom(userID, roomID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	rm, err := s.rooms.GetRoom(ctx, roomID)
+	if errors.Is(err, storage.ErrRoomNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to fetch room",
+			slog.String("roomID", roomID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch room", http.StatusInternalServerError)
+		return
+	}
+
+	resp := pinsResponse{RoomID: roomID, Count: len(rm.Pins), Pins: make([]pinResponse, 0, len(rm.Pins))}
+	for _, pin := range rm.Pins {
+		entry := pinResponse{Pin: pin}
+		if s.storage != nil {
+			// A pinned message that has since expired is listed without it.
+			msg, err := s.storage.GetMessage(ctx, roomID, pin.MessageID)
+			if err != nil && !errors.Is(err, storage.ErrMessageNotFound) {
+				s.logger.Error("failed to fetch pinned message",
+					slog.String("roomID", roomID),
+					slog.String("messageID", pin.MessageID),
+					slog.String("error", err.Error()))
+				http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+				return
+			}
+			entry.Message = msg
+		}
+		resp.Pins = append(resp.Pins, entry)
+	}
+	s.writeJSON(w, http.StatusOK, resp)
+}
+
+// handleRoomPresence lists the users connected to a room right now, each
+// counted once however many connections they have open. Rooms don't have to
+// be in the directory.
+func (s *Server) handleRoomPresence(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	roomID := r.PathValue("id")
+	if !s.canReadRoom(userID, roomID) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	members := s.hub.RoomPresence(roomID)
+	s.writeJSON(w, http.StatusOK, presenceResponse{
+		RoomID:  roomID,
+		Count:   len(members),
+		Members: members,
+	})
+}
+
+// handleCreateRoom adds a room to the directory, owned by the caller.
+func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.rooms == nil {
+		http.Error(w, "room directory is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req createRoomRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	rm := &room.Room{
+		ID:         req.ID,
+		Name:       req.Name,
+		Topic:      req.Topic,
+		CreatedBy:  userID,
+		CreatedAt:  time.Now().UTC(),
+		Visibility: req.Visibility,
+	}
+	if rm.Visibility == "" {
+		rm.Visibility = room.VisibilityPublic
+	}
+	if err := rm.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	err := s.rooms.CreateRoom(ctx, rm)
+	if errors.Is(err, storage.ErrRoomExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to create room",
+			slog.String("roomID", rm.ID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to create room", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info("room created",
+		slog.String("roomID", rm.ID),
+		slog.String("createdBy", userID))
+	s.writeJSON(w, http.StatusCreated, s.roomResponse(ctx, rm))
+}
+
+// handleUpload stores the file in a multipart/form-data body's "file" field
+// as a blob owned by the caller, for messages to attach by ID.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.blobs == nil {
+		http.Error(w, "uploads are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, s.uploadLimits.MaxSize+maxUploadOverhead)
+	parts, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	for {
+		part, err := parts.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, "a file field is required", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		b, err := blob.Save(ctx, s.blobs, s.uploadLimits, part, part.FileName(), userID)
+		if err != nil {
+			s.uploadError(w, err)
+			return
+		}
+		s.logger.Info("blob uploaded",
+			slog.String("blobID", b.ID),
+			slog.String("contentType", b.ContentType),
+			slog.Int64("size", b.Size),
+			slog.String("uploadedBy", userID))
+		s.writeJSON(w, http.StatusCreated, b)
+		return
+	}
+}
+
+// uploadError reports why an upload was refused.
+func (s *Server) uploadError(w http.ResponseWriter, err error) {
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.Is(err, blob.ErrTooLarge), errors.As(err, &tooBig):
+		http.Error(w, blob.ErrTooLarge.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, blob.ErrUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, blob.ErrEmpty):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.logger.Error("failed to store upload", slog.String("error", err.Error()))
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+	}
+}
+
+// handleGetUpload serves an uploaded blob as a download to its uploader, or
+// to a user who may read a room it was attached in.
+func (s *Server) handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if s.blobs == nil {
+		http.Error(w, "uploads are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	b, contents, err := s.blobs.Open(r.Context(), r.PathValue("id"))
+	if errors.Is(err, blob.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to open upload",
+			slog.String("blobID", r.PathValue("id")),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch upload", http.StatusInternalServerError)
+		return
+	}
+	defer contents.Close()
+	if !s.canReadBlob(userID, b) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	disposition := "attachment"
+	if b.Filename != "" {
+		disposition = mime.FormatMediaType("attachment", map[string]string{"filename": b.Filename})
+	}
+	w.Header().Set("Content-Type", b.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(b.Size, 10))
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := io.Copy(w, contents); err != nil {
+		s.logger.Warn("failed to send upload",
+			slog.String("blobID", b.ID),
+			slog.String("error", err.Error()))
+	}
+}
+
+// canReadBlob reports whether userID may download b: they uploaded it, or
+// may read a room a message attaching it was sent to.
+func (s *Server) canReadBlob(userID string, b *blob.Blob) bool {
+	if b.UploadedBy == userID {
+		return true
+	}
+	for _, roomID := range b.RoomIDs {
+		if s.canReadRoom(userID, roomID) {
+			return true
+		}
+	}
+	return false
+}
+
+// deadLettersResponse lists dead-lettered messages.
+type deadLettersResponse struct {
+	Count       int                  `json:"count"`
+	DeadLetters []persist.DeadLetter `json:"deadLetters"`
+}
+
+// redriveRequest selects dead letters to re-drive; no IDs means all of them.
+type redriveRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// requireSelf authenticates a request for a user's own resources, writing the
+// error response and returning false unless the caller is the user named by
+// the path.
+func (s *Server) requireSelf(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	if userID != r.PathValue("id") {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return userID, true
+}
+
+// handleMentions serves a user's mention inbox, newest first, with the
+// mentioning messages attached. "unread=true" leaves out mentions already
+// read. Only the user themselves may read it.
+func (s *Server) handleMentions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireSelf(w, r)
+	if !ok {
+		return
+	}
+	if s.mentions == nil {
+		http.Error(w, "mentions are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	mentions, err := s.mentions.GetMentions(ctx, userID, parseLimit(r), unreadOnly)
+	if err != nil {
+		s.logger.Error("failed to fetch mentions",
+			slog.String("userID", userID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch mentions", http.StatusInternalServerError)
+		return
+	}
+	unread, err := s.mentions.CountUnreadMentions(ctx, userID)
+	if err != nil {
+		s.logger.Error("failed to count unread mentions",
+			slog.String("userID", userID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch mentions", http.StatusInternalServerError)
+		return
+	}
+
+	if mentions == nil {
+		mentions = []*storage.Mention{}
+	}
+	s.writeJSON(w, http.StatusOK, mentionsResponse{
+		UserID:   userID,
+		Unread:   unread,
+		Count:    len(mentions),
+		Mentions: mentions,
+	})
+}
+
+// handleMarkMentionsRead marks the mentions of the given messages read, or
+// all of a user's mentions when no IDs are given. Only the user themselves
+// may mark them.
+func (s *Server) handleMarkMentionsRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireSelf(w, r)
+	if !ok {
+		return
+	}
+	if s.mentions == nil {
+		http.Error(w, "mentions are unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req markMentionsReadRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	marked, err := s.mentions.MarkMentionsRead(ctx, userID, req.MessageIDs, time.Now().UTC())
+	if err != nil {
+		s.logger.Error("failed to mark mentions read",
+			slog.String("userID", userID),
+			slog.Int("marked", marked),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to mark mentions read", http.StatusInternalServerError)
+		return
+	}
+	unread, err := s.mentions.CountUnreadMentions(ctx, userID)
+	if err != nil {
+		s.logger.Error("failed to count unread mentions",
+			slog.String("userID", userID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to mark mentions read", http.StatusInternalServerError)
+		return
 	}
+	s.writeJSON(w, http.StatusOK, markMentionsReadResponse{Marked: marked, Unread: unread})
+}
 
-	// Persist via a bounded worker pool only when storage is available.
-	if repo != nil {
-		s.persister = persist.New(repo, logger, persist.Config{
-			Workers:   cfg.PersistWorkers,
-			BatchSize: cfg.PersistBatchSize,
-			QueueSize: cfg.PersistQueueSize,
-		})
+// handleUnread serves a user's unread message counts: one entry per room they
+// have a read marker in, plus any rooms named by "room" query parameters,
+// ordered by room ID. Only the user themselves may read them.
+func (s *Server) handleUnread(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.requireSelf(w, r)
+	if !ok {
+		return
 	}
-
-	// Enable token auth only when a secret is configured.
-	if cfg.AuthSecret != "" {
-		s.auth = auth.New(cfg.AuthSecret)
+	if s.markers == nil {
+		http.Error(w, "read markers are unavailable", http.StatusServiceUnavailable)
+		return
 	}
 
-	s.upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
-		CheckOrigin:     s.originAllowed,
-	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
 
-	return s
-}
+	markers, err := s.markers.GetReadMarkers(ctx, userID)
+	if err != nil {
+		s.logger.Error("failed to fetch read markers",
+			slog.String("userID", userID),
+			slog.String("error", err.Error()))
+		http.Error(w, "failed to fetch unread counts", http.StatusInternalServerError)
+		return
+	}
 
-// originAllowed reports whether the request's Origin is permitted by the
-// configured allowlist. A "*" entry allows any origin.
-func (s *Server) originAllowed(r *http.Request) bool {
-	origin := r.Header.Get("Origin")
-	for _, o := range s.allowedOrigins {
-		if o == "*" || o == origin {
-			return true
+	rooms := make(map[string]roomUnread, len(markers))
+	for _, m := range markers {
+		readAt := m.ReadAt
+		rooms[m.RoomID] = roomUnread{RoomID: m.RoomID, LastReadMessageID: m.MessageID, LastReadAt: &readAt}
+	}
+	for _, roomID := range r.URL.Query()["room"] {
+		if !s.canReadRoom(userID, roomID) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if _, ok := rooms[roomID]; !ok {
+			rooms[roomID] = roomUnread{RoomID: roomID}
 		}
 	}
-	return false
-}
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	// Storage is an optional dependency; report its reachability so the
-	// endpoint can be used as a readiness probe rather than a bare liveness ping.
-	storageStatus := "disabled"
-	if s.storage != nil {
-		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
-		defer cancel()
-		if err := s.storage.HealthCheck(ctx); err != nil {
-			storageStatus = "unavailable"
-		} else {
-			storageStatus = "ok"
+	resp := unreadResponse{UserID: userID, Rooms: make([]roomUnread, 0, len(rooms))}
+	for _, entry := range rooms {
+		entry.Unread, err = s.markers.CountUnread(ctx, userID, entry.RoomID)
+		if err != nil {
+			s.logger.Error("failed to count unread messages",
+				slog.String("userID", userID),
+				slog.String("roomID", entry.RoomID),
+				slog.String("error", err.Error()))
+			http.Error(w, "failed to fetch unread counts", http.StatusInternalServerError)
+			return
 		}
+		resp.Total += entry.Unread
+		resp.Rooms = append(resp.Rooms, entry)
 	}
+	sort.Slice(resp.Rooms, func(i, j int) bool { return resp.Rooms[i].RoomID < resp.Rooms[j].RoomID })
+	s.writeJSON(w, http.StatusOK, resp)
+}
 
-	s.writeJSON(w, http.StatusOK, healthResponse{
-		Status:  "ok",
-		Clients: s.hub.ClientCount(),
-		Storage: storageStatus,
-	})
+// redriveResponse reports how many dead letters were stored.
+type redriveResponse struct {
+	Redriven int `json:"redriven"`
 }
 
-// handleRoomMessages serves the recent message history for a room.
-func (s *Server) handleRoomMessages(w http.ResponseWriter, r *http.Request) {
-	if s.storage == nil {
-		http.Error(w, "message history is unavailable", http.StatusServiceUnavailable)
-		return
+// requireModerator authenticates an admin request, writing the error response
+// and returning false unless the caller is a moderator.
+func (s *Server) requireModerator(w http.ResponseWriter, r *http.Request) bool {
+	userID, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
 	}
+	if !s.isModerator(userID) {
+		http.Error(w, "moderator access required", http.StatusForbidden)
+		return false
+	}
+	return true
+}
 
-	roomID := r.PathValue("id")
-	if roomID == "" {
-		http.Error(w, "room id is required", http.StatusBadRequest)
+// handleListDeadLetters lists messages the persistence writer gave up
+// storing, oldest first. Moderators only.
+func (s *Server) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
+		return
+	}
+	if s.persister == nil {
+		http.Error(w, "message storage is unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
-	defer cancel()
-
-	msgs, err := s.storage.GetRecentMessages(ctx, roomID, parseLimit(r))
+	letters, err := s.persister.DeadLetters(parseLimit(r))
 	if err != nil {
-		s.logger.Error("failed to fetch room messages",
-			slog.String("roomID", roomID),
-			slog.String("error", err.Error()))
-		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		s.logger.Error("failed to list dead letters", slog.String("error", err.Error()))
+		http.Error(w, "failed to list dead letters", http.StatusInternalServerError)
 		return
 	}
-
-	s.writeJSON(w, http.StatusOK, messagesResponse{
-		RoomID:   roomID,
-		Count:    len(msgs),
-		Messages: msgs,
-	})
+	s.writeJSON(w, http.StatusOK, deadLettersResponse{Count: len(letters), DeadLetters: letters})
 }
 
-// handleUserMessages serves the message history for a single user.
-func (s *Server) handleUserMessages(w http.ResponseWriter, r *http.Request) {
-	if s.storage == nil {
-		http.Error(w, "message history is unavailable", http.StatusServiceUnavailable)
+// handleRedriveDeadLetters stores dead-lettered messages again, removing them
+// from the queue once stored. Moderators only.
+func (s *Server) handleRedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !s.requireModerator(w, r) {
 		return
 	}
-
-	userID := r.PathValue("id")
-	if userID == "" {
-		http.Error(w, "user id is required", http.StatusBadRequest)
+	if s.persister == nil {
+		http.Error(w, "message storage is unavailable", http.StatusServiceUnavailable)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	var req redriveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
 
-	msgs, err := s.storage.GetMessagesByUser(ctx, userID, parseLimit(r))
+	n, err := s.persister.Redrive(ctx, req.IDs...)
 	if err != nil {
-		s.logger.Error("failed to fetch user messages",
-			slog.String("userID", userID),
+		s.logger.Error("failed to re-drive dead letters",
+			slog.Int("redriven", n),
 			slog.String("error", err.Error()))
-		http.Error(w, "failed to fetch messages", http.StatusInternalServerError)
+		http.Error(w, "failed to re-drive dead letters", http.StatusBadGateway)
 		return
 	}
 
-	s.writeJSON(w, http.StatusOK, messagesResponse{
-		UserID:   userID,
-		Count:    len(msgs),
-		Messages: msgs,
-	})
+	s.logger.Info("dead letters re-driven", slog.Int("redriven", n))
+	s.writeJSON(w, http.StatusOK, redriveResponse{Redriven: n})
+}
+
+// roomResponse merges a room with its live member count from the hub and the
+// timestamp of its latest stored message. A storage error only omits the
+// last-activity time.
+func (s *Server) roomResponse(ctx context.Context, rm *room.Room) roomResponse {
+	resp := roomResponse{Room: rm, MemberCount: s.hub.RoomMemberCount(rm.ID)}
+
+	latest, err := s.storage.GetRecentMessages(ctx, rm.ID, 1)
+	if err != nil {
+		s.logger.Warn("failed to load room activity",
+			slog.String("roomID", rm.ID),
+			slog.String("error", err.Error()))
+		return resp
+	}
+	if len(latest) > 0 {
+		ts := latest[0].Timestamp
+		resp.LastActivityAt = &ts
+	}
+	return resp
 }
 
 // writeJSON encodes v as a JSON response with the given status code.
@@ -220,11 +1360,62 @@ func parseLimit(r *http.Request) int {
 	return limit
 }
 
+// parsePageQuery reads the pagination parameters: "limit", at most one of the
+// opaque "before" and "after" cursors, and an optional RFC3339 "since"/"until"
+// time range.
+func parsePageQuery(r *http.Request) (storage.PageQuery, error) {
+	query := r.URL.Query()
+	q := storage.PageQuery{
+		Limit:  parseLimit(r),
+		Before: query.Get("before"),
+		After:  query.Get("after"),
+	}
+	if q.Before != "" && q.After != "" {
+		return storage.PageQuery{}, errors.New("before and after are mutually exclusive")
+	}
+
+	var err error
+	if q.Since, err = parseTime(query.Get("since")); err != nil {
+		return storage.PageQuery{}, errors.New("since must be an RFC3339 timestamp")
+	}
+	if q.Until, err = parseTime(query.Get("until")); err != nil {
+		return storage.PageQuery{}, errors.New("until must be an RFC3339 timestamp")
+	}
+	if !q.Since.IsZero() && !q.Until.IsZero() && q.Since.After(q.Until) {
+		return storage.PageQuery{}, errors.New("since must not be after until")
+	}
+	return q, nil
+}
+
+// parseTime parses an optional RFC3339 timestamp; empty input yields the zero
+// time.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// requestUsername returns the display name a request gives in "username",
+// or "Anonymous".
+func requestUsername(r *http.Request) string {
+	if username := r.URL.Query().Get("username"); username != "" {
+		return username
+	}
+	return "Anonymous"
+}
+
 func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	username := r.URL.Query().Get("username")
+	username := requestUsername(r)
 	room := r.URL.Query().Get("room")
-	if username == "" {
-		username = "Anonymous"
+	if message.IsConversationID(room) {
+		http.Error(w, "room id is reserved for direct messages", http.StatusBadRequest)
+		return
+	}
+	resume, err := parseResumePoint(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Resolve the user identity. With auth enabled the userID comes from a
@@ -234,6 +1425,10 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if !s.canReadRoom(userID, room) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Upgrade connection
 	conn, err := s.upgrader.Upgrade(w, r, nil)
@@ -248,19 +1443,43 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	if s.persister != nil {
 		c.SetPersister(s.persister)
 	}
+	if s.storage != nil {
+		c.SetEditor(s.storage)
+		c.SetDeleter(s.storage)
+		c.SetReactor(s.storage)
+		c.SetFinder(s.storage)
+	}
+	if s.markers != nil {
+		c.SetReadTracker(s.markers)
+	}
+	if s.rooms != nil {
+		c.SetPinner(s.rooms)
+	}
+	if s.blobs != nil {
+		c.SetBlobFinder(s.blobs)
+	}
+	c.SetModerator(s.isModerator(userID))
+	c.SetDeduper(s.dedupe)
+	c.SetTypingThrottle(s.typing)
+	c.SetRoomAccess(roomAccess{s})
+	c.SetMentionResolver(mentionResolver{s})
 	if s.rateLimitPerSec > 0 {
 		c.SetRateLimiter(ratelimit.NewTokenBucket(s.rateLimitBurst, s.rateLimitPerSec))
 	}
 	c.SetAnalytics(s.analytics)
 	c.SetRoom(room) // empty room falls back to the client's default
 
-	// Replay recent room history to this client before it joins the live
-	// broadcast set, so the backlog is queued ahead of any live messages.
-	if s.storage != nil {
-		s.hydrateHistory(c)
-	}
+	// Replay what this client missed, or the recent room history on a fresh
+	// connect, before it joins the live broadcast set, so the backlog is
+	// queued ahead of any live messages. Frames broadcast since the backlog
+	// was taken are queued by the hub as the client joins.
+	catchUp := s.hydrateHistory(c, resume)
 
-	s.hub.Register(c)
+	s.hub.RegisterWith(c, func() {
+		for _, data := range catchUp() {
+			c.Send(data)
+		}
+	})
 	c.Start()
 
 	s.logger.Info("new websocket connection",
@@ -302,23 +1521,111 @@ func (s *Server) authenticate(r *http.Request) (string, bool) {
 	return userID, true
 }
 
-// hydrateHistory queues the recent message history for the client's room onto
-// the client's send buffer. The backlog stays within the send buffer size, so
-// it is queued before the write pump starts and replayed in order ahead of any
-// live messages. Storage errors degrade gracefully to an empty backlog.
-func (s *Server) hydrateHistory(c *client.Client) {
+// resumePoint is where a reconnecting client's view of its room ends: the
+// messageId of the last frame it received and/or that frame's timestamp. It is
+// zero on a fresh connect.
+type resumePoint struct {
+	lastMessageID string
+	since         time.Time
+}
+
+// parseResumePoint reads the optional "lastMessageId" and RFC3339 "since"
+// query parameters of a WebSocket connect.
+func parseResumePoint(r *http.Request) (resumePoint, error) {
+	query := r.URL.Query()
+	since, err := parseTime(query.Get("since"))
+	if err != nil {
+		return resumePoint{}, errors.New("since must be an RFC3339 timestamp")
+	}
+	return resumePoint{lastMessageID: query.Get("lastMessageId"), since: since}, nil
+}
+
+// hydrateHistory queues the client's backlog for its room onto its send
+// buffer, followed by the room's current pins. The backlog stays within the
+// send buffer size, so it is queued before the write pump starts and replayed
+// in order ahead of any live messages. It returns the backlog's catch-up, to
+// run as the client joins the room.
+func (s *Server) hydrateHistory(c *client.Client, rp resumePoint) func() [][]byte {
+	frames, catchUp := s.backlog(c.RoomID(), rp)
+	for _, data := range frames {
+		c.Send(data)
+	}
+	if data := s.pinsFrame(c.RoomID()); data != nil {
+		c.Send(data)
+	}
+	s.logger.Debug("hydrated client with room history",
+		slog.String("clientID", c.ID()),
+		slog.String("roomID", c.RoomID()),
+		slog.Int("count", len(frames)))
+	return catchUp
+}
+
+// backlog returns the frames a client joining roomID should be sent first:
+// for a resuming client exactly the frames it missed, from the in-memory
+// history buffer when it covers the gap and from storage otherwise; for a
+// fresh join the recent room history. Storage errors degrade gracefully to an
+// empty backlog.
+//
+// Frames broadcast after the backlog is taken but before the client joins
+// the room would reach it neither way, so backlog also returns a catch-up
+// that yields them. It must run on the hub goroutine just before the client
+// joins (see hub.RegisterWith), when no further frame can slip through.
+func (s *Server) backlog(roomID string, rp resumePoint) ([][]byte, func() [][]byte) {
+	mark := s.history.Mark(roomID)
+	frames := s.replayFrames(roomID, rp)
+	return frames, func() [][]byte { return s.catchUp(roomID, mark, frames) }
+}
+
+// catchUp returns the frames recorded for roomID since mark that sent does
+// not already hold.
+func (s *Server) catchUp(roomID string, mark uint64, sent [][]byte) [][]byte {
+	recent, ok := s.history.Recorded(roomID, mark)
+	if !ok {
+		s.logger.Warn("room history overflowed while a client joined, frames may be missing",
+			slog.String("roomID", roomID))
+	}
+	if len(recent) == 0 {
+		return nil
+	}
+
+	replayed := make(map[string]bool, len(sent))
+	for _, data := range sent {
+		if msg, err := message.FromJSON(data); err == nil && msg.MessageID != "" {
+			replayed[msg.MessageID] = true
+		}
+	}
+	var missed [][]byte
+	for _, data := range recent {
+		if msg, err := message.FromJSON(data); err == nil && replayed[msg.MessageID] {
+			continue
+		}
+		missed = append(missed, data)
+	}
+	return missed
+}
+
+// replayFrames returns the frames backlog replays, from the history buffer
+// or storage.
+func (s *Server) replayFrames(roomID string, rp resumePoint) [][]byte {
+	if frames, ok := s.bufferedHistory(roomID, rp); ok {
+		return frames
+	}
+	if s.storage == nil {
+		return nil
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	msgs, err := s.storage.GetRecentMessages(ctx, c.RoomID(), defaultHistoryLimit)
+	msgs, err := s.storedHistory(ctx, roomID, rp)
 	if err != nil {
 		s.logger.Error("failed to load room history",
-			slog.String("clientID", c.ID()),
-			slog.String("roomID", c.RoomID()),
+			slog.String("roomID", roomID),
 			slog.String("error", err.Error()))
-		return
+		return nil
 	}
 
+	frames := make([][]byte, 0, len(msgs))
 	for _, m := range msgs {
 		data, err := m.ToJSON()
 		if err != nil {
@@ -327,13 +1634,148 @@ func (s *Server) hydrateHistory(c *client.Client) {
 				slog.String("error", err.Error()))
 			continue
 		}
-		c.Send(data)
+		frames = append(frames, data)
 	}
+	return frames
+}
 
-	s.logger.Debug("hydrated client with room history",
-		slog.String("clientID", c.ID()),
-		slog.String("roomID", c.RoomID()),
-		slog.Int("count", len(msgs)))
+// pinsFrame returns the pins frame for roomID, or nil if the room is not in
+// the directory or has nothing pinned.
+func (s *Server) pinsFrame(roomID string) []byte {
+	if s.rooms == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rm, err := s.rooms.GetRoom(ctx, roomID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrRoomNotFound) {
+			s.logger.Error("failed to load room pins",
+				slog.String("roomID", roomID),
+				slog.String("error", err.Error()))
+		}
+		return nil
+	}
+	if len(rm.Pins) == 0 {
+		return nil
+	}
+
+	data, err := message.NewPinsMessage(roomID, rm.Pins).ToJSON()
+	if err != nil {
+		s.logger.Error("failed to marshal pins",
+			slog.String("roomID", roomID),
+			slog.String("error", err.Error()))
+		return nil
+	}
+	return data
+}
+
+// roomAccess lets clients subscribe to further rooms the server allows them
+// to read, replaying each room's backlog as on connect. Implements the
+// client.RoomAccess interface.
+type roomAccess struct {
+	s *Server
+}
+
+// CanReadRoom applies the same check as the room HTTP endpoints. Direct
+// message conversations cannot be subscribed to as rooms.
+func (a roomAccess) CanReadRoom(userID, roomID string) bool {
+	return !message.IsConversationID(roomID) && a.s.canReadRoom(userID, roomID)
+}
+
+// Backlog returns the frames to replay for a subscribe frame's resume point,
+// followed by the room's current pins as on connect, and their catch-up.
+func (a roomAccess) Backlog(roomID, lastMessageID string, since time.Time) ([][]byte, func() [][]byte) {
+	frames, catchUp := a.s.backlog(roomID, resumePoint{lastMessageID: lastMessageID, since: since})
+	if data := a.s.pinsFrame(roomID); data != nil {
+		frames = append(frames, data)
+	}
+	return frames, catchUp
+}
+
+// mentionResolver resolves @handles against the hub's room presence,
+// implementing the client.MentionResolver interface.
+type mentionResolver struct {
+	s *Server
+}
+
+// ResolveMentions maps each handle to the user connected to roomID under a
+// matching username, ignoring case, or else to the user ID it names if that
+// user has an authenticated connection open. Handles naming no known user, and
+// users who may not read the room, are dropped.
+func (m mentionResolver) ResolveMentions(roomID string, handles []string) []string {
+	members := m.s.hub.RoomPresence(roomID)
+	userIDs := make([]string, 0, len(handles))
+	for _, handle := range handles {
+		userID := ""
+		for _, p := range members {
+			if strings.EqualFold(p.Username, handle) {
+				userID = p.UserID
+				break
+			}
+		}
+		if userID == "" && m.s.hub.UserConnectionCount(handle) > 0 {
+			userID = handle
+		}
+		if userID != "" && m.s.canReadRoom(userID, roomID) {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// bufferedHistory returns the frames a resuming client missed if the history
+// buffer holds all of them.
+func (s *Server) bufferedHistory(roomID string, rp resumePoint) ([][]byte, bool) {
+	if rp.lastMessageID != "" {
+		if frames, ok := s.history.After(roomID, rp.lastMessageID); ok {
+			return frames, true
+		}
+	}
+	if !rp.since.IsZero() {
+		return s.history.Since(roomID, rp.since)
+	}
+	return nil, false
+}
+
+// storedHistory loads a client's backlog from storage: the messages after
+// rp.lastMessageID if it is among the newest maxHistoryLimit, else those
+// after rp.since, else the newest maxHistoryLimit for a resuming client (it
+// missed more than can be replayed) or defaultHistoryLimit for a fresh one.
+func (s *Server) storedHistory(ctx context.Context, roomID string, rp resumePoint) ([]*message.Message, error) {
+	if rp.lastMessageID == "" && rp.since.IsZero() {
+		return s.storage.GetRecentMessages(ctx, roomID, defaultHistoryLimit)
+	}
+
+	if rp.lastMessageID != "" {
+		recent, err := s.storage.GetRecentMessages(ctx, roomID, maxHistoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(recent) - 1; i >= 0; i-- {
+			if recent[i].MessageID == rp.lastMessageID {
+				return recent[i+1:], nil
+			}
+		}
+		if rp.since.IsZero() {
+			return recent, nil
+		}
+	}
+
+	page, err := s.storage.GetRoomMessagesPage(ctx, roomID, storage.PageQuery{Limit: maxHistoryLimit, Since: rp.since})
+	if err != nil {
+		return nil, err
+	}
+	// Since is inclusive; the client already has the message at that instant.
+	missed := make([]*message.Message, 0, len(page.Messages))
+	for _, m := range page.Messages {
+		if m.Timestamp.After(rp.since) {
+			missed = append(missed, m)
+		}
+	}
+	return missed, nil
 }
 
 func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
@@ -355,7 +1797,7 @@ func corsMiddleware(allowedOrigins []string, next http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Add("Vary", "Origin")
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
@@ -370,11 +1812,70 @@ func (s *Server) setupRoutes() http.Handler {
 	mux.HandleFunc("/health", s.handleHealth)
 	mux.HandleFunc("/ws", s.handleWebSocket)
 	mux.HandleFunc("/api/analytics", analytics.NewHandler(s.analytics))
+	mux.HandleFunc("GET /api/rooms", s.handleListRooms)
+	mux.HandleFunc("POST /api/rooms", s.handleCreateRoom)
+	mux.HandleFunc("GET /api/rooms/{id}", s.handleGetRoom)
 	mux.HandleFunc("GET /api/rooms/{id}/messages", s.handleRoomMessages)
+	mux.HandleFunc("GET /api/rooms/{id}/messages/{messageId}/replies", s.handleThreadReplies)
+	mux.HandleFunc("GET /api/rooms/{id}/presence", s.handleRoomPresence)
+	mux.HandleFunc("GET /api/rooms/{id}/pins", s.handleRoomPins)
 	mux.HandleFunc("GET /api/users/{id}/messages", s.handleUserMessages)
+	mux.HandleFunc("GET /api/users/{id}/mentions", s.handleMentions)
+	mux.HandleFunc("POST /api/users/{id}/mentions/read", s.handleMarkMentionsRead)
+	mux.HandleFunc("GET /api/users/{id}/unread", s.handleUnread)
+	mux.HandleFunc("GET /api/conversations/{id}/messages", s.handleConversationMessages)
+	mux.HandleFunc("DELETE /api/rooms/{id}/messages/{messageId}", s.handleDeleteMessage)
+	mux.HandleFunc("GET /api/search", s.handleSearch)
+	mux.HandleFunc("POST /api/uploads", s.handleUpload)
+	mux.HandleFunc("GET /api/uploads/{id}", s.handleGetUpload)
+	mux.HandleFunc("GET /api/admin/dead-letters", s.handleListDeadLetters)
+	mux.HandleFunc("POST /api/admin/dead-letters/redrive", s.handleRedriveDeadLetters)
 	return corsMiddleware(s.allowedOrigins, mux)
 }
 
+// openStorage builds the message repository selected by cfg.StorageDriver.
+// It returns nil when the backend cannot be initialized so the server runs
+// without persistence. repo is kept as the interface type and only assigned
+// on success, so a failed init leaves it as a true nil interface (avoiding the
+// typed-nil trap where a nil *DynamoDBRepository would still compare != nil).
+func openStorage(cfg *config.Config, logger *slog.Logger) storage.MessageRepository {
+	var repo storage.MessageRepository
+	switch cfg.StorageDriver {
+	case config.StorageMemory:
+		logger.Warn("using in-memory storage, messages will not survive a restart")
+		mem := storage.NewMemoryRepository(logger)
+		mem.SetRetention(storage.NewRetentionPolicy(cfg), storage.DefaultSweepInterval)
+		repo = mem
+	case config.StorageBolt:
+		db, err := storage.NewBoltRepository(cfg.BoltPath, logger)
+		if err != nil {
+			logger.Warn("bolt storage unavailable, running without persistence",
+				slog.String("path", cfg.BoltPath),
+				slog.String("error", err.Error()))
+		} else {
+			db.SetRetention(storage.NewRetentionPolicy(cfg), storage.DefaultSweepInterval)
+			repo = db
+		}
+	case config.StorageDynamoDB:
+		if cfg.DynamoDBEndpoint == "" && cfg.DynamoDBRegion == "" {
+			break
+		}
+		dbCtx, dbCancel := context.WithTimeout(context.Background(), 90*time.Second)
+		defer dbCancel()
+		db, err := storage.NewDynamoDBRepository(dbCtx, cfg, logger)
+		if err != nil {
+			logger.Warn("DynamoDB unavailable, running without persistence",
+				slog.String("error", err.Error()))
+		} else {
+			repo = db
+		}
+	default:
+		logger.Warn("unknown storage driver, running without persistence",
+			slog.String("driver", cfg.StorageDriver))
+	}
+	return repo
+}
+
 func main() {
 	// Load configuration
 	cfg := config.Load()
@@ -398,25 +1899,24 @@ func main() {
 		slog.String("port", cfg.Port),
 		slog.String("dynamodb_endpoint", cfg.DynamoDBEndpoint),
 		slog.String("dynamodb_region", cfg.DynamoDBRegion),
-		slog.String("log_level", cfg.LogLevel))
-
-	// Initialize DynamoDB storage (graceful degradation if unavailable).
-	// repo is kept as the interface type and only assigned on success, so a
-	// failed init leaves it as a true nil interface (avoiding the typed-nil
-	// trap where a nil *DynamoDBRepository would still compare != nil).
-	var repo storage.MessageRepository
-	if cfg.DynamoDBEndpoint != "" || cfg.DynamoDBRegion != "" {
-		dbCtx, dbCancel := context.WithTimeout(context.Background(), 90*time.Second)
-		defer dbCancel()
-		db, err := storage.NewDynamoDBRepository(dbCtx, cfg, logger)
+		slog.String("log_level", cfg.LogLevel),
+		slog.String("storage_driver", cfg.StorageDriver))
+
+	// "server migrate up|status" manages the DynamoDB schema and exits.
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+		err := runMigrate(ctx, cfg, logger, os.Args[2:], os.Stdout)
+		cancel()
 		if err != nil {
-			logger.Warn("DynamoDB unavailable, running without persistence",
-				slog.String("error", err.Error()))
-		} else {
-			repo = db
+			logger.Error("migration failed", slog.String("error", err.Error()))
+			os.Exit(1)
 		}
+		return
 	}
 
+	// Initialize storage (graceful degradation if unavailable).
+	repo := openStorage(cfg, logger)
+
 	// Create server
 	srv := NewServer(logger, repo, cfg)
 
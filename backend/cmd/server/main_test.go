@@ -1,17 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/epw80/chat-analytics-platform/pkg/blob"
 	"github.com/epw80/chat-analytics-platform/pkg/config"
 	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/persist"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+	"github.com/epw80/chat-analytics-platform/pkg/storage"
+	"github.com/gorilla/websocket"
 )
 
 // mockRepo implements storage.MessageRepository for handler tests.
@@ -21,11 +31,19 @@ type mockRepo struct {
 	recentErr error
 	userErr   error
 
-	lastRoomID   string
-	lastUserID   string
-	lastRoomLim  int
-	lastUserLim  int
-	healthCalled bool
+	nextCursor string
+	pageErr    error
+
+	lastRoomID    string
+	lastUserID    string
+	lastRoomLim   int
+	lastUserLim   int
+	lastRoomQuery storage.PageQuery
+	healthCalled  bool
+
+	// stored is returned by GetMessage when its ID matches
+	stored       *message.Message
+	lastParentID string
 }
 
 func (m *mockRepo) SaveMessage(ctx context.Context, msg *message.Message) error { return nil }
@@ -44,6 +62,54 @@ func (m *mockRepo) GetMessagesByUser(ctx context.Context, userID string, limit i
 	return m.byUser, m.userErr
 }
 
+func (m *mockRepo) GetRoomMessagesPage(ctx context.Context, roomID string, q storage.PageQuery) (*storage.Page, error) {
+	m.lastRoomID = roomID
+	m.lastRoomLim = q.Limit
+	m.lastRoomQuery = q
+	if m.recentErr != nil || m.pageErr != nil {
+		return nil, errors.Join(m.recentErr, m.pageErr)
+	}
+	return &storage.Page{Messages: m.recent, NextCursor: m.nextCursor}, nil
+}
+
+func (m *mockRepo) GetUserMessagesPage(ctx context.Context, userID string, q storage.PageQuery) (*storage.Page, error) {
+	m.lastUserID = userID
+	m.lastUserLim = q.Limit
+	if m.userErr != nil || m.pageErr != nil {
+		return nil, errors.Join(m.userErr, m.pageErr)
+	}
+	return &storage.Page{Messages: m.byUser, NextCursor: m.nextCursor}, nil
+}
+
+func (m *mockRepo) GetMessage(ctx context.Context, roomID, messageID string) (*message.Message, error) {
+	if m.stored == nil || m.stored.RoomID != roomID || m.stored.MessageID != messageID {
+		return nil, storage.ErrMessageNotFound
+	}
+	return m.stored, nil
+}
+
+func (m *mockRepo) GetThreadPage(ctx context.Context, roomID, parentMessageID string, q storage.PageQuery) (*storage.Page, error) {
+	m.lastRoomID = roomID
+	m.lastParentID = parentMessageID
+	m.lastRoomLim = q.Limit
+	if m.recentErr != nil || m.pageErr != nil {
+		return nil, errors.Join(m.recentErr, m.pageErr)
+	}
+	return &storage.Page{Messages: m.recent, NextCursor: m.nextCursor}, nil
+}
+
+func (m *mockRepo) EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error) {
+	return nil, storage.ErrMessageNotFound
+}
+
+func (m *mockRepo) DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error) {
+	return nil, storage.ErrMessageNotFound
+}
+
+func (m *mockRepo) ReactToMessage(ctx context.Context, roomID, messageID, userID, emoji string, remove bool) (*message.Message, error) {
+	return nil, storage.ErrMessageNotFound
+}
+
 func (m *mockRepo) HealthCheck(ctx context.Context) error {
 	m.healthCalled = true
 	return nil
@@ -110,6 +176,93 @@ func TestHandleUserMessages(t *testing.T) {
 	}
 }
 
+func TestHandleUserMessages_HidesDirectMessages(t *testing.T) {
+	repo := &mockRepo{byUser: []*message.Message{
+		{MessageID: "m1", UserID: "u1", RoomID: "lobby"},
+		{MessageID: "d1", UserID: "u1", RoomID: message.ConversationID("u1", "u2"), Type: message.TypeDirect},
+	}}
+	srv := testServer(repo)
+
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/users/u1/messages", nil))
+
+	var resp messagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Count != 1 || resp.Messages[0].MessageID != "m1" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestHandleConversationMessages(t *testing.T) {
+	conversation := message.ConversationID("alice", "bob")
+	repo := &mockRepo{recent: []*message.Message{{MessageID: "d1", RoomID: conversation, Type: message.TypeDirect}}}
+	srv := testServer(repo)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"participant", "/api/conversations/" + conversation + "/messages?userId=bob", http.StatusOK},
+		{"outsider", "/api/conversations/" + conversation + "/messages?userId=carol", http.StatusForbidden},
+		{"not a conversation", "/api/conversations/lobby/messages?userId=bob", http.StatusNotFound},
+		{"read as a room", "/api/rooms/" + conversation + "/messages", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			var resp messagesResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if resp.ConversationID != conversation || resp.Count != 1 || repo.lastRoomID != conversation {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+		})
+	}
+}
+
+func TestHandleThreadReplies(t *testing.T) {
+	parent := &message.Message{MessageID: "m1", RoomID: "lobby", Type: message.TypeChat, ReplyCount: 1}
+	repo := &mockRepo{
+		stored: parent,
+		recent: []*message.Message{{MessageID: "r1", RoomID: "lobby", Type: message.TypeChat, ParentMessageID: "m1"}},
+	}
+	srv := testServer(repo)
+
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/lobby/messages/m1/replies?limit=10", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var resp messagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Parent == nil || resp.Parent.ReplyCount != 1 || resp.Count != 1 || resp.Messages[0].MessageID != "r1" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if repo.lastRoomID != "lobby" || repo.lastParentID != "m1" || repo.lastRoomLim != 10 {
+		t.Errorf("thread queried as %s/%s limit %d", repo.lastRoomID, repo.lastParentID, repo.lastRoomLim)
+	}
+
+	// The parent must exist in the room named by the path.
+	rec = httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/other/messages/m1/replies", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status for another room = %d, want 404", rec.Code)
+	}
+}
+
 func TestHandleRoomMessages_LimitClamped(t *testing.T) {
 	repo := &mockRepo{}
 	srv := testServer(repo)
@@ -148,6 +301,678 @@ func TestHandleRoomMessages_NoStorage(t *testing.T) {
 	}
 }
 
+func TestHandleRoomMessages_Cursor(t *testing.T) {
+	repo := &mockRepo{
+		recent:     []*message.Message{{MessageID: "m1", RoomID: "lobby"}},
+		nextCursor: "older",
+	}
+	srv := testServer(repo)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/lobby/messages?before=abc&limit=1", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if repo.lastRoomQuery.Before != "abc" || repo.lastRoomQuery.After != "" {
+		t.Errorf("expected before cursor to be passed through, got %+v", repo.lastRoomQuery)
+	}
+	var resp messagesResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.NextCursor != "older" {
+		t.Errorf("expected nextCursor %q, got %q", "older", resp.NextCursor)
+	}
+}
+
+func TestHandleRoomMessages_BeforeAndAfter(t *testing.T) {
+	repo := &mockRepo{}
+	srv := testServer(repo)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/lobby/messages?before=a&after=b", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when both cursors are set, got %d", rec.Code)
+	}
+}
+
+func TestHandleUserMessages_InvalidCursor(t *testing.T) {
+	repo := &mockRepo{pageErr: storage.ErrInvalidCursor}
+	srv := testServer(repo)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/users/u1/messages?after=bogus", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid cursor, got %d", rec.Code)
+	}
+}
+
+func TestHandleMessages_BadCursor(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	routes := srv.setupRoutes()
+	repo.SaveMessage(context.Background(), &message.Message{MessageID: "m1", RoomID: "lobby", Type: message.TypeChat, UserID: "u1", Content: "hi", Timestamp: time.Now().UTC()})
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"RoomID":"lobby","MessageID":"m1","Timestamp":"yesterday"}`))
+	conversation := message.ConversationID("u1", "u2")
+	paths := []string{
+		"/api/rooms/lobby/messages",
+		"/api/rooms/lobby/messages/m1/replies",
+		"/api/users/u1/messages",
+		"/api/conversations/" + conversation + "/messages",
+	}
+	for _, path := range paths {
+		for _, cursor := range []string{"not-a-cursor!", forged} {
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path+"?userId=u1&before="+cursor, nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("%s with cursor %q: expected 400, got %d", path, cursor, rec.Code)
+			}
+		}
+	}
+}
+
+func TestHandleRoomMessages_TimeRange(t *testing.T) {
+	repo := &mockRepo{}
+	srv := testServer(repo)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet,
+		"/api/rooms/lobby/messages?since=2026-06-16T09:00:00Z&until=2026-06-16T11:00:00%2B02:00", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	wantSince := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	wantUntil := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC) // 11:00+02:00
+	if !repo.lastRoomQuery.Since.Equal(wantSince) || !repo.lastRoomQuery.Until.Equal(wantUntil) {
+		t.Errorf("unexpected range %v..%v", repo.lastRoomQuery.Since, repo.lastRoomQuery.Until)
+	}
+}
+
+func TestParsePageQuery_TimeRangeErrors(t *testing.T) {
+	cases := []string{
+		"since=yesterday",
+		"until=2026-13-01T00:00:00Z",
+		"since=2026-06-16T11:00:00Z&until=2026-06-16T09:00:00Z",
+	}
+	for _, query := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/x?"+query, nil)
+		if _, err := parsePageQuery(req); err == nil {
+			t.Errorf("parsePageQuery(%q) expected an error", query)
+		}
+	}
+}
+
+func TestHandleDeleteMessage(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := storage.NewMemoryRepository(logger)
+	ctx := context.Background()
+	for _, id := range []string{"m1", "m2"} {
+		msg := &message.Message{MessageID: id, RoomID: "lobby", UserID: "alice", Content: "hi", Timestamp: time.Now().UTC()}
+		if err := repo.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+	srv := NewServer(logger, repo, &config.Config{AllowedOrigins: []string{"*"}, Moderators: []string{"mod"}})
+	routes := srv.setupRoutes()
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"not author", "/api/rooms/lobby/messages/m1?userId=bob", http.StatusForbidden},
+		{"not found", "/api/rooms/lobby/messages/missing?userId=alice", http.StatusNotFound},
+		{"author", "/api/rooms/lobby/messages/m1?userId=alice", http.StatusOK},
+		{"moderator", "/api/rooms/lobby/messages/m2?userId=mod", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			routes.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d", tt.status, rec.Code)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var tomb message.Message
+			if err := json.NewDecoder(rec.Body).Decode(&tomb); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !tomb.Deleted || tomb.Content != "" {
+				t.Errorf("expected tombstone, got %+v", tomb)
+			}
+		})
+	}
+}
+
+func TestHandleDeleteMessage_DirectMessage(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	conversation := message.ConversationID("alice", "bob")
+	repo.SaveMessage(context.Background(), &message.Message{MessageID: "d1", RoomID: conversation, Type: message.TypeDirect,
+		UserID: "alice", RecipientID: "bob", Content: "psst", Timestamp: time.Now().UTC()})
+	ws := dialRoom(t, srv, "lobby", "")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/rooms/"+conversation+"/messages/d1?userId=alice&username=Alice", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	// Skip the join and backlog frames that arrive first.
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var got *message.Message
+	for got == nil || got.Type != message.TypeDelete {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("recipient did not receive the delete: %v", err)
+		}
+		if got, err = message.FromJSON(data); err != nil {
+			t.Fatalf("frame is not a message: %s", data)
+		}
+	}
+	if got.TargetID != "d1" || got.UserID != "alice" || got.Username != "Alice" {
+		t.Errorf("unexpected delete frame: %+v", got)
+	}
+}
+
+func TestHandleDeleteMessage_Unauthorized(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := NewServer(logger, &mockRepo{}, &config.Config{AllowedOrigins: []string{"*"}, AuthSecret: "secret"})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/rooms/lobby/messages/m1", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rec.Code)
+	}
+}
+
+func TestHandleDeleteMessage_NoStorage(t *testing.T) {
+	srv := testServer(nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/rooms/lobby/messages/m1", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	srv := testServer(&mockRepo{})
+	srv.search.Add(
+		&message.Message{MessageID: "m1", RoomID: "lobby", Type: message.TypeChat, UserID: "alice", Content: "release notes", Timestamp: time.Now()},
+		&message.Message{MessageID: "m2", RoomID: "ops", Type: message.TypeChat, UserID: "bob", Content: "release day", Timestamp: time.Now()},
+	)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/search?q=releas*&room=lobby", nil)
+	srv.setupRoutes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var resp searchResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Count != 1 || resp.Results[0].Message.MessageID != "m1" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if want := "<mark>release</mark> notes"; resp.Results[0].Snippet != want {
+		t.Errorf("snippet = %q, want %q", resp.Results[0].Snippet, want)
+	}
+}
+
+func TestHandleSearch_Errors(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	authed := NewServer(logger, &mockRepo{}, &config.Config{AllowedOrigins: []string{"*"}, AuthSecret: "secret"})
+
+	tests := []struct {
+		name   string
+		srv    *Server
+		path   string
+		status int
+	}{
+		{"missing query", testServer(&mockRepo{}), "/api/search?q=", http.StatusBadRequest},
+		{"unauthorized", authed, "/api/search?q=hello", http.StatusUnauthorized},
+		{"no storage", testServer(nil), "/api/search?q=hello", http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+// memoryServer returns a server backed by a fresh in-memory repository.
+func memoryServer(t *testing.T, cfg *config.Config) (*Server, *storage.MemoryRepository) {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := storage.NewMemoryRepository(logger)
+	t.Cleanup(func() { repo.Close() })
+	cfg.AllowedOrigins = []string{"*"}
+	return NewServer(logger, repo, cfg), repo
+}
+
+func TestHandleMentions(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	routes := srv.setupRoutes()
+	base := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	for i, id := range []string{"m1", "m2"} {
+		msg := &message.Message{MessageID: id, RoomID: "lobby", Type: message.TypeChat, UserID: "alice",
+			Content: "@bob " + id, Mentions: []string{"bob"}, Timestamp: base.Add(time.Duration(i) * time.Second)}
+		if err := repo.SaveMessage(context.Background(), msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := serve(http.MethodGet, "/api/users/bob/mentions?userId=alice", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("another user's inbox: expected 403, got %d", rec.Code)
+	}
+
+	rec := serve(http.MethodGet, "/api/users/bob/mentions?userId=bob", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var inbox mentionsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&inbox); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if inbox.Unread != 2 || inbox.Count != 2 || inbox.Mentions[0].MessageID != "m2" || inbox.Mentions[0].Message.Content != "@bob m2" {
+		t.Errorf("unexpected inbox: %+v", inbox)
+	}
+
+	rec = serve(http.MethodPost, "/api/users/bob/mentions/read?userId=bob", `{"messageIds":["m2"]}`)
+	var marked markMentionsReadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&marked); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("mark read: %d %v", rec.Code, err)
+	}
+	if marked.Marked != 1 || marked.Unread != 1 {
+		t.Errorf("unexpected mark result: %+v", marked)
+	}
+
+	rec = serve(http.MethodGet, "/api/users/bob/mentions?userId=bob&unread=true", "")
+	inbox = mentionsResponse{}
+	json.NewDecoder(rec.Body).Decode(&inbox)
+	if inbox.Count != 1 || inbox.Mentions[0].MessageID != "m1" || inbox.Mentions[0].ReadAt != nil {
+		t.Errorf("unexpected unread inbox: %+v", inbox)
+	}
+}
+
+func TestHandleUnread(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	routes := srv.setupRoutes()
+	ctx := context.Background()
+	base := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	for i, id := range []string{"m1", "m2", "m3"} {
+		repo.SaveMessage(ctx, &message.Message{MessageID: id, RoomID: "lobby", Type: message.TypeChat, UserID: "alice", Content: id, Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	repo.SaveMessage(ctx, &message.Message{MessageID: "g1", RoomID: "games", Type: message.TypeChat, UserID: "alice", Content: "gg", Timestamp: base})
+	if _, _, err := repo.SetReadMarker(ctx, "bob", "lobby", "m1", base.Add(time.Hour)); err != nil {
+		t.Fatalf("SetReadMarker: %v", err)
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := serve("/api/users/bob/unread?userId=alice"); rec.Code != http.StatusForbidden {
+		t.Errorf("another user's counts: expected 403, got %d", rec.Code)
+	}
+	if rec := serve("/api/users/bob/unread?userId=bob&room=" + message.ConversationID("alice", "carol")); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's conversation: expected 403, got %d", rec.Code)
+	}
+
+	rec := serve("/api/users/bob/unread?userId=bob&room=games")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp unreadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Total != 3 || len(resp.Rooms) != 2 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	games, lobby := resp.Rooms[0], resp.Rooms[1]
+	if games.RoomID != "games" || games.Unread != 1 || games.LastReadMessageID != "" {
+		t.Errorf("unexpected games entry: %+v", games)
+	}
+	if lobby.RoomID != "lobby" || lobby.Unread != 2 || lobby.LastReadMessageID != "m1" || lobby.LastReadAt == nil {
+		t.Errorf("unexpected lobby entry: %+v", lobby)
+	}
+}
+
+func TestHandleRooms(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	routes := srv.setupRoutes()
+	active := time.Date(2026, 6, 16, 9, 30, 0, 0, time.UTC)
+	repo.SaveMessage(context.Background(), &message.Message{MessageID: "m1", RoomID: "lobby", UserID: "alice", Content: "hi", Timestamp: active})
+
+	create := func(userID, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/rooms?userId="+userID, strings.NewReader(body))
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := create("alice", `{"id":"lobby","name":"Lobby","topic":"Say hi"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := create("alice", `{"id":"secret","name":"Secret","visibility":"private"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := create("bob", `{"id":"lobby","name":"Again"}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for duplicate room, got %d", rec.Code)
+	}
+	if rec := create("bob", `{"id":"bad id","name":"Bad"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid room, got %d", rec.Code)
+	}
+
+	list := func(userID string) roomsResponse {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms?userId="+userID, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+		var resp roomsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp
+	}
+	if resp := list("alice"); resp.Count != 2 {
+		t.Errorf("expected creator to see 2 rooms, got %d", resp.Count)
+	}
+	resp := list("bob")
+	if resp.Count != 1 || resp.Rooms[0].ID != "lobby" {
+		t.Fatalf("expected bob to see only the public room, got %+v", resp.Rooms)
+	}
+	lobby := resp.Rooms[0]
+	if lobby.CreatedBy != "alice" || lobby.Visibility != room.VisibilityPublic || lobby.MemberCount != 0 {
+		t.Errorf("unexpected lobby entry: %+v", lobby)
+	}
+	if lobby.LastActivityAt == nil || !lobby.LastActivityAt.Equal(active) {
+		t.Errorf("expected lastActivityAt %v, got %v", active, lobby.LastActivityAt)
+	}
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/secret?userId=bob", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for someone else's private room, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/secret?userId=alice", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the creator to fetch their private room, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestPrivateRoomAccess(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{Moderators: []string{"mod"}})
+	routes := srv.setupRoutes()
+	ctx := context.Background()
+	base := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	if err := repo.CreateRoom(ctx, &room.Room{ID: "secret", Name: "Secret", CreatedBy: "alice", CreatedAt: base, Visibility: room.VisibilityPrivate}); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	secret := &message.Message{MessageID: "s1", RoomID: "secret", Type: message.TypeChat, UserID: "alice", Content: "launch codes", Timestamp: base}
+	open := &message.Message{MessageID: "o1", RoomID: "lobby", Type: message.TypeChat, UserID: "alice", Content: "launch party", Timestamp: base.Add(time.Second)}
+	repo.BatchSaveMessages(ctx, []*message.Message{secret, open})
+	srv.search.Add(secret, open)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	for _, path := range []string{"/api/rooms/secret/messages", "/api/rooms/secret/messages/s1/replies", "/api/search?q=launch&room=secret"} {
+		if rec := serve(path + sep(path) + "userId=bob"); rec.Code != http.StatusForbidden {
+			t.Errorf("%s as a non-member: expected 403, got %d", path, rec.Code)
+		}
+		for _, userID := range []string{"alice", "mod"} {
+			if rec := serve(path + sep(path) + "userId=" + userID); rec.Code != http.StatusOK {
+				t.Errorf("%s as %s: expected 200, got %d", path, userID, rec.Code)
+			}
+		}
+	}
+
+	var user messagesResponse
+	json.NewDecoder(serve("/api/users/alice/messages?userId=bob").Body).Decode(&user)
+	if user.Count != 1 || user.Messages[0].MessageID != "o1" {
+		t.Errorf("expected bob to see only alice's public message, got %+v", user.Messages)
+	}
+	var found searchResponse
+	json.NewDecoder(serve("/api/search?q=launch&userId=bob").Body).Decode(&found)
+	if found.Count != 1 || found.Results[0].Message.MessageID != "o1" {
+		t.Errorf("expected bob's search to skip the private room, got %+v", found.Results)
+	}
+
+	ts := httptest.NewServer(routes)
+	defer ts.Close()
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?userId=bob&room=secret", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 joining someone else's private room, got %v", err)
+	}
+}
+
+// sep returns the separator that adds a query parameter to path.
+func sep(path string) string {
+	if strings.Contains(path, "?") {
+		return "&"
+	}
+	return "?"
+}
+
+func TestHandleRoomPins(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	routes := srv.setupRoutes()
+	ctx := context.Background()
+	base := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	if err := repo.CreateRoom(ctx, &room.Room{ID: "lobby", Name: "Lobby", CreatedBy: "alice", CreatedAt: base, Visibility: room.VisibilityPublic}); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	repo.SaveMessage(ctx, &message.Message{MessageID: "m1", RoomID: "lobby", Type: message.TypeChat, UserID: "alice", Content: "release at 5", Timestamp: base})
+	if _, _, err := repo.PinMessage(ctx, "lobby", "m1", "mod", base.Add(time.Minute)); err != nil {
+		t.Fatalf("PinMessage: %v", err)
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := serve("/api/rooms/" + message.ConversationID("alice", "carol") + "/pins?userId=bob"); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's conversation: expected 403, got %d", rec.Code)
+	}
+	if rec := serve("/api/rooms/nowhere/pins?userId=bob"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown room: expected 404, got %d", rec.Code)
+	}
+	if err := repo.CreateRoom(ctx, &room.Room{ID: "secret", Name: "Secret", CreatedBy: "alice", CreatedAt: base, Visibility: room.VisibilityPrivate}); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if rec := serve("/api/rooms/secret/pins?userId=bob"); rec.Code != http.StatusForbidden {
+		t.Errorf("someone else's private room: expected 403, got %d", rec.Code)
+	}
+	if rec := serve("/api/rooms/secret/pins?userId=alice"); rec.Code != http.StatusOK {
+		t.Errorf("own private room: expected 200, got %d", rec.Code)
+	}
+
+	rec := serve("/api/rooms/lobby/pins?userId=bob")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp pinsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.RoomID != "lobby" || resp.Count != 1 || len(resp.Pins) != 1 {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if pin := resp.Pins[0]; pin.MessageID != "m1" || pin.PinnedBy != "mod" || pin.Message == nil || pin.Message.Content != "release at 5" {
+		t.Errorf("unexpected pin: %+v", pin)
+	}
+}
+
+// uploadRequest builds a POST /api/uploads request carrying data in the
+// given form field.
+func uploadRequest(t *testing.T, userID, field string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(field, "notes.txt")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/uploads?userId="+userID, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestHandleUploads(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{
+		UploadDir:          t.TempDir(),
+		UploadMaxBytes:     64,
+		UploadAllowedTypes: []string{"text/plain"},
+	})
+	routes := srv.setupRoutes()
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(uploadRequest(t, "alice", "file", []byte("agenda: ship it")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var b blob.Blob
+	if err := json.NewDecoder(rec.Body).Decode(&b); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if b.ID == "" || b.Filename != "notes.txt" || b.Size != 15 || b.UploadedBy != "alice" || b.ContentType != "text/plain; charset=utf-8" {
+		t.Errorf("unexpected blob: %+v", b)
+	}
+
+	// Only the uploader may fetch a blob until it is attached in a room the
+	// caller can read.
+	download := func(userID string) *httptest.ResponseRecorder {
+		return serve(httptest.NewRequest(http.MethodGet, "/api/uploads/"+b.ID+"?userId="+userID, nil))
+	}
+	if rec := download("bob"); rec.Code != http.StatusForbidden {
+		t.Errorf("unattached download by another user = %d, want 403", rec.Code)
+	}
+	repo.CreateRoom(context.Background(), &room.Room{ID: "secret", Name: "Secret", CreatedBy: "alice", Visibility: room.VisibilityPrivate})
+	srv.blobs.Attach(context.Background(), b.ID, "secret")
+	if rec := download("bob"); rec.Code != http.StatusForbidden {
+		t.Errorf("download from a private room by a non-member = %d, want 403", rec.Code)
+	}
+	if rec := download("alice"); rec.Code != http.StatusOK {
+		t.Errorf("download by the uploader = %d, want 200", rec.Code)
+	}
+	srv.blobs.Attach(context.Background(), b.ID, "lobby")
+
+	rec = download("bob")
+	if rec.Code != http.StatusOK || rec.Body.String() != "agenda: ship it" {
+		t.Fatalf("download = %d %q", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Type") != b.ContentType || rec.Header().Get("X-Content-Type-Options") != "nosniff" ||
+		rec.Header().Get("Content-Disposition") != `attachment; filename=notes.txt` {
+		t.Errorf("unexpected download headers: %v", rec.Header())
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"too large", uploadRequest(t, "alice", "file", bytes.Repeat([]byte("a"), 65)), http.StatusRequestEntityTooLarge},
+		{"unsupported type", uploadRequest(t, "alice", "file", []byte("%PDF-1.7\n")), http.StatusUnsupportedMediaType},
+		{"empty", uploadRequest(t, "alice", "file", nil), http.StatusBadRequest},
+		{"no file field", uploadRequest(t, "alice", "attachment", []byte("hi")), http.StatusBadRequest},
+		{"not multipart", httptest.NewRequest(http.MethodPost, "/api/uploads", strings.NewReader("hi")), http.StatusBadRequest},
+		{"unknown blob", httptest.NewRequest(http.MethodGet, "/api/uploads/"+strings.Repeat("0", 36), nil), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(tt.req); rec.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestHandleUploads_Disabled(t *testing.T) {
+	srv, _ := memoryServer(t, &config.Config{})
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, uploadRequest(t, "alice", "file", []byte("hi")))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 without an upload directory, got %d", rec.Code)
+	}
+}
+
+func TestHandleRooms_ModeratorSeesPrivate(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{Moderators: []string{"mod"}})
+	repo.CreateRoom(context.Background(), &room.Room{ID: "secret", Name: "Secret", CreatedBy: "alice", Visibility: room.VisibilityPrivate})
+
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms?userId=mod", nil))
+
+	var resp roomsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Count != 1 {
+		t.Errorf("expected moderator to see the private room, got %d rooms", resp.Count)
+	}
+}
+
+func TestHandleRooms_NoStorage(t *testing.T) {
+	srv := testServer(nil)
+
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
+
 func TestParseLimit(t *testing.T) {
 	cases := []struct {
 		query string
@@ -167,3 +992,453 @@ func TestParseLimit(t *testing.T) {
 		}
 	}
 }
+
+func TestOpenStorage_Memory(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := openStorage(&config.Config{StorageDriver: config.StorageMemory}, logger)
+	if repo == nil {
+		t.Fatal("expected an in-memory repository")
+	}
+	if err := repo.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck: %v", err)
+	}
+}
+
+func TestOpenStorage_UnknownDriver(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if repo := openStorage(&config.Config{StorageDriver: "nope"}, logger); repo != nil {
+		t.Errorf("expected nil repository for an unknown driver, got %T", repo)
+	}
+}
+
+func TestOpenStorage_Bolt(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{StorageDriver: config.StorageBolt, BoltPath: t.TempDir() + "/chat.db"}
+	repo := openStorage(cfg, logger)
+	if repo == nil {
+		t.Fatal("expected a bolt repository")
+	}
+	defer repo.Close()
+	if err := repo.HealthCheck(context.Background()); err != nil {
+		t.Errorf("HealthCheck: %v", err)
+	}
+}
+
+func TestHandleDeadLetters(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{Moderators: []string{"mod"}})
+	dlq := persist.NewMemoryDeadLetterQueue()
+	for _, id := range []string{"a", "b"} {
+		dlq.Put(persist.DeadLetter{
+			ID:       "dl-" + id,
+			Message:  &message.Message{MessageID: id, RoomID: "lobby", UserID: "alice", Content: "hi", Timestamp: time.Now().UTC()},
+			Error:    "throttled",
+			Attempts: 4,
+		})
+	}
+	srv.persister.SetDeadLetterQueue(dlq)
+	routes := srv.setupRoutes()
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/api/admin/dead-letters?userId=alice", http.StatusForbidden},
+		{http.MethodPost, "/api/admin/dead-letters/redrive?userId=alice", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: expected %d, got %d", tt.method, tt.path, tt.status, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/dead-letters?userId=mod", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: expected 200, got %d", rec.Code)
+	}
+	var list deadLettersResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if list.Count != 2 || list.DeadLetters[0].ID != "dl-a" || list.DeadLetters[0].Error != "throttled" {
+		t.Errorf("unexpected listing: %+v", list)
+	}
+
+	// Re-drive one by ID, then the rest
+	for _, tt := range []struct {
+		body string
+		want int
+	}{
+		{`{"ids":["dl-b"]}`, 1},
+		{"", 1},
+	} {
+		rec = httptest.NewRecorder()
+		routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/admin/dead-letters/redrive?userId=mod", strings.NewReader(tt.body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("redrive %q: expected 200, got %d", tt.body, rec.Code)
+		}
+		var resp redriveResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.Redriven != tt.want {
+			t.Errorf("redrive %q: redriven %d, want %d", tt.body, resp.Redriven, tt.want)
+		}
+	}
+
+	msgs, err := repo.GetRecentMessages(context.Background(), "lobby", 10)
+	if err != nil {
+		t.Fatalf("GetRecentMessages: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Errorf("stored %d re-driven messages, want 2", len(msgs))
+	}
+	if left, _ := dlq.List(0); len(left) != 0 {
+		t.Errorf("%d dead letters left", len(left))
+	}
+}
+
+func TestHandleDeadLetters_NoStorage(t *testing.T) {
+	srv := testServer(nil)
+	srv.moderators["mod"] = true
+
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/dead-letters?userId=mod", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rec.Code)
+	}
+}
+
+// dialRoom connects a WebSocket client to srv's room with extra query
+// parameters and returns it. The server's hub runs for the test's duration.
+func dialRoom(t *testing.T, srv *Server, room, query string) *websocket.Conn {
+	t.Helper()
+	ts := httptest.NewServer(srv.setupRoutes())
+	t.Cleanup(ts.Close)
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws?userId=bob&room=" + room + query
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+// readMessageIDs reads frames from ws until none arrive for a short while and
+// returns their messageIds in order, skipping presence events.
+func readMessageIDs(t *testing.T, ws *websocket.Conn) []string {
+	t.Helper()
+	var ids []string
+	for {
+		ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return ids
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			msg, err := message.FromJSON([]byte(line))
+			if err != nil {
+				t.Fatalf("frame is not a message: %s", line)
+			}
+			if msg.Type != message.TypeJoin && msg.Type != message.TypeLeave {
+				ids = append(ids, msg.MessageID)
+			}
+		}
+	}
+}
+
+func TestWebSocket_ResumeFromBuffer(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	// Broadcast frames land in the history buffer; storage has none of them
+	for _, id := range []string{"b1", "b2", "b3"} {
+		data, _ := (&message.Message{MessageID: id, RoomID: "lobby", Type: message.TypeChat, Timestamp: time.Now().UTC()}).ToJSON()
+		srv.hub.Broadcast("lobby", data)
+	}
+	repo.SaveMessage(context.Background(), &message.Message{MessageID: "stored", RoomID: "lobby", Type: message.TypeChat, Timestamp: time.Now().UTC()})
+	time.Sleep(20 * time.Millisecond)
+
+	ws := dialRoom(t, srv, "lobby", "&lastMessageId=b1")
+	if got := readMessageIDs(t, ws); strings.Join(got, ",") != "b2,b3" {
+		t.Errorf("replayed %v, want [b2 b3]", got)
+	}
+}
+
+// hookedRepo runs onRecent whenever recent history is loaded.
+type hookedRepo struct {
+	*storage.MemoryRepository
+	onRecent func()
+}
+
+func (r hookedRepo) GetRecentMessages(ctx context.Context, roomID string, limit int) ([]*message.Message, error) {
+	msgs, err := r.MemoryRepository.GetRecentMessages(ctx, roomID, limit)
+	r.onRecent()
+	return msgs, err
+}
+
+func TestWebSocket_BroadcastWhileJoining(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := storage.NewMemoryRepository(logger)
+	defer repo.Close()
+	repo.SaveMessage(context.Background(), &message.Message{MessageID: "stored", RoomID: "lobby", Type: message.TypeChat, Timestamp: time.Now().UTC()})
+
+	// A frame is broadcast after the backlog is loaded, before the client
+	// is in the room
+	var srv *Server
+	hooked := hookedRepo{MemoryRepository: repo}
+	hooked.onRecent = func() {
+		mark := srv.history.Mark("lobby")
+		data, _ := (&message.Message{MessageID: "live", RoomID: "lobby", Type: message.TypeChat, Timestamp: time.Now().UTC()}).ToJSON()
+		srv.hub.Broadcast("lobby", data)
+		for srv.history.Mark("lobby") == mark {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	srv = NewServer(logger, hooked, &config.Config{AllowedOrigins: []string{"*"}})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	ws := dialRoom(t, srv, "lobby", "")
+	if got := readMessageIDs(t, ws); strings.Join(got, ",") != "stored,live" {
+		t.Errorf("received %v, want [stored live]", got)
+	}
+}
+
+func TestWebSocket_SubscribeReplaysBacklog(t *testing.T) {
+	srv, _ := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	for _, id := range []string{"g1", "g2"} {
+		data, _ := (&message.Message{MessageID: id, RoomID: "games", Type: message.TypeChat, Timestamp: time.Now().UTC()}).ToJSON()
+		srv.hub.Broadcast("games", data)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ws := dialRoom(t, srv, "lobby", "")
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","roomId":"games","lastMessageId":"g1"}`)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	live, _ := (&message.Message{MessageID: "g3", RoomID: "games", Type: message.TypeChat, Timestamp: time.Now().UTC()}).ToJSON()
+	srv.hub.Broadcast("games", live)
+
+	// The missed frame, the ack, then live frames from the new room
+	var got []string
+	for _, id := range readMessageIDs(t, ws) {
+		if id == "g2" || id == "g3" {
+			got = append(got, id)
+		} else {
+			got = append(got, "ack")
+		}
+	}
+	if strings.Join(got, ",") != "g2,ack,g3" {
+		t.Errorf("after subscribing got %v, want [g2 ack g3]", got)
+	}
+	if n := srv.hub.RoomMemberCount("games"); n != 1 {
+		t.Errorf("games has %d members, want 1", n)
+	}
+}
+
+func TestWebSocket_HydratesPins(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	ctx := context.Background()
+	repo.CreateRoom(ctx, &room.Room{ID: "lobby", Name: "Lobby", CreatedAt: time.Now().UTC(), Visibility: room.VisibilityPublic})
+	repo.SaveMessage(ctx, &message.Message{MessageID: "m1", RoomID: "lobby", Type: message.TypeChat, Content: "read me", Timestamp: time.Now().UTC()})
+	if _, _, err := repo.PinMessage(ctx, "lobby", "m1", "mod", time.Now().UTC()); err != nil {
+		t.Fatalf("PinMessage: %v", err)
+	}
+
+	// The history, then the room's pins.
+	ws := dialRoom(t, srv, "lobby", "")
+	var frames []*message.Message
+	for {
+		ws.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if msg, err := message.FromJSON([]byte(line)); err == nil && msg.Type != message.TypeJoin {
+				frames = append(frames, msg)
+			}
+		}
+	}
+	if len(frames) != 2 || frames[0].MessageID != "m1" {
+		t.Fatalf("expected the history then pins, got %+v", frames)
+	}
+	if pins := frames[1]; pins.Type != message.TypePins || pins.RoomID != "lobby" || len(pins.Pins) != 1 || pins.Pins[0].MessageID != "m1" {
+		t.Errorf("unexpected pins frame: %+v", pins)
+	}
+}
+
+func TestWebSocket_ResumeFromStorage(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	// Written before this server started, so only storage has them
+	base := time.Now().UTC().Add(-time.Hour)
+	for i, id := range []string{"s1", "s2", "s3", "s4"} {
+		repo.SaveMessage(context.Background(), &message.Message{
+			MessageID: id, RoomID: "lobby", Type: message.TypeChat, Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"last message id", "&lastMessageId=s2", "s3,s4"},
+		{"since", "&since=" + base.Add(time.Minute).Format(time.RFC3339Nano), "s3,s4"},
+		{"unknown id falls back to since", "&lastMessageId=gone&since=" + base.Add(2*time.Minute).Format(time.RFC3339Nano), "s4"},
+		{"up to date", "&lastMessageId=s4", ""},
+		{"fresh connect", "", "s1,s2,s3,s4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ws := dialRoom(t, srv, "lobby", tt.query)
+			if got := readMessageIDs(t, ws); strings.Join(got, ",") != tt.want {
+				t.Errorf("replayed %v, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebSocket_ResumeInvalidSince(t *testing.T) {
+	srv := testServer(nil)
+	req := httptest.NewRequest(http.MethodGet, "/ws?since=yesterday", nil)
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestWebSocket_ConversationIsNotARoom(t *testing.T) {
+	srv := testServer(nil)
+	req := httptest.NewRequest(http.MethodGet, "/ws?userId=bob&room="+message.ConversationID("alice", "bob"), nil)
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestWebSocket_EditJustSentMessage(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	srv.persister.Start()
+	defer srv.persister.Close()
+
+	ws := dialRoom(t, srv, "lobby", "")
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	// ackFor reads frames until the ack or nack for clientMessageID.
+	ackFor := func(clientMessageID string) *message.Ack {
+		t.Helper()
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatalf("no ack for %s: %v", clientMessageID, err)
+			}
+			for _, line := range strings.Split(string(data), "\n") {
+				var ack message.Ack
+				if json.Unmarshal([]byte(line), &ack) == nil && ack.ClientMessageID == clientMessageID &&
+					(ack.Type == message.TypeAck || ack.Type == message.TypeNack) {
+					return &ack
+				}
+			}
+		}
+	}
+
+	// The chat message waits in the persistence queue for the next flush
+	// when the edit and the reply arrive.
+	ws.WriteJSON(map[string]string{"type": "chat", "content": "helo", "clientMessageId": "c1"})
+	sent := ackFor("c1")
+	ws.WriteJSON(map[string]string{"type": "edit", "targetId": sent.MessageID, "content": "hello", "clientMessageId": "c2"})
+	if ack := ackFor("c2"); ack.Type != message.TypeAck {
+		t.Errorf("edit of a just-sent message: got %s %s", ack.Code, ack.Error)
+	}
+	ws.WriteJSON(map[string]string{"type": "chat", "parentMessageId": sent.MessageID, "content": "hi", "clientMessageId": "c3"})
+	if ack := ackFor("c3"); ack.Type != message.TypeAck {
+		t.Errorf("reply to a just-sent message: got %s %s", ack.Code, ack.Error)
+	}
+
+	stored, err := repo.GetMessage(context.Background(), "lobby", sent.MessageID)
+	if err != nil || stored.Content != "hello" {
+		t.Errorf("expected the stored message to be edited, got %+v, %v", stored, err)
+	}
+}
+
+func TestHandleRoomPresence(t *testing.T) {
+	srv := testServer(nil)
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+
+	// Two tabs for bob count once
+	dialRoom(t, srv, "lobby", "")
+	dialRoom(t, srv, "lobby", "")
+	time.Sleep(20 * time.Millisecond)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms/lobby/presence", nil)
+	rec := httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	var resp presenceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.RoomID != "lobby" || resp.Count != 1 || len(resp.Members) != 1 {
+		t.Fatalf("unexpected presence: %+v", resp)
+	}
+	if m := resp.Members[0]; m.UserID != "bob" || m.Connections != 2 {
+		t.Errorf("member = %+v, want bob with 2 connections", m)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/rooms/empty/presence", nil)
+	rec = httptest.NewRecorder()
+	srv.setupRoutes().ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), `"members":[]`) {
+		t.Errorf("empty room body = %s, want an empty members list", rec.Body)
+	}
+}
+
+func TestMentionResolver(t *testing.T) {
+	srv, repo := memoryServer(t, &config.Config{})
+	go srv.hub.Run()
+	defer srv.hub.Shutdown()
+	if err := repo.CreateRoom(context.Background(), &room.Room{ID: "secret", Name: "Secret", CreatedBy: "alice", Visibility: room.VisibilityPrivate}); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	// bob is in the lobby as "Bobby"; carol is connected elsewhere
+	dialRoom(t, srv, "lobby", "&username=Bobby")
+	ts := httptest.NewServer(srv.setupRoutes())
+	t.Cleanup(ts.Close)
+	carol, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws?userId=carol&room=games", nil)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	t.Cleanup(func() { carol.Close() })
+	time.Sleep(20 * time.Millisecond)
+
+	resolver := mentionResolver{srv}
+	if got := resolver.ResolveMentions("lobby", []string{"BOBBY", "carol", "ghost"}); strings.Join(got, ",") != "bob,carol" {
+		t.Errorf("ResolveMentions(lobby) = %v, want [bob carol]", got)
+	}
+	if got := resolver.ResolveMentions("secret", []string{"carol", "alice"}); len(got) != 0 {
+		t.Errorf("ResolveMentions(secret) = %v, want none", got)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"text/tabwriter"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/config"
+	"github.com/epw80/chat-analytics-platform/pkg/storage"
+)
+
+const migrateUsage = "usage: server migrate up|status"
+
+// runMigrate implements the "migrate" subcommand against the configured
+// DynamoDB endpoint: "up" applies pending schema migrations and "status"
+// lists every migration and whether it has been applied.
+func runMigrate(ctx context.Context, cfg *config.Config, logger *slog.Logger, args []string, out io.Writer) error {
+	if len(args) != 1 || (args[0] != "up" && args[0] != "status") {
+		return errors.New(migrateUsage)
+	}
+	if cfg.StorageDriver != config.StorageDynamoDB {
+		return fmt.Errorf("migrations only apply to the %q storage driver, not %q",
+			config.StorageDynamoDB, cfg.StorageDriver)
+	}
+
+	client, err := storage.NewDynamoDBClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	migrator := storage.NewMigrator(client, logger)
+
+	if args[0] == "up" {
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "applied %d migration(s)\n", applied)
+		return nil
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return printMigrationStatus(out, status)
+}
+
+// printMigrationStatus writes one row per migration.
+func printMigrationStatus(out io.Writer, status []storage.MigrationStatus) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, applied, s.Description)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/config"
+	"github.com/epw80/chat-analytics-platform/pkg/storage"
+)
+
+func TestRunMigrateRejectsBadArgs(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{StorageDriver: config.StorageDynamoDB}
+
+	for _, args := range [][]string{nil, {"down"}, {"up", "extra"}} {
+		if err := runMigrate(context.Background(), cfg, logger, args, io.Discard); err == nil {
+			t.Errorf("runMigrate(%q) succeeded, want usage error", args)
+		}
+	}
+
+	cfg.StorageDriver = config.StorageMemory
+	if err := runMigrate(context.Background(), cfg, logger, []string{"up"}, io.Discard); err == nil {
+		t.Error("runMigrate succeeded for the memory driver")
+	}
+}
+
+func TestPrintMigrationStatus(t *testing.T) {
+	applied := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var out bytes.Buffer
+	err := printMigrationStatus(&out, []storage.MigrationStatus{
+		{Version: 1, Description: "create messages table", AppliedAt: &applied},
+		{Version: 2, Description: "enable TTL"},
+	})
+	if err != nil {
+		t.Fatalf("printMigrationStatus: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), out.String())
+	}
+	if !strings.Contains(lines[1], "2024-05-01T12:00:00Z") {
+		t.Errorf("applied row = %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "pending") {
+		t.Errorf("pending row = %q", lines[2])
+	}
+}
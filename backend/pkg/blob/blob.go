@@ -0,0 +1,133 @@
+// Package blob stores uploaded files, such as message attachments, behind a
+// pluggable Store.
+package blob
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotFound        = errors.New("blob not found")
+	ErrTooLarge        = errors.New("blob exceeds maximum size")
+	ErrUnsupportedType = errors.New("blob content type is not allowed")
+	ErrEmpty           = errors.New("blob is empty")
+)
+
+// Blob describes a stored upload.
+type Blob struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename,omitempty"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	UploadedBy  string    `json:"uploadedBy"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+
+	// RoomIDs are the rooms, including direct message conversations, that
+	// messages attaching the blob were sent to.
+	RoomIDs []string `json:"roomIds,omitempty"`
+}
+
+// AttachedTo reports whether a message in roomID attaches the blob.
+func (b *Blob) AttachedTo(roomID string) bool {
+	return slices.Contains(b.RoomIDs, roomID)
+}
+
+// Store keeps blob contents with their metadata.
+type Store interface {
+	// Put stores the contents read from r under b.ID. b.Size is set to the
+	// number of bytes stored. A read error from r is returned and nothing is
+	// stored.
+	Put(ctx context.Context, b *Blob, r io.Reader) error
+
+	// Stat returns a blob's metadata, or ErrNotFound.
+	Stat(ctx context.Context, id string) (*Blob, error)
+
+	// Open returns a blob's metadata and a reader for its contents, which the
+	// caller must close. Returns ErrNotFound if absent.
+	Open(ctx context.Context, id string) (*Blob, io.ReadCloser, error)
+
+	// Attach records that a message in roomID attaches the blob, adding
+	// roomID to its RoomIDs if absent. Returns ErrNotFound if absent.
+	Attach(ctx context.Context, id, roomID string) error
+}
+
+// Limits bound what Save accepts. Content types are sniffed from the data
+// rather than taken from the uploader.
+type Limits struct {
+	MaxSize      int64
+	AllowedTypes []string
+}
+
+// Allows reports whether a content type, ignoring parameters such as
+// charset, is in AllowedTypes.
+func (l Limits) Allows(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && slices.Contains(l.AllowedTypes, mediaType)
+}
+
+// ValidID reports whether id could have been assigned by Save. Stores use it
+// to refuse IDs that would escape their namespace.
+func ValidID(id string) bool {
+	parsed, err := uuid.Parse(id)
+	return err == nil && parsed.String() == id
+}
+
+// Save reads an upload from r, checks it against limits and stores it under a
+// new ID on behalf of uploadedBy. Returns ErrEmpty, ErrTooLarge or
+// ErrUnsupportedType if the upload is refused.
+func Save(ctx context.Context, store Store, limits Limits, r io.Reader, filename, uploadedBy string) (*Blob, error) {
+	// http.DetectContentType considers at most the first 512 bytes.
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if len(head) == 0 {
+		return nil, ErrEmpty
+	}
+	contentType := http.DetectContentType(head)
+	if !limits.Allows(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	b := &Blob{
+		ID:          uuid.New().String(),
+		Filename:    filename,
+		ContentType: contentType,
+		UploadedBy:  uploadedBy,
+		UploadedAt:  time.Now().UTC(),
+	}
+	if err := store.Put(ctx, b, &limitedReader{r: br, remaining: limits.MaxSize}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// limitedReader fails with ErrTooLarge once more than remaining bytes have
+// been read, unlike io.LimitedReader, which stops silently.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		return 0, ErrTooLarge
+	}
+	l.remaining -= int64(n)
+	return n, err
+}
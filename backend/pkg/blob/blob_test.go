@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testLimits = Limits{MaxSize: 1024, AllowedTypes: []string{"text/plain", "image/png"}}
+
+func TestSave(t *testing.T) {
+	store, err := OpenLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLocalStore: %v", err)
+	}
+	ctx := context.Background()
+
+	b, err := Save(ctx, store, testLimits, strings.NewReader("release notes"), "notes.txt", "alice")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if !ValidID(b.ID) || b.Size != 13 || b.ContentType != "text/plain; charset=utf-8" ||
+		b.Filename != "notes.txt" || b.UploadedBy != "alice" || b.UploadedAt.IsZero() {
+		t.Errorf("unexpected blob: %+v", b)
+	}
+
+	got, rc, err := store.Open(ctx, b.ID)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer rc.Close()
+	data, _ := io.ReadAll(rc)
+	if string(data) != "release notes" || !reflect.DeepEqual(got, b) {
+		t.Errorf("Open = %+v %q, want %+v %q", got, data, b, "release notes")
+	}
+}
+
+func TestSave_Limits(t *testing.T) {
+	store, err := OpenLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLocalStore: %v", err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, ErrEmpty},
+		{"too large", bytes.Repeat([]byte("a"), int(testLimits.MaxSize)+1), ErrTooLarge},
+		{"at the limit", bytes.Repeat([]byte("a"), int(testLimits.MaxSize)), nil},
+		{"unsupported type", []byte("%PDF-1.7\n"), ErrUnsupportedType},
+		{"png", []byte("\x89PNG\r\n\x1a\n...."), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Save(ctx, store, testLimits, bytes.NewReader(tt.data), "", "alice")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Save() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"6ba7b810-9dad-11d1-80b4-00c04fd430c8": true,
+		"6BA7B810-9DAD-11D1-80B4-00C04FD430C8": false,
+		"../etc/passwd":                        false,
+		"":                                     false,
+	} {
+		if got := ValidID(id); got != want {
+			t.Errorf("ValidID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
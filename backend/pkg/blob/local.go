@@ -0,0 +1,147 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LocalStore is a Store in a directory on the local filesystem. Each blob is
+// kept as a file named by its ID, with its metadata beside it in ID.json.
+// Both are written to temporary files and renamed into place, so a blob is
+// either fully stored or absent. It is safe for concurrent use.
+type LocalStore struct {
+	dir string
+
+	mu sync.Mutex // serializes Attach's metadata rewrites
+}
+
+// OpenLocalStore opens or creates the store directory.
+func OpenLocalStore(dir string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &LocalStore{dir: dir}, nil
+}
+
+// Put writes the blob's contents, then its metadata.
+func (s *LocalStore) Put(ctx context.Context, b *Blob, r io.Reader) error {
+	if !ValidID(b.ID) {
+		return fmt.Errorf("invalid blob id %q", b.ID)
+	}
+
+	size, err := s.writeFile(s.dataPath(b.ID), r)
+	if err != nil {
+		return err
+	}
+	b.Size = size
+
+	if err := s.writeMeta(b); err != nil {
+		os.Remove(s.dataPath(b.ID))
+		return err
+	}
+	return nil
+}
+
+// Attach rewrites the blob's metadata with roomID added.
+func (s *LocalStore) Attach(ctx context.Context, id, roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.Stat(ctx, id)
+	if err != nil {
+		return err
+	}
+	if b.AttachedTo(roomID) {
+		return nil
+	}
+	b.RoomIDs = append(b.RoomIDs, roomID)
+	return s.writeMeta(b)
+}
+
+// writeMeta replaces the blob's metadata file.
+func (s *LocalStore) writeMeta(b *Blob) error {
+	meta, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("failed to marshal blob metadata: %w", err)
+	}
+	_, err = s.writeFile(s.metaPath(b.ID), bytes.NewReader(meta))
+	return err
+}
+
+// Stat reads a blob's metadata.
+func (s *LocalStore) Stat(ctx context.Context, id string) (*Blob, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.metaPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob metadata: %w", err)
+	}
+
+	var b Blob
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal blob metadata: %w", err)
+	}
+	return &b, nil
+}
+
+// Open reads a blob's metadata and opens its contents.
+func (s *LocalStore) Open(ctx context.Context, id string) (*Blob, io.ReadCloser, error) {
+	b, err := s.Stat(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(s.dataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return b, f, nil
+}
+
+func (s *LocalStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id)
+}
+
+func (s *LocalStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// writeFile copies r to a temporary file in the store directory, syncs it and
+// renames it to path, returning the number of bytes written. The temporary
+// file is removed on failure.
+func (s *LocalStore) writeFile(path string, r io.Reader) (int64, error) {
+	f, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create blob file: %w", err)
+	}
+	tmp := f.Name()
+
+	n, err := io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write blob: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,65 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenLocalStore(dir)
+	if err != nil {
+		t.Fatalf("OpenLocalStore: %v", err)
+	}
+	ctx := context.Background()
+	id := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	if _, err := store.Stat(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := store.Stat(ctx, "../"+id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a path, got %v", err)
+	}
+
+	b := &Blob{ID: id, ContentType: "text/plain", UploadedBy: "alice", UploadedAt: time.Now().UTC()}
+	if err := store.Put(ctx, b, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if b.Size != 5 {
+		t.Errorf("Put set size %d, want 5", b.Size)
+	}
+	got, err := store.Stat(ctx, id)
+	if err != nil || got.Size != 5 || got.UploadedBy != "alice" {
+		t.Errorf("Stat = %+v, %v", got, err)
+	}
+
+	// A failed write leaves nothing behind.
+	failed := &Blob{ID: "7ba7b810-9dad-11d1-80b4-00c04fd430c8"}
+	if err := store.Put(ctx, failed, &limitedReader{r: strings.NewReader("too long"), remaining: 3}); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("expected only the first blob and its metadata, got %d entries", len(entries))
+	}
+	if err := store.Put(ctx, &Blob{ID: "../escape"}, strings.NewReader("x")); err == nil {
+		t.Error("expected an invalid ID to be refused")
+	}
+
+	// Attaching records each room once.
+	for _, room := range []string{"lobby", "games", "lobby"} {
+		if err := store.Attach(ctx, id, room); err != nil {
+			t.Fatalf("Attach(%s): %v", room, err)
+		}
+	}
+	if got, _ := store.Stat(ctx, id); strings.Join(got.RoomIDs, ",") != "lobby,games" || got.Size != 5 {
+		t.Errorf("after Attach, Stat = %+v", got)
+	}
+	if err := store.Attach(ctx, failed.ID, "lobby"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound attaching a missing blob, got %v", err)
+	}
+}
@@ -1,11 +1,18 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"slices"
 	"time"
 
 	"github.com/epw80/chat-analytics-platform/pkg/analytics"
+	"github.com/epw80/chat-analytics-platform/pkg/blob"
 	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+	"github.com/epw80/chat-analytics-platform/pkg/storage"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
@@ -28,18 +35,110 @@ const (
 
 	// Room a client joins when none is specified on connect
 	defaultRoomID = "global"
+
+	// Time allowed for the store to apply a message edit, delete or
+	// reaction, to check an idempotency key or to look up a reply's parent
+	editTimeout = 5 * time.Second
+
+	// How long a typing indicator stays up unless the user keeps typing
+	typingTTL = 5 * time.Second
+
+	// Maximum number of rooms one connection may be subscribed to
+	maxSubscriptions = 50
 )
 
 // Hub interface to avoid circular dependencies
 type Hub interface {
 	Register(any)
 	Unregister(any)
+	SubscribeWith(client any, roomID string, onJoin func())
+	Unsubscribe(client any, roomID string)
 	Broadcast(roomID string, data []byte)
+	SendToUsers(data []byte, userIDs ...string)
 }
 
 // Persister accepts messages for asynchronous, non-blocking persistence.
+// Enqueue reports whether the message was accepted; a rejected message is
+// neither stored nor broadcast.
 type Persister interface {
-	Enqueue(msg *message.Message)
+	Enqueue(msg *message.Message) bool
+}
+
+// PendingWaiter is implemented by a Persister that can wait for a message it
+// accepted to be stored. Await reports whether the message was pending and
+// has since been stored or given up on, so a lookup that missed it is worth
+// repeating.
+type PendingWaiter interface {
+	Await(ctx context.Context, roomID, messageID string) bool
+}
+
+// Editor applies author-checked edits to stored messages.
+type Editor interface {
+	EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error)
+}
+
+// Deleter replaces stored messages with tombstones. Only the author may
+// delete a message unless moderator is set.
+type Deleter interface {
+	DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error)
+}
+
+// Reactor applies emoji reactions to stored messages.
+type Reactor interface {
+	ReactToMessage(ctx context.Context, roomID, messageID, userID, emoji string, remove bool) (*message.Message, error)
+}
+
+// ReadTracker records how far users have read in each room. SetReadMarker
+// reports whether the marker moved; markers only move forward.
+type ReadTracker interface {
+	SetReadMarker(ctx context.Context, userID, roomID, messageID string, readAt time.Time) (*storage.ReadMarker, bool, error)
+}
+
+// Pinner records which messages are pinned to a room. Both methods report
+// whether the room's pins changed.
+type Pinner interface {
+	PinMessage(ctx context.Context, roomID, messageID, userID string, pinnedAt time.Time) (*room.Room, bool, error)
+	UnpinMessage(ctx context.Context, roomID, messageID string) (*room.Room, bool, error)
+}
+
+// Finder looks up stored messages, which replies are checked against.
+type Finder interface {
+	GetMessage(ctx context.Context, roomID, messageID string) (*message.Message, error)
+}
+
+// BlobFinder looks up uploaded blobs, which attachments are checked against,
+// and records the rooms they are attached in, whose readers may then
+// download them.
+type BlobFinder interface {
+	Stat(ctx context.Context, id string) (*blob.Blob, error)
+	Attach(ctx context.Context, id, roomID string) error
+}
+
+// MentionResolver maps the @handles in a room's chat message to the IDs of
+// the users they mention, dropping handles that name nobody who may read the
+// room.
+type MentionResolver interface {
+	ResolveMentions(roomID string, handles []string) []string
+}
+
+// Deduper recognizes chat and direct messages resent with the same client
+// message ID. Claim returns the message ID the key was first sent as and
+// whether this send is a duplicate; Release gives up a claim whose message was
+// rejected.
+type Deduper interface {
+	Claim(ctx context.Context, userID, clientMessageID, messageID string) (string, bool, error)
+	Release(ctx context.Context, userID, clientMessageID, messageID string) error
+}
+
+// RoomAccess governs subscribing to further rooms: whether a user may read a
+// room, and the backlog replayed to them when they subscribe. The backlog is
+// the frames after lastMessageID or since when either is set, else the
+// room's recent history. Its catch-up returns the frames broadcast to the
+// room since the backlog was taken; it runs on the hub goroutine as the
+// connection joins the room, so no frame falls between the two.
+type RoomAccess interface {
+	CanReadRoom(userID, roomID string) bool
+	Backlog(roomID, lastMessageID string, since time.Time) (frames [][]byte, catchUp func() [][]byte)
 }
 
 // Limiter decides whether an inbound message may be processed.
@@ -47,6 +146,12 @@ type Limiter interface {
 	Allow() bool
 }
 
+// KeyedLimiter decides whether an event for a key may go ahead. It is shared
+// across connections, so limits apply per user rather than per connection.
+type KeyedLimiter interface {
+	Allow(key string) bool
+}
+
 // Client represents a WebSocket client connection
 type Client struct {
 	hub Hub
@@ -63,12 +168,62 @@ type Client struct {
 	userID   string
 	roomID   string
 
+	// Rooms this connection receives and may send to, starting with roomID.
+	// Only touched by the read pump once started.
+	rooms map[string]bool
+
 	// Optional message persister (nil-safe)
 	persister Persister
 
+	// The persister, if it can wait for pending messages (nil-safe)
+	pending PendingWaiter
+
 	// Optional inbound rate limiter (nil-safe)
 	limiter Limiter
 
+	// Optional throttle on typing indicators, keyed by user and room
+	// (nil-safe)
+	typingThrottle KeyedLimiter
+
+	// Optional message editor; edits are rejected without one (nil-safe)
+	editor Editor
+
+	// Optional message deleter; deletes are rejected without one (nil-safe)
+	deleter Deleter
+
+	// Optional reaction store; reactions are rejected without one (nil-safe)
+	reactor Reactor
+
+	// Optional read marker store; read frames are rejected without one
+	// (nil-safe)
+	readTracker ReadTracker
+
+	// Optional pin store; pin and unpin frames are rejected without one
+	// (nil-safe)
+	pinner Pinner
+
+	// Whether this user may delete other users' messages and pin messages
+	moderator bool
+
+	// Optional lookup of reply parents; replies are rejected without one
+	// (nil-safe)
+	finder Finder
+
+	// Optional lookup of uploaded blobs; messages with attachments are
+	// rejected without one (nil-safe)
+	blobs BlobFinder
+
+	// Optional resolver of @handles; without one handles are taken as user
+	// IDs (nil-safe)
+	mentions MentionResolver
+
+	// Optional idempotency check for client message IDs (nil-safe)
+	deduper Deduper
+
+	// Optional access check and backlog for subscriptions; without one any
+	// room may be subscribed to, with no backlog (nil-safe)
+	access RoomAccess
+
 	// Optional analytics tracker (nil-safe)
 	analytics *analytics.Tracker
 
@@ -90,15 +245,19 @@ func New(hub Hub, conn *websocket.Conn, userID, username string, logger *slog.Lo
 		username:   username,
 		userID:     userID,
 		roomID:     defaultRoomID, // Can be overridden with SetRoom
+		rooms:      map[string]bool{defaultRoomID: true},
 		pongWait:   pongWait,
 		pingPeriod: pingPeriod,
 		logger:     logger,
 	}
 }
 
-// SetPersister sets the asynchronous message persister for this client (optional)
+// SetPersister sets the asynchronous message persister for this client
+// (optional). If it is a PendingWaiter, frames targeting a message it has
+// not stored yet wait for it rather than failing with not_found.
 func (c *Client) SetPersister(p Persister) {
 	c.persister = p
+	c.pending, _ = p.(PendingWaiter)
 }
 
 // SetRateLimiter sets the inbound rate limiter for this client (optional)
@@ -106,6 +265,71 @@ func (c *Client) SetRateLimiter(l Limiter) {
 	c.limiter = l
 }
 
+// SetTypingThrottle sets the limiter applied to this user's typing
+// indicators (optional)
+func (c *Client) SetTypingThrottle(l KeyedLimiter) {
+	c.typingThrottle = l
+}
+
+// SetEditor sets the store used to apply message edits (optional)
+func (c *Client) SetEditor(e Editor) {
+	c.editor = e
+}
+
+// SetDeleter sets the store used to apply message deletes (optional)
+func (c *Client) SetDeleter(d Deleter) {
+	c.deleter = d
+}
+
+// SetReactor sets the store used to apply emoji reactions (optional)
+func (c *Client) SetReactor(r Reactor) {
+	c.reactor = r
+}
+
+// SetReadTracker sets the store read markers are kept in (optional)
+func (c *Client) SetReadTracker(t ReadTracker) {
+	c.readTracker = t
+}
+
+// SetPinner sets the store room pins are kept in (optional)
+func (c *Client) SetPinner(p Pinner) {
+	c.pinner = p
+}
+
+// SetFinder sets the store replies' parent messages are looked up in
+// (optional)
+func (c *Client) SetFinder(f Finder) {
+	c.finder = f
+}
+
+// SetBlobFinder sets the store attachments are looked up in (optional)
+func (c *Client) SetBlobFinder(f BlobFinder) {
+	c.blobs = f
+}
+
+// SetMentionResolver sets the resolver of @handles in chat messages (optional)
+func (c *Client) SetMentionResolver(r MentionResolver) {
+	c.mentions = r
+}
+
+// SetDeduper sets the idempotency check applied to chat and direct messages
+// that carry a client message ID (optional)
+func (c *Client) SetDeduper(d Deduper) {
+	c.deduper = d
+}
+
+// SetRoomAccess sets the access check and backlog source used when the
+// client subscribes to another room (optional)
+func (c *Client) SetRoomAccess(a RoomAccess) {
+	c.access = a
+}
+
+// SetModerator grants this connection permission to delete any message in
+// the rooms it is subscribed to, and to pin and unpin messages there
+func (c *Client) SetModerator(moderator bool) {
+	c.moderator = moderator
+}
+
 // SetAnalytics attaches an analytics tracker to this client (optional)
 func (c *Client) SetAnalytics(t *analytics.Tracker) {
 	c.analytics = t
@@ -113,13 +337,16 @@ func (c *Client) SetAnalytics(t *analytics.Tracker) {
 
 // SetRoom assigns the client to a chat room. Must be called before the client
 // is registered with the hub. An empty roomID leaves the default room in place.
+// The client may subscribe to further rooms once started.
 func (c *Client) SetRoom(roomID string) {
 	if roomID != "" {
 		c.roomID = roomID
+		c.rooms = map[string]bool{roomID: true}
 	}
 }
 
-// RoomID returns the room this connection belongs to.
+// RoomID returns the room this connection joined on connect, which frames
+// that name no room are sent to.
 // Implements the hub.Client interface.
 func (c *Client) RoomID() string {
 	return c.roomID
@@ -167,46 +394,138 @@ func (c *Client) readPump() {
 			break
 		}
 
-		// Throttle abusive clients before doing any per-message work.
-		if c.limiter != nil && !c.limiter.Allow() {
-			c.logger.Debug("inbound message rate limited",
-				slog.String("clientID", c.id))
-			continue
-		}
-
 		// Parse and validate message
 		msg, err := message.FromJSON(data)
 		if err != nil {
 			c.logger.Warn("invalid message format",
 				slog.String("clientID", c.id),
 				slog.String("error", err.Error()))
+			c.nack(nil, message.CodeInvalidFormat, err)
 			continue
 		}
 
-		// Set client metadata (server overrides any client-supplied values)
+		// Throttle abusive clients before doing any further per-message work.
+		// Typing indicators have their own throttle, so they don't use up the
+		// tokens real messages need.
+		if c.limiter != nil && msg.Type != message.TypeTyping && !c.limiter.Allow() {
+			c.logger.Debug("inbound message rate limited",
+				slog.String("clientID", c.id))
+			c.nack(msg, message.CodeRateLimited, nil)
+			continue
+		}
+
+		// Set client metadata (server overrides any client-supplied values).
+		// The ID and timestamp are always the server's: a client-chosen ID
+		// could overwrite a stored message.
 		msg.UserID = c.userID
 		msg.Username = c.username
-		msg.RoomID = c.roomID
-
-		// Enrich with server-side fields the client doesn't set
-		if msg.MessageID == "" {
-			msg.MessageID = uuid.New().String()
-		}
-		if msg.Timestamp.IsZero() {
-			msg.Timestamp = time.Now().UTC()
-		}
+		msg.MessageID = uuid.New().String()
+		msg.Timestamp = time.Now().UTC()
+		msg.ClearServerFields()
 
 		// Validate
 		if err := msg.Validate(); err != nil {
 			c.logger.Warn("message validation failed",
 				slog.String("clientID", c.id),
 				slog.String("error", err.Error()))
+			c.nack(msg, message.CodeFor(err), err)
+			continue
+		}
+
+		// Presence is announced by the hub; clients cannot claim it.
+		if msg.Type == message.TypeJoin || msg.Type == message.TypeLeave {
+			c.nack(msg, message.CodeInvalidType, message.ErrInvalidType)
+			continue
+		}
+
+		if msg.Type == message.TypeSubscribe {
+			c.handleSubscribe(msg, data)
+			continue
+		}
+		if msg.Type == message.TypeUnsubscribe {
+			c.handleUnsubscribe(msg)
+			continue
+		}
+
+		if msg.Type == message.TypeDirect {
+			// Direct messages belong to the two users' conversation, not to
+			// a room.
+			msg.RoomID = message.ConversationID(c.userID, msg.RecipientID)
+		} else {
+			// A frame that names no room is for the one joined on connect.
+			// Only subscribed rooms, and the user's own conversations, may
+			// be sent to.
+			if msg.RoomID == "" {
+				msg.RoomID = c.roomID
+			}
+			if !c.canSendTo(msg.RoomID) {
+				c.nack(msg, message.CodeNotSubscribed, errors.New("not subscribed to room"))
+				continue
+			}
+			if msg.Type == message.TypeChat && message.IsConversationID(msg.RoomID) {
+				c.nack(msg, message.CodeInvalidType, errors.New("send direct messages as direct frames"))
+				continue
+			}
+		}
+
+		if msg.Type == message.TypeTyping {
+			c.handleTyping(msg)
+			continue
+		}
+		if msg.Type == message.TypeRead {
+			c.handleRead(msg)
+			continue
+		}
+
+		// Edits, deletes, reactions and pins update the stored original (or
+		// its room) rather than adding a new message.
+		if msg.Type == message.TypeEdit {
+			c.handleEdit(msg)
+			continue
+		}
+		if msg.Type == message.TypeDelete {
+			c.handleDelete(msg)
+			continue
+		}
+		if msg.Type == message.TypeReaction {
+			c.handleReaction(msg)
+			continue
+		}
+		if msg.Type == message.TypePin || msg.Type == message.TypeUnpin {
+			c.handlePin(msg)
+			continue
+		}
+
+		// A reply must start from a message stored in the same room.
+		if msg.ParentMessageID != "" && !c.checkParent(msg) {
+			continue
+		}
+
+		// Attachments must name blobs the sender uploaded.
+		if len(msg.Attachments) > 0 && !c.checkAttachments(msg) {
+			continue
+		}
+
+		// Who a message mentions is worked out here, never taken from the
+		// client.
+		msg.Mentions = c.mentionsIn(msg)
+
+		// A resend of a message already accepted is acknowledged again, under
+		// its original ID, without being stored or broadcast.
+		if c.isDuplicate(msg) {
+			ack := message.NewAck(msg)
+			ack.Duplicate = true
+			c.sendAck(ack)
 			continue
 		}
 
 		// Hand off to the persistence worker pool (non-blocking, nil-safe).
-		if c.persister != nil {
-			c.persister.Enqueue(msg)
+		// A message that cannot be stored is not broadcast either, so the
+		// client can retry it.
+		if c.persister != nil && !c.persister.Enqueue(msg) {
+			c.releaseClaim(msg)
+			c.nack(msg, message.CodeOverloaded, errors.New("message could not be queued for storage"))
+			continue
 		}
 
 		if c.analytics != nil {
@@ -219,11 +538,583 @@ func (c *Client) readPump() {
 			c.logger.Error("failed to marshal message",
 				slog.String("clientID", c.id),
 				slog.String("error", err.Error()))
+			c.nack(msg, message.CodeInternal, nil)
 			continue
 		}
 
-		c.hub.Broadcast(c.roomID, jsonData)
+		if msg.Type == message.TypeDirect {
+			// Both users see the message on every connection they have open,
+			// so the sender's other tabs stay in step.
+			c.hub.SendToUsers(jsonData, c.userID, msg.RecipientID)
+		} else {
+			c.hub.Broadcast(msg.RoomID, jsonData)
+		}
+		c.notifyMentions(msg)
+		c.sendAck(message.NewAck(msg))
+	}
+}
+
+// canSendTo reports whether this connection may send frames to roomID: a
+// room it is subscribed to, or a conversation its user takes part in.
+// Conversations cannot be subscribed to, so edits, deletes, reactions and
+// read receipts in them are checked against the participants instead.
+func (c *Client) canSendTo(roomID string) bool {
+	if userA, userB, ok := message.Participants(roomID); ok {
+		return c.userID == userA || c.userID == userB
+	}
+	return c.rooms[roomID]
+}
+
+// broadcast sends a frame to everyone in roomID: the room's subscribers, or,
+// for a conversation, every connection of its two participants.
+func (c *Client) broadcast(roomID string, data []byte) {
+	if userA, userB, ok := message.Participants(roomID); ok {
+		c.hub.SendToUsers(data, userA, userB)
+		return
+	}
+	c.hub.Broadcast(roomID, data)
+}
+
+// mentionsIn returns the IDs of the users a chat message @mentions, other
+// than its sender.
+func (c *Client) mentionsIn(msg *message.Message) []string {
+	if msg.Type != message.TypeChat {
+		return nil
+	}
+	handles := message.ParseMentions(msg.Content)
+	if len(handles) == 0 {
+		return nil
+	}
+
+	userIDs := handles
+	if c.mentions != nil {
+		userIDs = c.mentions.ResolveMentions(msg.RoomID, handles)
+	}
+	var mentioned []string
+	for _, id := range userIDs {
+		if id != c.userID && !slices.Contains(mentioned, id) {
+			mentioned = append(mentioned, id)
+		}
+	}
+	return mentioned
+}
+
+// notifyMentions sends each user msg mentions a mention frame on every
+// connection they have open, whichever rooms those are in.
+func (c *Client) notifyMentions(msg *message.Message) {
+	if len(msg.Mentions) == 0 {
+		return
+	}
+	notice := message.NewMentionMessage(msg)
+	notice.MessageID = uuid.New().String()
+	jsonData, err := notice.ToJSON()
+	if err != nil {
+		c.logger.Error("failed to marshal mention",
+			slog.String("clientID", c.id),
+			slog.String("error", err.Error()))
+		return
+	}
+	c.hub.SendToUsers(jsonData, msg.Mentions...)
+}
+
+// isDuplicate claims a chat or direct message's client message ID and reports whether
+// the message was already sent, pointing msg at the original MessageID if so.
+// A failing idempotency store is logged and the message treated as new.
+func (c *Client) isDuplicate(msg *message.Message) bool {
+	if c.deduper == nil || !dedupable(msg) {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	original, duplicate, err := c.deduper.Claim(ctx, c.userID, msg.ClientMessageID, msg.MessageID)
+	if err != nil {
+		c.logger.Warn("idempotency check failed, accepting message",
+			slog.String("clientID", c.id),
+			slog.String("clientMessageID", msg.ClientMessageID),
+			slog.String("error", err.Error()))
+		return false
+	}
+	if !duplicate {
+		return false
+	}
+
+	c.logger.Debug("duplicate message skipped",
+		slog.String("clientID", c.id),
+		slog.String("clientMessageID", msg.ClientMessageID),
+		slog.String("messageID", original))
+	msg.MessageID = original
+	return true
+}
+
+// dedupable reports whether msg is a chat or direct message carrying a client
+// message ID, which resends are recognized by.
+func dedupable(msg *message.Message) bool {
+	return (msg.Type == message.TypeChat || msg.Type == message.TypeDirect) && msg.ClientMessageID != ""
+}
+
+// releaseClaim gives up the idempotency claim isDuplicate made for msg.
+func (c *Client) releaseClaim(msg *message.Message) {
+	if c.deduper == nil || !dedupable(msg) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	if err := c.deduper.Release(ctx, c.userID, msg.ClientMessageID, msg.MessageID); err != nil {
+		c.logger.Warn("failed to release idempotency key",
+			slog.String("clientID", c.id),
+			slog.String("clientMessageID", msg.ClientMessageID),
+			slog.String("error", err.Error()))
+	}
+}
+
+// sendAck queues an ack or nack for this connection only.
+func (c *Client) sendAck(ack *message.Ack) {
+	data, err := ack.ToJSON()
+	if err != nil {
+		c.logger.Error("failed to marshal ack",
+			slog.String("clientID", c.id),
+			slog.String("error", err.Error()))
+		return
+	}
+	c.Send(data)
+}
+
+// nack tells this connection msg was rejected. msg is nil when the frame
+// could not be parsed; err, if set, is passed on as a readable description.
+func (c *Client) nack(msg *message.Message, code message.ErrorCode, err error) {
+	c.sendAck(message.NewNack(msg, code, err))
+}
+
+// storeErrorCode maps an edit, delete, reaction, pin, parent or attachment
+// lookup failure to a nack code.
+func storeErrorCode(err error) message.ErrorCode {
+	switch {
+	case errors.Is(err, storage.ErrMessageNotFound), errors.Is(err, storage.ErrRoomNotFound), errors.Is(err, blob.ErrNotFound):
+		return message.CodeNotFound
+	case errors.Is(err, room.ErrTooManyPins):
+		return message.CodeTooManyPins
+	case errors.Is(err, storage.ErrNotAuthor), errors.Is(err, storage.ErrMessageDeleted):
+		return message.CodeForbidden
+	default:
+		return message.CodeInternal
+	}
+}
+
+// retryPending runs op and, if it failed because the message is not stored
+// yet but is still on its way through the persister, waits for the message
+// and runs op once more.
+func (c *Client) retryPending(ctx context.Context, roomID, messageID string, op func() error) error {
+	err := op()
+	if errors.Is(err, storage.ErrMessageNotFound) && c.pending != nil && c.pending.Await(ctx, roomID, messageID) {
+		err = op()
+	}
+	return err
+}
+
+// checkParent reports whether a reply's parent is stored in the reply's room
+// and is not a reply itself, nacking the reply if not. Threads are one level
+// deep: a reply in a thread names the message that started it.
+func (c *Client) checkParent(msg *message.Message) bool {
+	if c.finder == nil {
+		c.logger.Warn("reply rejected, storage unavailable",
+			slog.String("clientID", c.id),
+			slog.String("parentMessageID", msg.ParentMessageID))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	var parent *message.Message
+	err := c.retryPending(ctx, msg.RoomID, msg.ParentMessageID, func() (err error) {
+		parent, err = c.finder.GetMessage(ctx, msg.RoomID, msg.ParentMessageID)
+		return err
+	})
+	if err != nil {
+		c.logger.Warn("reply rejected",
+			slog.String("clientID", c.id),
+			slog.String("parentMessageID", msg.ParentMessageID),
+			slog.String("error", err.Error()))
+		c.nack(msg, storeErrorCode(err), err)
+		return false
+	}
+	if parent.ParentMessageID != "" {
+		c.nack(msg, message.CodeInvalidParent, errors.New("cannot reply to a reply"))
+		return false
+	}
+	return true
+}
+
+// checkAttachments reports whether every attachment names a blob uploaded by
+// the sender, nacking the message if not. The client only supplies the IDs;
+// the filename, content type and size are filled in from the store. Each blob
+// is then recorded as attached in the message's room, so that the room's
+// readers may download it.
+func (c *Client) checkAttachments(msg *message.Message) bool {
+	if c.blobs == nil {
+		c.logger.Warn("attachments rejected, uploads unavailable",
+			slog.String("clientID", c.id))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	for i, a := range msg.Attachments {
+		b, err := c.blobs.Stat(ctx, a.ID)
+		if err != nil {
+			c.logger.Warn("attachment rejected",
+				slog.String("clientID", c.id),
+				slog.String("blobID", a.ID),
+				slog.String("error", err.Error()))
+			c.nack(msg, storeErrorCode(err), err)
+			return false
+		}
+		if b.UploadedBy != c.userID {
+			c.nack(msg, message.CodeForbidden, errors.New("attachment was uploaded by another user"))
+			return false
+		}
+		msg.Attachments[i] = message.Attachment{ID: b.ID, Filename: b.Filename, ContentType: b.ContentType, Size: b.Size}
+	}
+	for _, a := range msg.Attachments {
+		if err := c.blobs.Attach(ctx, a.ID, msg.RoomID); err != nil {
+			c.logger.Error("failed to attach blob",
+				slog.String("clientID", c.id),
+				slog.String("blobID", a.ID),
+				slog.String("error", err.Error()))
+			c.nack(msg, storeErrorCode(err), err)
+			return false
+		}
+	}
+	return true
+}
+
+// subscribeRequest is the resume point a subscribe frame may carry, as on a
+// WebSocket connect: the last frame the client holds from the room.
+type subscribeRequest struct {
+	LastMessageID string    `json:"lastMessageId"`
+	Since         time.Time `json:"since"`
+}
+
+// handleSubscribe adds the connection to another room. The room's backlog is
+// queued before the connection joins the live broadcast set, so it arrives
+// ahead of any live messages; the ack follows it. Subscribing to a room
+// already subscribed to is acknowledged without replaying anything.
+func (c *Client) handleSubscribe(msg *message.Message, data []byte) {
+	if c.rooms[msg.RoomID] {
+		c.sendAck(message.NewAck(msg))
+		return
+	}
+	if len(c.rooms) >= maxSubscriptions {
+		c.nack(msg, message.CodeTooManyRooms, errors.New("too many room subscriptions"))
+		return
+	}
+	var req subscribeRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		c.nack(msg, message.CodeInvalidFormat, err)
+		return
+	}
+
+	var catchUp func() [][]byte
+	if c.access != nil {
+		if !c.access.CanReadRoom(c.userID, msg.RoomID) {
+			c.nack(msg, message.CodeForbidden, nil)
+			return
+		}
+		var frames [][]byte
+		frames, catchUp = c.access.Backlog(msg.RoomID, req.LastMessageID, req.Since)
+		for _, frame := range frames {
+			c.Send(frame)
+		}
+	}
+
+	c.rooms[msg.RoomID] = true
+	c.hub.SubscribeWith(c, msg.RoomID, func() {
+		if catchUp == nil {
+			return
+		}
+		for _, frame := range catchUp() {
+			c.Send(frame)
+		}
+	})
+	c.logger.Debug("client subscribed to room",
+		slog.String("clientID", c.id),
+		slog.String("roomID", msg.RoomID))
+	c.sendAck(message.NewAck(msg))
+}
+
+// handleUnsubscribe removes the connection from a room. Unsubscribing from a
+// room not subscribed to is acknowledged as a no-op.
+func (c *Client) handleUnsubscribe(msg *message.Message) {
+	if c.rooms[msg.RoomID] {
+		delete(c.rooms, msg.RoomID)
+		c.hub.Unsubscribe(c, msg.RoomID)
+		c.logger.Debug("client unsubscribed from room",
+			slog.String("clientID", c.id),
+			slog.String("roomID", msg.RoomID))
+	}
+	c.sendAck(message.NewAck(msg))
+}
+
+// handleTyping relays a typing indicator to the room, at most once per
+// throttle interval per user and room. It bypasses storage, analytics, history
+// and acks. The event carries ExpiresAt so clients can clear the indicator
+// when the user stops typing or disconnects without saying so.
+func (c *Client) handleTyping(msg *message.Message) {
+	if c.typingThrottle != nil && !c.typingThrottle.Allow(c.userID+"\x00"+msg.RoomID) {
+		return
+	}
+
+	msg.Content = ""
+	msg.ClientMessageID = ""
+	expiresAt := time.Now().UTC().Add(typingTTL)
+	msg.ExpiresAt = &expiresAt
+
+	jsonData, err := msg.ToJSON()
+	if err != nil {
+		c.logger.Error("failed to marshal typing indicator",
+			slog.String("clientID", c.id),
+			slog.String("error", err.Error()))
+		return
+	}
+	c.broadcast(msg.RoomID, jsonData)
+}
+
+// handleRead moves the sender's read marker in the frame's room to the
+// message it names. When the marker moves, the frame is relayed to the room
+// so other members (and the sender's other connections) see the new
+// position; reading an older message is acknowledged without a relay.
+func (c *Client) handleRead(msg *message.Message) {
+	if c.readTracker == nil {
+		c.logger.Warn("read marker rejected, storage unavailable",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	var moved bool
+	err := c.retryPending(ctx, msg.RoomID, msg.TargetID, func() (err error) {
+		_, moved, err = c.readTracker.SetReadMarker(ctx, c.userID, msg.RoomID, msg.TargetID, msg.Timestamp)
+		return err
+	})
+	if err != nil {
+		c.logger.Warn("read marker rejected",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID),
+			slog.String("error", err.Error()))
+		c.nack(msg, storeErrorCode(err), err)
+		return
+	}
+	if moved {
+		// The event carries no content of its own.
+		msg.Content = ""
+		jsonData, err := msg.ToJSON()
+		if err != nil {
+			c.logger.Error("failed to marshal read marker",
+				slog.String("clientID", c.id),
+				slog.String("error", err.Error()))
+			c.nack(msg, message.CodeInternal, nil)
+			return
+		}
+		c.broadcast(msg.RoomID, jsonData)
+	}
+	c.sendAck(message.NewAck(msg))
+}
+
+// handleEdit applies an edit to the stored target message and, once the store
+// has accepted it, broadcasts the edit so clients replace the message in place.
+// The store enforces that only the original author may edit.
+func (c *Client) handleEdit(msg *message.Message) {
+	if c.editor == nil {
+		c.logger.Warn("message edit rejected, storage unavailable",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	var edited *message.Message
+	err := c.retryPending(ctx, msg.RoomID, msg.TargetID, func() (err error) {
+		edited, err = c.editor.EditMessage(ctx, msg.RoomID, msg.TargetID, c.userID, msg.Content, msg.Timestamp)
+		return err
+	})
+	if err != nil {
+		c.logger.Warn("message edit rejected",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID),
+			slog.String("error", err.Error()))
+		c.nack(msg, storeErrorCode(err), err)
+		return
+	}
+	msg.EditedAt = edited.EditedAt
+
+	jsonData, err := msg.ToJSON()
+	if err != nil {
+		c.logger.Error("failed to marshal edit",
+			slog.String("clientID", c.id),
+			slog.String("error", err.Error()))
+		c.nack(msg, message.CodeInternal, nil)
+		return
+	}
+	c.broadcast(msg.RoomID, jsonData)
+	c.sendAck(message.NewAck(msg))
+}
+
+// handleDelete tombstones the stored target message and, once the store has
+// accepted it, broadcasts the delete so clients hide the message. The store
+// enforces that only the author or a moderator may delete.
+func (c *Client) handleDelete(msg *message.Message) {
+	if c.deleter == nil {
+		c.logger.Warn("message delete rejected, storage unavailable",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	err := c.retryPending(ctx, msg.RoomID, msg.TargetID, func() error {
+		_, err := c.deleter.DeleteMessage(ctx, msg.RoomID, msg.TargetID, c.userID, c.moderator, msg.Timestamp)
+		return err
+	})
+	if err != nil {
+		c.logger.Warn("message delete rejected",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID),
+			slog.String("error", err.Error()))
+		c.nack(msg, storeErrorCode(err), err)
+		return
+	}
+	// The event carries no content of its own.
+	msg.Content = ""
+
+	jsonData, err := msg.ToJSON()
+	if err != nil {
+		c.logger.Error("failed to marshal delete",
+			slog.String("clientID", c.id),
+			slog.String("error", err.Error()))
+		c.nack(msg, message.CodeInternal, nil)
+		return
+	}
+	c.broadcast(msg.RoomID, jsonData)
+	c.sendAck(message.NewAck(msg))
+}
+
+// handleReaction applies a reaction to the stored target message and, once
+// the store has accepted it, broadcasts the reaction with the target's updated
+// tally so clients can replace their counters without refetching.
+func (c *Client) handleReaction(msg *message.Message) {
+	if c.reactor == nil {
+		c.logger.Warn("reaction rejected, storage unavailable",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	var target *message.Message
+	err := c.retryPending(ctx, msg.RoomID, msg.TargetID, func() (err error) {
+		target, err = c.reactor.ReactToMessage(ctx, msg.RoomID, msg.TargetID, c.userID, msg.Emoji, msg.Remove)
+		return err
+	})
+	if err != nil {
+		c.logger.Warn("reaction rejected",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID),
+			slog.String("error", err.Error()))
+		c.nack(msg, storeErrorCode(err), err)
+		return
+	}
+	// The event carries no content of its own.
+	msg.Content = ""
+	msg.Reactions = target.Reactions
+
+	jsonData, err := msg.ToJSON()
+	if err != nil {
+		c.logger.Error("failed to marshal reaction",
+			slog.String("clientID", c.id),
+			slog.String("error", err.Error()))
+		c.nack(msg, message.CodeInternal, nil)
+		return
+	}
+	c.broadcast(msg.RoomID, jsonData)
+	c.sendAck(message.NewAck(msg))
+}
+
+// handlePin pins or unpins the target message on its room and, if the pins
+// changed, broadcasts the frame with the room's updated Pins. Only moderators
+// may pin; the message must be stored in the room, and the room must be in
+// the directory.
+func (c *Client) handlePin(msg *message.Message) {
+	if !c.moderator {
+		c.nack(msg, message.CodeForbidden, errors.New("only moderators may pin messages"))
+		return
+	}
+	if c.pinner == nil {
+		c.logger.Warn("pin rejected, storage unavailable",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID))
+		c.nack(msg, message.CodeUnavailable, nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), editTimeout)
+	defer cancel()
+
+	var (
+		rm      *room.Room
+		changed bool
+		err     error
+	)
+	if msg.Type == message.TypePin {
+		err = c.retryPending(ctx, msg.RoomID, msg.TargetID, func() (err error) {
+			rm, changed, err = c.pinner.PinMessage(ctx, msg.RoomID, msg.TargetID, c.userID, msg.Timestamp)
+			return err
+		})
+	} else {
+		rm, changed, err = c.pinner.UnpinMessage(ctx, msg.RoomID, msg.TargetID)
+	}
+	if err != nil {
+		c.logger.Warn("pin rejected",
+			slog.String("clientID", c.id),
+			slog.String("targetID", msg.TargetID),
+			slog.String("error", err.Error()))
+		c.nack(msg, storeErrorCode(err), err)
+		return
+	}
+	if changed {
+		// The event carries no content of its own.
+		msg.Content = ""
+		msg.Pins = rm.Pins
+		jsonData, err := msg.ToJSON()
+		if err != nil {
+			c.logger.Error("failed to marshal pin",
+				slog.String("clientID", c.id),
+				slog.String("error", err.Error()))
+			c.nack(msg, message.CodeInternal, nil)
+			return
+		}
+		c.broadcast(msg.RoomID, jsonData)
 	}
+	c.sendAck(message.NewAck(msg))
 }
 
 // writePump pumps messages from the hub to the WebSocket connection
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
@@ -10,16 +13,27 @@ import (
 	"testing"
 	"time"
 
+	"github.com/epw80/chat-analytics-platform/pkg/blob"
+	"github.com/epw80/chat-analytics-platform/pkg/idempotency"
 	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/ratelimit"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+	"github.com/epw80/chat-analytics-platform/pkg/storage"
 	"github.com/gorilla/websocket"
 )
 
 // mockHub implements the Hub interface for testing
 type mockHub struct {
-	mu           sync.Mutex
-	registered   []*Client
-	unregistered []*Client
-	broadcasts   [][]byte
+	mu             sync.Mutex
+	registered     []*Client
+	unregistered   []*Client
+	broadcasts     [][]byte
+	broadcastRooms []string
+	// Subscription changes in order, as "+room" or "-room"
+	subscriptions []string
+	// Direct sends in order, with the users each was for
+	directs     [][]byte
+	directUsers [][]string
 }
 
 func (m *mockHub) Register(c any) {
@@ -38,9 +52,32 @@ func (m *mockHub) Unregister(c any) {
 	}
 }
 
+func (m *mockHub) SubscribeWith(c any, roomID string, onJoin func()) {
+	if onJoin != nil {
+		onJoin()
+	}
+	m.mu.Lock()
+	m.subscriptions = append(m.subscriptions, "+"+roomID)
+	m.mu.Unlock()
+}
+
+func (m *mockHub) Unsubscribe(c any, roomID string) {
+	m.mu.Lock()
+	m.subscriptions = append(m.subscriptions, "-"+roomID)
+	m.mu.Unlock()
+}
+
 func (m *mockHub) Broadcast(roomID string, data []byte) {
 	m.mu.Lock()
 	m.broadcasts = append(m.broadcasts, data)
+	m.broadcastRooms = append(m.broadcastRooms, roomID)
+	m.mu.Unlock()
+}
+
+func (m *mockHub) SendToUsers(data []byte, userIDs ...string) {
+	m.mu.Lock()
+	m.directs = append(m.directs, data)
+	m.directUsers = append(m.directUsers, userIDs)
 	m.mu.Unlock()
 }
 
@@ -462,20 +499,26 @@ func TestClient_MetadataOverride(t *testing.T) {
 }
 
 // mockPersister implements the Persister interface for testing. Enqueue records
-// synchronously, mirroring the non-blocking hand-off the client performs.
+// synchronously, mirroring the non-blocking hand-off the client performs, and
+// rejects every message when reject is set.
 type mockPersister struct {
 	mu       sync.Mutex
 	messages []*message.Message
+	reject   bool
 }
 
 func newMockPersister() *mockPersister {
 	return &mockPersister{messages: make([]*message.Message, 0)}
 }
 
-func (m *mockPersister) Enqueue(msg *message.Message) {
+func (m *mockPersister) Enqueue(msg *message.Message) bool {
 	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reject {
+		return false
+	}
 	m.messages = append(m.messages, msg)
-	m.mu.Unlock()
+	return true
 }
 
 func (m *mockPersister) MessageCount() int {
@@ -569,6 +612,35 @@ func TestClient_PersistsMessages(t *testing.T) {
 	}
 }
 
+func TestClient_IgnoresServerFields(t *testing.T) {
+	hub := newMockHub()
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", nil, persister)
+
+	frame := `{"type":"chat","messageId":"m1","timestamp":"2001-01-01T00:00:00Z","content":"hi",` +
+		`"deleted":true,"deletedBy":"admin","replyCount":99,"reactions":[{"emoji":"👍","userIds":["bob"]}],` +
+		`"revisions":[{"content":"old"}],"mentions":["bob"],"expiresAt":"2001-01-02T00:00:00Z"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	readAcks(t, ws, 1)
+
+	if persister.MessageCount() != 1 {
+		t.Fatalf("expected 1 enqueued message, got %d", persister.MessageCount())
+	}
+	stored := persister.GetMessage(0)
+	if stored.MessageID == "m1" {
+		t.Error("client-supplied messageId was kept")
+	}
+	if stored.Timestamp.Year() == 2001 {
+		t.Error("client-supplied timestamp was kept")
+	}
+	if stored.Deleted || stored.DeletedBy != "" || stored.ReplyCount != 0 || stored.Reactions != nil ||
+		stored.Revisions != nil || stored.Mentions != nil || stored.ExpiresAt != nil {
+		t.Errorf("server-owned fields were kept: %+v", stored)
+	}
+}
+
 func TestClient_PersisterNilSafe(t *testing.T) {
 	hub := newMockHub()
 	logger := newTestLogger()
@@ -664,3 +736,960 @@ func TestClient_RateLimited(t *testing.T) {
 		t.Errorf("expected 2 broadcasts under rate limit, got %d", hub.BroadcastCount())
 	}
 }
+
+// mockEditor implements the Editor and Deleter interfaces, accepting changes
+// from "user123" (or any moderator, for deletes) only.
+type mockEditor struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (m *mockEditor) EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error) {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+	if userID != "user123" {
+		return nil, storage.ErrNotAuthor
+	}
+	return &message.Message{MessageID: messageID, RoomID: roomID, UserID: userID, Content: content, EditedAt: &editedAt}, nil
+}
+
+func (m *mockEditor) DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error) {
+	m.mu.Lock()
+	m.calls++
+	m.mu.Unlock()
+	if !moderator && userID != "user123" {
+		return nil, storage.ErrNotAuthor
+	}
+	return &message.Message{MessageID: messageID, RoomID: roomID, Deleted: true, DeletedAt: &deletedAt, DeletedBy: userID}, nil
+}
+
+func (m *mockEditor) CallCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls
+}
+
+// dialEditClient starts a client with the given user and editor behind a test
+// server and returns the dialed connection. Extra options configure the client
+// before it starts.
+func dialEditClient(t *testing.T, hub *mockHub, userID string, editor Editor, persister Persister, opts ...func(*Client)) *websocket.Conn {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade error: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		client := New(hub, conn, userID, "TestUser", newTestLogger())
+		if editor != nil {
+			client.SetEditor(editor)
+		}
+		if persister != nil {
+			client.SetPersister(persister)
+		}
+		for _, opt := range opts {
+			opt(client)
+		}
+		client.Start()
+
+		time.Sleep(150 * time.Millisecond)
+	}))
+	t.Cleanup(server.Close)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial error: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return ws
+}
+
+func TestClient_EditBroadcast(t *testing.T) {
+	hub := newMockHub()
+	editor := &mockEditor{}
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", editor, persister)
+
+	edit := `{"type":"edit","targetId":"m1","content":"fixed"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(edit)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if editor.CallCount() != 1 {
+		t.Fatalf("expected 1 edit call, got %d", editor.CallCount())
+	}
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	got, err := message.FromJSON(hub.GetBroadcast(0))
+	if err != nil {
+		t.Fatalf("broadcast is not a message: %v", err)
+	}
+	if got.Type != message.TypeEdit || got.TargetID != "m1" || got.Content != "fixed" || got.EditedAt == nil {
+		t.Errorf("unexpected edit broadcast: %+v", got)
+	}
+	// Edits update the original; they are not persisted as new messages.
+	if persister.MessageCount() != 0 {
+		t.Errorf("expected edit not to be enqueued, got %d", persister.MessageCount())
+	}
+}
+
+func TestClient_EditRejected(t *testing.T) {
+	hub := newMockHub()
+	editor := &mockEditor{}
+	ws := dialEditClient(t, hub, "someoneElse", editor, nil)
+
+	edit := `{"type":"edit","targetId":"m1","content":"hijack"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(edit)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if editor.CallCount() != 1 {
+		t.Fatalf("expected 1 edit call, got %d", editor.CallCount())
+	}
+	if hub.BroadcastCount() != 0 {
+		t.Errorf("expected rejected edit not to be broadcast, got %d", hub.BroadcastCount())
+	}
+}
+
+func TestClient_EditWithoutEditor(t *testing.T) {
+	hub := newMockHub()
+	ws := dialEditClient(t, hub, "user123", nil, nil)
+
+	edit := `{"type":"edit","targetId":"m1","content":"fixed"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(edit)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if hub.BroadcastCount() != 0 {
+		t.Errorf("expected edit without storage to be dropped, got %d broadcasts", hub.BroadcastCount())
+	}
+}
+
+func TestClient_DeleteBroadcast(t *testing.T) {
+	hub := newMockHub()
+	deleter := &mockEditor{}
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetDeleter(deleter) })
+
+	del := `{"type":"delete","targetId":"m1","content":"ignored"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(del)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if deleter.CallCount() != 1 {
+		t.Fatalf("expected 1 delete call, got %d", deleter.CallCount())
+	}
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	got, err := message.FromJSON(hub.GetBroadcast(0))
+	if err != nil {
+		t.Fatalf("broadcast is not a message: %v", err)
+	}
+	if got.Type != message.TypeDelete || got.TargetID != "m1" || got.Content != "" {
+		t.Errorf("unexpected delete broadcast: %+v", got)
+	}
+	if persister.MessageCount() != 0 {
+		t.Errorf("expected delete not to be enqueued, got %d", persister.MessageCount())
+	}
+}
+
+func TestClient_DeleteRequiresAuthorOrModerator(t *testing.T) {
+	tests := []struct {
+		name      string
+		moderator bool
+		want      int
+	}{
+		{"member", false, 0},
+		{"moderator", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newMockHub()
+			deleter := &mockEditor{}
+			ws := dialEditClient(t, hub, "someoneElse", nil, nil, func(c *Client) {
+				c.SetDeleter(deleter)
+				c.SetModerator(tt.moderator)
+			})
+
+			del := `{"type":"delete","targetId":"m1"}`
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(del)); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+			time.Sleep(50 * time.Millisecond)
+
+			if hub.BroadcastCount() != tt.want {
+				t.Errorf("expected %d broadcasts, got %d", tt.want, hub.BroadcastCount())
+			}
+		})
+	}
+}
+
+func TestClient_DuplicateClientMessageID(t *testing.T) {
+	hub := newMockHub()
+	persister := newMockPersister()
+	dedupe := idempotency.New(time.Minute, nil)
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetDeduper(dedupe) })
+
+	send := `{"type":"chat","content":"hello","clientMessageId":"k1"}`
+	for i := 0; i < 2; i++ {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	// A different key is a different message
+	other := `{"type":"chat","content":"hello","clientMessageId":"k2"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(other)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if persister.MessageCount() != 2 {
+		t.Fatalf("expected 2 enqueued messages, got %d", persister.MessageCount())
+	}
+	if hub.BroadcastCount() != 2 {
+		t.Fatalf("expected 2 broadcasts, got %d", hub.BroadcastCount())
+	}
+	original := persister.GetMessage(0)
+	if original.ClientMessageID != "k1" {
+		t.Errorf("stored clientMessageId = %q, want k1", original.ClientMessageID)
+	}
+
+	// The duplicate is acknowledged to the sender only, under the original ID
+	acks := readAcks(t, ws, 3)
+	if acks[0].Type != message.TypeAck || acks[0].MessageID != original.MessageID || acks[0].Duplicate {
+		t.Errorf("first ack = %+v, want ack for %s", acks[0], original.MessageID)
+	}
+	if acks[1].Type != message.TypeAck || acks[1].MessageID != original.MessageID || !acks[1].Duplicate {
+		t.Errorf("duplicate ack = %+v, want duplicate ack for %s", acks[1], original.MessageID)
+	}
+	if acks[2].ClientMessageID != "k2" || acks[2].Duplicate {
+		t.Errorf("third ack = %+v, want ack for k2", acks[2])
+	}
+}
+
+// readAcks reads n ack or nack frames from ws. The write pump may coalesce
+// queued frames into one websocket message, one per line.
+func readAcks(t *testing.T, ws *websocket.Conn, n int) []message.Ack {
+	t.Helper()
+	var acks []message.Ack
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	for len(acks) < n {
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("read error after %d acks: %v", len(acks), err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			var ack message.Ack
+			if err := json.Unmarshal([]byte(line), &ack); err != nil {
+				t.Fatalf("frame is not an ack: %s", line)
+			}
+			acks = append(acks, ack)
+		}
+	}
+	return acks
+}
+
+func TestClient_AcksAcceptedMessage(t *testing.T) {
+	hub := newMockHub()
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", nil, persister)
+
+	send := `{"type":"chat","content":"hello","clientMessageId":"c1"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	ack := readAcks(t, ws, 1)[0]
+	stored := persister.GetMessage(0)
+	if stored == nil {
+		t.Fatal("message was not enqueued")
+	}
+	if ack.Type != message.TypeAck || ack.ClientMessageID != "c1" || ack.Code != "" {
+		t.Errorf("unexpected ack: %+v", ack)
+	}
+	if ack.MessageID != stored.MessageID || !ack.Timestamp.Equal(stored.Timestamp) {
+		t.Errorf("ack = %s at %v, want %s at %v", ack.MessageID, ack.Timestamp, stored.MessageID, stored.Timestamp)
+	}
+}
+
+func TestClient_NacksRejectedMessage(t *testing.T) {
+	long := strings.Repeat("x", message.MaxContentLength+1)
+	tests := []struct {
+		name      string
+		frame     string
+		userID    string
+		editor    Editor
+		persister *mockPersister
+		limiter   Limiter
+		want      message.ErrorCode
+	}{
+		{name: "malformed", frame: `{not json`, want: message.CodeInvalidFormat},
+		{name: "unknown type", frame: `{"type":"shout","content":"hi","clientMessageId":"c1"}`, want: message.CodeInvalidType},
+		{name: "client ack", frame: `{"type":"ack","clientMessageId":"c1"}`, want: message.CodeInvalidType},
+		{name: "client join", frame: `{"type":"join","clientMessageId":"c1"}`, want: message.CodeInvalidType},
+		{name: "too long", frame: `{"type":"chat","content":"` + long + `","clientMessageId":"c1"}`, want: message.CodeTooLong},
+		{name: "empty", frame: `{"type":"chat","clientMessageId":"c1"}`, want: message.CodeEmptyContent},
+		{name: "rate limited", frame: `{"type":"chat","content":"hi","clientMessageId":"c1"}`, limiter: &allowN{n: 0}, want: message.CodeRateLimited},
+		{name: "queue full", frame: `{"type":"chat","content":"hi","clientMessageId":"c1"}`, persister: &mockPersister{reject: true}, want: message.CodeOverloaded},
+		{name: "edit without storage", frame: `{"type":"edit","targetId":"m1","content":"x","clientMessageId":"c1"}`, want: message.CodeUnavailable},
+		{name: "subscribe without room", frame: `{"type":"subscribe","clientMessageId":"c1"}`, want: message.CodeMissingRoom},
+		{name: "unsubscribed room", frame: `{"type":"chat","roomId":"elsewhere","content":"hi","clientMessageId":"c1"}`, want: message.CodeNotSubscribed},
+		{name: "direct without recipient", frame: `{"type":"direct","content":"hi","clientMessageId":"c1"}`, want: message.CodeMissingRecipient},
+		{name: "edit by another user", frame: `{"type":"edit","targetId":"m1","content":"x","clientMessageId":"c1"}`, userID: "someoneElse", editor: &mockEditor{}, want: message.CodeForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := newMockHub()
+			userID := tt.userID
+			if userID == "" {
+				userID = "user123"
+			}
+			var persister Persister
+			if tt.persister != nil {
+				persister = tt.persister
+			}
+			ws := dialEditClient(t, hub, userID, tt.editor, persister, func(c *Client) {
+				if tt.limiter != nil {
+					c.SetRateLimiter(tt.limiter)
+				}
+			})
+
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(tt.frame)); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+
+			nack := readAcks(t, ws, 1)[0]
+			if nack.Type != message.TypeNack || nack.Code != tt.want {
+				t.Errorf("got %s %q, want nack %q", nack.Type, nack.Code, tt.want)
+			}
+			if tt.want != message.CodeInvalidFormat && nack.ClientMessageID != "c1" {
+				t.Errorf("nack clientMessageId = %q, want c1", nack.ClientMessageID)
+			}
+			if hub.BroadcastCount() != 0 {
+				t.Errorf("rejected message was broadcast")
+			}
+		})
+	}
+}
+
+func TestClient_RejectedMessageReleasesClientMessageID(t *testing.T) {
+	hub := newMockHub()
+	persister := &mockPersister{reject: true}
+	dedupe := idempotency.New(time.Minute, nil)
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetDeduper(dedupe) })
+
+	send := `{"type":"chat","content":"hello","clientMessageId":"k1"}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if nack := readAcks(t, ws, 1)[0]; nack.Code != message.CodeOverloaded {
+		t.Fatalf("got %+v, want overloaded nack", nack)
+	}
+
+	// The retry is a new message, not a duplicate of the rejected one
+	persister.mu.Lock()
+	persister.reject = false
+	persister.mu.Unlock()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	ack := readAcks(t, ws, 1)[0]
+	if ack.Type != message.TypeAck || ack.Duplicate {
+		t.Errorf("retry got %+v, want a fresh ack", ack)
+	}
+	if persister.MessageCount() != 1 || hub.BroadcastCount() != 1 {
+		t.Errorf("retry stored %d and broadcast %d, want 1 and 1", persister.MessageCount(), hub.BroadcastCount())
+	}
+}
+
+func TestClient_TypingIsEphemeral(t *testing.T) {
+	hub := newMockHub()
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) {
+		c.SetTypingThrottle(ratelimit.NewThrottle(time.Minute))
+		// Typing must not need tokens from the message rate limiter
+		c.SetRateLimiter(&allowN{n: 0})
+	})
+
+	typing := `{"type":"typing","content":"draft","clientMessageId":"c1"}`
+	for i := 0; i < 3; i++ {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(typing)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Throttled to one relay per user, never stored
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	if persister.MessageCount() != 0 {
+		t.Errorf("typing indicator was enqueued for storage")
+	}
+	got, err := message.FromJSON(hub.GetBroadcast(0))
+	if err != nil {
+		t.Fatalf("broadcast is not a message: %v", err)
+	}
+	if got.Type != message.TypeTyping || got.UserID != "user123" || got.Content != "" || got.ClientMessageID != "" {
+		t.Errorf("unexpected typing broadcast: %+v", got)
+	}
+	if got.ExpiresAt == nil || !got.ExpiresAt.After(time.Now()) {
+		t.Errorf("typing indicator expiresAt = %v, want a time in the future", got.ExpiresAt)
+	}
+
+	// No ack or nack either
+	ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := ws.ReadMessage(); err == nil {
+		t.Errorf("unexpected frame for a typing indicator: %s", data)
+	}
+}
+
+// mockAccess implements the RoomAccess interface, denying "secret" and
+// returning one backlog frame and one catch-up frame per room.
+type mockAccess struct {
+	mu            sync.Mutex
+	lastMessageID string
+}
+
+func (m *mockAccess) CanReadRoom(userID, roomID string) bool {
+	return roomID != "secret"
+}
+
+func (m *mockAccess) Backlog(roomID, lastMessageID string, since time.Time) ([][]byte, func() [][]byte) {
+	m.mu.Lock()
+	m.lastMessageID = lastMessageID
+	m.mu.Unlock()
+	backlog := [][]byte{[]byte(`{"type":"chat","messageId":"backlog-` + roomID + `","roomId":"` + roomID + `"}`)}
+	return backlog, func() [][]byte {
+		return [][]byte{[]byte(`{"type":"chat","messageId":"catchup-` + roomID + `","roomId":"` + roomID + `"}`)}
+	}
+}
+
+func TestClient_Subscriptions(t *testing.T) {
+	hub := newMockHub()
+	access := &mockAccess{}
+	ws := dialEditClient(t, hub, "user123", nil, nil, func(c *Client) {
+		c.SetRoom("lobby")
+		c.SetRoomAccess(access)
+	})
+	send := func(frame string) {
+		t.Helper()
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+
+	// The backlog and its catch-up are replayed ahead of the ack
+	send(`{"type":"subscribe","roomId":"games","lastMessageId":"m1"}`)
+	got := readAcks(t, ws, 3)
+	if got[0].Type != message.TypeChat || got[0].MessageID != "backlog-games" {
+		t.Errorf("first frame = %+v, want the games backlog", got[0])
+	}
+	if got[1].Type != message.TypeChat || got[1].MessageID != "catchup-games" {
+		t.Errorf("second frame = %+v, want the games catch-up", got[1])
+	}
+	if got[2].Type != message.TypeAck || got[2].RoomID != "games" {
+		t.Errorf("third frame = %+v, want an ack for games", got[2])
+	}
+	access.mu.Lock()
+	if access.lastMessageID != "m1" {
+		t.Errorf("backlog resumed after %q, want m1", access.lastMessageID)
+	}
+	access.mu.Unlock()
+
+	// Frames go to the room they name, or the connect room if none
+	send(`{"type":"chat","roomId":"games","content":"gg"}`)
+	send(`{"type":"chat","content":"hi"}`)
+	got = readAcks(t, ws, 2)
+	if got[0].RoomID != "games" || got[1].RoomID != "lobby" {
+		t.Errorf("acks for rooms %q and %q, want games and lobby", got[0].RoomID, got[1].RoomID)
+	}
+
+	send(`{"type":"subscribe","roomId":"secret"}`)
+	if nack := readAcks(t, ws, 1)[0]; nack.Code != message.CodeForbidden {
+		t.Errorf("subscribe to secret = %+v, want forbidden", nack)
+	}
+
+	send(`{"type":"unsubscribe","roomId":"games"}`)
+	send(`{"type":"chat","roomId":"games","content":"still here?"}`)
+	got = readAcks(t, ws, 2)
+	if got[0].Type != message.TypeAck || got[1].Code != message.CodeNotSubscribed {
+		t.Errorf("after unsubscribe got %+v, %+v; want ack then not_subscribed", got[0], got[1])
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if strings.Join(hub.subscriptions, ",") != "+games,-games" {
+		t.Errorf("hub subscriptions = %v, want +games,-games", hub.subscriptions)
+	}
+	if strings.Join(hub.broadcastRooms, ",") != "games,lobby" {
+		t.Errorf("broadcast to rooms %v, want games,lobby", hub.broadcastRooms)
+	}
+}
+
+func TestClient_SubscriptionLimit(t *testing.T) {
+	hub := newMockHub()
+	ws := dialEditClient(t, hub, "user123", nil, nil)
+
+	// The connect room counts towards the limit
+	for i := 1; i < maxSubscriptions; i++ {
+		frame := fmt.Sprintf(`{"type":"subscribe","roomId":"room-%d"}`, i)
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	for _, ack := range readAcks(t, ws, maxSubscriptions-1) {
+		if ack.Type != message.TypeAck {
+			t.Fatalf("subscribe within the limit got %+v", ack)
+		}
+	}
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"subscribe","roomId":"one-too-many"}`)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	if nack := readAcks(t, ws, 1)[0]; nack.Code != message.CodeTooManyRooms {
+		t.Errorf("got %+v, want too_many_rooms", nack)
+	}
+}
+
+func TestClient_DirectMessage(t *testing.T) {
+	hub := newMockHub()
+	persister := newMockPersister()
+	dedupe := idempotency.New(time.Minute, nil)
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetDeduper(dedupe) })
+
+	// A client-supplied room is ignored, and a resend is only acked
+	send := `{"type":"direct","recipientId":"bob","roomId":"lobby","content":"psst","clientMessageId":"d1"}`
+	for i := 0; i < 2; i++ {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	acks := readAcks(t, ws, 2)
+
+	conversation := message.ConversationID("user123", "bob")
+	if acks[0].Type != message.TypeAck || acks[0].RoomID != conversation {
+		t.Errorf("ack = %+v, want an ack for %s", acks[0], conversation)
+	}
+	if !acks[1].Duplicate || acks[1].MessageID != acks[0].MessageID {
+		t.Errorf("resend ack = %+v, want a duplicate of %s", acks[1], acks[0].MessageID)
+	}
+	if hub.BroadcastCount() != 0 {
+		t.Errorf("direct message was broadcast to a room")
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(hub.directs) != 1 || strings.Join(hub.directUsers[0], ",") != "user123,bob" {
+		t.Fatalf("direct sends = %v, want one to user123 and bob", hub.directUsers)
+	}
+	got, _ := message.FromJSON(hub.directs[0])
+	if got.RoomID != conversation || got.RecipientID != "bob" || got.UserID != "user123" {
+		t.Errorf("unexpected direct message: %+v", got)
+	}
+	if stored := persister.GetMessage(0); stored == nil || stored.RoomID != conversation {
+		t.Errorf("stored %+v, want it under %s", stored, conversation)
+	}
+}
+
+func TestClient_DirectMessageUpdates(t *testing.T) {
+	hub := newMockHub()
+	repo := storage.NewMemoryRepository(newTestLogger())
+	defer repo.Close()
+	conversation := message.ConversationID("user123", "bob")
+	dm := &message.Message{MessageID: "d1", RoomID: conversation, Type: message.TypeDirect, UserID: "user123", RecipientID: "bob", Content: "psst", Timestamp: time.Now().UTC()}
+	if err := repo.SaveMessage(context.Background(), dm); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	ws := dialEditClient(t, hub, "user123", repo, nil, func(c *Client) {
+		c.SetReactor(repo)
+		c.SetDeleter(repo)
+	})
+
+	// The user's own conversation needs no subscription; others' do
+	sends := []string{
+		`{"type":"edit","roomId":"` + conversation + `","targetId":"d1","content":"psst!"}`,
+		`{"type":"reaction","roomId":"` + conversation + `","targetId":"d1","emoji":"👀"}`,
+		`{"type":"delete","roomId":"` + conversation + `","targetId":"d1"}`,
+		`{"type":"edit","roomId":"` + message.ConversationID("alice", "bob") + `","targetId":"d1","content":"snoop"}`,
+		`{"type":"chat","roomId":"` + conversation + `","content":"hi"}`,
+	}
+	for _, send := range sends {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	acks := readAcks(t, ws, len(sends))
+
+	for i, kind := range []string{"edit", "reaction", "delete"} {
+		if acks[i].Type != message.TypeAck || acks[i].RoomID != conversation {
+			t.Errorf("%s in a DM: got %+v, want an ack", kind, acks[i])
+		}
+	}
+	if acks[3].Type != message.TypeNack || acks[3].Code != message.CodeNotSubscribed {
+		t.Errorf("edit in another conversation: got %+v, want a not_subscribed nack", acks[3])
+	}
+	if acks[4].Type != message.TypeNack || acks[4].Code != message.CodeInvalidType {
+		t.Errorf("chat frame to a conversation: got %+v, want an invalid_type nack", acks[4])
+	}
+
+	stored, err := repo.GetMessage(context.Background(), conversation, "d1")
+	if err != nil || !stored.Deleted {
+		t.Errorf("expected the DM to be deleted, got %+v, %v", stored, err)
+	}
+
+	// Nobody subscribes to a conversation, so updates go to its participants
+	if hub.BroadcastCount() != 0 {
+		t.Errorf("DM updates were broadcast to a room")
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(hub.directs) != 3 {
+		t.Fatalf("direct sends = %d, want 3", len(hub.directs))
+	}
+	for i, want := range []message.Type{message.TypeEdit, message.TypeReaction, message.TypeDelete} {
+		got, _ := message.FromJSON(hub.directs[i])
+		if got.Type != want || strings.Join(hub.directUsers[i], ",") != "bob,user123" {
+			t.Errorf("direct send %d = %s to %v, want %s to bob and user123", i, got.Type, hub.directUsers[i], want)
+		}
+	}
+}
+
+func TestClient_Replies(t *testing.T) {
+	hub := newMockHub()
+	repo := storage.NewMemoryRepository(newTestLogger())
+	defer repo.Close()
+	ctx := context.Background()
+	root := &message.Message{MessageID: "m1", RoomID: "global", Type: message.TypeChat, UserID: "bob", Username: "Bob", Content: "lunch?", Timestamp: time.Now().UTC()}
+	reply := &message.Message{MessageID: "r1", RoomID: "global", Type: message.TypeChat, UserID: "bob", Username: "Bob", Content: "noon", ParentMessageID: "m1", Timestamp: time.Now().UTC()}
+	if err := repo.BatchSaveMessages(ctx, []*message.Message{root, reply}); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	ws := dialEditClient(t, hub, "user123", nil, nil, func(c *Client) { c.SetFinder(repo) })
+
+	sends := []string{
+		`{"type":"chat","parentMessageId":"m1","content":"count me in"}`,
+		`{"type":"chat","parentMessageId":"r1","content":"nested"}`,
+		`{"type":"chat","parentMessageId":"missing","content":"orphan"}`,
+	}
+	for _, send := range sends {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	acks := readAcks(t, ws, 3)
+
+	if acks[0].Type != message.TypeAck {
+		t.Errorf("reply to a root message: got %+v, want an ack", acks[0])
+	}
+	if acks[1].Type != message.TypeNack || acks[1].Code != message.CodeInvalidParent {
+		t.Errorf("reply to a reply: got %+v, want an invalid_parent nack", acks[1])
+	}
+	if acks[2].Type != message.TypeNack || acks[2].Code != message.CodeNotFound {
+		t.Errorf("reply to a missing message: got %+v, want a not_found nack", acks[2])
+	}
+
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	got, _ := message.FromJSON(hub.GetBroadcast(0))
+	if got.ParentMessageID != "m1" {
+		t.Errorf("broadcast reply = %+v, want parent m1", got)
+	}
+}
+
+func TestClient_ReadMarkers(t *testing.T) {
+	hub := newMockHub()
+	repo := storage.NewMemoryRepository(newTestLogger())
+	defer repo.Close()
+	base := time.Now().UTC()
+	for i, id := range []string{"m1", "m2"} {
+		msg := &message.Message{MessageID: id, RoomID: "global", Type: message.TypeChat, UserID: "bob", Username: "Bob", Content: id, Timestamp: base.Add(time.Duration(i) * time.Second)}
+		if err := repo.SaveMessage(context.Background(), msg); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetReadTracker(repo) })
+
+	// Only the first read moves the marker; reading back is a no-op.
+	sends := []string{
+		`{"type":"read","targetId":"m2"}`,
+		`{"type":"read","targetId":"m1"}`,
+		`{"type":"read","targetId":"missing"}`,
+		`{"type":"read"}`,
+	}
+	for _, send := range sends {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	acks := readAcks(t, ws, 4)
+
+	if acks[0].Type != message.TypeAck || acks[0].TargetID != "m2" || acks[1].Type != message.TypeAck {
+		t.Errorf("reads: got %+v and %+v, want acks", acks[0], acks[1])
+	}
+	if acks[2].Type != message.TypeNack || acks[2].Code != message.CodeNotFound {
+		t.Errorf("read of a missing message: got %+v, want a not_found nack", acks[2])
+	}
+	if acks[3].Type != message.TypeNack || acks[3].Code != message.CodeMissingTarget {
+		t.Errorf("read without target: got %+v, want a missing_target nack", acks[3])
+	}
+
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	got, _ := message.FromJSON(hub.GetBroadcast(0))
+	if got.Type != message.TypeRead || got.TargetID != "m2" || got.UserID != "user123" {
+		t.Errorf("unexpected read broadcast: %+v", got)
+	}
+	if n, _ := repo.CountUnread(context.Background(), "user123", "global"); n != 0 {
+		t.Errorf("expected nothing unread, got %d", n)
+	}
+	if persister.MessageCount() != 0 {
+		t.Errorf("expected read frames not to be enqueued, got %d", persister.MessageCount())
+	}
+}
+
+func TestClient_Pins(t *testing.T) {
+	repo := storage.NewMemoryRepository(newTestLogger())
+	defer repo.Close()
+	if err := repo.CreateRoom(context.Background(), &room.Room{ID: "global", Name: "Global", CreatedAt: time.Now().UTC()}); err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	target := &message.Message{MessageID: "m1", RoomID: "global", Type: message.TypeChat, UserID: "bob", Username: "Bob", Content: "release at 5", Timestamp: time.Now().UTC()}
+	if err := repo.SaveMessage(context.Background(), target); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	t.Run("moderator", func(t *testing.T) {
+		hub := newMockHub()
+		persister := newMockPersister()
+		ws := dialEditClient(t, hub, "mod", nil, persister, func(c *Client) {
+			c.SetPinner(repo)
+			c.SetModerator(true)
+		})
+
+		// Pinning twice changes the pins once.
+		sends := []string{
+			`{"type":"pin","targetId":"m1"}`,
+			`{"type":"pin","targetId":"m1"}`,
+			`{"type":"pin","targetId":"missing"}`,
+			`{"type":"unpin","targetId":"m1"}`,
+		}
+		for _, send := range sends {
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+				t.Fatalf("write error: %v", err)
+			}
+		}
+		acks := readAcks(t, ws, 4)
+
+		if acks[0].Type != message.TypeAck || acks[1].Type != message.TypeAck || acks[3].Type != message.TypeAck {
+			t.Errorf("pins: got %+v, want acks", acks)
+		}
+		if acks[2].Type != message.TypeNack || acks[2].Code != message.CodeNotFound {
+			t.Errorf("pin of a missing message: got %+v, want a not_found nack", acks[2])
+		}
+
+		if hub.BroadcastCount() != 2 {
+			t.Fatalf("expected 2 broadcasts, got %d", hub.BroadcastCount())
+		}
+		pinned, _ := message.FromJSON(hub.GetBroadcast(0))
+		if pinned.Type != message.TypePin || len(pinned.Pins) != 1 || pinned.Pins[0].MessageID != "m1" || pinned.Pins[0].PinnedBy != "mod" {
+			t.Errorf("unexpected pin broadcast: %+v", pinned)
+		}
+		unpinned, _ := message.FromJSON(hub.GetBroadcast(1))
+		if unpinned.Type != message.TypeUnpin || unpinned.TargetID != "m1" || len(unpinned.Pins) != 0 {
+			t.Errorf("unexpected unpin broadcast: %+v", unpinned)
+		}
+		if persister.MessageCount() != 0 {
+			t.Errorf("expected pin frames not to be enqueued, got %d", persister.MessageCount())
+		}
+	})
+
+	t.Run("not a moderator", func(t *testing.T) {
+		hub := newMockHub()
+		ws := dialEditClient(t, hub, "user123", nil, nil, func(c *Client) { c.SetPinner(repo) })
+
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"type":"pin","targetId":"m1"}`)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+		acks := readAcks(t, ws, 1)
+		if acks[0].Type != message.TypeNack || acks[0].Code != message.CodeForbidden {
+			t.Errorf("pin by a non-moderator: got %+v, want a forbidden nack", acks[0])
+		}
+		if hub.BroadcastCount() != 0 {
+			t.Errorf("expected no broadcast, got %d", hub.BroadcastCount())
+		}
+	})
+}
+
+func TestClient_Attachments(t *testing.T) {
+	hub := newMockHub()
+	blobs, err := blob.OpenLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenLocalStore: %v", err)
+	}
+	limits := blob.Limits{MaxSize: 1024, AllowedTypes: []string{"text/plain"}}
+	own, err := blob.Save(context.Background(), blobs, limits, strings.NewReader("agenda"), "agenda.txt", "user123")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	other, err := blob.Save(context.Background(), blobs, limits, strings.NewReader("secret"), "secret.txt", "bob")
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	ws := dialEditClient(t, hub, "user123", nil, newMockPersister(), func(c *Client) { c.SetBlobFinder(blobs) })
+
+	// Clients name attachments by ID; anything else they send is replaced.
+	sends := []string{
+		`{"type":"chat","attachments":[{"id":"` + own.ID + `","size":1}]}`,
+		`{"type":"chat","content":"look","attachments":[{"id":"` + other.ID + `"}]}`,
+		`{"type":"chat","content":"look","attachments":[{"id":"missing"}]}`,
+	}
+	for _, send := range sends {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	acks := readAcks(t, ws, 3)
+
+	if acks[0].Type != message.TypeAck {
+		t.Errorf("own attachment: got %+v, want an ack", acks[0])
+	}
+	if acks[1].Type != message.TypeNack || acks[1].Code != message.CodeForbidden {
+		t.Errorf("another user's upload: got %+v, want a forbidden nack", acks[1])
+	}
+	if acks[2].Type != message.TypeNack || acks[2].Code != message.CodeNotFound {
+		t.Errorf("missing upload: got %+v, want a not_found nack", acks[2])
+	}
+
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	got, _ := message.FromJSON(hub.GetBroadcast(0))
+	want := message.Attachment{ID: own.ID, Filename: "agenda.txt", ContentType: "text/plain; charset=utf-8", Size: 6}
+	if len(got.Attachments) != 1 || got.Attachments[0] != want {
+		t.Errorf("broadcast attachments = %+v, want [%+v]", got.Attachments, want)
+	}
+	if b, _ := blobs.Stat(context.Background(), own.ID); !b.AttachedTo(got.RoomID) {
+		t.Errorf("blob rooms = %v, want it attached in %s", b.RoomIDs, got.RoomID)
+	}
+	if b, _ := blobs.Stat(context.Background(), other.ID); len(b.RoomIDs) != 0 {
+		t.Errorf("rejected attachment was recorded in %v", b.RoomIDs)
+	}
+}
+
+// mockResolver resolves handles from a fixed table, dropping the rest.
+type mockResolver map[string]string
+
+func (m mockResolver) ResolveMentions(roomID string, handles []string) []string {
+	var userIDs []string
+	for _, h := range handles {
+		if id, ok := m[h]; ok {
+			userIDs = append(userIDs, id)
+		}
+	}
+	return userIDs
+}
+
+func TestClient_Mentions(t *testing.T) {
+	hub := newMockHub()
+	persister := newMockPersister()
+	resolver := mockResolver{"Alice": "alice", "al": "alice", "me": "user123"}
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetMentionResolver(resolver) })
+
+	// Client-supplied mentions are ignored; self-mentions, repeats and
+	// unknown handles are dropped.
+	sends := []string{
+		`{"type":"chat","content":"@Alice @al @me @ghost lunch?","mentions":["bob"]}`,
+		`{"type":"chat","content":"mail me at a@b.com","mentions":["bob"]}`,
+	}
+	for _, send := range sends {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	readAcks(t, ws, 2)
+
+	if got := persister.GetMessage(0); got == nil || strings.Join(got.Mentions, ",") != "alice" {
+		t.Errorf("stored mentions = %+v, want [alice]", got)
+	}
+	if got := persister.GetMessage(1); got == nil || got.Mentions != nil {
+		t.Errorf("stored mentions = %+v, want none", got)
+	}
+	chat, _ := message.FromJSON(hub.GetBroadcast(0))
+	if strings.Join(chat.Mentions, ",") != "alice" {
+		t.Errorf("broadcast mentions = %v, want [alice]", chat.Mentions)
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if len(hub.directs) != 1 || strings.Join(hub.directUsers[0], ",") != "alice" {
+		t.Fatalf("mention sends = %v, want one to alice", hub.directUsers)
+	}
+	notice, _ := message.FromJSON(hub.directs[0])
+	if notice.Type != message.TypeMention || notice.TargetID != chat.MessageID || notice.RoomID != "global" ||
+		notice.Content != chat.Content || notice.MessageID == "" || notice.MessageID == chat.MessageID {
+		t.Errorf("unexpected mention frame: %+v", notice)
+	}
+}
+
+func TestClient_Reactions(t *testing.T) {
+	hub := newMockHub()
+	repo := storage.NewMemoryRepository(newTestLogger())
+	defer repo.Close()
+	target := &message.Message{MessageID: "m1", RoomID: "global", Type: message.TypeChat, UserID: "bob", Username: "Bob", Content: "shipped", Timestamp: time.Now().UTC()}
+	if err := repo.SaveMessage(context.Background(), target); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	persister := newMockPersister()
+	ws := dialEditClient(t, hub, "user123", nil, persister, func(c *Client) { c.SetReactor(repo) })
+
+	sends := []string{
+		`{"type":"reaction","targetId":"m1","emoji":"🎉"}`,
+		`{"type":"reaction","targetId":"missing","emoji":"🎉"}`,
+		`{"type":"reaction","targetId":"m1"}`,
+	}
+	for _, send := range sends {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(send)); err != nil {
+			t.Fatalf("write error: %v", err)
+		}
+	}
+	acks := readAcks(t, ws, 3)
+
+	if acks[0].Type != message.TypeAck || acks[0].TargetID != "m1" {
+		t.Errorf("reaction: got %+v, want an ack for m1", acks[0])
+	}
+	if acks[1].Type != message.TypeNack || acks[1].Code != message.CodeNotFound {
+		t.Errorf("reaction to a missing message: got %+v, want a not_found nack", acks[1])
+	}
+	if acks[2].Type != message.TypeNack || acks[2].Code != message.CodeMissingEmoji {
+		t.Errorf("reaction without emoji: got %+v, want a missing_emoji nack", acks[2])
+	}
+
+	if hub.BroadcastCount() != 1 {
+		t.Fatalf("expected 1 broadcast, got %d", hub.BroadcastCount())
+	}
+	got, _ := message.FromJSON(hub.GetBroadcast(0))
+	if got.Type != message.TypeReaction || got.Emoji != "🎉" || len(got.Reactions) != 1 || got.Reactions[0].Count != 1 || got.Reactions[0].UserIDs[0] != "user123" {
+		t.Errorf("unexpected reaction broadcast: %+v", got)
+	}
+	// Reactions update the target; they are not stored as messages.
+	if persister.MessageCount() != 0 {
+		t.Errorf("expected reaction not to be enqueued, got %d", persister.MessageCount())
+	}
+}
@@ -4,6 +4,14 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+)
+
+// Storage drivers selectable via STORAGE_DRIVER.
+const (
+	StorageDynamoDB = "dynamodb"
+	StorageMemory   = "memory"
+	StorageBolt     = "bolt"
 )
 
 // Config holds all configuration for the application
@@ -15,6 +23,12 @@ type Config struct {
 	AWSSecretKey     string
 	LogLevel         string
 
+	// StorageDriver selects the message repository backend: "dynamodb"
+	// (default), "bolt" (embedded file at BoltPath) or "memory" (non-durable,
+	// for development and tests).
+	StorageDriver string
+	BoltPath      string
+
 	// AllowedOrigins is the CORS/WebSocket origin allowlist. A single "*"
 	// entry allows all origins (development default).
 	AllowedOrigins []string
@@ -23,6 +37,18 @@ type Config struct {
 	// falls back to the userId query parameter (development only).
 	AuthSecret string
 
+	// RetentionDays is how long messages are kept (0 keeps them forever).
+	// RoomRetentionDays overrides it per room, from "room=days" pairs.
+	RetentionDays     int
+	RoomRetentionDays map[string]int
+
+	// Moderators lists the user IDs allowed to delete other users' messages.
+	Moderators []string
+
+	// IdempotencyWindow is how long a client message ID is remembered, so a
+	// resend within it is recognized as a duplicate.
+	IdempotencyWindow time.Duration
+
 	// RateLimitPerSec / RateLimitBurst tune the per-connection token bucket.
 	// RateLimitPerSec <= 0 disables rate limiting.
 	RateLimitPerSec float64
@@ -32,6 +58,27 @@ type Config struct {
 	PersistWorkers   int
 	PersistBatchSize int
 	PersistQueueSize int
+
+	// PersistWALDir enables the persistence write-ahead log in that
+	// directory, so accepted messages survive a full queue, failed writes
+	// and crashes. Empty disables it.
+	PersistWALDir string
+
+	// Failed persistence batches are retried PersistMaxRetries times with
+	// jittered exponential backoff between the two delays, then
+	// dead-lettered to PersistDeadLetterPath (a JSON-lines file; empty keeps
+	// dead letters in memory, or in the WAL directory when one is set).
+	PersistMaxRetries     int
+	PersistRetryBaseDelay time.Duration
+	PersistRetryMaxDelay  time.Duration
+	PersistDeadLetterPath string
+
+	// UploadDir is where uploaded blobs are kept; empty disables uploads.
+	// Uploads over UploadMaxBytes, or whose sniffed content type is not in
+	// UploadAllowedTypes, are refused.
+	UploadDir          string
+	UploadMaxBytes     int
+	UploadAllowedTypes []string
 }
 
 // Load reads configuration from environment variables
@@ -43,9 +90,17 @@ func Load() *Config {
 		AWSAccessKey:     getEnv("AWS_ACCESS_KEY_ID", "dummy"),
 		AWSSecretKey:     getEnv("AWS_SECRET_ACCESS_KEY", "dummy"),
 		LogLevel:         getEnv("LOG_LEVEL", "info"),
+		StorageDriver:    getEnv("STORAGE_DRIVER", StorageDynamoDB),
+		BoltPath:         getEnv("BOLT_PATH", "data/chat.db"),
 
 		AllowedOrigins: getEnvCSV("ALLOWED_ORIGINS", []string{"*"}),
 		AuthSecret:     getEnv("AUTH_SECRET", ""),
+		Moderators:     getEnvCSV("MODERATORS", nil),
+
+		IdempotencyWindow: getEnvDuration("IDEMPOTENCY_WINDOW", 10*time.Minute),
+
+		RetentionDays:     getEnvInt("RETENTION_DAYS", 0),
+		RoomRetentionDays: getEnvIntMap("ROOM_RETENTION_DAYS"),
 
 		RateLimitPerSec: getEnvFloat("RATE_LIMIT_PER_SEC", 5),
 		RateLimitBurst:  getEnvFloat("RATE_LIMIT_BURST", 10),
@@ -53,6 +108,18 @@ func Load() *Config {
 		PersistWorkers:   getEnvInt("PERSIST_WORKERS", 4),
 		PersistBatchSize: getEnvInt("PERSIST_BATCH_SIZE", 25),
 		PersistQueueSize: getEnvInt("PERSIST_QUEUE_SIZE", 1024),
+		PersistWALDir:    getEnv("PERSIST_WAL_DIR", ""),
+
+		PersistMaxRetries:     getEnvInt("PERSIST_MAX_RETRIES", 3),
+		PersistRetryBaseDelay: getEnvDuration("PERSIST_RETRY_BASE_DELAY", 100*time.Millisecond),
+		PersistRetryMaxDelay:  getEnvDuration("PERSIST_RETRY_MAX_DELAY", 5*time.Second),
+		PersistDeadLetterPath: getEnv("PERSIST_DEAD_LETTER_PATH", ""),
+
+		UploadDir:      getEnv("UPLOAD_DIR", ""),
+		UploadMaxBytes: getEnvInt("UPLOAD_MAX_BYTES", 10<<20),
+		UploadAllowedTypes: getEnvCSV("UPLOAD_ALLOWED_TYPES", []string{
+			"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain",
+		}),
 	}
 }
 
@@ -86,6 +153,17 @@ func getEnvFloat(key string, defaultValue float64) float64 {
 	return defaultValue
 }
 
+// getEnvDuration reads a duration environment variable such as "250ms",
+// falling back on absence or a parse error.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
 // getEnvCSV reads a comma-separated environment variable into a trimmed slice,
 // falling back when unset or empty.
 func getEnvCSV(key string, defaultValue []string) []string {
@@ -105,3 +183,25 @@ func getEnvCSV(key string, defaultValue []string) []string {
 	}
 	return out
 }
+
+// getEnvIntMap reads a comma-separated list of key=int pairs, skipping
+// malformed entries. Returns nil when unset.
+func getEnvIntMap(key string) map[string]int {
+	pairs := getEnvCSV(key, nil)
+	if len(pairs) == 0 {
+		return nil
+	}
+	out := make(map[string]int, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			continue
+		}
+		out[strings.TrimSpace(k)] = n
+	}
+	return out
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoad_DefaultValues(t *testing.T) {
@@ -138,3 +139,91 @@ func TestGetEnv_EmptyString(t *testing.T) {
 		t.Errorf("getEnv() with empty string = %q, want %q", result, "default")
 	}
 }
+
+func TestLoad_StorageDriver(t *testing.T) {
+	os.Unsetenv("STORAGE_DRIVER")
+	if got := Load().StorageDriver; got != StorageDynamoDB {
+		t.Errorf("StorageDriver default = %q, want %q", got, StorageDynamoDB)
+	}
+	if got := Load().BoltPath; got != "data/chat.db" {
+		t.Errorf("BoltPath default = %q, want %q", got, "data/chat.db")
+	}
+
+	os.Setenv("STORAGE_DRIVER", StorageMemory)
+	defer os.Unsetenv("STORAGE_DRIVER")
+	if got := Load().StorageDriver; got != StorageMemory {
+		t.Errorf("StorageDriver = %q, want %q", got, StorageMemory)
+	}
+}
+
+func TestLoad_Moderators(t *testing.T) {
+	os.Unsetenv("MODERATORS")
+	if got := Load().Moderators; len(got) != 0 {
+		t.Errorf("Moderators default = %v, want none", got)
+	}
+
+	os.Setenv("MODERATORS", "alice, bob")
+	defer os.Unsetenv("MODERATORS")
+	got := Load().Moderators
+	if len(got) != 2 || got[0] != "alice" || got[1] != "bob" {
+		t.Errorf("Moderators = %v, want [alice bob]", got)
+	}
+}
+
+func TestLoad_Uploads(t *testing.T) {
+	cfg := Load()
+	if cfg.UploadDir != "" || cfg.UploadMaxBytes != 10<<20 || len(cfg.UploadAllowedTypes) != 6 {
+		t.Errorf("upload defaults = %q, %d, %v", cfg.UploadDir, cfg.UploadMaxBytes, cfg.UploadAllowedTypes)
+	}
+
+	os.Setenv("UPLOAD_MAX_BYTES", "1024")
+	os.Setenv("UPLOAD_ALLOWED_TYPES", "image/png")
+	defer func() {
+		os.Unsetenv("UPLOAD_MAX_BYTES")
+		os.Unsetenv("UPLOAD_ALLOWED_TYPES")
+	}()
+	cfg = Load()
+	if cfg.UploadMaxBytes != 1024 || len(cfg.UploadAllowedTypes) != 1 || cfg.UploadAllowedTypes[0] != "image/png" {
+		t.Errorf("upload settings = %d, %v", cfg.UploadMaxBytes, cfg.UploadAllowedTypes)
+	}
+}
+
+func TestLoad_Retention(t *testing.T) {
+	os.Setenv("RETENTION_DAYS", "30")
+	os.Setenv("ROOM_RETENTION_DAYS", "lobby=7, archive=0, bogus, bad=x")
+	defer func() {
+		os.Unsetenv("RETENTION_DAYS")
+		os.Unsetenv("ROOM_RETENTION_DAYS")
+	}()
+
+	cfg := Load()
+	if cfg.RetentionDays != 30 {
+		t.Errorf("RetentionDays = %d, want 30", cfg.RetentionDays)
+	}
+	rooms := cfg.RoomRetentionDays
+	if len(rooms) != 2 || rooms["lobby"] != 7 || rooms["archive"] != 0 {
+		t.Errorf("RoomRetentionDays = %v, want map[archive:0 lobby:7]", rooms)
+	}
+}
+
+func TestLoad_PersistRetry(t *testing.T) {
+	os.Setenv("PERSIST_MAX_RETRIES", "5")
+	os.Setenv("PERSIST_RETRY_BASE_DELAY", "250ms")
+	os.Setenv("PERSIST_RETRY_MAX_DELAY", "bogus")
+	defer func() {
+		os.Unsetenv("PERSIST_MAX_RETRIES")
+		os.Unsetenv("PERSIST_RETRY_BASE_DELAY")
+		os.Unsetenv("PERSIST_RETRY_MAX_DELAY")
+	}()
+
+	cfg := Load()
+	if cfg.PersistMaxRetries != 5 {
+		t.Errorf("PersistMaxRetries = %d, want 5", cfg.PersistMaxRetries)
+	}
+	if cfg.PersistRetryBaseDelay != 250*time.Millisecond {
+		t.Errorf("PersistRetryBaseDelay = %v, want 250ms", cfg.PersistRetryBaseDelay)
+	}
+	if cfg.PersistRetryMaxDelay != 5*time.Second {
+		t.Errorf("PersistRetryMaxDelay = %v, want default 5s", cfg.PersistRetryMaxDelay)
+	}
+}
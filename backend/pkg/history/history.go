@@ -0,0 +1,182 @@
+// Package history keeps the frames most recently broadcast to each room in a
+// bounded in-memory ring, so a reconnecting client can be sent exactly the
+// messages it missed without a round-trip to storage.
+package history
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// DefaultSize is the number of frames kept per room when no size is given.
+const DefaultSize = 200
+
+// frame is one broadcast frame and the fields needed to locate it.
+type frame struct {
+	id   string
+	at   time.Time
+	data []byte
+}
+
+// ring holds a room's newest frames, oldest first once unwrapped.
+type ring struct {
+	frames []frame
+	next   int  // slot the next frame is written to
+	full   bool // whether frames has wrapped
+
+	// evictedAt is the latest timestamp among frames pushed out of the ring;
+	// anything at or before it may be missing.
+	evictedAt time.Time
+
+	// recorded counts every frame ever recorded, for Mark.
+	recorded uint64
+}
+
+// Buffer is a per-room ring of recently broadcast frames. It is safe for
+// concurrent use.
+type Buffer struct {
+	size    int
+	started time.Time
+
+	mu    sync.Mutex
+	rooms map[string]*ring
+}
+
+// New creates a buffer keeping the newest size frames of every room
+// (DefaultSize if not positive).
+func New(size int) *Buffer {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Buffer{
+		size:    size,
+		started: time.Now().UTC(),
+		rooms:   make(map[string]*ring),
+	}
+}
+
+// Record adds a frame broadcast to roomID. Frames that are not JSON messages,
+// and ephemeral ones such as typing indicators, are ignored. Implements the
+// hub.Recorder interface.
+func (b *Buffer) Record(roomID string, data []byte) {
+	var envelope struct {
+		MessageID string       `json:"messageId"`
+		Type      message.Type `json:"type"`
+		Timestamp time.Time    `json:"timestamp"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.Type.Ephemeral() {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := b.rooms[roomID]
+	if r == nil {
+		r = &ring{frames: make([]frame, b.size)}
+		b.rooms[roomID] = r
+	}
+	if r.full {
+		if old := r.frames[r.next].at; old.After(r.evictedAt) {
+			r.evictedAt = old
+		}
+	}
+	r.frames[r.next] = frame{id: envelope.MessageID, at: envelope.Timestamp, data: data}
+	r.next = (r.next + 1) % b.size
+	if r.next == 0 {
+		r.full = true
+	}
+	r.recorded++
+}
+
+// Mark returns a position in roomID's frames, to pass to Recorded later.
+func (b *Buffer) Mark(roomID string) uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r := b.rooms[roomID]; r != nil {
+		return r.recorded
+	}
+	return 0
+}
+
+// Recorded returns the frames broadcast to roomID since mark was taken,
+// oldest first. ok is false if some of them have been pushed out of the
+// buffer.
+func (b *Buffer) Recorded(roomID string, mark uint64) (frames [][]byte, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r := b.rooms[roomID]
+	if r == nil || r.recorded <= mark {
+		return nil, true
+	}
+	held := b.ordered(roomID)
+	n := r.recorded - mark
+	if n > uint64(len(held)) {
+		return data(held), false
+	}
+	return data(held[uint64(len(held))-n:]), true
+}
+
+// After returns the frames broadcast to roomID after the one with messageID,
+// oldest first. ok is false if that frame is no longer (or was never) in the
+// buffer, in which case the caller must look elsewhere.
+func (b *Buffer) After(roomID, messageID string) (frames [][]byte, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	held := b.ordered(roomID)
+	for i := len(held) - 1; i >= 0; i-- {
+		if held[i].id == messageID {
+			return data(held[i+1:]), true
+		}
+	}
+	return nil, false
+}
+
+// Since returns the frames broadcast to roomID with a timestamp after since,
+// oldest first. ok is false unless the buffer is known to hold every such
+// frame: since must not precede the buffer's creation or any evicted frame.
+func (b *Buffer) Since(roomID string, since time.Time) (frames [][]byte, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if since.Before(b.started) {
+		return nil, false
+	}
+	if r := b.rooms[roomID]; r != nil && since.Before(r.evictedAt) {
+		return nil, false
+	}
+
+	var after []frame
+	for _, f := range b.ordered(roomID) {
+		if f.at.After(since) {
+			after = append(after, f)
+		}
+	}
+	return data(after), true
+}
+
+// ordered returns roomID's frames oldest first. Must be called with b.mu
+// held.
+func (b *Buffer) ordered(roomID string) []frame {
+	r := b.rooms[roomID]
+	if r == nil {
+		return nil
+	}
+	if !r.full {
+		return r.frames[:r.next]
+	}
+	return append(append([]frame(nil), r.frames[r.next:]...), r.frames[:r.next]...)
+}
+
+// data extracts the raw frames.
+func data(frames []frame) [][]byte {
+	if len(frames) == 0 {
+		return nil
+	}
+	out := make([][]byte, len(frames))
+	for i, f := range frames {
+		out[i] = f.data
+	}
+	return out
+}
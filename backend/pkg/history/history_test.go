@@ -0,0 +1,124 @@
+package history
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func frameJSON(id string, at time.Time) []byte {
+	return []byte(fmt.Sprintf(`{"messageId":%q,"timestamp":%q}`, id, at.Format(time.RFC3339Nano)))
+}
+
+func TestBuffer_After(t *testing.T) {
+	b := New(10)
+	base := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		b.Record("lobby", frameJSON(fmt.Sprintf("m%d", i), base.Add(time.Duration(i)*time.Second)))
+	}
+	b.Record("other", frameJSON("x", base))
+
+	frames, ok := b.After("lobby", "m2")
+	if !ok {
+		t.Fatal("After(m2) not found")
+	}
+	if len(frames) != 2 || string(frames[0]) != string(frameJSON("m3", base.Add(3*time.Second))) {
+		t.Errorf("After(m2) = %q, want m3 and m4", frames)
+	}
+
+	if frames, ok := b.After("lobby", "m4"); !ok || len(frames) != 0 {
+		t.Errorf("After(newest) = %d frames, %v; want none, true", len(frames), ok)
+	}
+	if _, ok := b.After("lobby", "x"); ok {
+		t.Error("found a message from another room")
+	}
+	if _, ok := b.After("empty", "m1"); ok {
+		t.Error("found a message in an unknown room")
+	}
+}
+
+func TestBuffer_AfterEvicted(t *testing.T) {
+	b := New(3)
+	base := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		b.Record("lobby", frameJSON(fmt.Sprintf("m%d", i), base.Add(time.Duration(i)*time.Second)))
+	}
+
+	if _, ok := b.After("lobby", "m1"); ok {
+		t.Error("evicted message was still found")
+	}
+	frames, ok := b.After("lobby", "m2")
+	if !ok || len(frames) != 2 {
+		t.Fatalf("After(m2) = %d frames, %v; want 2, true", len(frames), ok)
+	}
+	if string(frames[1]) != string(frameJSON("m4", base.Add(4*time.Second))) {
+		t.Errorf("frames out of order: %q", frames)
+	}
+}
+
+func TestBuffer_Recorded(t *testing.T) {
+	b := New(3)
+	base := time.Now().UTC()
+	empty := b.Mark("lobby")
+	b.Record("lobby", frameJSON("m0", base))
+	mark := b.Mark("lobby")
+
+	if frames, ok := b.Recorded("lobby", mark); !ok || len(frames) != 0 {
+		t.Errorf("Recorded(now) = %d frames, %v; want none, true", len(frames), ok)
+	}
+	for i := 1; i < 3; i++ {
+		b.Record("lobby", frameJSON(fmt.Sprintf("m%d", i), base.Add(time.Duration(i)*time.Second)))
+	}
+	b.Record("other", frameJSON("x", base))
+	frames, ok := b.Recorded("lobby", mark)
+	if !ok || len(frames) != 2 || string(frames[0]) != string(frameJSON("m1", base.Add(time.Second))) {
+		t.Errorf("Recorded(mark) = %q, %v; want m1 and m2", frames, ok)
+	}
+	if frames, ok := b.Recorded("lobby", empty); !ok || len(frames) != 3 {
+		t.Errorf("Recorded(before any frame) = %d frames, %v; want 3, true", len(frames), ok)
+	}
+
+	b.Record("lobby", frameJSON("m3", base.Add(3*time.Second)))
+	if _, ok := b.Recorded("lobby", empty); ok {
+		t.Error("Recorded reported complete frames after an eviction")
+	}
+}
+
+func TestBuffer_Since(t *testing.T) {
+	b := New(3)
+	base := time.Now().UTC()
+	for i := 1; i <= 3; i++ {
+		b.Record("lobby", frameJSON(fmt.Sprintf("m%d", i), base.Add(time.Duration(i)*time.Second)))
+	}
+
+	frames, ok := b.Since("lobby", base.Add(time.Second))
+	if !ok || len(frames) != 2 {
+		t.Fatalf("Since(m1) = %d frames, %v; want 2, true", len(frames), ok)
+	}
+	if frames, ok := b.Since("quiet", base); !ok || len(frames) != 0 {
+		t.Errorf("Since on a quiet room = %d frames, %v; want none, true", len(frames), ok)
+	}
+
+	// Before the buffer existed, storage may hold messages it never saw
+	if _, ok := b.Since("lobby", base.Add(-time.Hour)); ok {
+		t.Error("Since before the buffer started claimed to be complete")
+	}
+
+	// Evicting m1 makes anything up to its timestamp incomplete
+	b.Record("lobby", frameJSON("m4", base.Add(4*time.Second)))
+	if _, ok := b.Since("lobby", base.Add(500*time.Millisecond)); ok {
+		t.Error("Since across an evicted frame claimed to be complete")
+	}
+	if frames, ok := b.Since("lobby", base.Add(time.Second)); !ok || len(frames) != 3 {
+		t.Errorf("Since(m1) after eviction = %d frames, %v; want 3, true", len(frames), ok)
+	}
+}
+
+func TestBuffer_IgnoresMalformedAndEphemeralFrames(t *testing.T) {
+	b := New(3)
+	b.Record("lobby", []byte("not json"))
+	b.Record("lobby", []byte(`{"messageId":"t1","type":"typing","timestamp":"2030-01-01T00:00:00Z"}`))
+	if frames, _ := b.Since("lobby", time.Now()); len(frames) != 0 {
+		t.Errorf("unexpected frames recorded: %q", frames)
+	}
+}
@@ -2,10 +2,13 @@ package hub
 
 import (
 	"log/slog"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/epw80/chat-analytics-platform/pkg/analytics"
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/google/uuid"
 )
 
 // Client represents a connected WebSocket client
@@ -16,30 +19,89 @@ type Client interface {
 	ID() string
 	UserID() string
 	Username() string
+	// RoomID is the room the client joins when it registers; it may
+	// subscribe to more later.
 	RoomID() string
 }
 
+// Recorder keeps a copy of every frame broadcast to a room, e.g. so it can be
+// replayed to clients that missed it.
+type Recorder interface {
+	Record(roomID string, data []byte)
+}
+
+// Presence is a user connected to a room, counted once however many
+// connections (e.g. tabs) they have open.
+type Presence struct {
+	UserID      string    `json:"userId"`
+	Username    string    `json:"username"`
+	Connections int       `json:"connections"`
+	JoinedAt    time.Time `json:"joinedAt"`
+}
+
 // broadcastRequest carries a message destined for a single room.
 type broadcastRequest struct {
 	roomID string
 	data   []byte
 }
 
+// directRequest carries a message destined for every connection of a set of
+// users.
+type directRequest struct {
+	userIDs []string
+	data    []byte
+}
+
+// registerRequest adds a client to the hub and its room. onJoin, if set,
+// runs on the hub goroutine just before the client joins the room.
+type registerRequest struct {
+	client Client
+	onJoin func()
+}
+
+// subscriptionRequest adds a registered client to a room, or removes it.
+// onJoin is as for registerRequest.
+type subscriptionRequest struct {
+	client Client
+	roomID string
+	join   bool
+	onJoin func()
+}
+
 // Hub maintains active clients grouped by room and broadcasts each message
-// only to the clients in its originating room.
+// only to the clients in its originating room. A client may be in several
+// rooms at once. The hub announces a user to a room with a join event when
+// their first connection enters it, and with a leave event when their last
+// one leaves. Clients are also indexed by user, so direct messages reach
+// every connection a user has open whatever rooms they are in.
 type Hub struct {
-	// Registered clients grouped by room ID
+	// Clients grouped by room ID
 	rooms map[string]map[Client]bool
 
+	// Rooms each registered client is in
+	memberships map[Client]map[string]bool
+
+	// Registered clients grouped by user ID
+	users map[string]map[Client]bool
+
+	// Connected users grouped by room ID, then user ID
+	presence map[string]map[string]*Presence
+
 	// Inbound messages from clients, tagged with their destination room
 	broadcast chan broadcastRequest
 
+	// Direct messages, tagged with the users they are for
+	direct chan directRequest
+
 	// Register requests from clients
-	register chan Client
+	register chan registerRequest
 
 	// Unregister requests from clients
 	unregister chan Client
 
+	// Subscribe and unsubscribe requests from registered clients
+	subscription chan subscriptionRequest
+
 	// Mutex for thread-safe room map access
 	mu sync.RWMutex
 
@@ -51,6 +113,9 @@ type Hub struct {
 
 	// Optional analytics tracker (nil-safe)
 	analytics *analytics.Tracker
+
+	// Optional recorder of broadcast frames (nil-safe)
+	recorder Recorder
 }
 
 // SetAnalytics attaches an analytics tracker to the hub (optional).
@@ -58,15 +123,26 @@ func (h *Hub) SetAnalytics(t *analytics.Tracker) {
 	h.analytics = t
 }
 
+// SetRecorder attaches a recorder that sees every broadcast frame (optional).
+// Must be called before Run.
+func (h *Hub) SetRecorder(r Recorder) {
+	h.recorder = r
+}
+
 // New creates a new Hub instance
 func New(logger *slog.Logger) *Hub {
 	return &Hub{
-		rooms:      make(map[string]map[Client]bool),
-		broadcast:  make(chan broadcastRequest, 256),
-		register:   make(chan Client),
-		unregister: make(chan Client),
-		logger:     logger,
-		done:       make(chan struct{}),
+		rooms:        make(map[string]map[Client]bool),
+		memberships:  make(map[Client]map[string]bool),
+		users:        make(map[string]map[Client]bool),
+		presence:     make(map[string]map[string]*Presence),
+		broadcast:    make(chan broadcastRequest, 256),
+		direct:       make(chan directRequest, 256),
+		register:     make(chan registerRequest),
+		unregister:   make(chan Client),
+		subscription: make(chan subscriptionRequest),
+		logger:       logger,
+		done:         make(chan struct{}),
 	}
 }
 
@@ -77,86 +153,264 @@ func (h *Hub) Run() {
 
 	for {
 		select {
-		case client := <-h.register:
-			room := client.RoomID()
+		case req := <-h.register:
+			client := req.client
 			h.mu.Lock()
-			members := h.rooms[room]
-			if members == nil {
-				members = make(map[Client]bool)
-				h.rooms[room] = members
+			_, existed := h.memberships[client]
+			if !existed {
+				h.memberships[client] = make(map[string]bool)
+				conns := h.users[client.UserID()]
+				if conns == nil {
+					conns = make(map[Client]bool)
+					h.users[client.UserID()] = conns
+				}
+				conns[client] = true
 			}
-			_, existed := members[client]
-			members[client] = true
 			h.mu.Unlock()
 
 			if !existed && h.analytics != nil {
 				h.analytics.TrackConnect(client.ID(), client.UserID(), client.Username())
 			}
+			if req.onJoin != nil {
+				req.onJoin()
+			}
+			h.join(client, client.RoomID())
 
 			count := h.ClientCount()
 			h.logger.Info("client registered",
 				slog.String("clientID", client.ID()),
-				slog.String("roomID", room),
+				slog.String("roomID", client.RoomID()),
 				slog.Int("totalClients", count))
 
+		case req := <-h.subscription:
+			h.mu.RLock()
+			_, registered := h.memberships[req.client]
+			h.mu.RUnlock()
+			// A client that has already unregistered must not be added
+			// back: its send channel is closed.
+			if !registered {
+				break
+			}
+			if req.join {
+				if req.onJoin != nil {
+					req.onJoin()
+				}
+				h.join(req.client, req.roomID)
+			} else {
+				h.leave(req.client, req.roomID)
+			}
+			h.logger.Info("client subscription changed",
+				slog.String("clientID", req.client.ID()),
+				slog.String("roomID", req.roomID),
+				slog.Bool("subscribed", req.join))
+
 		case client := <-h.unregister:
-			room := client.RoomID()
+			h.mu.RLock()
+			rooms, registered := h.memberships[client]
+			joined := make([]string, 0, len(rooms))
+			for room := range rooms {
+				joined = append(joined, room)
+			}
+			h.mu.RUnlock()
+			if !registered {
+				break
+			}
+
+			for _, room := range joined {
+				h.leave(client, room)
+			}
 			h.mu.Lock()
-			removed := false
-			if members, ok := h.rooms[room]; ok {
-				if _, ok := members[client]; ok {
-					delete(members, client)
-					client.Close()
-					removed = true
-					if len(members) == 0 {
-						delete(h.rooms, room)
-					}
+			delete(h.memberships, client)
+			if conns := h.users[client.UserID()]; conns != nil {
+				delete(conns, client)
+				if len(conns) == 0 {
+					delete(h.users, client.UserID())
 				}
 			}
 			h.mu.Unlock()
+			client.Close()
 
-			if removed && h.analytics != nil {
+			if h.analytics != nil {
 				h.analytics.TrackDisconnect(client.ID(), client.UserID())
 			}
 
 			count := h.ClientCount()
 			h.logger.Info("client unregistered",
 				slog.String("clientID", client.ID()),
-				slog.String("roomID", room),
+				slog.Int("rooms", len(joined)),
 				slog.Int("totalClients", count))
 
 		case req := <-h.broadcast:
-			start := time.Now()
-			h.mu.RLock()
-			for client := range h.rooms[req.roomID] {
-				// Non-blocking send
-				// If client's send buffer is full, skip it
-				client.Send(req.data)
-			}
-			h.mu.RUnlock()
-			if h.analytics != nil {
-				h.analytics.TrackBroadcastLatency(time.Since(start))
-			}
+			h.deliver(req.roomID, req.data)
+
+		case req := <-h.direct:
+			h.deliverToUsers(req.userIDs, req.data)
 
 		case <-h.done:
 			h.logger.Info("hub shutting down")
 			h.mu.Lock()
-			for _, members := range h.rooms {
-				for client := range members {
-					client.Close()
-				}
+			for client := range h.memberships {
+				client.Close()
 			}
 			h.rooms = make(map[string]map[Client]bool)
+			h.memberships = make(map[Client]map[string]bool)
+			h.users = make(map[string]map[Client]bool)
+			h.presence = make(map[string]map[string]*Presence)
 			h.mu.Unlock()
 			return
 		}
 	}
 }
 
+// deliver records a frame and sends it to every client in the room. Runs on
+// the hub goroutine.
+func (h *Hub) deliver(roomID string, data []byte) {
+	start := time.Now()
+	// Record before delivering, so a frame is never delivered live and yet
+	// missing from a replay.
+	if h.recorder != nil {
+		h.recorder.Record(roomID, data)
+	}
+	h.mu.RLock()
+	for client := range h.rooms[roomID] {
+		// Non-blocking send
+		// If client's send buffer is full, skip it
+		client.Send(data)
+	}
+	h.mu.RUnlock()
+	if h.analytics != nil {
+		h.analytics.TrackBroadcastLatency(time.Since(start))
+	}
+}
+
+// deliverToUsers sends a frame to every connection of each user, once per
+// connection even if a user is listed twice. Runs on the hub goroutine.
+func (h *Hub) deliverToUsers(userIDs []string, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	sent := make(map[Client]bool)
+	for _, userID := range userIDs {
+		for client := range h.users[userID] {
+			if !sent[client] {
+				sent[client] = true
+				client.Send(data)
+			}
+		}
+	}
+}
+
+// join adds client to a room, announcing its user there if this is their
+// first connection in the room. Runs on the hub goroutine.
+func (h *Hub) join(client Client, room string) {
+	h.mu.Lock()
+	members := h.rooms[room]
+	if members == nil {
+		members = make(map[Client]bool)
+		h.rooms[room] = members
+	}
+	if members[client] {
+		h.mu.Unlock()
+		return
+	}
+	members[client] = true
+	h.memberships[client][room] = true
+	first := h.addPresence(room, client)
+	h.mu.Unlock()
+
+	if first {
+		h.announce(message.TypeJoin, room, client)
+	}
+}
+
+// leave removes client from a room, announcing its user's departure if this
+// was their last connection in the room. Runs on the hub goroutine.
+func (h *Hub) leave(client Client, room string) {
+	h.mu.Lock()
+	members := h.rooms[room]
+	if !members[client] {
+		h.mu.Unlock()
+		return
+	}
+	delete(members, client)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+	delete(h.memberships[client], room)
+	last := h.removePresence(room, client)
+	h.mu.Unlock()
+
+	if last {
+		h.announce(message.TypeLeave, room, client)
+	}
+}
+
+// announce delivers a join or leave event for client's user to the room.
+// Runs on the hub goroutine, so it delivers directly rather than through the
+// broadcast channel.
+func (h *Hub) announce(t message.Type, roomID string, client Client) {
+	msg := message.NewPresenceMessage(t, roomID, client.UserID(), client.Username())
+	msg.MessageID = uuid.NewString()
+	data, err := msg.ToJSON()
+	if err != nil {
+		h.logger.Error("failed to marshal presence event",
+			slog.String("roomID", roomID),
+			slog.String("error", err.Error()))
+		return
+	}
+	h.deliver(roomID, data)
+}
+
+// addPresence counts a new connection for client's user in room and reports
+// whether it is the user's first there. Must be called with h.mu held.
+func (h *Hub) addPresence(room string, client Client) bool {
+	users := h.presence[room]
+	if users == nil {
+		users = make(map[string]*Presence)
+		h.presence[room] = users
+	}
+	if p, ok := users[client.UserID()]; ok {
+		p.Connections++
+		return false
+	}
+	users[client.UserID()] = &Presence{
+		UserID:      client.UserID(),
+		Username:    client.Username(),
+		Connections: 1,
+		JoinedAt:    time.Now().UTC(),
+	}
+	return true
+}
+
+// removePresence drops a connection for client's user in room and reports
+// whether it was the user's last there. Must be called with h.mu held.
+func (h *Hub) removePresence(room string, client Client) bool {
+	users := h.presence[room]
+	p, ok := users[client.UserID()]
+	if !ok {
+		return false
+	}
+	if p.Connections--; p.Connections > 0 {
+		return false
+	}
+	delete(users, client.UserID())
+	if len(users) == 0 {
+		delete(h.presence, room)
+	}
+	return true
+}
+
 // Register adds a client to the hub
 func (h *Hub) Register(client any) {
+	h.RegisterWith(client, nil)
+}
+
+// RegisterWith adds a client to the hub like Register, first running onJoin
+// on the hub goroutine. No frame is delivered between onJoin and the client
+// joining its room, so onJoin can queue exactly the frames the client would
+// otherwise miss. onJoin must not block.
+func (h *Hub) RegisterWith(client any, onJoin func()) {
 	if c, ok := client.(Client); ok {
-		h.register <- c
+		h.register <- registerRequest{client: c, onJoin: onJoin}
 	}
 }
 
@@ -167,20 +421,53 @@ func (h *Hub) Unregister(client any) {
 	}
 }
 
+// Subscribe adds a registered client to another room. It has no effect if
+// the client is already in the room or is no longer registered.
+func (h *Hub) Subscribe(client any, roomID string) {
+	h.SubscribeWith(client, roomID, nil)
+}
+
+// SubscribeWith adds a registered client to another room like Subscribe,
+// first running onJoin on the hub goroutine as for RegisterWith. onJoin does
+// not run if the client is no longer registered.
+func (h *Hub) SubscribeWith(client any, roomID string, onJoin func()) {
+	if c, ok := client.(Client); ok {
+		h.subscription <- subscriptionRequest{client: c, roomID: roomID, join: true, onJoin: onJoin}
+	}
+}
+
+// Unsubscribe removes a client from a room. The client stays registered,
+// even if it is left in no rooms at all.
+func (h *Hub) Unsubscribe(client any, roomID string) {
+	if c, ok := client.(Client); ok {
+		h.subscription <- subscriptionRequest{client: c, roomID: roomID}
+	}
+}
+
 // Broadcast sends a message to all clients in the given room.
 func (h *Hub) Broadcast(roomID string, message []byte) {
 	h.broadcast <- broadcastRequest{roomID: roomID, data: message}
 }
 
-// ClientCount returns the total number of connected clients across all rooms.
+// SendToUsers sends a message to every connection of the given users,
+// whatever rooms they are in. Users with no connection open miss it.
+func (h *Hub) SendToUsers(data []byte, userIDs ...string) {
+	h.direct <- directRequest{userIDs: userIDs, data: data}
+}
+
+// UserConnectionCount returns the number of connections a user has open.
+func (h *Hub) UserConnectionCount(userID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.users[userID])
+}
+
+// ClientCount returns the total number of connected clients, counting a
+// client in several rooms once.
 func (h *Hub) ClientCount() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	total := 0
-	for _, members := range h.rooms {
-		total += len(members)
-	}
-	return total
+	return len(h.memberships)
 }
 
 // RoomCount returns the number of rooms with at least one connected client.
@@ -190,6 +477,33 @@ func (h *Hub) RoomCount() int {
 	return len(h.rooms)
 }
 
+// RoomMemberCount returns the number of distinct users connected to a room,
+// counting a user with several connections (e.g. tabs) once.
+func (h *Hub) RoomMemberCount(roomID string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.presence[roomID])
+}
+
+// RoomPresence returns the users connected to a room, earliest arrival
+// first.
+func (h *Hub) RoomPresence(roomID string) []Presence {
+	h.mu.RLock()
+	members := make([]Presence, 0, len(h.presence[roomID]))
+	for _, p := range h.presence[roomID] {
+		members = append(members, *p)
+	}
+	h.mu.RUnlock()
+
+	sort.Slice(members, func(i, j int) bool {
+		if !members[i].JoinedAt.Equal(members[j].JoinedAt) {
+			return members[i].JoinedAt.Before(members[j].JoinedAt)
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members
+}
+
 // Shutdown gracefully shuts down the hub
 func (h *Hub) Shutdown() {
 	close(h.done)
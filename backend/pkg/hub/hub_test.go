@@ -6,14 +6,18 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
 )
 
 // mockClient implements the Client interface for testing
 type mockClient struct {
 	id       string
+	userID   string // defaults to "user-<id>"
 	room     string
 	messages [][]byte
 	closed   bool
+	closes   int
 	mu       sync.Mutex
 }
 
@@ -29,6 +33,7 @@ func (m *mockClient) Close() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.closed = true
+	m.closes++
 }
 
 func (m *mockClient) ID() string {
@@ -36,6 +41,9 @@ func (m *mockClient) ID() string {
 }
 
 func (m *mockClient) UserID() string {
+	if m.userID != "" {
+		return m.userID
+	}
 	return "user-" + m.id
 }
 
@@ -47,10 +55,40 @@ func (m *mockClient) Username() string {
 	return "testuser"
 }
 
+// MessageCount returns the number of frames received, not counting the
+// hub's presence events.
 func (m *mockClient) MessageCount() int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return len(m.messages)
+	n := 0
+	for _, data := range m.messages {
+		if presenceEvent(data) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Presence returns the presence events received, in order.
+func (m *mockClient) Presence() []*message.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []*message.Message
+	for _, data := range m.messages {
+		if msg := presenceEvent(data); msg != nil {
+			events = append(events, msg)
+		}
+	}
+	return events
+}
+
+// presenceEvent decodes data if it is a join or leave event.
+func presenceEvent(data []byte) *message.Message {
+	msg, err := message.FromJSON(data)
+	if err != nil || (msg.Type != message.TypeJoin && msg.Type != message.TypeLeave) {
+		return nil
+	}
+	return msg
 }
 
 func (m *mockClient) IsClosed() bool {
@@ -403,3 +441,248 @@ func BenchmarkHub_RegisterUnregister(b *testing.B) {
 		hub.Unregister(client)
 	}
 }
+
+func TestHub_RoomMemberCount(t *testing.T) {
+	hub := newTestHub()
+	go hub.Run()
+	defer hub.Shutdown()
+
+	// Two tabs of the same user count once.
+	tab1 := newMockClientInRoom("tab1", "lobby")
+	tab2 := newMockClientInRoom("tab2", "lobby")
+	tab1.userID, tab2.userID = "alice", "alice"
+	hub.Register(tab1)
+	hub.Register(tab2)
+	hub.Register(newMockClientInRoom("bob", "lobby"))
+	hub.Register(newMockClientInRoom("carol", "other"))
+	time.Sleep(10 * time.Millisecond)
+
+	if n := hub.RoomMemberCount("lobby"); n != 2 {
+		t.Errorf("expected 2 lobby members, got %d", n)
+	}
+	if n := hub.RoomMemberCount("empty"); n != 0 {
+		t.Errorf("expected 0 members in an empty room, got %d", n)
+	}
+}
+
+// recordedFrame is a frame seen by a test recorder.
+type recordedFrame struct {
+	room string
+	data string
+}
+
+// mockRecorder collects recorded frames. Safe for concurrent use.
+type mockRecorder struct {
+	mu     sync.Mutex
+	frames []recordedFrame
+}
+
+func (m *mockRecorder) Record(roomID string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.frames = append(m.frames, recordedFrame{room: roomID, data: string(data)})
+}
+
+func TestHub_RecordsBroadcasts(t *testing.T) {
+	hub := newTestHub()
+	rec := &mockRecorder{}
+	hub.SetRecorder(rec)
+	go hub.Run()
+	defer hub.Shutdown()
+
+	// Frames are recorded even when nobody is connected to receive them
+	hub.Broadcast("lobby", []byte("a"))
+	hub.Broadcast("other", []byte("b"))
+	time.Sleep(10 * time.Millisecond)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	want := []recordedFrame{{"lobby", "a"}, {"other", "b"}}
+	if len(rec.frames) != len(want) {
+		t.Fatalf("recorded %v, want %v", rec.frames, want)
+	}
+	for i := range want {
+		if rec.frames[i] != want[i] {
+			t.Errorf("frame %d = %v, want %v", i, rec.frames[i], want[i])
+		}
+	}
+}
+
+func TestHub_PresenceEvents(t *testing.T) {
+	hub := newTestHub()
+	go hub.Run()
+	defer hub.Shutdown()
+
+	// Two tabs for alice, one for bob
+	tab1 := &mockClient{id: "tab1", userID: "alice", room: "lobby"}
+	tab2 := &mockClient{id: "tab2", userID: "alice", room: "lobby"}
+	bob := &mockClient{id: "bob", userID: "bob", room: "lobby"}
+	elsewhere := &mockClient{id: "x", userID: "carol", room: "other"}
+
+	hub.Register(elsewhere)
+	hub.Register(tab1)
+	hub.Register(tab2)
+	hub.Register(bob)
+	time.Sleep(10 * time.Millisecond)
+
+	// alice joins once, despite two connections; tab2 arrived after it
+	events := tab1.Presence()
+	if len(events) != 2 || events[0].UserID != "alice" || events[1].UserID != "bob" {
+		t.Fatalf("tab1 presence = %v, want joins for alice then bob", presenceSummary(events))
+	}
+	if events[0].Type != message.TypeJoin || events[0].RoomID != "lobby" || events[0].MessageID == "" {
+		t.Errorf("unexpected join event: %+v", events[0])
+	}
+	if n := len(tab2.Presence()); n != 1 {
+		t.Errorf("tab2 saw %d presence events, want 1 (bob's join)", n)
+	}
+
+	// Closing one of alice's tabs is not a leave
+	hub.Unregister(tab1)
+	time.Sleep(10 * time.Millisecond)
+	if n := len(bob.Presence()); n != 1 {
+		t.Fatalf("bob saw %d presence events after a tab closed, want 1", n)
+	}
+
+	hub.Unregister(tab2)
+	time.Sleep(10 * time.Millisecond)
+	events = bob.Presence()
+	if len(events) != 2 || events[1].Type != message.TypeLeave || events[1].UserID != "alice" {
+		t.Errorf("bob presence = %v, want alice's leave last", presenceSummary(events))
+	}
+
+	// Presence stays within the room
+	if n := len(elsewhere.Presence()); n != 1 {
+		t.Errorf("other room saw %d presence events, want only its own join", n)
+	}
+}
+
+func TestHub_RoomPresence(t *testing.T) {
+	hub := newTestHub()
+	go hub.Run()
+	defer hub.Shutdown()
+
+	hub.Register(&mockClient{id: "tab1", userID: "alice", room: "lobby"})
+	time.Sleep(5 * time.Millisecond)
+	hub.Register(&mockClient{id: "tab2", userID: "alice", room: "lobby"})
+	hub.Register(&mockClient{id: "bob", userID: "bob", room: "lobby"})
+	time.Sleep(10 * time.Millisecond)
+
+	members := hub.RoomPresence("lobby")
+	if len(members) != 2 {
+		t.Fatalf("RoomPresence = %+v, want 2 members", members)
+	}
+	if members[0].UserID != "alice" || members[0].Connections != 2 || members[0].Username != "testuser" {
+		t.Errorf("first member = %+v, want alice with 2 connections", members[0])
+	}
+	if members[1].UserID != "bob" || members[1].Connections != 1 {
+		t.Errorf("second member = %+v, want bob with 1 connection", members[1])
+	}
+	if got := hub.RoomPresence("empty"); len(got) != 0 {
+		t.Errorf("empty room presence = %+v", got)
+	}
+}
+
+func TestHub_Subscriptions(t *testing.T) {
+	hub := newTestHub()
+	go hub.Run()
+	defer hub.Shutdown()
+
+	alice := &mockClient{id: "alice", userID: "alice", room: "lobby"}
+	bob := &mockClient{id: "bob", userID: "bob", room: "games"}
+	hub.Register(alice)
+	hub.Register(bob)
+	hub.Subscribe(alice, "games")
+	time.Sleep(10 * time.Millisecond)
+
+	// One connection, counted once, receiving from both rooms
+	if hub.ClientCount() != 2 || hub.RoomMemberCount("games") != 2 {
+		t.Errorf("ClientCount = %d, games members = %d; want 2 and 2", hub.ClientCount(), hub.RoomMemberCount("games"))
+	}
+	hub.Broadcast("lobby", []byte(`{"n":1}`))
+	hub.Broadcast("games", []byte(`{"n":2}`))
+	time.Sleep(10 * time.Millisecond)
+	if alice.MessageCount() != 2 || bob.MessageCount() != 1 {
+		t.Errorf("alice got %d and bob %d messages, want 2 and 1", alice.MessageCount(), bob.MessageCount())
+	}
+	if events := bob.Presence(); len(events) != 2 || events[1].UserID != "alice" || events[1].RoomID != "games" {
+		t.Errorf("bob presence = %v, want alice joining games", presenceSummary(events))
+	}
+
+	hub.Unsubscribe(alice, "games")
+	time.Sleep(10 * time.Millisecond)
+	hub.Broadcast("games", []byte(`{"n":3}`))
+	time.Sleep(10 * time.Millisecond)
+	if alice.MessageCount() != 2 {
+		t.Errorf("alice received from a room she left")
+	}
+	if events := bob.Presence(); len(events) != 3 || events[2].Type != message.TypeLeave {
+		t.Errorf("bob presence = %v, want alice's leave last", presenceSummary(events))
+	}
+
+	// Unregistering leaves every room and closes the client once
+	hub.Subscribe(alice, "games")
+	hub.Unregister(alice)
+	time.Sleep(10 * time.Millisecond)
+	if hub.RoomMemberCount("games") != 1 || hub.RoomMemberCount("lobby") != 0 {
+		t.Errorf("alice still present after unregister: games %d, lobby %d", hub.RoomMemberCount("games"), hub.RoomMemberCount("lobby"))
+	}
+	alice.mu.Lock()
+	if alice.closes != 1 {
+		t.Errorf("client closed %d times, want 1", alice.closes)
+	}
+	alice.mu.Unlock()
+
+	// A late subscribe from an unregistered client is ignored
+	hub.Subscribe(alice, "games")
+	time.Sleep(10 * time.Millisecond)
+	if hub.ClientCount() != 1 || hub.RoomMemberCount("games") != 1 {
+		t.Errorf("unregistered client was subscribed again")
+	}
+}
+
+func TestHub_SendToUsers(t *testing.T) {
+	hub := newTestHub()
+	go hub.Run()
+	defer hub.Shutdown()
+
+	// alice has two connections in different rooms
+	tab1 := &mockClient{id: "tab1", userID: "alice", room: "lobby"}
+	tab2 := &mockClient{id: "tab2", userID: "alice", room: "games"}
+	bob := &mockClient{id: "bob", userID: "bob", room: "lobby"}
+	carol := &mockClient{id: "carol", userID: "carol", room: "lobby"}
+	for _, c := range []*mockClient{tab1, tab2, bob, carol} {
+		hub.Register(c)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := hub.UserConnectionCount("alice"); n != 2 {
+		t.Errorf("alice has %d connections, want 2", n)
+	}
+
+	// Listing a user twice still delivers once per connection
+	hub.SendToUsers([]byte(`{"n":1}`), "alice", "bob", "alice")
+	time.Sleep(10 * time.Millisecond)
+	for _, c := range []*mockClient{tab1, tab2, bob} {
+		if c.MessageCount() != 1 {
+			t.Errorf("%s got %d direct messages, want 1", c.id, c.MessageCount())
+		}
+	}
+	if carol.MessageCount() != 0 {
+		t.Errorf("carol received a message not addressed to her")
+	}
+
+	hub.Unregister(tab1)
+	time.Sleep(10 * time.Millisecond)
+	if n := hub.UserConnectionCount("alice"); n != 1 {
+		t.Errorf("alice has %d connections after one closed, want 1", n)
+	}
+}
+
+// presenceSummary renders presence events as "type:user" for failure output.
+func presenceSummary(events []*message.Message) []string {
+	out := make([]string, len(events))
+	for i, e := range events {
+		out[i] = string(e.Type) + ":" + e.UserID
+	}
+	return out
+}
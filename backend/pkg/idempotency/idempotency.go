@@ -0,0 +1,138 @@
+// Package idempotency recognizes resent chat messages by the idempotency key
+// (clientMessageId) their sender attached, so a message retried after a
+// reconnect is stored and broadcast only once.
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is how long a key is remembered when no window is configured.
+const DefaultWindow = 10 * time.Minute
+
+// Store is a durable record of claimed keys shared by every server instance,
+// so duplicates are recognized across instances and restarts.
+type Store interface {
+	// ClaimIdempotencyKey records messageID under userID's key until
+	// expiresAt. If an unexpired claim exists it is left alone and its
+	// message ID is returned with claimed false.
+	ClaimIdempotencyKey(ctx context.Context, userID, key, messageID string, expiresAt time.Time) (existing string, claimed bool, err error)
+
+	// ReleaseIdempotencyKey removes userID's claim on key if it is still held
+	// for messageID.
+	ReleaseIdempotencyKey(ctx context.Context, userID, key, messageID string) error
+}
+
+// entryKey scopes keys per user, so clients cannot collide with each other.
+type entryKey struct {
+	userID string
+	key    string
+}
+
+type entry struct {
+	messageID string
+	expiresAt time.Time
+}
+
+// Cache remembers claimed keys in memory for a fixed window, consulting an
+// optional Store on a miss. It is safe for concurrent use.
+type Cache struct {
+	window time.Duration
+	store  Store
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[entryKey]entry
+	order   []entryKey // claim order, which is also expiry order
+}
+
+// New creates a cache remembering keys for window (DefaultWindow if not
+// positive), backed by store when non-nil.
+func New(window time.Duration, store Store) *Cache {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Cache{
+		window:  window,
+		store:   store,
+		now:     time.Now,
+		entries: make(map[entryKey]entry),
+	}
+}
+
+// Claim records that userID sent key as messageID. If the key was already
+// claimed within the window it returns the original message ID and duplicate
+// true. On a store error the claim is kept in memory only and the error is
+// returned alongside a non-duplicate result, so callers can log it and still
+// deliver the message.
+func (c *Cache) Claim(ctx context.Context, userID, key, messageID string) (string, bool, error) {
+	k := entryKey{userID: userID, key: key}
+	now := c.now()
+	expiresAt := now.Add(c.window)
+
+	c.mu.Lock()
+	c.prune(now)
+	if e, ok := c.entries[k]; ok {
+		c.mu.Unlock()
+		return e.messageID, true, nil
+	}
+	// Reserve the key before asking the store, so a concurrent resend on
+	// another connection is caught here.
+	c.entries[k] = entry{messageID: messageID, expiresAt: expiresAt}
+	c.order = append(c.order, k)
+	c.mu.Unlock()
+
+	if c.store == nil {
+		return messageID, false, nil
+	}
+	existing, claimed, err := c.store.ClaimIdempotencyKey(ctx, userID, key, messageID, expiresAt)
+	if err != nil {
+		return messageID, false, err
+	}
+	if claimed {
+		return messageID, false, nil
+	}
+
+	c.mu.Lock()
+	c.entries[k] = entry{messageID: existing, expiresAt: expiresAt}
+	c.mu.Unlock()
+	return existing, true, nil
+}
+
+// Release forgets userID's claim on key made for messageID, so a message that
+// was claimed but then rejected can be sent again under the same key. A claim
+// since taken for another message is left alone.
+func (c *Cache) Release(ctx context.Context, userID, key, messageID string) error {
+	k := entryKey{userID: userID, key: key}
+
+	c.mu.Lock()
+	// The key stays in c.order; prune skips keys that are gone, and a
+	// re-claimed key only delays pruning of later claims by one window.
+	if e, ok := c.entries[k]; ok && e.messageID == messageID {
+		delete(c.entries, k)
+	}
+	c.mu.Unlock()
+
+	if c.store == nil {
+		return nil
+	}
+	return c.store.ReleaseIdempotencyKey(ctx, userID, key, messageID)
+}
+
+// prune forgets expired claims. Must be called with c.mu held.
+func (c *Cache) prune(now time.Time) {
+	n := 0
+	for _, k := range c.order {
+		e, ok := c.entries[k]
+		if ok && e.expiresAt.After(now) {
+			break
+		}
+		if ok {
+			delete(c.entries, k)
+		}
+		n++
+	}
+	c.order = c.order[n:]
+}
@@ -0,0 +1,144 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeStore records claims like the durable store, without expiry.
+type fakeStore struct {
+	claims map[string]string
+	err    error
+	calls  int
+}
+
+func (f *fakeStore) ClaimIdempotencyKey(ctx context.Context, userID, key, messageID string, expiresAt time.Time) (string, bool, error) {
+	f.calls++
+	if f.err != nil {
+		return "", false, f.err
+	}
+	if existing, ok := f.claims[userID+"/"+key]; ok {
+		return existing, false, nil
+	}
+	f.claims[userID+"/"+key] = messageID
+	return messageID, true, nil
+}
+
+func (f *fakeStore) ReleaseIdempotencyKey(ctx context.Context, userID, key, messageID string) error {
+	if f.claims[userID+"/"+key] == messageID {
+		delete(f.claims, userID+"/"+key)
+	}
+	return f.err
+}
+
+func newTestCache(window time.Duration, store Store) (*Cache, *time.Time) {
+	c := New(window, store)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCache_DetectsDuplicates(t *testing.T) {
+	c, _ := newTestCache(time.Minute, nil)
+	ctx := context.Background()
+
+	id, dup, err := c.Claim(ctx, "alice", "k1", "m1")
+	if err != nil || dup || id != "m1" {
+		t.Fatalf("first claim = %q, %v, %v; want m1, false, nil", id, dup, err)
+	}
+	id, dup, _ = c.Claim(ctx, "alice", "k1", "m2")
+	if !dup || id != "m1" {
+		t.Errorf("resend = %q, %v; want m1, true", id, dup)
+	}
+
+	// Keys are scoped per user
+	if _, dup, _ := c.Claim(ctx, "bob", "k1", "m3"); dup {
+		t.Error("another user's key was treated as a duplicate")
+	}
+}
+
+func TestCache_ForgetsAfterWindow(t *testing.T) {
+	c, now := newTestCache(time.Minute, nil)
+	ctx := context.Background()
+
+	c.Claim(ctx, "alice", "k1", "m1")
+	*now = now.Add(59 * time.Second)
+	if _, dup, _ := c.Claim(ctx, "alice", "k1", "m2"); !dup {
+		t.Error("resend inside the window was not a duplicate")
+	}
+
+	*now = now.Add(2 * time.Second)
+	id, dup, _ := c.Claim(ctx, "alice", "k1", "m3")
+	if dup || id != "m3" {
+		t.Errorf("claim after the window = %q, %v; want m3, false", id, dup)
+	}
+	if len(c.entries) != 1 || len(c.order) != 1 {
+		t.Errorf("expired claims not pruned: %d entries, %d ordered", len(c.entries), len(c.order))
+	}
+}
+
+func TestCache_ConsultsStore(t *testing.T) {
+	store := &fakeStore{claims: map[string]string{"alice/k1": "m-original"}}
+	c, _ := newTestCache(time.Minute, store)
+	ctx := context.Background()
+
+	// Claimed by another instance: the store's message ID wins
+	id, dup, err := c.Claim(ctx, "alice", "k1", "m1")
+	if err != nil || !dup || id != "m-original" {
+		t.Fatalf("claim = %q, %v, %v; want m-original, true, nil", id, dup, err)
+	}
+	// Now cached, so the store is not asked again
+	if id, _, _ := c.Claim(ctx, "alice", "k1", "m2"); id != "m-original" || store.calls != 1 {
+		t.Errorf("cached claim = %q after %d store calls", id, store.calls)
+	}
+
+	if _, dup, _ := c.Claim(ctx, "alice", "k2", "m3"); dup {
+		t.Error("new key was a duplicate")
+	}
+	if store.claims["alice/k2"] != "m3" {
+		t.Error("new key was not recorded in the store")
+	}
+}
+
+func TestCache_StoreErrorFailsOpen(t *testing.T) {
+	store := &fakeStore{claims: map[string]string{}, err: errors.New("throttled")}
+	c, _ := newTestCache(time.Minute, store)
+	ctx := context.Background()
+
+	id, dup, err := c.Claim(ctx, "alice", "k1", "m1")
+	if err == nil || dup || id != "m1" {
+		t.Fatalf("claim = %q, %v, %v; want m1, false, error", id, dup, err)
+	}
+	// The in-memory claim still catches a resend to this instance
+	if _, dup, _ := c.Claim(ctx, "alice", "k1", "m2"); !dup {
+		t.Error("resend after a store error was not a duplicate")
+	}
+}
+
+func TestCache_Release(t *testing.T) {
+	store := &fakeStore{claims: map[string]string{}}
+	c, _ := newTestCache(time.Minute, store)
+	ctx := context.Background()
+
+	c.Claim(ctx, "alice", "k1", "m1")
+	// Releasing for another message leaves the claim in place
+	if err := c.Release(ctx, "alice", "k1", "m-other"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, dup, _ := c.Claim(ctx, "alice", "k1", "m2"); !dup {
+		t.Fatal("claim released for the wrong message")
+	}
+
+	if err := c.Release(ctx, "alice", "k1", "m1"); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, ok := store.claims["alice/k1"]; ok {
+		t.Error("claim not released in the store")
+	}
+	id, dup, _ := c.Claim(ctx, "alice", "k1", "m3")
+	if dup || id != "m3" {
+		t.Errorf("claim after release = %q, %v; want m3, false", id, dup)
+	}
+}
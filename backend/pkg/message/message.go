@@ -3,7 +3,13 @@ package message
 import (
 	"encoding/json"
 	"errors"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/room"
 )
 
 // Type represents different message types in the system
@@ -12,8 +18,63 @@ type Type string
 const (
 	TypeChat   Type = "chat"
 	TypeSystem Type = "system"
-	TypeJoin   Type = "join"
-	TypeLeave  Type = "leave"
+
+	// TypeJoin and TypeLeave announce a user's first connection to a room
+	// and the close of their last one. Only the hub emits them.
+	TypeJoin  Type = "join"
+	TypeLeave Type = "leave"
+
+	// TypeEdit replaces the content of the message named by TargetID.
+	TypeEdit Type = "edit"
+
+	// TypeDelete retracts the message named by TargetID, leaving a tombstone.
+	TypeDelete Type = "delete"
+
+	// TypeReaction adds the Emoji to the message named by TargetID, or takes
+	// it back when Remove is set. The stored target keeps the aggregate in
+	// Reactions.
+	TypeReaction Type = "reaction"
+
+	// TypeDirect is a private message to the user named by RecipientID. It
+	// is delivered to both users' connections and stored under their
+	// conversation (see ConversationID) instead of a room.
+	TypeDirect Type = "direct"
+
+	// TypeTyping announces that the sender is typing. It is ephemeral: relayed
+	// to the room but never stored, counted or replayed, and it lapses at
+	// ExpiresAt unless renewed.
+	TypeTyping Type = "typing"
+
+	// TypeRead moves the sender's read marker in a room to the message named
+	// by TargetID. The server relays it to the room when the marker moves,
+	// so it is ephemeral like typing: never stored as a message or replayed.
+	TypeRead Type = "read"
+
+	// TypeMention notifies a user, on every connection they have open, that
+	// the message named by TargetID mentioned them. Only the server sends it.
+	TypeMention Type = "mention"
+
+	// TypePin and TypeUnpin pin the message named by TargetID to its room,
+	// or unpin it. Only moderators may send them; the server relays them to
+	// the room with the room's updated Pins.
+	TypePin   Type = "pin"
+	TypeUnpin Type = "unpin"
+
+	// TypePins carries a room's current Pins to a connection joining it.
+	// Only the server sends it.
+	TypePins Type = "pins"
+
+	// TypeAck and TypeNack are sent by the server to the connection a
+	// message came from, reporting whether it was accepted. Clients cannot
+	// send them.
+	TypeAck  Type = "ack"
+	TypeNack Type = "nack"
+
+	// TypeSubscribe and TypeUnsubscribe add the sending connection to the
+	// room named by RoomID, or remove it. They are control frames: answered
+	// with an ack or nack, never broadcast or stored.
+	TypeSubscribe   Type = "subscribe"
+	TypeUnsubscribe Type = "unsubscribe"
 )
 
 // Message represents a WebSocket message
@@ -25,12 +86,235 @@ type Message struct {
 	Username  string    `json:"username" dynamodbav:"Username"`
 	Content   string    `json:"content" dynamodbav:"Content"`
 	Timestamp time.Time `json:"timestamp" dynamodbav:"Timestamp"`
+
+	// ClientMessageID is an idempotency key chosen by the sending client; a
+	// resend with the same key is recognized as a duplicate rather than
+	// stored again.
+	ClientMessageID string `json:"clientMessageId,omitempty" dynamodbav:"ClientMessageID,omitempty"`
+
+	// RecipientID is the user a direct message is addressed to.
+	RecipientID string `json:"recipientId,omitempty" dynamodbav:"RecipientID,omitempty"`
+
+	// TargetID is the MessageID an edit or delete applies to.
+	TargetID string `json:"targetId,omitempty" dynamodbav:"TargetID,omitempty"`
+
+	// Emoji and Remove are the change a reaction makes to its target.
+	Emoji  string `json:"emoji,omitempty" dynamodbav:"-"`
+	Remove bool   `json:"remove,omitempty" dynamodbav:"-"`
+
+	// Mentions are the IDs of the users a chat message mentions with
+	// @username or @userId. The server sets them; clients cannot.
+	Mentions []string `json:"mentions,omitempty" dynamodbav:"Mentions,omitempty"`
+
+	// Reactions aggregates the emoji reactions to a stored message, in the
+	// order each emoji was first used. A reaction frame carries its target's
+	// updated aggregate.
+	Reactions []Reaction `json:"reactions,omitempty" dynamodbav:"Reactions,omitempty"`
+
+	// Attachments reference blobs uploaded to /api/uploads. A client names
+	// each by ID; the server fills in the rest from the blob store.
+	Attachments []Attachment `json:"attachments,omitempty" dynamodbav:"Attachments,omitempty"`
+
+	// Pins are a room's pinned messages, oldest pin first, carried by pin,
+	// unpin and pins frames. They are kept on the room, never on a message.
+	Pins []room.Pin `json:"pins,omitempty" dynamodbav:"-"`
+
+	// ParentMessageID makes a chat message a reply in the thread started by
+	// that message, which must be in the same room. ReplyCount is kept on
+	// the parent and counts the replies stored under it.
+	ParentMessageID string `json:"parentMessageId,omitempty" dynamodbav:"ParentMessageID,omitempty"`
+	ReplyCount      int    `json:"replyCount,omitempty" dynamodbav:"ReplyCount,omitempty"`
+
+	// EditedAt is set once a message has been edited; Revisions holds its
+	// superseded content, newest last, capped at MaxRevisions.
+	EditedAt  *time.Time `json:"editedAt,omitempty" dynamodbav:"EditedAt,omitempty"`
+	Revisions []Revision `json:"revisions,omitempty" dynamodbav:"Revisions,omitempty"`
+
+	// Deleted marks a tombstone: the content and revisions are gone but the
+	// MessageID and Timestamp remain so history keeps its shape.
+	Deleted   bool       `json:"deleted,omitempty" dynamodbav:"Deleted,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty" dynamodbav:"DeletedAt,omitempty"`
+	DeletedBy string     `json:"deletedBy,omitempty" dynamodbav:"DeletedBy,omitempty"`
+
+	// ExpiresAt is when the retention policy removes the message, or when a
+	// typing indicator lapses. It is stored as epoch seconds so DynamoDB TTL
+	// can act on it.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" dynamodbav:"ExpiresAt,omitempty,unixtime"`
+}
+
+// ErrorCode is a machine-readable reason a message was rejected, carried by
+// nack frames.
+type ErrorCode string
+
+const (
+	CodeRateLimited       ErrorCode = "rate_limited"
+	CodeInvalidFormat     ErrorCode = "invalid_format"
+	CodeInvalidType       ErrorCode = "invalid_type"
+	CodeEmptyContent      ErrorCode = "empty_content"
+	CodeTooLong           ErrorCode = "too_long"
+	CodeInvalidUsername   ErrorCode = "invalid_username"
+	CodeMissingTarget     ErrorCode = "missing_target"
+	CodeMissingRoom       ErrorCode = "missing_room"
+	CodeMissingRecipient  ErrorCode = "missing_recipient"
+	CodeInvalidParent     ErrorCode = "invalid_parent"
+	CodeMissingEmoji      ErrorCode = "missing_emoji"
+	CodeInvalidAttachment ErrorCode = "invalid_attachment"
+	CodeNotSubscribed     ErrorCode = "not_subscribed"
+	CodeTooManyRooms      ErrorCode = "too_many_rooms"
+	CodeTooManyPins       ErrorCode = "too_many_pins"
+	CodeNotFound          ErrorCode = "not_found"
+	CodeForbidden         ErrorCode = "forbidden"
+	CodeUnavailable       ErrorCode = "unavailable"
+	CodeOverloaded        ErrorCode = "overloaded"
+	CodeInternal          ErrorCode = "internal"
+)
+
+// Ack reports the outcome of a message to the connection that sent it. An
+// ack means the message was accepted and broadcast; a nack carries the Code
+// and Error explaining why it was not.
+type Ack struct {
+	Type Type `json:"type"`
+
+	// ClientMessageID correlates the ack with the client's message; empty if
+	// the message could not be parsed or did not carry one.
+	ClientMessageID string `json:"clientMessageId,omitempty"`
+
+	// MessageID is the ID the server assigned, and TargetID the message an
+	// edit or delete applied to.
+	MessageID string `json:"messageId,omitempty"`
+	TargetID  string `json:"targetId,omitempty"`
+
+	// RoomID is the room the message was for, or the room subscribed to.
+	RoomID string `json:"roomId,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
+
+	// Duplicate is set when the message was a resend of one already accepted;
+	// MessageID is then the original's.
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	Code  ErrorCode `json:"code,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// NewAck creates the ack for an accepted message.
+func NewAck(m *Message) *Ack {
+	return &Ack{
+		Type:            TypeAck,
+		ClientMessageID: m.ClientMessageID,
+		MessageID:       m.MessageID,
+		TargetID:        m.TargetID,
+		RoomID:          m.RoomID,
+		Timestamp:       m.Timestamp,
+	}
+}
+
+// NewNack creates the nack for a rejected message. m may be nil when the
+// message could not be parsed.
+func NewNack(m *Message, code ErrorCode, err error) *Ack {
+	a := &Ack{Type: TypeNack, Timestamp: time.Now().UTC(), Code: code}
+	if m != nil {
+		a.ClientMessageID = m.ClientMessageID
+		a.MessageID = m.MessageID
+		a.TargetID = m.TargetID
+		a.RoomID = m.RoomID
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+// ToJSON converts the ack to JSON bytes
+func (a *Ack) ToJSON() ([]byte, error) {
+	return json.Marshal(a)
+}
+
+// CodeFor returns the error code for a Validate error, or CodeInternal for
+// any other error.
+func CodeFor(err error) ErrorCode {
+	switch {
+	case errors.Is(err, ErrInvalidType):
+		return CodeInvalidType
+	case errors.Is(err, ErrEmptyContent):
+		return CodeEmptyContent
+	case errors.Is(err, ErrContentTooLong), errors.Is(err, ErrClientIDTooLong), errors.Is(err, ErrRoomIDTooLong),
+		errors.Is(err, ErrRecipientTooLong), errors.Is(err, ErrParentIDTooLong), errors.Is(err, ErrEmojiTooLong):
+		return CodeTooLong
+	case errors.Is(err, ErrEmptyUsername), errors.Is(err, ErrUsernameTooLong):
+		return CodeInvalidUsername
+	case errors.Is(err, ErrMissingTarget):
+		return CodeMissingTarget
+	case errors.Is(err, ErrMissingRoom):
+		return CodeMissingRoom
+	case errors.Is(err, ErrMissingRecipient):
+		return CodeMissingRecipient
+	case errors.Is(err, ErrInvalidParent):
+		return CodeInvalidParent
+	case errors.Is(err, ErrMissingEmoji):
+		return CodeMissingEmoji
+	case errors.Is(err, ErrInvalidAttachment), errors.Is(err, ErrTooManyAttachments):
+		return CodeInvalidAttachment
+	default:
+		return CodeInternal
+	}
+}
+
+// Revision is a superseded version of a message's content.
+type Revision struct {
+	Content    string    `json:"content" dynamodbav:"Content"`
+	ReplacedAt time.Time `json:"replacedAt" dynamodbav:"ReplacedAt"`
+}
+
+// Reaction is one emoji's tally on a message: the users who reacted with it,
+// in the order they did.
+type Reaction struct {
+	Emoji   string   `json:"emoji" dynamodbav:"Emoji"`
+	Count   int      `json:"count" dynamodbav:"Count"`
+	UserIDs []string `json:"userIds" dynamodbav:"UserIDs"`
+}
+
+// Attachment is an uploaded blob a message carries.
+type Attachment struct {
+	ID          string `json:"id" dynamodbav:"ID"`
+	Filename    string `json:"filename,omitempty" dynamodbav:"Filename,omitempty"`
+	ContentType string `json:"contentType,omitempty" dynamodbav:"ContentType,omitempty"`
+	Size        int64  `json:"size,omitempty" dynamodbav:"Size,omitempty"`
 }
 
 // Validation constants
 const (
 	MaxContentLength  = 1000
 	MaxUsernameLength = 50
+
+	// MaxClientMessageIDLength bounds client-supplied idempotency keys.
+	MaxClientMessageIDLength = 64
+
+	// MaxRevisions bounds the edit history kept per message.
+	MaxRevisions = 10
+
+	// MaxRoomIDLength bounds the room a message names, matching the room
+	// directory's limit.
+	MaxRoomIDLength = 64
+
+	// MaxRecipientIDLength bounds the user a direct message is addressed to.
+	MaxRecipientIDLength = 128
+
+	// MaxParentMessageIDLength bounds the message a reply names.
+	MaxParentMessageIDLength = 128
+
+	// MaxEmojiLength bounds a reaction's emoji in bytes, leaving room for
+	// multi-codepoint sequences such as skin tones and ZWJ families.
+	MaxEmojiLength = 32
+
+	// MaxMentions bounds the handles taken from one message; any further
+	// ones are left as plain text.
+	MaxMentions = 20
+
+	// MaxAttachments bounds the blobs one message carries, and
+	// MaxAttachmentIDLength the ID naming each.
+	MaxAttachments        = 10
+	MaxAttachmentIDLength = 64
 )
 
 var (
@@ -39,12 +323,52 @@ var (
 	ErrEmptyUsername   = errors.New("username cannot be empty")
 	ErrUsernameTooLong = errors.New("username exceeds maximum length")
 	ErrInvalidType     = errors.New("invalid message type")
+	ErrMissingTarget   = errors.New("message target id is required")
+	ErrClientIDTooLong = errors.New("client message id exceeds maximum length")
+	ErrMissingRoom     = errors.New("message room id is required")
+	ErrRoomIDTooLong   = errors.New("room id exceeds maximum length")
+
+	ErrMissingRecipient = errors.New("direct message recipient id is required")
+	ErrRecipientTooLong = errors.New("recipient id exceeds maximum length")
+
+	ErrInvalidParent   = errors.New("only chat messages can reply to another message")
+	ErrParentIDTooLong = errors.New("parent message id exceeds maximum length")
+
+	ErrMissingEmoji = errors.New("reaction emoji is required")
+	ErrEmojiTooLong = errors.New("reaction emoji exceeds maximum length")
+
+	ErrInvalidAttachment  = errors.New("attachments must be distinct blob ids on a chat or direct message")
+	ErrTooManyAttachments = errors.New("message has too many attachments")
 )
 
+// validTypes is the set of message types accepted by Validate.
+var validTypes = map[Type]bool{
+	TypeChat:        true,
+	TypeSystem:      true,
+	TypeJoin:        true,
+	TypeLeave:       true,
+	TypeEdit:        true,
+	TypeDelete:      true,
+	TypeReaction:    true,
+	TypeDirect:      true,
+	TypeTyping:      true,
+	TypeRead:        true,
+	TypePin:         true,
+	TypeUnpin:       true,
+	TypeSubscribe:   true,
+	TypeUnsubscribe: true,
+}
+
+// Ephemeral reports whether messages of this type are relayed live only,
+// bypassing storage, analytics and history.
+func (t Type) Ephemeral() bool {
+	return t == TypeTyping || t == TypeRead
+}
+
 // Validate checks if the message meets all requirements
 func (m *Message) Validate() error {
 	// Type validation
-	if m.Type != TypeChat && m.Type != TypeSystem && m.Type != TypeJoin && m.Type != TypeLeave {
+	if !validTypes[m.Type] {
 		return ErrInvalidType
 	}
 
@@ -56,9 +380,58 @@ func (m *Message) Validate() error {
 		return ErrUsernameTooLong
 	}
 
-	// Content validation (only for chat messages)
-	if m.Type == TypeChat {
-		if m.Content == "" {
+	if len(m.ClientMessageID) > MaxClientMessageIDLength {
+		return ErrClientIDTooLong
+	}
+
+	if len(m.RoomID) > MaxRoomIDLength {
+		return ErrRoomIDTooLong
+	}
+
+	// Subscriptions must name the room they apply to
+	if (m.Type == TypeSubscribe || m.Type == TypeUnsubscribe) && m.RoomID == "" {
+		return ErrMissingRoom
+	}
+
+	// Direct messages must name their recipient
+	if m.Type == TypeDirect && m.RecipientID == "" {
+		return ErrMissingRecipient
+	}
+	if len(m.RecipientID) > MaxRecipientIDLength {
+		return ErrRecipientTooLong
+	}
+
+	// Only chat messages can be replies, and never to themselves
+	if m.ParentMessageID != "" && (m.Type != TypeChat || m.ParentMessageID == m.MessageID) {
+		return ErrInvalidParent
+	}
+	if len(m.ParentMessageID) > MaxParentMessageIDLength {
+		return ErrParentIDTooLong
+	}
+
+	// Edits, deletes, reactions, read markers and pins must name the message
+	// they apply to
+	if (m.Type == TypeEdit || m.Type == TypeDelete || m.Type == TypeReaction || m.Type == TypeRead ||
+		m.Type == TypePin || m.Type == TypeUnpin) && m.TargetID == "" {
+		return ErrMissingTarget
+	}
+
+	// Reactions must name their emoji
+	if m.Type == TypeReaction && m.Emoji == "" {
+		return ErrMissingEmoji
+	}
+	if len(m.Emoji) > MaxEmojiLength {
+		return ErrEmojiTooLong
+	}
+
+	if err := m.validateAttachments(); err != nil {
+		return err
+	}
+
+	// Content validation (only for chat messages, direct messages and edits).
+	// A message with attachments may have no text.
+	if m.Type == TypeChat || m.Type == TypeDirect || m.Type == TypeEdit {
+		if m.Content == "" && len(m.Attachments) == 0 {
 			return ErrEmptyContent
 		}
 		if len(m.Content) > MaxContentLength {
@@ -69,6 +442,136 @@ func (m *Message) Validate() error {
 	return nil
 }
 
+// validateAttachments checks that only chat and direct messages carry
+// attachments, that there are at most MaxAttachments, and that each names a
+// distinct blob ID.
+func (m *Message) validateAttachments() error {
+	if len(m.Attachments) == 0 {
+		return nil
+	}
+	if m.Type != TypeChat && m.Type != TypeDirect {
+		return ErrInvalidAttachment
+	}
+	if len(m.Attachments) > MaxAttachments {
+		return ErrTooManyAttachments
+	}
+	seen := make(map[string]bool, len(m.Attachments))
+	for _, a := range m.Attachments {
+		if a.ID == "" || len(a.ID) > MaxAttachmentIDLength || seen[a.ID] {
+			return ErrInvalidAttachment
+		}
+		seen[a.ID] = true
+	}
+	return nil
+}
+
+// conversationPrefix starts every conversation ID. Room IDs in the directory
+// cannot contain ':', so the two never collide.
+const conversationPrefix = "dm:"
+
+// ConversationID returns the ID direct messages between two users are stored
+// under. It is the same whichever of them sends, and each user ID is escaped
+// so it can be split apart again by Participants.
+func ConversationID(userA, userB string) string {
+	if userB < userA {
+		userA, userB = userB, userA
+	}
+	return conversationPrefix + url.QueryEscape(userA) + ":" + url.QueryEscape(userB)
+}
+
+// Participants returns the two users of a conversation ID. ok is false if id
+// is not a conversation ID, e.g. because it is a room.
+func Participants(id string) (userA, userB string, ok bool) {
+	rest, found := strings.CutPrefix(id, conversationPrefix)
+	if !found {
+		return "", "", false
+	}
+	a, b, found := strings.Cut(rest, ":")
+	if !found {
+		return "", "", false
+	}
+	userA, errA := url.QueryUnescape(a)
+	userB, errB := url.QueryUnescape(b)
+	if errA != nil || errB != nil || userA == "" || userB == "" || ConversationID(userA, userB) != id {
+		return "", "", false
+	}
+	return userA, userB, true
+}
+
+// IsConversationID reports whether id names a direct message conversation
+// rather than a room.
+func IsConversationID(id string) bool {
+	_, _, ok := Participants(id)
+	return ok
+}
+
+// ApplyEdit replaces the message content, recording the previous content as a
+// revision and dropping the oldest revisions beyond MaxRevisions.
+func (m *Message) ApplyEdit(content string, editedAt time.Time) {
+	m.Revisions = append(m.Revisions, Revision{Content: m.Content, ReplacedAt: editedAt})
+	if len(m.Revisions) > MaxRevisions {
+		m.Revisions = m.Revisions[len(m.Revisions)-MaxRevisions:]
+	}
+	m.Content = content
+	m.EditedAt = &editedAt
+}
+
+// mentionPattern matches an @handle at the start of the content or after a
+// character that can't be part of a word or an email address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\pL\pN_.@-])@([\pL\pN_.-]+)`)
+
+// ParseMentions returns the distinct handles written as @handle in content,
+// in order of first appearance and at most MaxMentions of them. Trailing dots
+// and dashes are taken as punctuation, so "thanks @bob." mentions "bob".
+func ParseMentions(content string) []string {
+	var handles []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		handle := strings.TrimRight(match[1], ".-")
+		if handle == "" || len(handle) > MaxRecipientIDLength || slices.Contains(handles, handle) {
+			continue
+		}
+		handles = append(handles, handle)
+		if len(handles) == MaxMentions {
+			break
+		}
+	}
+	return handles
+}
+
+// ApplyReaction adds userID's emoji reaction to the message, or removes it
+// when remove is set, and reports whether anything changed. Reacting twice
+// with the same emoji, or removing a reaction never made, is a no-op.
+func (m *Message) ApplyReaction(userID, emoji string, remove bool) bool {
+	for i := range m.Reactions {
+		r := &m.Reactions[i]
+		if r.Emoji != emoji {
+			continue
+		}
+		at := slices.Index(r.UserIDs, userID)
+		switch {
+		case !remove && at < 0:
+			r.UserIDs = append(r.UserIDs, userID)
+		case remove && at >= 0:
+			r.UserIDs = slices.Delete(r.UserIDs, at, at+1)
+		default:
+			return false
+		}
+		r.Count = len(r.UserIDs)
+		if r.Count == 0 {
+			m.Reactions = slices.Delete(m.Reactions, i, i+1)
+		}
+		if len(m.Reactions) == 0 {
+			m.Reactions = nil
+		}
+		return true
+	}
+	if remove {
+		return false
+	}
+	m.Reactions = append(m.Reactions, Reaction{Emoji: emoji, Count: 1, UserIDs: []string{userID}})
+	return true
+}
+
 // NewChatMessage creates a new chat message
 func NewChatMessage(userID, username, content string) *Message {
 	return &Message{
@@ -91,6 +594,120 @@ func NewSystemMessage(content string) *Message {
 	}
 }
 
+// Tombstone retracts the message: its content, edit history, reactions and
+// attachments are discarded and it is marked deleted by deletedBy.
+func (m *Message) Tombstone(deletedBy string, deletedAt time.Time) {
+	m.Content = ""
+	m.Revisions = nil
+	m.Reactions = nil
+	m.Attachments = nil
+	m.Deleted = true
+	m.DeletedAt = &deletedAt
+	m.DeletedBy = deletedBy
+}
+
+// ClearServerFields zeroes the fields only the server may set, so a frame
+// from a client cannot forge a tombstone, reply count, reactions, edit
+// history, pins, mentions or expiry.
+func (m *Message) ClearServerFields() {
+	m.Mentions = nil
+	m.Reactions = nil
+	m.Pins = nil
+	m.ReplyCount = 0
+	m.EditedAt = nil
+	m.Revisions = nil
+	m.Deleted = false
+	m.DeletedAt = nil
+	m.DeletedBy = ""
+	m.ExpiresAt = nil
+}
+
+// Clone returns a deep copy of the message.
+func (m *Message) Clone() *Message {
+	c := *m
+	if m.EditedAt != nil {
+		editedAt := *m.EditedAt
+		c.EditedAt = &editedAt
+	}
+	if m.DeletedAt != nil {
+		deletedAt := *m.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+	if m.ExpiresAt != nil {
+		expiresAt := *m.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	if m.Revisions != nil {
+		c.Revisions = append([]Revision(nil), m.Revisions...)
+	}
+	c.Mentions = slices.Clone(m.Mentions)
+	c.Pins = slices.Clone(m.Pins)
+	c.Attachments = slices.Clone(m.Attachments)
+	if m.Reactions != nil {
+		c.Reactions = make([]Reaction, len(m.Reactions))
+		for i, r := range m.Reactions {
+			r.UserIDs = slices.Clone(r.UserIDs)
+			c.Reactions[i] = r
+		}
+	}
+	return &c
+}
+
+// NewMentionMessage creates the notification sent to the users m mentions.
+func NewMentionMessage(m *Message) *Message {
+	return &Message{
+		Type:      TypeMention,
+		RoomID:    m.RoomID,
+		TargetID:  m.MessageID,
+		UserID:    m.UserID,
+		Username:  m.Username,
+		Content:   m.Content,
+		Timestamp: m.Timestamp,
+	}
+}
+
+// NewPinsMessage creates the frame carrying a room's current pins.
+func NewPinsMessage(roomID string, pins []room.Pin) *Message {
+	return &Message{
+		Type:      TypePins,
+		RoomID:    roomID,
+		UserID:    "system",
+		Username:  "System",
+		Pins:      pins,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// NewDeleteMessage creates the event announcing that targetID in roomID was
+// deleted by the given user.
+func NewDeleteMessage(roomID, targetID, userID, username string) *Message {
+	return &Message{
+		Type:      TypeDelete,
+		RoomID:    roomID,
+		TargetID:  targetID,
+		UserID:    userID,
+		Username:  username,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// NewPresenceMessage creates the TypeJoin or TypeLeave event announcing that
+// a user arrived in or left roomID.
+func NewPresenceMessage(t Type, roomID, userID, username string) *Message {
+	verb := "joined"
+	if t == TypeLeave {
+		verb = "left"
+	}
+	return &Message{
+		Type:      t,
+		RoomID:    roomID,
+		UserID:    userID,
+		Username:  username,
+		Content:   username + " " + verb,
+		Timestamp: time.Now().UTC(),
+	}
+}
+
 // ToJSON converts message to JSON bytes
 func (m *Message) ToJSON() ([]byte, error) {
 	return json.Marshal(m)
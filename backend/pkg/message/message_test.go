@@ -2,6 +2,9 @@ package message
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -95,6 +98,313 @@ func TestMessage_Validate(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "valid edit message",
+			msg: Message{
+				Type:     TypeEdit,
+				Username: "alice",
+				TargetID: "m1",
+				Content:  "fixed typo",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "edit without target",
+			msg: Message{
+				Type:     TypeEdit,
+				Username: "alice",
+				Content:  "fixed typo",
+			},
+			wantErr: ErrMissingTarget,
+		},
+		{
+			name: "valid delete message",
+			msg: Message{
+				Type:     TypeDelete,
+				Username: "alice",
+				TargetID: "m1",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "delete without target",
+			msg: Message{
+				Type:     TypeDelete,
+				Username: "alice",
+			},
+			wantErr: ErrMissingTarget,
+		},
+		{
+			name: "edit with empty content",
+			msg: Message{
+				Type:     TypeEdit,
+				Username: "alice",
+				TargetID: "m1",
+			},
+			wantErr: ErrEmptyContent,
+		},
+		{
+			name: "client message id too long",
+			msg: Message{
+				Type:            TypeChat,
+				Username:        "alice",
+				Content:         "hi",
+				ClientMessageID: strings.Repeat("k", MaxClientMessageIDLength+1),
+			},
+			wantErr: ErrClientIDTooLong,
+		},
+		{
+			name: "typing without content",
+			msg: Message{
+				Type:     TypeTyping,
+				Username: "alice",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "ack from a client",
+			msg: Message{
+				Type:     TypeAck,
+				Username: "alice",
+			},
+			wantErr: ErrInvalidType,
+		},
+		{
+			name: "subscribe",
+			msg: Message{
+				Type:     TypeSubscribe,
+				Username: "alice",
+				RoomID:   "games",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "unsubscribe without room",
+			msg: Message{
+				Type:     TypeUnsubscribe,
+				Username: "alice",
+			},
+			wantErr: ErrMissingRoom,
+		},
+		{
+			name: "direct message",
+			msg: Message{
+				Type:        TypeDirect,
+				Username:    "alice",
+				Content:     "psst",
+				RecipientID: "bob",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "direct message without recipient",
+			msg: Message{
+				Type:     TypeDirect,
+				Username: "alice",
+				Content:  "psst",
+			},
+			wantErr: ErrMissingRecipient,
+		},
+		{
+			name: "direct message without content",
+			msg: Message{
+				Type:        TypeDirect,
+				Username:    "alice",
+				RecipientID: "bob",
+			},
+			wantErr: ErrEmptyContent,
+		},
+		{
+			name: "room id too long",
+			msg: Message{
+				Type:     TypeChat,
+				Username: "alice",
+				Content:  "hi",
+				RoomID:   strings.Repeat("r", MaxRoomIDLength+1),
+			},
+			wantErr: ErrRoomIDTooLong,
+		},
+		{
+			name: "reply",
+			msg: Message{
+				Type:            TypeChat,
+				MessageID:       "m2",
+				Username:        "alice",
+				Content:         "agreed",
+				ParentMessageID: "m1",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "reply to itself",
+			msg: Message{
+				Type:            TypeChat,
+				MessageID:       "m1",
+				Username:        "alice",
+				Content:         "agreed",
+				ParentMessageID: "m1",
+			},
+			wantErr: ErrInvalidParent,
+		},
+		{
+			name: "direct message reply",
+			msg: Message{
+				Type:            TypeDirect,
+				Username:        "alice",
+				Content:         "psst",
+				RecipientID:     "bob",
+				ParentMessageID: "m1",
+			},
+			wantErr: ErrInvalidParent,
+		},
+		{
+			name: "parent id too long",
+			msg: Message{
+				Type:            TypeChat,
+				Username:        "alice",
+				Content:         "agreed",
+				ParentMessageID: strings.Repeat("p", MaxParentMessageIDLength+1),
+			},
+			wantErr: ErrParentIDTooLong,
+		},
+		{
+			name: "reaction",
+			msg: Message{
+				Type:     TypeReaction,
+				Username: "alice",
+				TargetID: "m1",
+				Emoji:    "👍",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "reaction without target",
+			msg: Message{
+				Type:     TypeReaction,
+				Username: "alice",
+				Emoji:    "👍",
+			},
+			wantErr: ErrMissingTarget,
+		},
+		{
+			name: "reaction without emoji",
+			msg: Message{
+				Type:     TypeReaction,
+				Username: "alice",
+				TargetID: "m1",
+			},
+			wantErr: ErrMissingEmoji,
+		},
+		{
+			name: "emoji too long",
+			msg: Message{
+				Type:     TypeReaction,
+				Username: "alice",
+				TargetID: "m1",
+				Emoji:    strings.Repeat("👍", MaxEmojiLength),
+			},
+			wantErr: ErrEmojiTooLong,
+		},
+		{
+			name: "read",
+			msg: Message{
+				Type:     TypeRead,
+				Username: "alice",
+				TargetID: "m1",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "read without target",
+			msg: Message{
+				Type:     TypeRead,
+				Username: "alice",
+			},
+			wantErr: ErrMissingTarget,
+		},
+		{
+			name: "pin",
+			msg: Message{
+				Type:     TypePin,
+				Username: "alice",
+				TargetID: "m1",
+			},
+			wantErr: nil,
+		},
+		{
+			name: "unpin without target",
+			msg: Message{
+				Type:     TypeUnpin,
+				Username: "alice",
+			},
+			wantErr: ErrMissingTarget,
+		},
+		{
+			name: "attachments without content",
+			msg: Message{
+				Type:        TypeChat,
+				Username:    "alice",
+				Attachments: []Attachment{{ID: "b1"}, {ID: "b2"}},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "duplicate attachment",
+			msg: Message{
+				Type:        TypeDirect,
+				Username:    "alice",
+				RecipientID: "bob",
+				Attachments: []Attachment{{ID: "b1"}, {ID: "b1"}},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "attachment without id",
+			msg: Message{
+				Type:        TypeChat,
+				Username:    "alice",
+				Content:     "see attached",
+				Attachments: []Attachment{{Filename: "notes.txt"}},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "attachment id too long",
+			msg: Message{
+				Type:        TypeChat,
+				Username:    "alice",
+				Attachments: []Attachment{{ID: strings.Repeat("b", MaxAttachmentIDLength+1)}},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "attachment on an edit",
+			msg: Message{
+				Type:        TypeEdit,
+				Username:    "alice",
+				TargetID:    "m1",
+				Content:     "fixed",
+				Attachments: []Attachment{{ID: "b1"}},
+			},
+			wantErr: ErrInvalidAttachment,
+		},
+		{
+			name: "too many attachments",
+			msg: Message{
+				Type:        TypeChat,
+				Username:    "alice",
+				Attachments: make([]Attachment, MaxAttachments+1),
+			},
+			wantErr: ErrTooManyAttachments,
+		},
+		{
+			name: "pins from a client",
+			msg: Message{
+				Type:     TypePins,
+				Username: "alice",
+			},
+			wantErr: ErrInvalidType,
+		},
 	}
 
 	for _, tt := range tests {
@@ -107,6 +417,104 @@ func TestMessage_Validate(t *testing.T) {
 	}
 }
 
+func TestType_Ephemeral(t *testing.T) {
+	for _, typ := range []Type{TypeTyping, TypeRead} {
+		if !typ.Ephemeral() {
+			t.Errorf("%s should be ephemeral", typ)
+		}
+	}
+	for _, typ := range []Type{TypeChat, TypeSystem, TypeJoin, TypeLeave, TypeEdit, TypeDelete} {
+		if typ.Ephemeral() {
+			t.Errorf("%s should not be ephemeral", typ)
+		}
+	}
+}
+
+func TestMessage_ApplyEdit(t *testing.T) {
+	msg := NewChatMessage("user123", "alice", "v0")
+	base := time.Now().UTC()
+
+	for i := 1; i <= MaxRevisions+2; i++ {
+		msg.ApplyEdit("v"+strconv.Itoa(i), base.Add(time.Duration(i)*time.Second))
+	}
+
+	if msg.Content != "v"+strconv.Itoa(MaxRevisions+2) {
+		t.Errorf("expected latest content, got %q", msg.Content)
+	}
+	if msg.EditedAt == nil || !msg.EditedAt.Equal(base.Add(time.Duration(MaxRevisions+2)*time.Second)) {
+		t.Errorf("unexpected EditedAt %v", msg.EditedAt)
+	}
+	if len(msg.Revisions) != MaxRevisions {
+		t.Fatalf("expected %d revisions, got %d", MaxRevisions, len(msg.Revisions))
+	}
+	// The two oldest revisions (v0, v1) were dropped.
+	if msg.Revisions[0].Content != "v2" || msg.Revisions[MaxRevisions-1].Content != "v"+strconv.Itoa(MaxRevisions+1) {
+		t.Errorf("unexpected revision window: first %q, last %q",
+			msg.Revisions[0].Content, msg.Revisions[MaxRevisions-1].Content)
+	}
+}
+
+func TestMessage_ApplyReaction(t *testing.T) {
+	msg := NewChatMessage("user123", "alice", "ship it")
+
+	steps := []struct {
+		userID, emoji string
+		remove        bool
+		changed       bool
+		want          string
+	}{
+		{"bob", "👍", false, true, "👍:[bob]"},
+		{"carol", "👍", false, true, "👍:[bob carol]"},
+		{"bob", "👍", false, false, "👍:[bob carol]"},
+		{"bob", "🎉", false, true, "👍:[bob carol] 🎉:[bob]"},
+		{"bob", "👍", true, true, "👍:[carol] 🎉:[bob]"},
+		{"bob", "👀", true, false, "👍:[carol] 🎉:[bob]"},
+		{"carol", "👍", true, true, "🎉:[bob]"},
+		{"bob", "🎉", true, true, ""},
+	}
+	for i, step := range steps {
+		if changed := msg.ApplyReaction(step.userID, step.emoji, step.remove); changed != step.changed {
+			t.Errorf("step %d: changed = %v, want %v", i, changed, step.changed)
+		}
+		var got []string
+		for _, r := range msg.Reactions {
+			if r.Count != len(r.UserIDs) {
+				t.Errorf("step %d: %s count %d for %d users", i, r.Emoji, r.Count, len(r.UserIDs))
+			}
+			got = append(got, fmt.Sprintf("%s:%v", r.Emoji, r.UserIDs))
+		}
+		if strings.Join(got, " ") != step.want {
+			t.Errorf("step %d: reactions = %q, want %q", i, strings.Join(got, " "), step.want)
+		}
+	}
+	if msg.Reactions != nil {
+		t.Errorf("expected no reactions left, got %+v", msg.Reactions)
+	}
+}
+
+func TestMessage_Tombstone(t *testing.T) {
+	msg := NewChatMessage("user123", "alice", "oops")
+	msg.MessageID = "m1"
+	msg.ApplyEdit("oops again", time.Now())
+	msg.ApplyReaction("bob", "👍", false)
+	msg.Attachments = []Attachment{{ID: "b1"}}
+	ts := msg.Timestamp
+	deletedAt := time.Now().UTC()
+
+	msg.Tombstone("mod1", deletedAt)
+
+	if msg.Content != "" || msg.Revisions != nil || msg.Reactions != nil || msg.Attachments != nil {
+		t.Errorf("expected content, revisions, reactions and attachments to be discarded, got %q / %v / %v / %v",
+			msg.Content, msg.Revisions, msg.Reactions, msg.Attachments)
+	}
+	if !msg.Deleted || msg.DeletedBy != "mod1" || msg.DeletedAt == nil || !msg.DeletedAt.Equal(deletedAt) {
+		t.Errorf("unexpected tombstone fields: %+v", msg)
+	}
+	if msg.MessageID != "m1" || !msg.Timestamp.Equal(ts) {
+		t.Error("tombstone must keep MessageID and Timestamp")
+	}
+}
+
 func TestNewChatMessage(t *testing.T) {
 	msg := NewChatMessage("user123", "alice", "Hello world")
 
@@ -212,6 +620,110 @@ func TestMessage_JSONFields(t *testing.T) {
 	}
 }
 
+func TestCodeFor(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCode
+	}{
+		{ErrInvalidType, CodeInvalidType},
+		{ErrEmptyContent, CodeEmptyContent},
+		{ErrContentTooLong, CodeTooLong},
+		{ErrClientIDTooLong, CodeTooLong},
+		{ErrEmptyUsername, CodeInvalidUsername},
+		{ErrMissingTarget, CodeMissingTarget},
+		{ErrTooManyAttachments, CodeInvalidAttachment},
+		{ErrMissingRoom, CodeMissingRoom},
+		{ErrMissingRecipient, CodeMissingRecipient},
+		{ErrRecipientTooLong, CodeTooLong},
+		{ErrRoomIDTooLong, CodeTooLong},
+		{ErrInvalidParent, CodeInvalidParent},
+		{ErrParentIDTooLong, CodeTooLong},
+		{ErrMissingEmoji, CodeMissingEmoji},
+		{ErrEmojiTooLong, CodeTooLong},
+		{errors.New("boom"), CodeInternal},
+	}
+	for _, tt := range tests {
+		if got := CodeFor(tt.err); got != tt.want {
+			t.Errorf("CodeFor(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestConversationID(t *testing.T) {
+	if a, b := ConversationID("alice", "bob"), ConversationID("bob", "alice"); a != b || a != "dm:alice:bob" {
+		t.Errorf("ConversationID = %q and %q, want dm:alice:bob for both", a, b)
+	}
+
+	// User IDs containing the separator still round-trip unambiguously
+	id := ConversationID("a:b", "c")
+	if id == ConversationID("a", "b:c") {
+		t.Errorf("distinct pairs share conversation ID %q", id)
+	}
+	if a, b, ok := Participants(id); !ok || a != "a:b" || b != "c" {
+		t.Errorf("Participants(%q) = %q, %q, %v", id, a, b, ok)
+	}
+
+	for _, id := range []string{"lobby", "dm:", "dm:alice", "dm:bob:alice", "dm:alice:bob:carol", "dm:%zz:bob"} {
+		if IsConversationID(id) {
+			t.Errorf("IsConversationID(%q) = true", id)
+		}
+	}
+}
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hey @bob", []string{"bob"}},
+		{"@alice and @bob.smith, thanks @carol.", []string{"alice", "bob.smith", "carol"}},
+		{"@bob @bob @Bob", []string{"bob", "Bob"}},
+		{"(@bob) @élodie", []string{"bob", "élodie"}},
+		{"mail bob@example.com or @ alone or @@bob", nil},
+		{"no mentions here", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseMentions(tt.content); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("ParseMentions(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+
+	var many string
+	for i := 0; i < MaxMentions+5; i++ {
+		many += fmt.Sprintf("@user%d ", i)
+	}
+	if got := ParseMentions(many); len(got) != MaxMentions {
+		t.Errorf("got %d mentions, want them capped at %d", len(got), MaxMentions)
+	}
+}
+
+func TestAck_JSON(t *testing.T) {
+	msg := NewChatMessage("user123", "alice", "hi")
+	msg.MessageID = "m1"
+	msg.ClientMessageID = "c1"
+	msg.RoomID = "lobby"
+
+	data, err := NewAck(msg).ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+	var ack map[string]any
+	json.Unmarshal(data, &ack)
+	if ack["type"] != "ack" || ack["messageId"] != "m1" || ack["clientMessageId"] != "c1" || ack["roomId"] != "lobby" {
+		t.Errorf("unexpected ack: %s", data)
+	}
+	if _, ok := ack["code"]; ok {
+		t.Errorf("ack should not carry a code: %s", data)
+	}
+
+	data, _ = NewNack(nil, CodeInvalidFormat, errors.New("bad json")).ToJSON()
+	var nack map[string]any
+	json.Unmarshal(data, &nack)
+	if nack["type"] != "nack" || nack["code"] != "invalid_format" || nack["error"] != "bad json" {
+		t.Errorf("unexpected nack: %s", data)
+	}
+}
+
 // Benchmark JSON operations
 func BenchmarkMessage_ToJSON(b *testing.B) {
 	msg := NewChatMessage("user123", "alice", "Hello world")
@@ -0,0 +1,225 @@
+package persist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// DeadLetter is a message the writer gave up storing after exhausting its
+// retries.
+type DeadLetter struct {
+	ID       string           `json:"id"`
+	Message  *message.Message `json:"message"`
+	Error    string           `json:"error"`
+	Attempts int              `json:"attempts"`
+	FailedAt time.Time        `json:"failedAt"`
+}
+
+// DeadLetterQueue holds dead-lettered messages until they are re-driven.
+type DeadLetterQueue interface {
+	// Put adds dead letters to the queue.
+	Put(letters ...DeadLetter) error
+
+	// List returns up to limit dead letters, oldest first; zero means all.
+	List(limit int) ([]DeadLetter, error)
+
+	// Remove deletes dead letters by ID. Unknown IDs are ignored.
+	Remove(ids ...string) error
+}
+
+// MemoryDeadLetterQueue is a non-durable DeadLetterQueue for development and
+// tests. It is safe for concurrent use.
+type MemoryDeadLetterQueue struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// NewMemoryDeadLetterQueue creates an empty in-memory queue.
+func NewMemoryDeadLetterQueue() *MemoryDeadLetterQueue {
+	return &MemoryDeadLetterQueue{}
+}
+
+// Put adds dead letters to the queue.
+func (q *MemoryDeadLetterQueue) Put(letters ...DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.letters = append(q.letters, letters...)
+	return nil
+}
+
+// List returns up to limit dead letters, oldest first.
+func (q *MemoryDeadLetterQueue) List(limit int) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return head(q.letters, limit), nil
+}
+
+// Remove deletes dead letters by ID.
+func (q *MemoryDeadLetterQueue) Remove(ids ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.letters = without(q.letters, ids)
+	return nil
+}
+
+// FileDeadLetterQueue is a DeadLetterQueue kept in a JSON-lines file. New
+// dead letters are appended and fsynced; removals rewrite the file atomically.
+// The whole queue is also held in memory, which suits the small volume of a
+// dead-letter queue. It is safe for concurrent use.
+type FileDeadLetterQueue struct {
+	path string
+
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// OpenFileDeadLetterQueue opens or creates the queue file at path.
+func OpenFileDeadLetterQueue(path string) (*FileDeadLetterQueue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+
+	q := &FileDeadLetterQueue{path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter queue: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	corrupt := false
+	for scanner.Scan() {
+		var l DeadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			corrupt = true // torn final line from a crash mid-append
+			continue
+		}
+		q.letters = append(q.letters, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter queue: %w", err)
+	}
+
+	// Drop unreadable lines so later appends start on a fresh line
+	if corrupt {
+		if err := q.rewrite(q.letters); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Put appends dead letters to the file.
+func (q *FileDeadLetterQueue) Put(letters ...DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter queue: %w", err)
+	}
+	defer f.Close()
+
+	if err := writeLetters(f, letters); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dead-letter queue: %w", err)
+	}
+	q.letters = append(q.letters, letters...)
+	return nil
+}
+
+// List returns up to limit dead letters, oldest first.
+func (q *FileDeadLetterQueue) List(limit int) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return head(q.letters, limit), nil
+}
+
+// Remove deletes dead letters by ID, rewriting the file.
+func (q *FileDeadLetterQueue) Remove(ids ...string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	remaining := without(q.letters, ids)
+	if len(remaining) == len(q.letters) {
+		return nil
+	}
+	return q.rewrite(remaining)
+}
+
+// rewrite atomically replaces the file with letters and adopts them. Must be
+// called with q.mu held (or before the queue is shared).
+func (q *FileDeadLetterQueue) rewrite(letters []DeadLetter) error {
+	tmp := q.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite dead-letter queue: %w", err)
+	}
+	if err := writeLetters(f, letters); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync dead-letter queue: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to rewrite dead-letter queue: %w", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("failed to rewrite dead-letter queue: %w", err)
+	}
+	q.letters = letters
+	return nil
+}
+
+// writeLetters writes one JSON document per line.
+func writeLetters(f *os.File, letters []DeadLetter) error {
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, l := range letters {
+		if err := enc.Encode(l); err != nil {
+			return fmt.Errorf("failed to write dead letter: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+// head copies up to limit letters; zero means all.
+func head(letters []DeadLetter, limit int) []DeadLetter {
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return append([]DeadLetter(nil), letters...)
+}
+
+// without returns the letters whose IDs are not in ids.
+func without(letters []DeadLetter, ids []string) []DeadLetter {
+	drop := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	kept := make([]DeadLetter, 0, len(letters))
+	for _, l := range letters {
+		if !drop[l.ID] {
+			kept = append(kept, l)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,116 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+func testLetters(ids ...string) []DeadLetter {
+	letters := make([]DeadLetter, len(ids))
+	for i, id := range ids {
+		letters[i] = DeadLetter{
+			ID:       id,
+			Message:  &message.Message{MessageID: "msg-" + id, Content: "hi"},
+			Error:    "boom",
+			Attempts: 4,
+			FailedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}
+	}
+	return letters
+}
+
+func letterIDs(t *testing.T, q DeadLetterQueue) []string {
+	t.Helper()
+	letters, err := q.List(0)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	ids := make([]string, len(letters))
+	for i, l := range letters {
+		ids[i] = l.ID
+	}
+	return ids
+}
+
+func testDeadLetterQueue(t *testing.T, q DeadLetterQueue) {
+	if err := q.Put(testLetters("a", "b")...); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := q.Put(testLetters("c")...); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := letterIDs(t, q); len(got) != 3 || got[0] != "a" || got[2] != "c" {
+		t.Fatalf("List = %v, want [a b c]", got)
+	}
+	if page, _ := q.List(2); len(page) != 2 {
+		t.Errorf("List(2) returned %d letters", len(page))
+	}
+
+	if err := q.Remove("b", "unknown"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := letterIDs(t, q); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("List after Remove = %v, want [a c]", got)
+	}
+}
+
+func TestMemoryDeadLetterQueue(t *testing.T) {
+	testDeadLetterQueue(t, NewMemoryDeadLetterQueue())
+}
+
+func TestFileDeadLetterQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dlq", "dead-letters.jsonl")
+	q, err := OpenFileDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("OpenFileDeadLetterQueue: %v", err)
+	}
+	testDeadLetterQueue(t, q)
+
+	// Survives reopening
+	q, err = OpenFileDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	letters, _ := q.List(0)
+	if len(letters) != 2 || letters[0].Message.MessageID != "msg-a" || letters[0].Attempts != 4 {
+		t.Fatalf("reopened queue = %+v", letters)
+	}
+}
+
+func TestFileDeadLetterQueue_TornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letters.jsonl")
+	q, err := OpenFileDeadLetterQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Put(testLetters("a")...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a crash halfway through appending a line
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":"b","mess`)
+	f.Close()
+
+	q, err = OpenFileDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if err := q.Put(testLetters("c")...); err != nil {
+		t.Fatal(err)
+	}
+	q, err = OpenFileDeadLetterQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := letterIDs(t, q); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("List = %v, want [a c]", got)
+	}
+}
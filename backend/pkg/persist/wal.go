@@ -0,0 +1,361 @@
+package persist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// ErrWALClosed is returned when appending to a closed log.
+var ErrWALClosed = errors.New("write-ahead log is closed")
+
+const (
+	defaultSegmentSize = 4 << 20 // 4 MiB
+	segmentExt         = ".wal"
+
+	// Record kinds
+	recordEntry byte = 1
+	recordAck   byte = 2
+
+	// Record header: payload length and CRC-32 of the payload
+	headerSize = 8
+
+	// Upper bound on a single record, to reject garbage lengths in a torn file
+	maxRecordSize = 1 << 20
+)
+
+// Entry is a message recorded in the log, identified by its sequence number.
+type Entry struct {
+	Seq uint64
+	Msg *message.Message
+}
+
+// segment is one log file. It holds the entries with sequence numbers from
+// first up to the next segment's first.
+type segment struct {
+	path    string
+	first   uint64
+	pending map[uint64]struct{} // entries not yet acknowledged
+}
+
+// WAL is an append-only, segmented on-disk log of messages accepted for
+// persistence. Entries are fsynced before Append returns and acknowledged once
+// stored; a segment is deleted when it and every older segment are fully
+// acknowledged. Opening a log recovers the entries that were never
+// acknowledged so they can be replayed. It is safe for concurrent use.
+//
+// Acknowledgements are written without fsync, so a crash can replay an entry
+// that was already stored: replay is at-least-once.
+type WAL struct {
+	dir         string
+	segmentSize int64
+	logger      *slog.Logger
+
+	mu         sync.Mutex
+	segments   []*segment // oldest first; the last one is active
+	active     *os.File
+	activeSize int64
+	nextSeq    uint64
+	recovered  []Entry
+	closed     bool
+}
+
+// OpenWAL opens or creates the log in dir. A non-positive segmentSize uses
+// the default of 4 MiB. Entries left unacknowledged by a previous process are
+// available from Recovered.
+func OpenWAL(dir string, segmentSize int64, logger *slog.Logger) (*WAL, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL directory: %w", err)
+	}
+
+	w := &WAL{dir: dir, segmentSize: segmentSize, logger: logger, nextSeq: 1}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	if err := w.roll(); err != nil {
+		return nil, err
+	}
+	w.compact()
+
+	if len(w.recovered) > 0 {
+		logger.Info("recovered unacknowledged WAL entries",
+			slog.String("dir", dir),
+			slog.Int("entries", len(w.recovered)))
+	}
+	return w, nil
+}
+
+// recover reads every existing segment, rebuilding pending entries.
+func (w *WAL) recover() error {
+	paths, err := filepath.Glob(filepath.Join(w.dir, "*"+segmentExt))
+	if err != nil {
+		return fmt.Errorf("failed to list WAL segments: %w", err)
+	}
+
+	for _, path := range paths {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), segmentExt), 10, 64)
+		if err != nil {
+			continue // not one of ours
+		}
+		w.segments = append(w.segments, &segment{path: path, first: first, pending: make(map[uint64]struct{})})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].first < w.segments[j].first })
+
+	entries := make(map[uint64]*message.Message)
+	for _, seg := range w.segments {
+		err := w.readSegment(seg.path, func(kind byte, seq uint64, body []byte) {
+			switch kind {
+			case recordEntry:
+				var msg message.Message
+				if err := json.Unmarshal(body, &msg); err != nil {
+					w.logger.Warn("skipping corrupt WAL entry",
+						slog.String("segment", seg.path),
+						slog.Uint64("seq", seq))
+					return
+				}
+				entries[seq] = &msg
+				seg.pending[seq] = struct{}{}
+			case recordAck:
+				delete(entries, seq)
+				if s := w.segmentFor(seq); s != nil {
+					delete(s.pending, seq)
+				}
+			}
+			if seq >= w.nextSeq {
+				w.nextSeq = seq + 1
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if seg.first > w.nextSeq {
+			w.nextSeq = seg.first
+		}
+	}
+
+	for seq, msg := range entries {
+		w.recovered = append(w.recovered, Entry{Seq: seq, Msg: msg})
+	}
+	sort.Slice(w.recovered, func(i, j int) bool { return w.recovered[i].Seq < w.recovered[j].Seq })
+	return nil
+}
+
+// readSegment calls fn for every intact record in a segment. Reading stops at
+// the first torn or corrupt record, which is what a crash mid-write leaves
+// behind, and the segment is truncated there so later appends stay readable.
+func (w *WAL) readSegment(path string, fn func(kind byte, seq uint64, body []byte)) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	header := make([]byte, headerSize)
+	var offset int64
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		size := binary.BigEndian.Uint32(header[0:4])
+		sum := binary.BigEndian.Uint32(header[4:8])
+		if size < 9 || size > maxRecordSize {
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(payload) != sum {
+			break
+		}
+		fn(payload[0], binary.BigEndian.Uint64(payload[1:9]), payload[9:])
+		offset += headerSize + int64(size)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat WAL segment: %w", err)
+	}
+	if info.Size() > offset {
+		w.logger.Warn("truncating torn WAL segment",
+			slog.String("segment", path),
+			slog.Int64("offset", offset))
+		if err := f.Truncate(offset); err != nil {
+			return fmt.Errorf("failed to truncate WAL segment: %w", err)
+		}
+	}
+	return nil
+}
+
+// Recovered returns the entries a previous process appended but never
+// acknowledged, oldest first.
+func (w *WAL) Recovered() []Entry {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]Entry(nil), w.recovered...)
+}
+
+// Append durably records msg and returns its sequence number.
+func (w *WAL) Append(msg *message.Message) (uint64, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal WAL entry: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, ErrWALClosed
+	}
+	if w.activeSize >= w.segmentSize {
+		if err := w.roll(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := w.nextSeq
+	if err := w.write(recordEntry, seq, body); err != nil {
+		return 0, err
+	}
+	if err := w.active.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	w.nextSeq++
+	w.segments[len(w.segments)-1].pending[seq] = struct{}{}
+	return seq, nil
+}
+
+// Ack marks entries as stored so they are not replayed, deleting segments
+// that no longer hold unacknowledged entries. Unknown or repeated sequence
+// numbers are ignored.
+func (w *WAL) Ack(seqs ...uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrWALClosed
+	}
+
+	for _, seq := range seqs {
+		seg := w.segmentFor(seq)
+		if seg == nil {
+			continue
+		}
+		if _, ok := seg.pending[seq]; !ok {
+			continue
+		}
+		if err := w.write(recordAck, seq, nil); err != nil {
+			return err
+		}
+		delete(seg.pending, seq)
+	}
+	w.compact()
+	return nil
+}
+
+// Close syncs and closes the active segment.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if err := w.active.Sync(); err != nil {
+		w.active.Close()
+		return fmt.Errorf("failed to sync WAL: %w", err)
+	}
+	return w.active.Close()
+}
+
+// write appends one record to the active segment. Must be called with w.mu
+// held.
+func (w *WAL) write(kind byte, seq uint64, body []byte) error {
+	record := make([]byte, headerSize+9+len(body))
+	payload := record[headerSize:]
+	payload[0] = kind
+	binary.BigEndian.PutUint64(payload[1:9], seq)
+	copy(payload[9:], body)
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+
+	n, err := w.active.Write(record)
+	w.activeSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write WAL: %w", err)
+	}
+	return nil
+}
+
+// roll closes the active segment, if any, and starts a new one at nextSeq.
+// Must be called with w.mu held (or before the log is shared).
+func (w *WAL) roll() error {
+	if w.active != nil {
+		if err := w.active.Sync(); err != nil {
+			return fmt.Errorf("failed to sync WAL: %w", err)
+		}
+		if err := w.active.Close(); err != nil {
+			return fmt.Errorf("failed to close WAL segment: %w", err)
+		}
+	}
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%s", w.nextSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create WAL segment: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat WAL segment: %w", err)
+	}
+
+	w.active = f
+	w.activeSize = info.Size()
+	if n := len(w.segments); n == 0 || w.segments[n-1].path != path {
+		w.segments = append(w.segments, &segment{path: path, first: w.nextSeq, pending: make(map[uint64]struct{})})
+	}
+	return nil
+}
+
+// compact deletes fully acknowledged segments from the oldest end. A segment
+// can hold acknowledgements for older segments' entries, so it is only
+// deleted once every older segment is gone. The active segment is kept. Must
+// be called with w.mu held.
+func (w *WAL) compact() {
+	for len(w.segments) > 1 && len(w.segments[0].pending) == 0 {
+		if err := os.Remove(w.segments[0].path); err != nil && !os.IsNotExist(err) {
+			w.logger.Warn("failed to remove WAL segment",
+				slog.String("segment", w.segments[0].path),
+				slog.String("error", err.Error()))
+			return
+		}
+		w.segments = w.segments[1:]
+	}
+}
+
+// segmentFor returns the segment holding seq, or nil. Must be called with
+// w.mu held.
+func (w *WAL) segmentFor(seq uint64) *segment {
+	i := sort.Search(len(w.segments), func(i int) bool { return w.segments[i].first > seq })
+	if i == 0 {
+		return nil
+	}
+	return w.segments[i-1]
+}
@@ -0,0 +1,171 @@
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+func openTestWAL(t *testing.T, dir string, segmentSize int64) *WAL {
+	t.Helper()
+	wal, err := OpenWAL(dir, segmentSize, testLogger())
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	return wal
+}
+
+func segmentCount(t *testing.T, dir string) int {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(paths)
+}
+
+func recoveredIDs(wal *WAL) []string {
+	var ids []string
+	for _, e := range wal.Recovered() {
+		ids = append(ids, e.Msg.MessageID)
+	}
+	return ids
+}
+
+func TestWAL_RecoversUnacknowledged(t *testing.T) {
+	dir := t.TempDir()
+	wal := openTestWAL(t, dir, 0)
+
+	seqs := make(map[string]uint64)
+	for _, id := range []string{"a", "b", "c"} {
+		seq, err := wal.Append(&message.Message{MessageID: id, Content: "hello " + id})
+		if err != nil {
+			t.Fatalf("Append(%s): %v", id, err)
+		}
+		seqs[id] = seq
+	}
+	if err := wal.Ack(seqs["b"]); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	wal = openTestWAL(t, dir, 0)
+	defer wal.Close()
+	got := recoveredIDs(wal)
+	if len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Fatalf("recovered %v, want [a c]", got)
+	}
+	if e := wal.Recovered()[0]; e.Msg.Content != "hello a" {
+		t.Errorf("recovered content = %q", e.Msg.Content)
+	}
+
+	// New entries never reuse recovered sequence numbers
+	seq, err := wal.Append(&message.Message{MessageID: "d"})
+	if err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	if seq <= seqs["c"] {
+		t.Errorf("new seq %d <= recovered seq %d", seq, seqs["c"])
+	}
+}
+
+func TestWAL_AcknowledgedAcrossRestart(t *testing.T) {
+	// Entries recovered by one process and acknowledged by the next are not
+	// replayed a third time
+	dir := t.TempDir()
+	wal := openTestWAL(t, dir, 0)
+	if _, err := wal.Append(&message.Message{MessageID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	wal = openTestWAL(t, dir, 0)
+	if err := wal.Ack(wal.Recovered()[0].Seq); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	wal = openTestWAL(t, dir, 0)
+	defer wal.Close()
+	if got := recoveredIDs(wal); len(got) != 0 {
+		t.Errorf("recovered %v after acknowledgement, want none", got)
+	}
+}
+
+func TestWAL_DeletesAcknowledgedSegments(t *testing.T) {
+	dir := t.TempDir()
+	wal := openTestWAL(t, dir, 1) // roll on every append
+	defer wal.Close()
+
+	var seqs []uint64
+	for i := 0; i < 4; i++ {
+		seq, err := wal.Append(&message.Message{MessageID: "m"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	if n := segmentCount(t, dir); n != 4 {
+		t.Fatalf("got %d segments, want 4", n)
+	}
+
+	// An acknowledged segment behind an unacknowledged one is kept
+	if err := wal.Ack(seqs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := segmentCount(t, dir); n != 4 {
+		t.Errorf("got %d segments after out-of-order ack, want 4", n)
+	}
+
+	if err := wal.Ack(seqs[0], seqs[2]); err != nil {
+		t.Fatal(err)
+	}
+	if n := segmentCount(t, dir); n != 1 {
+		t.Errorf("got %d segments, want only the active one", n)
+	}
+}
+
+func TestWAL_TruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	wal := openTestWAL(t, dir, 0)
+	if _, err := wal.Append(&message.Message{MessageID: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	// Simulate a crash halfway through writing a record
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	f, err := os.OpenFile(paths[0], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	wal = openTestWAL(t, dir, 0)
+	if got := recoveredIDs(wal); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("recovered %v, want [a]", got)
+	}
+	if _, err := wal.Append(&message.Message{MessageID: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+
+	wal = openTestWAL(t, dir, 0)
+	defer wal.Close()
+	if got := recoveredIDs(wal); len(got) != 2 || got[1] != "b" {
+		t.Errorf("recovered %v, want [a b]", got)
+	}
+}
+
+func TestWAL_AppendAfterClose(t *testing.T) {
+	wal := openTestWAL(t, t.TempDir(), 0)
+	wal.Close()
+	if _, err := wal.Append(&message.Message{MessageID: "a"}); err != ErrWALClosed {
+		t.Errorf("Append after Close = %v, want ErrWALClosed", err)
+	}
+}
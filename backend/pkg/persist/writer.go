@@ -1,16 +1,20 @@
 // Package persist provides a bounded, batching writer that decouples the hot
 // WebSocket path from storage latency. Messages are enqueued without blocking
-// and flushed to the repository by a fixed pool of workers.
+// and flushed to the repository by a fixed pool of workers. An optional
+// write-ahead log makes accepted messages survive a full queue, failed writes
+// and crashes.
 package persist
 
 import (
 	"context"
 	"log/slog"
+	"math/rand/v2"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/google/uuid"
 )
 
 // Repository is the subset of storage the writer needs.
@@ -18,36 +22,92 @@ type Repository interface {
 	BatchSaveMessages(ctx context.Context, msgs []*message.Message) error
 }
 
+// Observer is notified of every batch the writer has persisted, e.g. to keep
+// a search index in step with storage. It must not retain the slice.
+type Observer interface {
+	Persisted(msgs []*message.Message)
+}
+
+// ObserverFunc adapts an ordinary function to the Observer interface.
+type ObserverFunc func(msgs []*message.Message)
+
+// Persisted calls f(msgs).
+func (f ObserverFunc) Persisted(msgs []*message.Message) {
+	f(msgs)
+}
+
 // Config tunes the writer. Non-positive fields fall back to defaults.
 type Config struct {
 	Workers       int
 	BatchSize     int
 	QueueSize     int
 	FlushInterval time.Duration
+
+	// A failed batch is retried up to MaxRetries times, waiting a jittered
+	// exponential backoff starting at RetryBaseDelay and capped at
+	// RetryMaxDelay, before it is dead-lettered.
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
 }
 
 const (
 	defaultWorkers       = 4
-	defaultBatchSize     = 25 // DynamoDB BatchWriteItem hard limit
+	defaultBatchSize     = 25 // messages handed to BatchSaveMessages per flush
 	defaultQueueSize     = 1024
 	defaultFlushInterval = 500 * time.Millisecond
 	maxBatchSize         = 25
 	writeTimeout         = 5 * time.Second
+
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
 )
 
+// queued is a message awaiting persistence and its WAL sequence number (zero
+// without a WAL).
+type queued struct {
+	msg *message.Message
+	seq uint64
+}
+
 // Writer batches enqueued messages and persists them via a bounded worker pool.
 type Writer struct {
 	repo          Repository
 	logger        *slog.Logger
-	queue         chan *message.Message
+	queue         chan queued
 	batchSize     int
 	flushInterval time.Duration
 	workers       int
+	observer      Observer
+	wal           *WAL
+	deadLetters   DeadLetterQueue
+
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
 
 	wg      sync.WaitGroup
 	mu      sync.RWMutex // guards closed, serialized against Enqueue sends
 	closed  bool
 	dropped atomic.Int64
+
+	// deferred holds accepted messages the queue had no room for, and
+	// batches that could be neither stored nor dead-lettered. The workers
+	// retry them every flush interval.
+	deferMu  sync.Mutex
+	deferred []queued
+
+	// pending maps each accepted message not yet stored or dead-lettered
+	// to a channel closed once it is, for Await.
+	pendingMu sync.Mutex
+	pending   map[pendingKey]chan struct{}
+}
+
+// pendingKey identifies an accepted message by room and message ID.
+type pendingKey struct {
+	roomID    string
+	messageID string
 }
 
 // New builds a Writer. Call Start to launch the workers.
@@ -67,49 +127,189 @@ func New(repo Repository, logger *slog.Logger, cfg Config) *Writer {
 	if cfg.FlushInterval <= 0 {
 		cfg.FlushInterval = defaultFlushInterval
 	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.RetryBaseDelay <= 0 {
+		cfg.RetryBaseDelay = defaultRetryBaseDelay
+	}
+	if cfg.RetryMaxDelay <= 0 {
+		cfg.RetryMaxDelay = defaultRetryMaxDelay
+	}
 	return &Writer{
-		repo:          repo,
-		logger:        logger,
-		queue:         make(chan *message.Message, cfg.QueueSize),
-		batchSize:     cfg.BatchSize,
-		flushInterval: cfg.FlushInterval,
-		workers:       cfg.Workers,
+		repo:           repo,
+		logger:         logger,
+		queue:          make(chan queued, cfg.QueueSize),
+		batchSize:      cfg.BatchSize,
+		flushInterval:  cfg.FlushInterval,
+		workers:        cfg.Workers,
+		deadLetters:    NewMemoryDeadLetterQueue(),
+		pending:        make(map[pendingKey]chan struct{}),
+		maxRetries:     cfg.MaxRetries,
+		retryBaseDelay: cfg.RetryBaseDelay,
+		retryMaxDelay:  cfg.RetryMaxDelay,
 	}
 }
 
-// Start launches the worker pool.
+// SetObserver registers an observer for successfully persisted batches
+// (optional). Must be called before Start.
+func (w *Writer) SetObserver(o Observer) {
+	w.observer = o
+}
+
+// SetWAL makes the writer log every enqueued message to wal before queueing
+// it and acknowledge it once stored (optional). Must be called before Start;
+// the writer closes the log on Close.
+func (w *Writer) SetWAL(wal *WAL) {
+	w.wal = wal
+}
+
+// SetDeadLetterQueue replaces the default in-memory dead-letter queue
+// (optional). Must be called before Start. With a WAL, dead-lettered entries
+// are acknowledged, so the queue should be durable.
+func (w *Writer) SetDeadLetterQueue(q DeadLetterQueue) {
+	w.deadLetters = q
+}
+
+// Start replays messages the WAL recovered from a previous run, then launches
+// the worker pool. Replayed messages are retried and dead-lettered like any
+// other batch.
 func (w *Writer) Start() {
+	if w.wal != nil {
+		w.replay(w.wal.Recovered())
+	}
 	for i := 0; i < w.workers; i++ {
 		w.wg.Add(1)
 		go w.worker()
 	}
 }
 
-// Enqueue submits a message for asynchronous persistence. It never blocks: if
-// the queue is full the message is dropped and counted. Safe to call after
-// Close (the message is silently dropped).
-func (w *Writer) Enqueue(msg *message.Message) {
+// Enqueue submits a message for asynchronous persistence and reports whether
+// it was accepted. It never blocks: if the queue is full the message is
+// dropped and counted, unless the WAL holds it and fewer than a queue's worth
+// of messages are already deferred, in which case it is accepted and stored
+// by a worker at its next flush. Safe to call after Close (the message is
+// rejected).
+func (w *Writer) Enqueue(msg *message.Message) bool {
 	w.mu.RLock()
 	defer w.mu.RUnlock()
 	if w.closed {
-		return
+		return false
+	}
+
+	var seq uint64
+	if w.wal != nil {
+		var err error
+		if seq, err = w.wal.Append(msg); err != nil {
+			w.logger.Error("failed to append message to WAL",
+				slog.String("messageID", msg.MessageID),
+				slog.String("error", err.Error()))
+		}
 	}
+
+	// Tracked before queueing, so a worker never stores it untracked
+	w.track(msg)
 	select {
-	case w.queue <- msg:
+	case w.queue <- queued{msg: msg, seq: seq}:
+		return true
 	default:
+		if seq != 0 && w.deferQueued(queued{msg: msg, seq: seq}) {
+			w.logger.Warn("persistence queue full, deferring message",
+				slog.String("messageID", msg.MessageID))
+			return true
+		}
+		w.settle([]queued{{msg: msg}})
+		if seq != 0 {
+			// The sender is told to retry, so a restart must not store it too.
+			w.ack([]queued{{msg: msg, seq: seq}})
+		}
 		n := w.dropped.Add(1)
 		w.logger.Warn("persistence queue full, dropping message",
 			slog.String("messageID", msg.MessageID),
 			slog.Int64("totalDropped", n))
+		return false
+	}
+}
+
+// deferQueued adds q to the deferred messages unless a queue's worth are
+// already waiting, and reports whether it did.
+func (w *Writer) deferQueued(q queued) bool {
+	w.deferMu.Lock()
+	defer w.deferMu.Unlock()
+	if len(w.deferred) >= cap(w.queue) {
+		return false
+	}
+	w.deferred = append(w.deferred, q)
+	return true
+}
+
+// requeue defers a batch that has already been accepted, whatever the
+// number waiting.
+func (w *Writer) requeue(batch []queued) {
+	w.deferMu.Lock()
+	defer w.deferMu.Unlock()
+	w.deferred = append(w.deferred, batch...)
+}
+
+// takeDeferred removes and returns every deferred message, oldest first.
+func (w *Writer) takeDeferred() []queued {
+	w.deferMu.Lock()
+	defer w.deferMu.Unlock()
+	taken := w.deferred
+	w.deferred = nil
+	return taken
+}
+
+// track marks msg as pending until settle is called for it.
+func (w *Writer) track(msg *message.Message) {
+	w.pendingMu.Lock()
+	defer w.pendingMu.Unlock()
+	key := pendingKey{roomID: msg.RoomID, messageID: msg.MessageID}
+	if _, ok := w.pending[key]; !ok {
+		w.pending[key] = make(chan struct{})
+	}
+}
+
+// settle ends the pending state of a batch's messages, waking their waiters.
+func (w *Writer) settle(batch []queued) {
+	w.pendingMu.Lock()
+	defer w.pendingMu.Unlock()
+	for _, q := range batch {
+		key := pendingKey{roomID: q.msg.RoomID, messageID: q.msg.MessageID}
+		if done, ok := w.pending[key]; ok {
+			close(done)
+			delete(w.pending, key)
+		}
+	}
+}
+
+// Await blocks while a message accepted by Enqueue is waiting to be stored,
+// until it is stored or dead-lettered or ctx is done. It reports whether the
+// message was pending and has since settled, in which case a lookup that
+// missed it is worth repeating.
+func (w *Writer) Await(ctx context.Context, roomID, messageID string) bool {
+	w.pendingMu.Lock()
+	done, ok := w.pending[pendingKey{roomID: roomID, messageID: messageID}]
+	w.pendingMu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
-// Dropped returns the number of messages dropped because the queue was full.
+// Dropped returns the number of messages dropped because the queue was full
+// and they could not be deferred.
 func (w *Writer) Dropped() int64 {
 	return w.dropped.Load()
 }
 
-// Close stops accepting messages and waits for in-flight batches to flush.
+// Close stops accepting messages and waits for in-flight batches to flush,
+// including their retries.
 func (w *Writer) Close() {
 	w.mu.Lock()
 	if w.closed {
@@ -121,12 +321,184 @@ func (w *Writer) Close() {
 	w.mu.Unlock()
 
 	w.wg.Wait()
+
+	if w.wal != nil {
+		if err := w.wal.Close(); err != nil {
+			w.logger.Error("failed to close WAL", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// replay stores recovered WAL entries in batches, acknowledging each batch
+// that succeeds.
+func (w *Writer) replay(entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	batch := make([]queued, 0, w.batchSize)
+	for i, e := range entries {
+		batch = append(batch, queued{msg: e.Msg, seq: e.Seq})
+		if len(batch) == w.batchSize || i == len(entries)-1 {
+			w.store(batch)
+			batch = batch[:0]
+		}
+	}
+	w.logger.Info("replayed WAL entries", slog.Int("entries", len(entries)))
+}
+
+// store persists one batch, retrying failures with backoff, then notifies the
+// observer and acknowledges the batch's WAL entries. A batch that exhausts its
+// retries is dead-lettered.
+func (w *Writer) store(batch []queued) {
+	msgs := make([]*message.Message, len(batch))
+	for i, q := range batch {
+		msgs[i] = q.msg
+	}
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		err := w.repo.BatchSaveMessages(ctx, msgs)
+		cancel()
+		if err == nil {
+			break
+		}
+		if attempt > w.maxRetries {
+			w.deadLetter(batch, err, attempt)
+			return
+		}
+		w.logger.Warn("failed to persist message batch, retrying",
+			slog.Int("batchSize", len(msgs)),
+			slog.Int("attempt", attempt),
+			slog.String("error", err.Error()))
+		time.Sleep(w.backoff(attempt))
+	}
+
+	if w.observer != nil {
+		w.observer.Persisted(msgs)
+	}
+	w.ack(batch)
+	w.settle(batch)
+}
+
+// backoff returns the jittered exponential delay before retry n (1-based): a
+// random duration between half and all of base*2^(n-1), capped at the
+// maximum.
+func (w *Writer) backoff(n int) time.Duration {
+	delay := w.retryMaxDelay
+	if shift := n - 1; shift < 32 && w.retryBaseDelay<<shift < w.retryMaxDelay {
+		delay = w.retryBaseDelay << shift
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// deadLetter moves a batch that could not be stored to the dead-letter queue
+// and acknowledges its WAL entries. If the queue rejects it too, the batch is
+// deferred to be stored again at the next flush.
+func (w *Writer) deadLetter(batch []queued, cause error, attempts int) {
+	now := time.Now().UTC()
+	letters := make([]DeadLetter, len(batch))
+	for i, q := range batch {
+		letters[i] = DeadLetter{
+			ID:       uuid.New().String(),
+			Message:  q.msg,
+			Error:    cause.Error(),
+			Attempts: attempts,
+			FailedAt: now,
+		}
+	}
+
+	if err := w.deadLetters.Put(letters...); err != nil {
+		w.logger.Error("failed to dead-letter message batch, deferring it",
+			slog.Int("batchSize", len(batch)),
+			slog.String("cause", cause.Error()),
+			slog.String("error", err.Error()))
+		w.requeue(batch)
+		return
+	}
+	w.logger.Error("message batch dead-lettered",
+		slog.Int("batchSize", len(batch)),
+		slog.Int("attempts", attempts),
+		slog.String("error", cause.Error()))
+	w.ack(batch)
+	w.settle(batch)
+}
+
+// ack acknowledges a batch's WAL entries.
+func (w *Writer) ack(batch []queued) {
+	if w.wal == nil {
+		return
+	}
+	seqs := make([]uint64, 0, len(batch))
+	for _, q := range batch {
+		if q.seq != 0 {
+			seqs = append(seqs, q.seq)
+		}
+	}
+	if err := w.wal.Ack(seqs...); err != nil {
+		w.logger.Error("failed to acknowledge WAL entries", slog.String("error", err.Error()))
+	}
+}
+
+// DeadLetters returns up to limit dead-lettered messages, oldest first; zero
+// means all.
+func (w *Writer) DeadLetters(limit int) ([]DeadLetter, error) {
+	return w.deadLetters.List(limit)
+}
+
+// Redrive stores dead-lettered messages again, in batches, removing each
+// batch from the queue once stored. With no IDs every dead letter is
+// re-driven; unknown IDs are ignored. Returns how many were stored before the
+// first failure.
+func (w *Writer) Redrive(ctx context.Context, ids ...string) (int, error) {
+	letters, err := w.deadLetters.List(0)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) > 0 {
+		want := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			want[id] = true
+		}
+		selected := letters[:0]
+		for _, l := range letters {
+			if want[l.ID] {
+				selected = append(selected, l)
+			}
+		}
+		letters = selected
+	}
+
+	redriven := 0
+	for start := 0; start < len(letters); start += w.batchSize {
+		chunk := letters[start:min(start+w.batchSize, len(letters))]
+		msgs := make([]*message.Message, len(chunk))
+		batchIDs := make([]string, len(chunk))
+		for i, l := range chunk {
+			msgs[i] = l.Message
+			batchIDs[i] = l.ID
+		}
+
+		writeCtx, cancel := context.WithTimeout(ctx, writeTimeout)
+		err := w.repo.BatchSaveMessages(writeCtx, msgs)
+		cancel()
+		if err != nil {
+			return redriven, err
+		}
+		if w.observer != nil {
+			w.observer.Persisted(msgs)
+		}
+		if err := w.deadLetters.Remove(batchIDs...); err != nil {
+			return redriven, err
+		}
+		redriven += len(chunk)
+	}
+	return redriven, nil
 }
 
 func (w *Writer) worker() {
 	defer w.wg.Done()
 
-	batch := make([]*message.Message, 0, w.batchSize)
+	batch := make([]queued, 0, w.batchSize)
 	timer := time.NewTimer(w.flushInterval)
 	defer timer.Stop()
 
@@ -144,30 +516,35 @@ func (w *Writer) worker() {
 		if len(batch) == 0 {
 			return
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
-		if err := w.repo.BatchSaveMessages(ctx, batch); err != nil {
-			w.logger.Error("failed to persist message batch",
-				slog.Int("batchSize", len(batch)),
-				slog.String("error", err.Error()))
-		}
-		cancel()
+		w.store(batch)
 		batch = batch[:0]
 	}
 
+	// storeDeferred stores the messages deferred so far in batches. Any it
+	// defers again wait for the next flush, or on shutdown for the WAL replay.
+	storeDeferred := func() {
+		pending := w.takeDeferred()
+		for start := 0; start < len(pending); start += w.batchSize {
+			w.store(pending[start:min(start+w.batchSize, len(pending))])
+		}
+	}
+
 	for {
 		select {
-		case msg, ok := <-w.queue:
+		case q, ok := <-w.queue:
 			if !ok {
 				flush() // drain remaining on shutdown
+				storeDeferred()
 				return
 			}
-			batch = append(batch, msg)
+			batch = append(batch, q)
 			if len(batch) >= w.batchSize {
 				flush()
 				resetTimer()
 			}
 		case <-timer.C:
 			flush()
+			storeDeferred()
 			timer.Reset(w.flushInterval)
 		}
 	}
@@ -107,12 +107,18 @@ func TestWriter_DropsWhenQueueFull(t *testing.T) {
 	w.Start()
 
 	// Flood well past worker(1)+queue(1) capacity.
+	rejected := 0
 	for i := 0; i < 100; i++ {
-		w.Enqueue(&message.Message{MessageID: "m"})
+		if !w.Enqueue(&message.Message{MessageID: "m"}) {
+			rejected++
+		}
 	}
 	if w.Dropped() == 0 {
 		t.Error("expected some messages to be dropped when the queue is saturated")
 	}
+	if int64(rejected) != w.Dropped() {
+		t.Errorf("Enqueue rejected %d messages, but %d were dropped", rejected, w.Dropped())
+	}
 
 	close(release)
 	w.Close()
@@ -132,15 +138,321 @@ func TestWriter_EnqueueAfterCloseIsSafe(t *testing.T) {
 	w.Start()
 	w.Close()
 	// Must not panic on send to a closed queue.
-	w.Enqueue(&message.Message{MessageID: "m"})
+	if w.Enqueue(&message.Message{MessageID: "m"}) {
+		t.Error("expected Enqueue after Close to reject the message")
+	}
 }
 
-// blockingRepo blocks every batch until release is closed.
+// blockingRepo blocks every batch until release is closed, then stores it.
 type blockingRepo struct {
+	mockRepo
 	release chan struct{}
 }
 
 func (b *blockingRepo) BatchSaveMessages(ctx context.Context, msgs []*message.Message) error {
 	<-b.release
-	return nil
+	return b.mockRepo.BatchSaveMessages(ctx, msgs)
+}
+
+func TestWriter_NotifiesObserverOnSuccess(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 3},
+		{"failure", errors.New("boom"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			observed := 0
+			w := New(&mockRepo{err: tt.err}, testLogger(), Config{Workers: 1, BatchSize: 10, FlushInterval: time.Hour})
+			w.SetObserver(ObserverFunc(func(msgs []*message.Message) {
+				mu.Lock()
+				observed += len(msgs)
+				mu.Unlock()
+			}))
+			w.Start()
+			for _, id := range []string{"a", "b", "c"} {
+				w.Enqueue(&message.Message{MessageID: id})
+			}
+			w.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if observed != tt.want {
+				t.Errorf("expected %d observed messages, got %d", tt.want, observed)
+			}
+		})
+	}
+}
+
+func TestWriter_WALReplaysFailedBatches(t *testing.T) {
+	dir := t.TempDir()
+
+	// First run: storage and the dead-letter queue fail, so nothing is
+	// acknowledged
+	wal := openTestWAL(t, dir, 0)
+	w := New(&mockRepo{err: errors.New("boom")}, testLogger(), Config{Workers: 1, BatchSize: 2, FlushInterval: time.Hour, MaxRetries: 1, RetryBaseDelay: time.Millisecond})
+	w.SetWAL(wal)
+	w.SetDeadLetterQueue(failingDeadLetterQueue{NewMemoryDeadLetterQueue()})
+	w.Start()
+	for _, id := range []string{"a", "b", "c"} {
+		w.Enqueue(&message.Message{MessageID: id})
+	}
+	w.Close()
+
+	// Second run: replay stores them before any new message
+	repo := &mockRepo{}
+	observed := 0
+	w = New(repo, testLogger(), Config{Workers: 1, BatchSize: 2, FlushInterval: time.Hour})
+	w.SetWAL(openTestWAL(t, dir, 0))
+	w.SetObserver(ObserverFunc(func(msgs []*message.Message) { observed += len(msgs) }))
+	w.Start()
+	if got := repo.count(); got != 3 {
+		t.Errorf("replayed %d messages, want 3", got)
+	}
+	if repo.batchCount() != 2 {
+		t.Errorf("replayed in %d batches, want 2", repo.batchCount())
+	}
+	if observed != 3 {
+		t.Errorf("observer saw %d replayed messages, want 3", observed)
+	}
+	w.Close()
+
+	// Third run: everything was acknowledged
+	wal = openTestWAL(t, dir, 0)
+	defer wal.Close()
+	if n := len(wal.Recovered()); n != 0 {
+		t.Errorf("recovered %d entries after successful replay, want 0", n)
+	}
+}
+
+func TestWriter_WALDefersWhenQueueFull(t *testing.T) {
+	dir := t.TempDir()
+	repo := &blockingRepo{release: make(chan struct{})}
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 1, QueueSize: 2, FlushInterval: 10 * time.Millisecond})
+	w.SetWAL(openTestWAL(t, dir, 0))
+	w.Start()
+
+	// Worker(1) + queue(2) + deferred(2) at most are accepted
+	accepted := 0
+	for i := 0; i < 20; i++ {
+		if w.Enqueue(&message.Message{MessageID: "m"}) {
+			accepted++
+		}
+	}
+	if accepted < 4 || accepted > 5 {
+		t.Errorf("accepted %d messages, want 4 or 5", accepted)
+	}
+	if w.Dropped() != int64(20-accepted) {
+		t.Errorf("Dropped() = %d, want %d", w.Dropped(), 20-accepted)
+	}
+
+	// The deferred messages are stored without waiting for a restart
+	close(repo.release)
+	deadline := time.Now().Add(2 * time.Second)
+	for repo.count() < accepted && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := repo.count(); got != accepted {
+		t.Errorf("stored %d messages, want %d", got, accepted)
+	}
+	w.Close()
+
+	// Stored and dropped messages alike are acknowledged
+	wal := openTestWAL(t, dir, 0)
+	defer wal.Close()
+	if n := len(wal.Recovered()); n != 0 {
+		t.Errorf("recovered %d entries, want 0", n)
+	}
+}
+
+func TestWriter_RetriesWhenDeadLetterFails(t *testing.T) {
+	repo := &flakyRepo{failures: 2}
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 10, FlushInterval: 10 * time.Millisecond, MaxRetries: 1, RetryBaseDelay: time.Millisecond})
+	w.SetDeadLetterQueue(failingDeadLetterQueue{NewMemoryDeadLetterQueue()})
+	w.Start()
+	defer w.Close()
+	w.Enqueue(&message.Message{MessageID: "a"})
+
+	// Both attempts fail and the dead-letter queue rejects the batch, so
+	// it is stored at a later flush
+	deadline := time.Now().Add(2 * time.Second)
+	for repo.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := repo.count(); got != 1 {
+		t.Errorf("stored %d messages, want 1", got)
+	}
+}
+
+func TestWriter_AwaitPending(t *testing.T) {
+	repo := &blockingRepo{release: make(chan struct{})}
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 1, FlushInterval: time.Hour})
+	w.Start()
+	defer w.Close()
+
+	if w.Await(context.Background(), "lobby", "unknown") {
+		t.Error("Await reported an unknown message as pending")
+	}
+
+	w.Enqueue(&message.Message{MessageID: "m1", RoomID: "lobby"})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if w.Await(ctx, "lobby", "m1") {
+		t.Error("Await returned before the message was stored")
+	}
+
+	close(repo.release)
+	if !w.Await(context.Background(), "lobby", "m1") {
+		t.Error("Await did not report the pending message")
+	}
+	if repo.count() != 1 {
+		t.Errorf("stored %d messages when Await returned, want 1", repo.count())
+	}
+	if w.Await(context.Background(), "lobby", "m1") {
+		t.Error("Await reported a stored message as still pending")
+	}
+}
+
+// failingDeadLetterQueue rejects every dead letter.
+type failingDeadLetterQueue struct{ *MemoryDeadLetterQueue }
+
+func (failingDeadLetterQueue) Put(...DeadLetter) error { return errors.New("disk full") }
+
+// flakyRepo fails the first failures batches, then stores normally.
+type flakyRepo struct {
+	mockRepo
+	failures int
+	attempts int
+}
+
+func (f *flakyRepo) BatchSaveMessages(ctx context.Context, msgs []*message.Message) error {
+	f.mu.Lock()
+	f.attempts++
+	fail := f.attempts <= f.failures
+	f.mu.Unlock()
+	if fail {
+		return errors.New("throttled")
+	}
+	return f.mockRepo.BatchSaveMessages(ctx, msgs)
+}
+
+func TestWriter_RetriesFailedBatches(t *testing.T) {
+	repo := &flakyRepo{failures: 2}
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 10, FlushInterval: time.Hour, MaxRetries: 3, RetryBaseDelay: time.Millisecond})
+	w.Start()
+	w.Enqueue(&message.Message{MessageID: "a"})
+	w.Enqueue(&message.Message{MessageID: "b"})
+	w.Close()
+
+	if got := repo.count(); got != 2 {
+		t.Errorf("stored %d messages, want 2", got)
+	}
+	if repo.attempts != 3 {
+		t.Errorf("made %d attempts, want 3", repo.attempts)
+	}
+	if letters, _ := w.DeadLetters(0); len(letters) != 0 {
+		t.Errorf("dead-lettered %d messages, want 0", len(letters))
+	}
+}
+
+func TestWriter_DeadLettersAfterRetries(t *testing.T) {
+	repo := &flakyRepo{failures: 100}
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 10, FlushInterval: time.Hour, MaxRetries: 2, RetryBaseDelay: time.Millisecond})
+	w.Start()
+	w.Enqueue(&message.Message{MessageID: "a"})
+	w.Enqueue(&message.Message{MessageID: "b"})
+	w.Close()
+
+	if repo.attempts != 3 {
+		t.Errorf("made %d attempts, want 3", repo.attempts)
+	}
+	letters, err := w.DeadLetters(0)
+	if err != nil {
+		t.Fatalf("DeadLetters: %v", err)
+	}
+	if len(letters) != 2 {
+		t.Fatalf("dead-lettered %d messages, want 2", len(letters))
+	}
+	if l := letters[0]; l.Message.MessageID != "a" || l.Attempts != 3 || l.Error != "throttled" || l.ID == "" {
+		t.Errorf("dead letter = %+v", l)
+	}
+}
+
+func TestWriter_BackoffGrowsWithJitter(t *testing.T) {
+	w := New(&mockRepo{}, testLogger(), Config{RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second})
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second}, // capped
+		{64, 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := w.backoff(tt.retry); d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d) = %v, want within [%v, %v]", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestWriter_Redrive(t *testing.T) {
+	repo := &flakyRepo{failures: 2}
+	observed := 0
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 1, FlushInterval: time.Hour, MaxRetries: 1, RetryBaseDelay: time.Millisecond})
+	w.SetObserver(ObserverFunc(func(msgs []*message.Message) { observed += len(msgs) }))
+	w.Start()
+	w.Enqueue(&message.Message{MessageID: "a"})
+	w.Enqueue(&message.Message{MessageID: "b"})
+	w.Enqueue(&message.Message{MessageID: "c"})
+	w.Close()
+
+	letters, _ := w.DeadLetters(0)
+	if len(letters) != 1 {
+		t.Fatalf("dead-lettered %d messages, want 1", len(letters))
+	}
+
+	n, err := w.Redrive(context.Background(), "unknown")
+	if err != nil || n != 0 {
+		t.Errorf("Redrive(unknown) = %d, %v; want 0, nil", n, err)
+	}
+	n, err = w.Redrive(context.Background(), letters[0].ID)
+	if err != nil {
+		t.Fatalf("Redrive: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("redriven %d, want 1", n)
+	}
+	if repo.count() != 3 {
+		t.Errorf("stored %d messages, want 3", repo.count())
+	}
+	if observed != 3 {
+		t.Errorf("observer saw %d messages, want 3", observed)
+	}
+	if letters, _ := w.DeadLetters(0); len(letters) != 0 {
+		t.Errorf("%d dead letters left after redrive", len(letters))
+	}
+}
+
+func TestWriter_RedriveFailureKeepsDeadLetters(t *testing.T) {
+	repo := &flakyRepo{failures: 100}
+	w := New(repo, testLogger(), Config{Workers: 1, BatchSize: 1, FlushInterval: time.Hour, MaxRetries: 1, RetryBaseDelay: time.Millisecond})
+	w.Start()
+	w.Enqueue(&message.Message{MessageID: "a"})
+	w.Close()
+
+	if _, err := w.Redrive(context.Background()); err == nil {
+		t.Error("Redrive succeeded against failing storage")
+	}
+	if letters, _ := w.DeadLetters(0); len(letters) != 1 {
+		t.Errorf("%d dead letters left, want 1", len(letters))
+	}
 }
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle allows at most one event per key in each interval, e.g. one typing
+// indicator per user and room. Keys idle for a full interval are forgotten.
+// It is safe for concurrent use.
+type Throttle struct {
+	interval time.Duration
+
+	mu        sync.Mutex
+	last      map[string]time.Time
+	lastSweep time.Time
+
+	// now is injectable so tests can drive the clock deterministically.
+	now func() time.Time
+}
+
+// NewThrottle returns a throttle allowing one event per key per interval.
+func NewThrottle(interval time.Duration) *Throttle {
+	return &Throttle{
+		interval:  interval,
+		last:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether an event for key may go ahead, starting a new
+// interval for the key if so.
+func (t *Throttle) Allow(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if now.Sub(t.lastSweep) >= t.interval {
+		for k, last := range t.last {
+			if now.Sub(last) >= t.interval {
+				delete(t.last, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	if last, ok := t.last[key]; ok && now.Sub(last) < t.interval {
+		return false
+	}
+	t.last[key] = now
+	return true
+}
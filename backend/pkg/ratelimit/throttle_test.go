@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThrottle_OnePerInterval(t *testing.T) {
+	th := NewThrottle(2 * time.Second)
+	now := time.Now()
+	th.now = func() time.Time { return now }
+
+	if !th.Allow("alice") {
+		t.Fatal("expected first event to be allowed")
+	}
+	if th.Allow("alice") {
+		t.Error("expected second event within the interval to be denied")
+	}
+	if !th.Allow("bob") {
+		t.Error("expected another key to be allowed independently")
+	}
+
+	now = now.Add(2 * time.Second)
+	if !th.Allow("alice") {
+		t.Error("expected event after the interval to be allowed")
+	}
+}
+
+func TestThrottle_ForgetsIdleKeys(t *testing.T) {
+	th := NewThrottle(time.Second)
+	now := time.Now()
+	th.now = func() time.Time { return now }
+
+	th.Allow("alice")
+	th.Allow("bob")
+	now = now.Add(time.Second)
+	th.Allow("carol")
+
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	if len(th.last) != 1 {
+		t.Errorf("tracking %d keys, want only the active one", len(th.last))
+	}
+}
@@ -1,5 +1,6 @@
-// Package ratelimit provides a small, dependency-free token-bucket limiter
-// used to throttle per-connection message rates.
+// Package ratelimit provides small, dependency-free limiters: a token bucket
+// used to throttle per-connection message rates, and a keyed throttle for
+// events such as typing indicators.
 package ratelimit
 
 import (
@@ -0,0 +1,129 @@
+// Package room defines the persisted chat room entity listed by the room
+// directory.
+package room
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"time"
+)
+
+// Visibility controls whether a room appears in the room directory.
+type Visibility string
+
+const (
+	// VisibilityPublic rooms are listed for everyone.
+	VisibilityPublic Visibility = "public"
+
+	// VisibilityPrivate rooms are unlisted: only their creator sees them in
+	// the directory, everyone else needs the room ID.
+	VisibilityPrivate Visibility = "private"
+)
+
+// Room is a chat room's directory entry.
+type Room struct {
+	ID         string     `json:"id" dynamodbav:"RoomID"`
+	Name       string     `json:"name" dynamodbav:"Name"`
+	Topic      string     `json:"topic,omitempty" dynamodbav:"Topic,omitempty"`
+	CreatedBy  string     `json:"createdBy" dynamodbav:"CreatedBy"`
+	CreatedAt  time.Time  `json:"createdAt" dynamodbav:"CreatedAt"`
+	Visibility Visibility `json:"visibility" dynamodbav:"Visibility"`
+
+	// Pins are the room's pinned messages, oldest pin first.
+	Pins []Pin `json:"pins,omitempty" dynamodbav:"Pins,omitempty"`
+}
+
+// Pin records a message pinned to a room.
+type Pin struct {
+	MessageID string    `json:"messageId" dynamodbav:"MessageID"`
+	PinnedBy  string    `json:"pinnedBy" dynamodbav:"PinnedBy"`
+	PinnedAt  time.Time `json:"pinnedAt" dynamodbav:"PinnedAt"`
+}
+
+// Validation constants
+const (
+	MaxIDLength    = 64
+	MaxNameLength  = 100
+	MaxTopicLength = 500
+
+	// MaxPins bounds a room's pinned messages, which every connection to
+	// the room is sent on join.
+	MaxPins = 50
+)
+
+var (
+	ErrInvalidID         = errors.New("room id must be 1-64 letters, digits, '-' or '_'")
+	ErrEmptyName         = errors.New("room name cannot be empty")
+	ErrNameTooLong       = errors.New("room name exceeds maximum length")
+	ErrTopicTooLong      = errors.New("room topic exceeds maximum length")
+	ErrInvalidVisibility = errors.New("invalid room visibility")
+	ErrTooManyPins       = errors.New("room has too many pinned messages")
+)
+
+// idPattern matches the room IDs clients can join via /ws?room=.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Validate checks if the room meets all requirements
+func (r *Room) Validate() error {
+	if len(r.ID) > MaxIDLength || !idPattern.MatchString(r.ID) {
+		return ErrInvalidID
+	}
+	if r.Name == "" {
+		return ErrEmptyName
+	}
+	if len(r.Name) > MaxNameLength {
+		return ErrNameTooLong
+	}
+	if len(r.Topic) > MaxTopicLength {
+		return ErrTopicTooLong
+	}
+	if r.Visibility != VisibilityPublic && r.Visibility != VisibilityPrivate {
+		return ErrInvalidVisibility
+	}
+	return nil
+}
+
+// VisibleTo reports whether the room is listed in userID's room directory.
+func (r *Room) VisibleTo(userID string) bool {
+	return r.Visibility != VisibilityPrivate || r.CreatedBy == userID
+}
+
+// Clone returns a copy of the room that shares no slices with it.
+func (r *Room) Clone() *Room {
+	c := *r
+	c.Pins = slices.Clone(r.Pins)
+	return &c
+}
+
+// Pin pins messageID on behalf of userID and reports whether it was added.
+// Pinning a message twice is a no-op; a new pin beyond MaxPins returns
+// ErrTooManyPins.
+func (r *Room) Pin(messageID, userID string, pinnedAt time.Time) (bool, error) {
+	if r.Pinned(messageID) {
+		return false, nil
+	}
+	if len(r.Pins) >= MaxPins {
+		return false, ErrTooManyPins
+	}
+	r.Pins = append(r.Pins, Pin{MessageID: messageID, PinnedBy: userID, PinnedAt: pinnedAt})
+	return true, nil
+}
+
+// Unpin removes messageID's pin and reports whether it was pinned.
+func (r *Room) Unpin(messageID string) bool {
+	i := slices.IndexFunc(r.Pins, func(p Pin) bool { return p.MessageID == messageID })
+	if i < 0 {
+		return false
+	}
+	r.Pins = slices.Delete(r.Pins, i, i+1)
+	if len(r.Pins) == 0 {
+		r.Pins = nil
+	}
+	return true
+}
+
+// Pinned reports whether messageID is pinned to the room.
+func (r *Room) Pinned(messageID string) bool {
+	return slices.ContainsFunc(r.Pins, func(p Pin) bool { return p.MessageID == messageID })
+}
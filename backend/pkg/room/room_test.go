@@ -0,0 +1,84 @@
+package room
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoom_Validate(t *testing.T) {
+	valid := func() Room {
+		return Room{ID: "general-chat_1", Name: "General", Visibility: VisibilityPublic}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(r *Room)
+		wantErr error
+	}{
+		{"valid", func(r *Room) {}, nil},
+		{"empty id", func(r *Room) { r.ID = "" }, ErrInvalidID},
+		{"id with spaces", func(r *Room) { r.ID = "my room" }, ErrInvalidID},
+		{"id too long", func(r *Room) { r.ID = strings.Repeat("a", MaxIDLength+1) }, ErrInvalidID},
+		{"empty name", func(r *Room) { r.Name = "" }, ErrEmptyName},
+		{"name too long", func(r *Room) { r.Name = strings.Repeat("n", MaxNameLength+1) }, ErrNameTooLong},
+		{"topic too long", func(r *Room) { r.Topic = strings.Repeat("t", MaxTopicLength+1) }, ErrTopicTooLong},
+		{"bad visibility", func(r *Room) { r.Visibility = "secret" }, ErrInvalidVisibility},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid()
+			tt.modify(&r)
+			if err := r.Validate(); err != tt.wantErr {
+				t.Errorf("Validate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoom_VisibleTo(t *testing.T) {
+	public := Room{Visibility: VisibilityPublic, CreatedBy: "alice"}
+	private := Room{Visibility: VisibilityPrivate, CreatedBy: "alice"}
+
+	if !public.VisibleTo("bob") {
+		t.Error("public room should be visible to everyone")
+	}
+	if !private.VisibleTo("alice") {
+		t.Error("private room should be visible to its creator")
+	}
+	if private.VisibleTo("bob") {
+		t.Error("private room should be hidden from other users")
+	}
+}
+
+func TestRoom_Pins(t *testing.T) {
+	var r Room
+	at := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+
+	for _, id := range []string{"m1", "m2", "m1"} {
+		if _, err := r.Pin(id, "mod", at); err != nil {
+			t.Fatalf("Pin(%s): %v", id, err)
+		}
+	}
+	if len(r.Pins) != 2 || r.Pins[0].MessageID != "m1" || r.Pins[1].PinnedBy != "mod" || !r.Pins[1].PinnedAt.Equal(at) {
+		t.Errorf("unexpected pins: %+v", r.Pins)
+	}
+	if !r.Unpin("m1") || r.Unpin("m1") || r.Pinned("m1") || !r.Pinned("m2") {
+		t.Errorf("unexpected pins after unpin: %+v", r.Pins)
+	}
+	if !r.Unpin("m2") || r.Pins != nil {
+		t.Errorf("expected no pins left, got %+v", r.Pins)
+	}
+
+	for i := 0; i < MaxPins; i++ {
+		r.Pin(fmt.Sprintf("p%d", i), "mod", at)
+	}
+	if _, err := r.Pin("one-more", "mod", at); err != ErrTooManyPins {
+		t.Errorf("expected ErrTooManyPins, got %v", err)
+	}
+	if added, err := r.Pin("p0", "mod", at); added || err != nil {
+		t.Errorf("re-pinning in a full room = %v, %v; want a no-op", added, err)
+	}
+}
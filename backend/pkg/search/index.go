@@ -0,0 +1,249 @@
+// Package search provides full-text search over chat history. Indexes are
+// pluggable behind the Index interface; MemoryIndex is an in-process inverted
+// index fed from the persistence writer.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// Index is a searchable view of message history.
+type Index interface {
+	// Add indexes messages, replacing earlier versions of the same message.
+	// Deleted messages (tombstones) are removed from the index.
+	Add(msgs ...*message.Message)
+
+	// Search returns the messages matching q, newest first.
+	// Returns ErrEmptyQuery if q.Text has no searchable terms.
+	Search(q Query) ([]Result, error)
+}
+
+// Query selects messages by text and optional room and user filters.
+type Query struct {
+	// Text holds space-separated words, "quoted phrases" and prefix* words;
+	// all of them must match.
+	Text string
+
+	// RoomID and UserID, when set, restrict results to that room or sender.
+	RoomID string
+	UserID string
+
+	// Allow, when set, is consulted for every candidate's room so results
+	// the caller may not read are skipped before the limit is applied.
+	Allow func(roomID string) bool
+
+	// Limit is the maximum number of results; zero means no limit.
+	Limit int
+}
+
+// Result is one matching message with a highlighted excerpt of its content.
+type Result struct {
+	Message *message.Message `json:"message"`
+
+	// Snippet is an HTML-escaped excerpt of the content with matched words
+	// wrapped in <mark> tags.
+	Snippet string `json:"snippet"`
+}
+
+// docKey identifies a message like the table's primary key.
+type docKey struct {
+	roomID    string
+	messageID string
+}
+
+// document is an indexed message and its tokenized content.
+type document struct {
+	msg    *message.Message
+	tokens []token
+}
+
+// MemoryIndex is an in-process inverted index. It is safe for concurrent use
+// and holds every indexed message in memory, so it only covers messages
+// written since the process started.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[docKey]*document
+	postings map[string]map[docKey]struct{} // token -> documents containing it
+	deleted  map[docKey]struct{}            // tombstones, so late writes can't resurrect them
+}
+
+// NewMemoryIndex creates an empty in-memory index.
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     make(map[docKey]*document),
+		postings: make(map[string]map[docKey]struct{}),
+		deleted:  make(map[docKey]struct{}),
+	}
+}
+
+// Add indexes messages. Only chat messages are searchable. A version older
+// than the one already indexed (an unedited copy arriving after its edit) is
+// ignored, as is any message that has since been deleted.
+func (x *MemoryIndex) Add(msgs ...*message.Message) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, msg := range msgs {
+		if msg.Type != message.TypeChat {
+			continue
+		}
+		key := docKey{roomID: msg.RoomID, messageID: msg.MessageID}
+		if _, ok := x.deleted[key]; ok {
+			continue
+		}
+		if msg.Deleted {
+			x.remove(key)
+			x.deleted[key] = struct{}{}
+			continue
+		}
+		if old, ok := x.docs[key]; ok {
+			if newerEdit(old.msg, msg) {
+				continue
+			}
+			x.remove(key)
+		}
+
+		doc := &document{msg: msg.Clone(), tokens: tokenize(msg.Content)}
+		x.docs[key] = doc
+		for _, tok := range doc.tokens {
+			docs, ok := x.postings[tok.text]
+			if !ok {
+				docs = make(map[docKey]struct{})
+				x.postings[tok.text] = docs
+			}
+			docs[key] = struct{}{}
+		}
+	}
+}
+
+// newerEdit reports whether indexed is a later edit than incoming.
+func newerEdit(indexed, incoming *message.Message) bool {
+	if indexed.EditedAt == nil {
+		return false
+	}
+	return incoming.EditedAt == nil || indexed.EditedAt.After(*incoming.EditedAt)
+}
+
+// remove drops a document and its postings. Must be called with x.mu held.
+func (x *MemoryIndex) remove(key docKey) {
+	doc, ok := x.docs[key]
+	if !ok {
+		return
+	}
+	for _, tok := range doc.tokens {
+		if docs, ok := x.postings[tok.text]; ok {
+			delete(docs, key)
+			if len(docs) == 0 {
+				delete(x.postings, tok.text)
+			}
+		}
+	}
+	delete(x.docs, key)
+}
+
+// Search returns the messages matching q, newest first. Messages whose
+// retention period has elapsed are skipped.
+func (x *MemoryIndex) Search(q Query) ([]Result, error) {
+	clauses, err := parseQuery(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var matches []*document
+	var spans [][]token
+	for key := range x.candidates(clauses[0][0]) {
+		doc := x.docs[key]
+		msg := doc.msg
+		switch {
+		case q.RoomID != "" && msg.RoomID != q.RoomID,
+			q.UserID != "" && msg.UserID != q.UserID,
+			msg.ExpiresAt != nil && !msg.ExpiresAt.After(now),
+			q.Allow != nil && !q.Allow(msg.RoomID):
+			continue
+		}
+		if hits, ok := matchAll(doc.tokens, clauses); ok {
+			matches = append(matches, doc)
+			spans = append(spans, hits)
+		}
+	}
+
+	order := make([]int, len(matches))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		ma, mb := matches[order[a]].msg, matches[order[b]].msg
+		if !ma.Timestamp.Equal(mb.Timestamp) {
+			return ma.Timestamp.After(mb.Timestamp)
+		}
+		return ma.MessageID > mb.MessageID
+	})
+	if q.Limit > 0 && len(order) > q.Limit {
+		order = order[:q.Limit]
+	}
+
+	results := make([]Result, len(order))
+	for i, j := range order {
+		doc := matches[j]
+		results[i] = Result{
+			Message: doc.msg.Clone(),
+			Snippet: snippet(doc.msg.Content, doc.tokens, spans[j]),
+		}
+	}
+	return results, nil
+}
+
+// candidates returns the documents containing a token that matches t. Must be
+// called with x.mu held.
+func (x *MemoryIndex) candidates(t term) map[docKey]struct{} {
+	if !t.prefix {
+		return x.postings[t.text]
+	}
+	union := make(map[docKey]struct{})
+	for tok, docs := range x.postings {
+		if strings.HasPrefix(tok, t.text) {
+			for key := range docs {
+				union[key] = struct{}{}
+			}
+		}
+	}
+	return union
+}
+
+// matchAll checks every clause against a document's tokens and returns the
+// tokens that matched, for highlighting.
+func matchAll(tokens []token, clauses []clause) ([]token, bool) {
+	var hits []token
+	for _, c := range clauses {
+		found := false
+		for i := 0; i+len(c) <= len(tokens); i++ {
+			if phraseAt(tokens, i, c) {
+				hits = append(hits, tokens[i:i+len(c)]...)
+				found = true
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return hits, true
+}
+
+// phraseAt reports whether the clause's terms match tokens starting at i.
+func phraseAt(tokens []token, i int, c clause) bool {
+	for k, t := range c {
+		if !t.matches(tokens[i+k].text) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+var testBase = time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+
+func chat(id, room, user, content string, minute int) *message.Message {
+	return &message.Message{
+		MessageID: id,
+		RoomID:    room,
+		Type:      message.TypeChat,
+		UserID:    user,
+		Username:  user,
+		Content:   content,
+		Timestamp: testBase.Add(time.Duration(minute) * time.Minute),
+	}
+}
+
+func seededIndex() *MemoryIndex {
+	x := NewMemoryIndex()
+	x.Add(
+		chat("m1", "lobby", "alice", "Deploying the release tonight", 1),
+		chat("m2", "lobby", "bob", "release notes are in the wiki", 2),
+		chat("m3", "ops", "alice", "The notes on release day", 3),
+		chat("m4", "ops", "carol", "deployment finished", 4),
+	)
+	return x
+}
+
+func resultIDs(results []Result) string {
+	ids := make([]string, len(results))
+	for i, r := range results {
+		ids[i] = r.Message.MessageID
+	}
+	return fmt.Sprint(ids)
+}
+
+func TestMemoryIndex_Search(t *testing.T) {
+	x := seededIndex()
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"single word newest first", Query{Text: "release"}, "[m3 m2 m1]"},
+		{"all words must match", Query{Text: "release notes"}, "[m3 m2]"},
+		{"phrase", Query{Text: `"release notes"`}, "[m2]"},
+		{"prefix", Query{Text: "deploy*"}, "[m4 m1]"},
+		{"case insensitive", Query{Text: "DEPLOYMENT"}, "[m4]"},
+		{"room filter", Query{Text: "release", RoomID: "lobby"}, "[m2 m1]"},
+		{"user filter", Query{Text: "release", UserID: "alice"}, "[m3 m1]"},
+		{"limit", Query{Text: "release", Limit: 1}, "[m3]"},
+		{"access rule", Query{Text: "release", Allow: func(room string) bool { return room != "ops" }}, "[m2 m1]"},
+		{"no match", Query{Text: "rollback"}, "[]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := x.Search(tt.query)
+			if err != nil {
+				t.Fatalf("Search: %v", err)
+			}
+			if got := resultIDs(results); got != tt.want {
+				t.Errorf("Search(%+v) = %s, want %s", tt.query.Text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryIndex_EditAndDelete(t *testing.T) {
+	x := seededIndex()
+
+	edited := chat("m1", "lobby", "alice", "Rolling back tonight", 1)
+	edited.ApplyEdit(edited.Content, testBase.Add(time.Hour))
+	x.Add(edited)
+
+	// The unedited copy arriving late must not overwrite the edit.
+	x.Add(chat("m1", "lobby", "alice", "Deploying the release tonight", 1))
+
+	if results, _ := x.Search(Query{Text: "rolling"}); resultIDs(results) != "[m1]" {
+		t.Errorf("expected edited content to be searchable, got %s", resultIDs(results))
+	}
+	if results, _ := x.Search(Query{Text: "deploying"}); len(results) != 0 {
+		t.Errorf("expected old content to be gone, got %s", resultIDs(results))
+	}
+
+	tomb := chat("m2", "lobby", "bob", "", 2)
+	tomb.Tombstone("bob", testBase.Add(time.Hour))
+	x.Add(tomb)
+	x.Add(chat("m2", "lobby", "bob", "release notes are in the wiki", 2))
+
+	if results, _ := x.Search(Query{Text: "wiki"}); len(results) != 0 {
+		t.Errorf("expected deleted message to stay out of the index, got %s", resultIDs(results))
+	}
+}
+
+func TestMemoryIndex_SkipsExpiredAndNonChat(t *testing.T) {
+	x := NewMemoryIndex()
+	expired := chat("m1", "lobby", "alice", "stale news", 1)
+	past := time.Now().Add(-time.Minute)
+	expired.ExpiresAt = &past
+	system := message.NewSystemMessage("news from the server")
+	x.Add(expired, system)
+
+	if results, _ := x.Search(Query{Text: "news"}); len(results) != 0 {
+		t.Errorf("expected no results, got %s", resultIDs(results))
+	}
+}
+
+func TestMemoryIndex_Snippet(t *testing.T) {
+	x := NewMemoryIndex()
+	long := strings.Repeat("filler ", 20) + "the <b>release</b> is out " + strings.Repeat("more ", 30)
+	x.Add(chat("m1", "lobby", "alice", long, 1))
+
+	results, err := x.Search(Query{Text: "release"})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Search: %v, %d results", err, len(results))
+	}
+	s := results[0].Snippet
+	if !strings.Contains(s, "&lt;b&gt;<mark>release</mark>&lt;/b&gt;") {
+		t.Errorf("expected escaped, highlighted match in snippet, got %q", s)
+	}
+	if !strings.HasPrefix(s, ellipsis) || !strings.HasSuffix(s, ellipsis) {
+		t.Errorf("expected snippet trimmed on both sides, got %q", s)
+	}
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// ErrEmptyQuery is returned when a query contains no searchable terms.
+var ErrEmptyQuery = errors.New("search query is empty")
+
+// term is one word of a query clause. A prefix term matches any token that
+// starts with text.
+type term struct {
+	text   string
+	prefix bool
+}
+
+// clause is a run of terms that must appear consecutively in a message. A
+// bare word is a one-term clause; a quoted phrase is a multi-term clause.
+type clause []term
+
+// parseQuery splits query text into clauses. Double quotes group words into a
+// phrase and a trailing '*' makes a word a prefix match, e.g.
+// `"release notes" deploy*`. Every clause must match.
+func parseQuery(text string) ([]clause, error) {
+	var clauses []clause
+	for i, part := range strings.Split(text, `"`) {
+		// Odd parts sit between quotes; an unterminated quote runs to the end.
+		phrase := i%2 == 1
+		var current clause
+		for _, word := range strings.Fields(part) {
+			n := len(current)
+			for _, tok := range tokenize(word) {
+				current = append(current, term{text: tok.text})
+			}
+			if strings.HasSuffix(word, "*") && len(current) > n {
+				current[len(current)-1].prefix = true
+			}
+			// An unquoted word that tokenizes into several terms (e.g.
+			// "e-mail") stays a phrase so it matches the text as typed.
+			if !phrase && len(current) > 0 {
+				clauses = append(clauses, current)
+				current = nil
+			}
+		}
+		if phrase && len(current) > 0 {
+			clauses = append(clauses, current)
+		}
+	}
+	if len(clauses) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	return clauses, nil
+}
+
+// token is a normalized word and its byte range in the source text.
+type token struct {
+	text       string
+	start, end int
+}
+
+// tokenize splits text into lowercase runs of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, token{text: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{text: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// matches reports whether a token satisfies t.
+func (t term) matches(tok string) bool {
+	if t.prefix {
+		return strings.HasPrefix(tok, t.text)
+	}
+	return tok == t.text
+}
@@ -0,0 +1,55 @@
+package search
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []clause
+	}{
+		{"words", "Hello World", []clause{{{text: "hello"}}, {{text: "world"}}}},
+		{"phrase", `"release notes" ship`, []clause{{{text: "release"}, {text: "notes"}}, {{text: "ship"}}}},
+		{"prefix", "depl*", []clause{{{text: "depl", prefix: true}}}},
+		{"prefix in phrase", `"new rel*"`, []clause{{{text: "new"}, {text: "rel", prefix: true}}}},
+		{"unterminated quote", `"big deal`, []clause{{{text: "big"}, {text: "deal"}}}},
+		{"compound word", "e-mail", []clause{{{text: "e"}, {text: "mail"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseQuery(tt.text)
+			if err != nil {
+				t.Fatalf("parseQuery(%q): %v", tt.text, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuery(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuery_Empty(t *testing.T) {
+	for _, text := range []string{"", "   ", `""`, "*", "!?"} {
+		if _, err := parseQuery(text); !errors.Is(err, ErrEmptyQuery) {
+			t.Errorf("parseQuery(%q): expected ErrEmptyQuery, got %v", text, err)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Café, déjà-vu 42!")
+	want := []token{
+		{text: "café", start: 0, end: 5},
+		{text: "déjà", start: 7, end: 13},
+		{text: "vu", start: 14, end: 16},
+		{text: "42", start: 17, end: 19},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenize = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+)
+
+const (
+	// Words of context kept before the first match in a snippet.
+	snippetLead = 8
+
+	// Maximum number of words in a snippet.
+	snippetWords = 30
+
+	ellipsis = "…"
+)
+
+// snippet excerpts content around the first hit, HTML-escaping the text and
+// wrapping every hit inside the excerpt in <mark> tags.
+func snippet(content string, tokens []token, hits []token) string {
+	if len(tokens) == 0 || len(hits) == 0 {
+		return html.EscapeString(content)
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].start < hits[j].start })
+
+	first := sort.Search(len(tokens), func(i int) bool { return tokens[i].start >= hits[0].start })
+	lo := max(first-snippetLead, 0)
+	hi := min(lo+snippetWords, len(tokens)) - 1
+
+	start, end := tokens[lo].start, tokens[hi].end
+	if lo == 0 {
+		start = 0
+	}
+	if hi == len(tokens)-1 {
+		end = len(content)
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString(ellipsis)
+	}
+	pos := start
+	for _, h := range hits {
+		if h.start < pos || h.end > end {
+			continue // duplicate or outside the excerpt
+		}
+		b.WriteString(html.EscapeString(content[pos:h.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(content[h.start:h.end]))
+		b.WriteString("</mark>")
+		pos = h.end
+	}
+	b.WriteString(html.EscapeString(content[pos:end]))
+	if end < len(content) {
+		b.WriteString(ellipsis)
+	}
+	return b.String()
+}
@@ -0,0 +1,1045 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bolt bucket names. The messages bucket is the primary store keyed like the
+// DynamoDB table (RoomID + MessageID); the index buckets mirror the
+// RoomID-Timestamp, UserID-Timestamp and ParentMessageID-Timestamp GSIs and
+// map an ordered index key back to the primary key. Thread entries are
+// partitioned by RoomID and ParentMessageID together. The expiry bucket
+// orders messages by ExpiresAt for the retention sweeper. The rooms bucket
+// holds the room directory keyed by ID, the mentions bucket holds each
+// user's mention inbox ordered by Timestamp, and the read markers bucket
+// holds each user's read marker per room, keyed by user and room ID. The meta
+// bucket records the key format the ordered buckets were written in.
+var (
+	bucketMeta     = []byte("meta")
+	bucketMessages = []byte("messages")
+	bucketRoomTS   = []byte("room_timestamp")
+	bucketUserTS   = []byte("user_timestamp")
+	bucketThreadTS = []byte("thread_timestamp")
+	bucketExpiry   = []byte("expiry")
+	bucketRooms    = []byte("rooms")
+	bucketMentions = []byte("mentions")
+	bucketMarkers  = []byte("read_markers")
+)
+
+// keySep separates key components. IDs never contain a NUL byte.
+const keySep = 0x00
+
+// keyFormat is the version of the ordered key encoding, stored under
+// metaKeyFormat. Version 2 flipped the timestamp's sign bit (see keyNanos);
+// a database without it is reindexed on open.
+const keyFormat = "2"
+
+var metaKeyFormat = []byte("key_format")
+
+// BoltRepository implements MessageRepository on an embedded BoltDB file, for
+// small deployments that don't want to operate DynamoDB.
+type BoltRepository struct {
+	db     *bolt.DB
+	logger *slog.Logger
+
+	retention RetentionPolicy
+	done      chan struct{} // closed by Close to stop the retention sweeper
+	closeOnce sync.Once
+}
+
+// NewBoltRepository opens (creating if needed) the database file at path and
+// ensures its buckets exist.
+func NewBoltRepository(path string, logger *slog.Logger) (*BoltRepository, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	// The timeout stops a second process from blocking forever on the file lock.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketMeta, bucketMessages, bucketRoomTS, bucketUserTS, bucketThreadTS, bucketExpiry, bucketRooms, bucketMentions, bucketMarkers} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		if string(tx.Bucket(bucketMeta).Get(metaKeyFormat)) == keyFormat {
+			return nil
+		}
+		if err := reindex(tx, logger); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMeta).Put(metaKeyFormat, []byte(keyFormat))
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bolt buckets: %w", err)
+	}
+
+	logger.Info("bolt repository initialized", slog.String("path", path))
+
+	return &BoltRepository{db: db, logger: logger, done: make(chan struct{})}, nil
+}
+
+// SetRetention applies a retention policy to messages saved from now on and,
+// when the policy can expire anything, starts a sweeper that purges expired
+// messages every interval until Close. Call at most once, before use.
+func (r *BoltRepository) SetRetention(policy RetentionPolicy, interval time.Duration) {
+	r.retention = policy
+	if policy.Enabled() {
+		go runSweeper(r.done, interval, r.sweep, r.logger)
+	}
+}
+
+// SaveMessage persists a message unless one with the same key is stored.
+func (r *BoltRepository) SaveMessage(ctx context.Context, msg *message.Message) error {
+	return r.BatchSaveMessages(ctx, []*message.Message{msg})
+}
+
+// BatchSaveMessages persists all messages in a single transaction.
+func (r *BoltRepository) BatchSaveMessages(ctx context.Context, msgs []*message.Message) error {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		for _, msg := range msgs {
+			r.retention.Stamp(msg)
+			if err := putMessage(tx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger.Error("failed to save messages to bolt",
+			slog.Int("count", len(msgs)),
+			slog.String("error", err.Error()))
+		return fmt.Errorf("failed to save messages: %w", err)
+	}
+	return nil
+}
+
+// putMessage writes msg and its index entries. A message already stored
+// under the same key is left as it is, so replaying one that was since edited
+// or deleted changes nothing. A new reply increments its parent's ReplyCount.
+func putMessage(tx *bolt.Tx, msg *message.Message) error {
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.New().String()
+	}
+	if msg.RoomID == "" {
+		msg.RoomID = DefaultRoomID
+	}
+
+	primary := joinKey([]byte(msg.RoomID), []byte(msg.MessageID))
+	messages := tx.Bucket(bucketMessages)
+	if messages.Get(primary) != nil {
+		return nil
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message %s: %w", msg.MessageID, err)
+	}
+
+	roomTS := tx.Bucket(bucketRoomTS)
+	userTS := tx.Bucket(bucketUserTS)
+	threadTS := tx.Bucket(bucketThreadTS)
+	expiry := tx.Bucket(bucketExpiry)
+
+	if err := messages.Put(primary, data); err != nil {
+		return err
+	}
+	if msg.ParentMessageID != "" {
+		thread := threadPartition(msg.RoomID, msg.ParentMessageID)
+		if err := threadTS.Put(indexKey(thread, msg.Timestamp, msg.MessageID), primary); err != nil {
+			return err
+		}
+		if err := countReply(messages, msg, 1); err != nil {
+			return err
+		}
+	}
+	if err := roomTS.Put(indexKey(msg.RoomID, msg.Timestamp, msg.MessageID), primary); err != nil {
+		return err
+	}
+	if msg.ExpiresAt != nil {
+		if err := expiry.Put(expiryKey(*msg.ExpiresAt, primary), primary); err != nil {
+			return err
+		}
+	}
+	if err := addMentions(tx.Bucket(bucketMentions), msg); err != nil {
+		return err
+	}
+	return userTS.Put(indexKey(msg.UserID, msg.Timestamp, msg.MessageID), primary)
+}
+
+// reindex rebuilds the ordered buckets in the current key format: the index
+// and expiry buckets from the stored messages, and the mentions bucket from
+// its own entries, keeping their read state.
+func reindex(tx *bolt.Tx, logger *slog.Logger) error {
+	for _, name := range [][]byte{bucketRoomTS, bucketUserTS, bucketThreadTS, bucketExpiry} {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+
+	err := tx.Bucket(bucketMessages).ForEach(func(primary, data []byte) error {
+		msg := decodeMessage(data, logger)
+		if msg == nil {
+			return nil
+		}
+		primary = bytes.Clone(primary)
+		if err := tx.Bucket(bucketRoomTS).Put(indexKey(msg.RoomID, msg.Timestamp, msg.MessageID), primary); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketUserTS).Put(indexKey(msg.UserID, msg.Timestamp, msg.MessageID), primary); err != nil {
+			return err
+		}
+		if msg.ParentMessageID != "" {
+			thread := threadPartition(msg.RoomID, msg.ParentMessageID)
+			if err := tx.Bucket(bucketThreadTS).Put(indexKey(thread, msg.Timestamp, msg.MessageID), primary); err != nil {
+				return err
+			}
+		}
+		if msg.ExpiresAt != nil {
+			return tx.Bucket(bucketExpiry).Put(expiryKey(*msg.ExpiresAt, primary), primary)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var entries [][]byte
+	if err := tx.Bucket(bucketMentions).ForEach(func(_, data []byte) error {
+		entries = append(entries, bytes.Clone(data))
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := tx.DeleteBucket(bucketMentions); err != nil {
+		return err
+	}
+	mentions, err := tx.CreateBucket(bucketMentions)
+	if err != nil {
+		return err
+	}
+	for _, data := range entries {
+		var m Mention
+		if err := json.Unmarshal(data, &m); err != nil {
+			logger.Error("failed to unmarshal mention",
+				slog.String("error", err.Error()))
+			continue
+		}
+		if err := mentions.Put(mentionEntryKey(&m), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addMentions adds msg to the inbox of each user it mentions, leaving
+// existing entries (and their read state) alone.
+func addMentions(mentions *bolt.Bucket, msg *message.Message) error {
+	for _, m := range mentionsOf(msg) {
+		key := mentionEntryKey(m)
+		if mentions.Get(key) != nil {
+			continue
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to marshal mention of %s: %w", m.UserID, err)
+		}
+		if err := mentions.Put(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeMentions takes msg out of the inbox of each user it mentions.
+func removeMentions(mentions *bolt.Bucket, msg *message.Message) error {
+	for _, m := range mentionsOf(msg) {
+		if err := mentions.Delete(mentionEntryKey(m)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countReply adds delta to the ReplyCount of reply's parent, if it is stored.
+func countReply(messages *bolt.Bucket, reply *message.Message, delta int) error {
+	primary := joinKey([]byte(reply.RoomID), []byte(reply.ParentMessageID))
+	var parent message.Message
+	data := messages.Get(primary)
+	if data == nil || json.Unmarshal(data, &parent) != nil {
+		return nil
+	}
+	parent.ReplyCount = max(parent.ReplyCount+delta, 0)
+	return rewriteMessage(messages, primary, &parent)
+}
+
+// GetRecentMessages returns up to limit of the newest messages in a room, in
+// chronological order (oldest first).
+func (r *BoltRepository) GetRecentMessages(ctx context.Context, roomID string, limit int) ([]*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	page, err := r.queryPage(bucketRoomTS, roomID, AttrRoomID, roomID, PageQuery{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	return page.Messages, nil
+}
+
+// GetMessagesByUser returns up to limit of a user's messages in chronological
+// order (oldest first).
+func (r *BoltRepository) GetMessagesByUser(ctx context.Context, userID string, limit int) ([]*message.Message, error) {
+	var msgs []*message.Message
+	err := r.db.View(func(tx *bolt.Tx) error {
+		prefix := indexPrefix(userID)
+		messages := tx.Bucket(bucketMessages)
+		c := tx.Bucket(bucketUserTS).Cursor()
+
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if limit > 0 && len(msgs) >= limit {
+				break
+			}
+			if msg := decodeMessage(messages.Get(v), r.logger); msg != nil {
+				msgs = append(msgs, msg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user messages: %w", err)
+	}
+	return msgs, nil
+}
+
+// GetRoomMessagesPage retrieves one page of a room's history.
+func (r *BoltRepository) GetRoomMessagesPage(ctx context.Context, roomID string, q PageQuery) (*Page, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	return r.queryPage(bucketRoomTS, roomID, AttrRoomID, roomID, q)
+}
+
+// GetUserMessagesPage retrieves one page of a user's history.
+func (r *BoltRepository) GetUserMessagesPage(ctx context.Context, userID string, q PageQuery) (*Page, error) {
+	return r.queryPage(bucketUserTS, userID, AttrUserID, userID, q)
+}
+
+// GetMessage retrieves one message by its primary key.
+func (r *BoltRepository) GetMessage(ctx context.Context, roomID, messageID string) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	var msg *message.Message
+	err := r.db.View(func(tx *bolt.Tx) error {
+		msg = decodeMessage(tx.Bucket(bucketMessages).Get(joinKey([]byte(roomID), []byte(messageID))), r.logger)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	if msg == nil {
+		return nil, ErrMessageNotFound
+	}
+	return msg, nil
+}
+
+// GetThreadPage retrieves one page of a thread's replies.
+func (r *BoltRepository) GetThreadPage(ctx context.Context, roomID, parentMessageID string, q PageQuery) (*Page, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	return r.queryPage(bucketThreadTS, threadPartition(roomID, parentMessageID), AttrParentMessageID, parentMessageID, q)
+}
+
+// queryPage walks the index bucket entries under keyPartition from the cursor
+// position (or the newest entry), reading one entry past the limit to learn
+// whether more remain. Cursors are checked against partitionAttr and
+// partition, as the matching GSI would.
+func (r *BoltRepository) queryPage(bucket []byte, keyPartition, partitionAttr, partition string, q PageQuery) (*Page, error) {
+	// [low, high) bounds the walk: the partition's key range, narrowed by the
+	// Since/Until time range. An empty message ID sorts before every real one.
+	prefix := indexPrefix(keyPartition)
+	low, high := prefix, prefixEnd(prefix)
+	if !q.Since.IsZero() {
+		low = indexKey(keyPartition, q.Since, "")
+	}
+	if !q.Until.IsZero() {
+		high = indexKey(keyPartition, q.Until.Add(time.Nanosecond), "")
+	}
+
+	var position []byte
+	if q.cursor() != "" {
+		key, err := decodeCursor(q, partitionAttr, partition)
+		if err != nil {
+			return nil, err
+		}
+		ts, messageID, err := key.position()
+		if err != nil {
+			return nil, err
+		}
+		position = indexKey(keyPartition, ts, messageID)
+	}
+
+	var msgs []*message.Message
+	more := false
+	err := r.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		c := tx.Bucket(bucket).Cursor()
+
+		// Position on the first entry strictly past the cursor (or at the edge
+		// of the range) in the walk direction.
+		var k, v []byte
+		if q.forward() {
+			start := low
+			if bytes.Compare(position, start) > 0 {
+				start = position
+			}
+			k, v = c.Seek(start)
+			if k != nil && bytes.Equal(k, position) {
+				k, v = c.Next()
+			}
+		} else {
+			start := high
+			if position != nil && bytes.Compare(position, start) < 0 {
+				start = position
+			}
+			k, v = c.Seek(start)
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil && bytes.Compare(k, low) >= 0 && bytes.Compare(k, high) < 0; k, v = step(c, q.forward()) {
+			if q.Limit > 0 && len(msgs) >= q.Limit {
+				more = true
+				break
+			}
+			if msg := decodeMessage(messages.Get(v), r.logger); msg != nil {
+				msgs = append(msgs, msg)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query message page: %w", err)
+	}
+
+	page := &Page{}
+	if more && len(msgs) > 0 {
+		page.NextCursor = encodeCursor(messageCursor(msgs[len(msgs)-1], partitionAttr))
+	}
+	if !q.forward() {
+		for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
+			msgs[i], msgs[j] = msgs[j], msgs[i]
+		}
+	}
+	page.Messages = msgs
+	return page, nil
+}
+
+// step advances a cursor one entry in the given direction.
+func step(c *bolt.Cursor, forward bool) ([]byte, []byte) {
+	if forward {
+		return c.Next()
+	}
+	return c.Prev()
+}
+
+// EditMessage replaces a message's content within a single transaction. The
+// index entries are untouched because the Timestamp does not change.
+func (r *BoltRepository) EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	var edited *message.Message
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		primary := joinKey([]byte(roomID), []byte(messageID))
+
+		msg := decodeMessage(messages.Get(primary), r.logger)
+		if msg == nil {
+			return ErrMessageNotFound
+		}
+		if msg.UserID != userID {
+			return ErrNotAuthor
+		}
+		if msg.Deleted {
+			return ErrMessageDeleted
+		}
+		msg.ApplyEdit(content, editedAt)
+
+		edited = msg
+		return rewriteMessage(messages, primary, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return edited, nil
+}
+
+// DeleteMessage replaces a message with a tombstone within a single
+// transaction.
+func (r *BoltRepository) DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	var deleted *message.Message
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		primary := joinKey([]byte(roomID), []byte(messageID))
+
+		msg := decodeMessage(messages.Get(primary), r.logger)
+		if msg == nil {
+			return ErrMessageNotFound
+		}
+		if !moderator && msg.UserID != userID {
+			return ErrNotAuthor
+		}
+		deleted = msg
+		if msg.Deleted {
+			return nil
+		}
+		msg.Tombstone(userID, deletedAt)
+		if err := removeMentions(tx.Bucket(bucketMentions), msg); err != nil {
+			return err
+		}
+		return rewriteMessage(messages, primary, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// ReactToMessage applies a reaction change within a single transaction.
+func (r *BoltRepository) ReactToMessage(ctx context.Context, roomID, messageID, userID, emoji string, remove bool) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	var reacted *message.Message
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		primary := joinKey([]byte(roomID), []byte(messageID))
+
+		msg := decodeMessage(messages.Get(primary), r.logger)
+		if msg == nil {
+			return ErrMessageNotFound
+		}
+		if msg.Deleted {
+			return ErrMessageDeleted
+		}
+		reacted = msg
+		if !msg.ApplyReaction(userID, emoji, remove) {
+			return nil
+		}
+		return rewriteMessage(messages, primary, msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return reacted, nil
+}
+
+// rewriteMessage stores an updated message under its existing primary key.
+// Only for changes that leave the Timestamp, and so the index keys, intact.
+func rewriteMessage(messages *bolt.Bucket, primary []byte, msg *message.Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message %s: %w", msg.MessageID, err)
+	}
+	return messages.Put(primary, data)
+}
+
+// sweep removes every message whose retention period has elapsed at now,
+// walking the expiry bucket from the oldest deadline and uncounting swept
+// replies from parents still stored, and returns how many were removed.
+func (r *BoltRepository) sweep(now time.Time) (int, error) {
+	removed := 0
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		expiry := tx.Bucket(bucketExpiry)
+
+		// Collect first: deleting under a live cursor skips entries.
+		end := expiryKey(now.Add(time.Nanosecond), nil)
+		var expiredKeys, primaries [][]byte
+		c := expiry.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			expiredKeys = append(expiredKeys, bytes.Clone(k))
+			primaries = append(primaries, bytes.Clone(v))
+		}
+
+		for i, primary := range primaries {
+			if msg := decodeMessage(messages.Get(primary), r.logger); msg != nil {
+				tx.Bucket(bucketRoomTS).Delete(indexKey(msg.RoomID, msg.Timestamp, msg.MessageID))
+				tx.Bucket(bucketUserTS).Delete(indexKey(msg.UserID, msg.Timestamp, msg.MessageID))
+				if msg.ParentMessageID != "" {
+					tx.Bucket(bucketThreadTS).Delete(indexKey(threadPartition(msg.RoomID, msg.ParentMessageID), msg.Timestamp, msg.MessageID))
+					if err := countReply(messages, msg, -1); err != nil {
+						return err
+					}
+				}
+				if err := removeMentions(tx.Bucket(bucketMentions), msg); err != nil {
+					return err
+				}
+				if err := messages.Delete(primary); err != nil {
+					return err
+				}
+				removed++
+			}
+			if err := expiry.Delete(expiredKeys[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to sweep expired messages: %w", err)
+	}
+	return removed, nil
+}
+
+// GetMentions walks a user's inbox from the newest entry, attaching each
+// mentioning message.
+func (r *BoltRepository) GetMentions(ctx context.Context, userID string, limit int, unreadOnly bool) ([]*Mention, error) {
+	var mentions []*Mention
+	err := r.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		prefix := indexPrefix(userID)
+		c := tx.Bucket(bucketMentions).Cursor()
+
+		// Seek past the prefix and step back onto its last key.
+		k, v := c.Seek(prefixEnd(prefix))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && (limit <= 0 || len(mentions) < limit); k, v = c.Prev() {
+			var m Mention
+			if err := json.Unmarshal(v, &m); err != nil {
+				r.logger.Error("failed to unmarshal mention", slog.String("error", err.Error()))
+				continue
+			}
+			if unreadOnly && m.ReadAt != nil {
+				continue
+			}
+			m.Message = decodeMessage(messages.Get(joinKey([]byte(m.RoomID), []byte(m.MessageID))), r.logger)
+			if m.Message == nil {
+				continue
+			}
+			mentions = append(mentions, &m)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mentions of %s: %w", userID, err)
+	}
+	return mentions, nil
+}
+
+// CountUnreadMentions counts the unread entries in a user's inbox.
+func (r *BoltRepository) CountUnreadMentions(ctx context.Context, userID string) (int, error) {
+	unread := 0
+	err := r.forEachMention(userID, func(m *Mention) bool {
+		if m.ReadAt == nil {
+			unread++
+		}
+		return false
+	}, false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count mentions of %s: %w", userID, err)
+	}
+	return unread, nil
+}
+
+// MarkMentionsRead marks a user's unread mentions read within a single
+// transaction.
+func (r *BoltRepository) MarkMentionsRead(ctx context.Context, userID string, messageIDs []string, readAt time.Time) (int, error) {
+	ids := idSet(messageIDs)
+	marked := 0
+	err := r.forEachMention(userID, func(m *Mention) bool {
+		if !markable(m, ids) {
+			return false
+		}
+		m.ReadAt = &readAt
+		marked++
+		return true
+	}, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark mentions of %s read: %w", userID, err)
+	}
+	return marked, nil
+}
+
+// forEachMention calls fn with every entry in a user's inbox, oldest first.
+// With write set it runs in a read-write transaction and stores each entry
+// for which fn returns true.
+func (r *BoltRepository) forEachMention(userID string, fn func(*Mention) bool, write bool) error {
+	walk := func(tx *bolt.Tx) error {
+		mentions := tx.Bucket(bucketMentions)
+		prefix := indexPrefix(userID)
+
+		// Collect first: writing under a live cursor skips entries.
+		var keys [][]byte
+		var updated []*Mention
+		c := mentions.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var m Mention
+			if err := json.Unmarshal(v, &m); err != nil {
+				r.logger.Error("failed to unmarshal mention", slog.String("error", err.Error()))
+				continue
+			}
+			if fn(&m) && write {
+				keys = append(keys, bytes.Clone(k))
+				updated = append(updated, &m)
+			}
+		}
+
+		for i, m := range updated {
+			data, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := mentions.Put(keys[i], data); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if write {
+		return r.db.Update(walk)
+	}
+	return r.db.View(walk)
+}
+
+// SetReadMarker moves a user's read marker forward to a stored message
+// within a single transaction.
+func (r *BoltRepository) SetReadMarker(ctx context.Context, userID, roomID, messageID string, readAt time.Time) (*ReadMarker, bool, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	var marker *ReadMarker
+	moved := false
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		msg := decodeMessage(tx.Bucket(bucketMessages).Get(joinKey([]byte(roomID), []byte(messageID))), r.logger)
+		if msg == nil {
+			return ErrMessageNotFound
+		}
+
+		markers := tx.Bucket(bucketMarkers)
+		key := joinKey([]byte(userID), []byte(roomID))
+		current := decodeReadMarker(markers.Get(key), r.logger)
+		marker = newReadMarker(userID, msg, readAt)
+		if !advances(current, marker) {
+			marker = current
+			return nil
+		}
+
+		data, err := json.Marshal(marker)
+		if err != nil {
+			return fmt.Errorf("failed to marshal read marker: %w", err)
+		}
+		moved = true
+		return markers.Put(key, data)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return marker, moved, nil
+}
+
+// GetReadMarkers walks a user's read markers, which are keyed in room order.
+func (r *BoltRepository) GetReadMarkers(ctx context.Context, userID string) ([]*ReadMarker, error) {
+	markers := []*ReadMarker{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		prefix := indexPrefix(userID)
+		c := tx.Bucket(bucketMarkers).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if m := decodeReadMarker(v, r.logger); m != nil {
+				markers = append(markers, m)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get read markers of %s: %w", userID, err)
+	}
+	return markers, nil
+}
+
+// CountUnread walks a room's index forward from the user's read marker.
+func (r *BoltRepository) CountUnread(ctx context.Context, userID, roomID string) (int, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	unread := 0
+	err := r.db.View(func(tx *bolt.Tx) error {
+		messages := tx.Bucket(bucketMessages)
+		marker := decodeReadMarker(tx.Bucket(bucketMarkers).Get(joinKey([]byte(userID), []byte(roomID))), r.logger)
+
+		prefix := indexPrefix(roomID)
+		start := prefix
+		if marker != nil {
+			start = indexKey(roomID, marker.Timestamp, marker.MessageID)
+		}
+		c := tx.Bucket(bucketRoomTS).Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if msg := decodeMessage(messages.Get(v), r.logger); msg != nil && unreadBy(msg, userID, marker) {
+				unread++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread messages in %s: %w", roomID, err)
+	}
+	return unread, nil
+}
+
+// CreateRoom stores a new room.
+func (r *BoltRepository) CreateRoom(ctx context.Context, rm *room.Room) error {
+	data, err := json.Marshal(rm)
+	if err != nil {
+		return fmt.Errorf("failed to marshal room %s: %w", rm.ID, err)
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		rooms := tx.Bucket(bucketRooms)
+		if rooms.Get([]byte(rm.ID)) != nil {
+			return ErrRoomExists
+		}
+		return rooms.Put([]byte(rm.ID), data)
+	})
+}
+
+// GetRoom retrieves a room by ID.
+func (r *BoltRepository) GetRoom(ctx context.Context, roomID string) (*room.Room, error) {
+	var rm *room.Room
+	err := r.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketRooms).Get([]byte(roomID))
+		if data == nil {
+			return ErrRoomNotFound
+		}
+		rm = &room.Room{}
+		return json.Unmarshal(data, rm)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rm, nil
+}
+
+// ListRooms returns every room, oldest first.
+func (r *BoltRepository) ListRooms(ctx context.Context) ([]*room.Room, error) {
+	var rooms []*room.Room
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketRooms).ForEach(func(k, v []byte) error {
+			var rm room.Room
+			if err := json.Unmarshal(v, &rm); err != nil {
+				r.logger.Error("failed to unmarshal room", slog.String("error", err.Error()))
+				return nil
+			}
+			rooms = append(rooms, &rm)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+	sortRooms(rooms)
+	return rooms, nil
+}
+
+// PinMessage pins a stored message to a room.
+func (r *BoltRepository) PinMessage(ctx context.Context, roomID, messageID, userID string, pinnedAt time.Time) (*room.Room, bool, error) {
+	return r.updateRoom(roomID, func(tx *bolt.Tx, rm *room.Room) (bool, error) {
+		msg := decodeMessage(tx.Bucket(bucketMessages).Get(joinKey([]byte(roomID), []byte(messageID))), r.logger)
+		if msg == nil {
+			return false, ErrMessageNotFound
+		}
+		if msg.Deleted {
+			return false, ErrMessageDeleted
+		}
+		return rm.Pin(messageID, userID, pinnedAt)
+	})
+}
+
+// UnpinMessage removes a pin from a room.
+func (r *BoltRepository) UnpinMessage(ctx context.Context, roomID, messageID string) (*room.Room, bool, error) {
+	return r.updateRoom(roomID, func(tx *bolt.Tx, rm *room.Room) (bool, error) {
+		return rm.Unpin(messageID), nil
+	})
+}
+
+// updateRoom applies fn to a stored room in one transaction, writing the room
+// back only if fn reports a change.
+func (r *BoltRepository) updateRoom(roomID string, fn func(tx *bolt.Tx, rm *room.Room) (bool, error)) (*room.Room, bool, error) {
+	var rm *room.Room
+	changed := false
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		rooms := tx.Bucket(bucketRooms)
+		data := rooms.Get([]byte(roomID))
+		if data == nil {
+			return ErrRoomNotFound
+		}
+		rm = &room.Room{}
+		if err := json.Unmarshal(data, rm); err != nil {
+			return fmt.Errorf("failed to unmarshal room %s: %w", roomID, err)
+		}
+
+		var err error
+		if changed, err = fn(tx, rm); err != nil || !changed {
+			return err
+		}
+		if data, err = json.Marshal(rm); err != nil {
+			return fmt.Errorf("failed to marshal room %s: %w", roomID, err)
+		}
+		return rooms.Put([]byte(roomID), data)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return rm, changed, nil
+}
+
+// HealthCheck verifies the database is open and readable.
+func (r *BoltRepository) HealthCheck(ctx context.Context) error {
+	if err := r.db.View(func(tx *bolt.Tx) error { return nil }); err != nil {
+		return fmt.Errorf("bolt health check failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying database file.
+func (r *BoltRepository) Close() error {
+	r.closeOnce.Do(func() { close(r.done) })
+	if err := r.db.Close(); err != nil {
+		return fmt.Errorf("failed to close bolt database: %w", err)
+	}
+	r.logger.Info("bolt repository closed")
+	return nil
+}
+
+// decodeMessage unmarshals a stored message, logging and skipping bad data.
+func decodeMessage(data []byte, logger *slog.Logger) *message.Message {
+	if data == nil {
+		return nil
+	}
+	var msg message.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		logger.Error("failed to unmarshal message", slog.String("error", err.Error()))
+		return nil
+	}
+	return &msg
+}
+
+// decodeReadMarker unmarshals a stored read marker, logging and skipping bad
+// data.
+func decodeReadMarker(data []byte, logger *slog.Logger) *ReadMarker {
+	if data == nil {
+		return nil
+	}
+	var m ReadMarker
+	if err := json.Unmarshal(data, &m); err != nil {
+		logger.Error("failed to unmarshal read marker", slog.String("error", err.Error()))
+		return nil
+	}
+	return &m
+}
+
+// joinKey concatenates key components with keySep.
+func joinKey(parts ...[]byte) []byte {
+	return bytes.Join(parts, []byte{keySep})
+}
+
+// threadPartition is the thread index partition of the replies to
+// parentMessageID in a room.
+func threadPartition(roomID, parentMessageID string) string {
+	return string(joinKey([]byte(roomID), []byte(parentMessageID)))
+}
+
+// mentionEntryKey builds a mentions bucket key: the mentioned user's index
+// key for the message, with its room and ID as the tie-breaker.
+func mentionEntryKey(m *Mention) []byte {
+	return indexKey(m.UserID, m.Timestamp, threadPartition(m.RoomID, m.MessageID))
+}
+
+// indexPrefix returns the key prefix shared by every index entry of id.
+func indexPrefix(id string) []byte {
+	return append([]byte(id), keySep)
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	end[len(end)-1]++
+	return end
+}
+
+// indexKey builds an ordered index key: partition, big-endian nanosecond
+// timestamp (so keys sort chronologically) and message ID as a tie-breaker.
+func indexKey(partition string, ts time.Time, messageID string) []byte {
+	var tsBuf [8]byte
+	binary.BigEndian.PutUint64(tsBuf[:], keyNanos(ts))
+	return joinKey([]byte(partition), tsBuf[:], []byte(messageID))
+}
+
+// expiryKey builds an expiry bucket key: big-endian nanosecond deadline (so
+// keys sort by expiry) followed by the message's primary key.
+func expiryKey(expiresAt time.Time, primary []byte) []byte {
+	var tsBuf [8]byte
+	binary.BigEndian.PutUint64(tsBuf[:], keyNanos(expiresAt))
+	return append(tsBuf[:], primary...)
+}
+
+// The range of times UnixNano can represent.
+var (
+	minKeyTime = time.Unix(0, math.MinInt64)
+	maxKeyTime = time.Unix(0, math.MaxInt64)
+)
+
+// keyNanos encodes t for a key as nanoseconds since the epoch with the sign
+// bit flipped, so that times before 1970 (and the zero time) sort before
+// later ones. Times beyond what an int64 holds are clamped to its range.
+func keyNanos(t time.Time) uint64 {
+	n := t.UnixNano()
+	if t.Before(minKeyTime) {
+		n = math.MinInt64
+	} else if t.After(maxKeyTime) {
+		n = math.MaxInt64
+	}
+	return uint64(n) ^ 1<<63
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestBoltRepo(t *testing.T, path string) *BoltRepository {
+	t.Helper()
+	repo, err := NewBoltRepository(path, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatalf("NewBoltRepository: %v", err)
+	}
+	return repo
+}
+
+func TestBoltRepository_Contract(t *testing.T) {
+	testRepositoryContract(t, func(t *testing.T) MessageRepository {
+		return newTestBoltRepo(t, filepath.Join(t.TempDir(), "chat.db"))
+	})
+}
+
+func TestBoltRepository_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "chat.db")
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	repo := newTestBoltRepo(t, path)
+	repo.SaveMessage(ctx, testMessage("m1", "lobby", "u1", base))
+	repo.SaveMessage(ctx, testMessage("m2", "lobby", "u1", base.Add(time.Second)))
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	repo = newTestBoltRepo(t, path)
+	defer repo.Close()
+
+	msgs, err := repo.GetRecentMessages(ctx, "lobby", 10)
+	if err != nil {
+		t.Fatalf("GetRecentMessages: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].MessageID != "m1" || !msgs[1].Timestamp.Equal(base.Add(time.Second)) {
+		t.Errorf("unexpected messages after reopen: %v", ids(msgs))
+	}
+}
+
+// A database written with the old key format, where a pre-1970 timestamp
+// sorted after every later one, is reindexed on open.
+func TestBoltRepository_ReindexesOldKeyFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.db")
+	ctx := context.Background()
+	early := time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)
+
+	repo := newTestBoltRepo(t, path)
+	repo.SaveMessage(ctx, testMessage("m1", "lobby", "u1", early))
+	repo.SaveMessage(ctx, testMessage("m2", "lobby", "u1", seedBase))
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(bucketMeta).Delete(metaKeyFormat); err != nil {
+			return err
+		}
+		roomTS := tx.Bucket(bucketRoomTS)
+		for _, msg := range []*message.Message{testMessage("m1", "lobby", "u1", early), testMessage("m2", "lobby", "u1", seedBase)} {
+			roomTS.Delete(indexKey(msg.RoomID, msg.Timestamp, msg.MessageID))
+			var tsBuf [8]byte
+			binary.BigEndian.PutUint64(tsBuf[:], uint64(msg.Timestamp.UnixNano()))
+			primary := joinKey([]byte(msg.RoomID), []byte(msg.MessageID))
+			if err := roomTS.Put(joinKey([]byte(msg.RoomID), tsBuf[:], []byte(msg.MessageID)), primary); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("rewrite keys: %v", err)
+	}
+	repo.Close()
+
+	repo = newTestBoltRepo(t, path)
+	defer repo.Close()
+
+	msgs, err := repo.GetRecentMessages(ctx, "lobby", 10)
+	if err != nil {
+		t.Fatalf("GetRecentMessages: %v", err)
+	}
+	if got := ids(msgs); len(got) != 2 || got[0] != "m1" || got[1] != "m2" {
+		t.Errorf("expected [m1 m2] after reindex, got %v", got)
+	}
+}
+
+// Room IDs that share a prefix must not bleed into each other's key range.
+func TestBoltRepository_PrefixIsolation(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "chat.db"))
+	defer repo.Close()
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	repo.SaveMessage(ctx, testMessage("m1", "room", "u1", base))
+	repo.SaveMessage(ctx, testMessage("m2", "room2", "u1", base.Add(time.Second)))
+
+	msgs, _ := repo.GetRecentMessages(ctx, "room", 10)
+	if len(msgs) != 1 || msgs[0].MessageID != "m1" {
+		t.Errorf("expected only m1 in room, got %v", ids(msgs))
+	}
+}
+
+func TestBoltRepository_HealthCheckAfterClose(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "chat.db"))
+	ctx := context.Background()
+
+	if err := repo.HealthCheck(ctx); err != nil {
+		t.Fatalf("HealthCheck: %v", err)
+	}
+	repo.Close()
+	if err := repo.HealthCheck(ctx); err == nil {
+		t.Error("expected HealthCheck to fail after Close")
+	}
+}
+
+func TestBoltRepository_RetentionSweep(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "chat.db"))
+	defer repo.Close()
+	testRetentionSweep(t, repo)
+}
+
+func TestBoltRepository_RetentionSweepReplies(t *testing.T) {
+	repo := newTestBoltRepo(t, filepath.Join(t.TempDir(), "chat.db"))
+	defer repo.Close()
+	testRetentionSweepReplies(t, repo)
+}
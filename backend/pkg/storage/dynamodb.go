@@ -2,8 +2,11 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,17 +17,50 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	appconfig "github.com/epw80/chat-analytics-platform/pkg/config"
 	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
 	"github.com/google/uuid"
 )
 
 // DynamoDBRepository implements MessageRepository using AWS DynamoDB
 type DynamoDBRepository struct {
-	client *dynamodb.Client
-	logger *slog.Logger
+	client    *dynamodb.Client
+	logger    *slog.Logger
+	retention RetentionPolicy
 }
 
 // NewDynamoDBRepository creates a new DynamoDB-backed message repository
 func NewDynamoDBRepository(ctx context.Context, cfg *appconfig.Config, logger *slog.Logger) (*DynamoDBRepository, error) {
+	client, err := NewDynamoDBClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &DynamoDBRepository{
+		client:    client,
+		logger:    logger,
+		retention: NewRetentionPolicy(cfg),
+	}
+
+	// Create or upgrade the tables
+	if err := repo.EnsureTable(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ensure DynamoDB table: %w", err)
+	}
+
+	// Verify connection with health check
+	if err := repo.HealthCheck(ctx); err != nil {
+		return nil, fmt.Errorf("DynamoDB health check failed: %w", err)
+	}
+
+	logger.Info("DynamoDB repository initialized",
+		slog.String("region", cfg.DynamoDBRegion),
+		slog.String("endpoint", cfg.DynamoDBEndpoint))
+
+	return repo, nil
+}
+
+// NewDynamoDBClient creates a DynamoDB client for the configured region,
+// pointing at DYNAMODB_ENDPOINT with static credentials when one is set
+func NewDynamoDBClient(ctx context.Context, cfg *appconfig.Config) (*dynamodb.Client, error) {
 	// Load AWS config
 	var awsCfg aws.Config
 	var err error
@@ -50,36 +86,18 @@ func NewDynamoDBRepository(ctx context.Context, cfg *appconfig.Config, logger *s
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// Create DynamoDB client
-	client := dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+	return dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
 		if cfg.DynamoDBEndpoint != "" {
 			o.BaseEndpoint = aws.String(cfg.DynamoDBEndpoint)
 		}
-	})
-
-	repo := &DynamoDBRepository{
-		client: client,
-		logger: logger,
-	}
-
-	// Create table if it doesn't exist
-	if err := repo.EnsureTable(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ensure DynamoDB table: %w", err)
-	}
-
-	// Verify connection with health check
-	if err := repo.HealthCheck(ctx); err != nil {
-		return nil, fmt.Errorf("DynamoDB health check failed: %w", err)
-	}
-
-	logger.Info("DynamoDB repository initialized",
-		slog.String("region", cfg.DynamoDBRegion),
-		slog.String("endpoint", cfg.DynamoDBEndpoint))
-
-	return repo, nil
+	}), nil
 }
 
-// SaveMessage persists a message to DynamoDB
+// SaveMessage persists a message to DynamoDB unless one with the same key is
+// already stored, so replaying a message that was since edited or deleted
+// changes nothing. Replies are written through saveReply so their parent's
+// ReplyCount is kept, and a new message is then added to the inbox of each
+// user it mentions.
 func (r *DynamoDBRepository) SaveMessage(ctx context.Context, msg *message.Message) error {
 	// Generate UUID if not already set
 	if msg.MessageID == "" {
@@ -91,8 +109,11 @@ func (r *DynamoDBRepository) SaveMessage(ctx context.Context, msg *message.Messa
 		msg.RoomID = DefaultRoomID
 	}
 
+	// Stamp the TTL attribute so DynamoDB expires the item per the policy
+	r.retention.Stamp(msg)
+
 	// Marshal message to DynamoDB attribute values
-	item, err := attributevalue.MarshalMap(msg)
+	item, err := marshalMessage(msg)
 	if err != nil {
 		r.logger.Error("failed to marshal message",
 			slog.String("error", err.Error()),
@@ -100,19 +121,23 @@ func (r *DynamoDBRepository) SaveMessage(ctx context.Context, msg *message.Messa
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	// Put item to DynamoDB
-	input := &dynamodb.PutItemInput{
-		TableName: aws.String(TableName),
-		Item:      item,
+	if msg.ParentMessageID != "" {
+		err = r.saveReply(ctx, msg, item)
+	} else {
+		err = r.putNew(ctx, item)
+	}
+	if errors.Is(err, errAlreadyStored) {
+		return nil
 	}
-
-	_, err = r.client.PutItem(ctx, input)
 	if err != nil {
 		r.logger.Error("failed to save message to DynamoDB",
 			slog.String("error", err.Error()),
 			slog.String("messageId", msg.MessageID))
 		return fmt.Errorf("failed to save message: %w", err)
 	}
+	if err := r.saveMentions(ctx, msg); err != nil {
+		return err
+	}
 
 	r.logger.Debug("message saved to DynamoDB",
 		slog.String("messageId", msg.MessageID),
@@ -122,61 +147,152 @@ func (r *DynamoDBRepository) SaveMessage(ctx context.Context, msg *message.Messa
 	return nil
 }
 
-// BatchSaveMessages persists multiple messages using DynamoDB BatchWriteItem,
-// chunking into the 25-item-per-call limit and retrying unprocessed items.
+// BatchSaveMessages persists multiple messages with conditional puts, so each
+// is written only if absent, which BatchWriteItem cannot guarantee. Rooms are
+// written concurrently; within a room messages are saved one at a time, in
+// order, so any parents in the batch are stored before their replies.
 func (r *DynamoDBRepository) BatchSaveMessages(ctx context.Context, msgs []*message.Message) error {
-	const maxBatch = 25 // DynamoDB BatchWriteItem hard limit
-
-	for start := 0; start < len(msgs); start += maxBatch {
-		end := start + maxBatch
-		if end > len(msgs) {
-			end = len(msgs)
-		}
-		if err := r.writeBatch(ctx, msgs[start:end]); err != nil {
-			return err
+	var rooms []string
+	byRoom := make(map[string][]*message.Message)
+	for _, msg := range msgs {
+		if _, ok := byRoom[msg.RoomID]; !ok {
+			rooms = append(rooms, msg.RoomID)
 		}
+		byRoom[msg.RoomID] = append(byRoom[msg.RoomID], msg)
 	}
-	return nil
+
+	errs := make([]error, len(rooms))
+	var wg sync.WaitGroup
+	for i, roomID := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, msg := range byRoom[roomID] {
+				if err := r.SaveMessage(ctx, msg); err != nil {
+					errs[i] = err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
 
-// writeBatch writes a single chunk (<=25 items), retrying any items DynamoDB
-// reports as unprocessed (e.g. due to throttling).
-func (r *DynamoDBRepository) writeBatch(ctx context.Context, msgs []*message.Message) error {
-	requests := make([]types.WriteRequest, 0, len(msgs))
-	for _, msg := range msgs {
-		if msg.MessageID == "" {
-			msg.MessageID = uuid.New().String()
-		}
-		if msg.RoomID == "" {
-			msg.RoomID = DefaultRoomID
+// errAlreadyStored reports that a message was not written because one with
+// the same key is already stored.
+var errAlreadyStored = errors.New("message already stored")
+
+// putNew writes a message item only if no item has its key, returning
+// errAlreadyStored otherwise.
+func (r *DynamoDBRepository) putNew(ctx context.Context, item map[string]types.AttributeValue) error {
+	_, err := r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(TableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#messageId)"),
+		ExpressionAttributeNames: map[string]string{"#messageId": AttrMessageID},
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return errAlreadyStored
+	}
+	return err
+}
+
+// saveReply stores a new reply and increments its parent's ReplyCount in one
+// transaction, so a reply is counted once however often it is saved. A reply
+// already stored is left as it is and errAlreadyStored returned; one whose
+// parent is gone (e.g. expired) is stored uncounted.
+func (r *DynamoDBRepository) saveReply(ctx context.Context, msg *message.Message, item map[string]types.AttributeValue) error {
+	parentKey := map[string]types.AttributeValue{
+		AttrRoomID:    &types.AttributeValueMemberS{Value: msg.RoomID},
+		AttrMessageID: &types.AttributeValueMemberS{Value: msg.ParentMessageID},
+	}
+	_, err := r.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:                aws.String(TableName),
+				Item:                     item,
+				ConditionExpression:      aws.String("attribute_not_exists(#messageId)"),
+				ExpressionAttributeNames: map[string]string{"#messageId": AttrMessageID},
+			}},
+			{Update: &types.Update{
+				TableName:           aws.String(TableName),
+				Key:                 parentKey,
+				UpdateExpression:    aws.String("ADD #replyCount :one"),
+				ConditionExpression: aws.String("attribute_exists(#messageId)"),
+				ExpressionAttributeNames: map[string]string{
+					"#messageId":  AttrMessageID,
+					"#replyCount": AttrReplyCount,
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":one": &types.AttributeValueMemberN{Value: "1"},
+				},
+			}},
+		},
+	})
+
+	// Cancellation reasons are listed in TransactItems order.
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) == 2 {
+		switch {
+		case aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed":
+			return errAlreadyStored
+		case aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed":
+			err = r.putNew(ctx, item)
 		}
-		item, err := attributevalue.MarshalMap(msg)
+	}
+	return err
+}
+
+// saveMentions adds msg to the inbox of each user it mentions. Each entry is
+// put only if absent, so saving a message again keeps its read state.
+func (r *DynamoDBRepository) saveMentions(ctx context.Context, msg *message.Message) error {
+	for _, m := range mentionsOf(msg) {
+		item, err := attributevalue.MarshalMap(m)
 		if err != nil {
-			return fmt.Errorf("failed to marshal message %s: %w", msg.MessageID, err)
+			return fmt.Errorf("failed to marshal mention of %s: %w", m.UserID, err)
 		}
-		requests = append(requests, types.WriteRequest{
-			PutRequest: &types.PutRequest{Item: item},
+		item[AttrMentionKey] = &types.AttributeValueMemberS{Value: mentionKey(m)}
+
+		_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName:                aws.String(MentionsTableName),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#userId)"),
+			ExpressionAttributeNames: map[string]string{"#userId": AttrUserID},
 		})
+		var failed *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &failed) {
+			r.logger.Error("failed to save mention",
+				slog.String("error", err.Error()),
+				slog.String("messageId", msg.MessageID),
+				slog.String("userId", m.UserID))
+			return fmt.Errorf("failed to save mention: %w", err)
+		}
 	}
+	return nil
+}
 
-	unprocessed := map[string][]types.WriteRequest{TableName: requests}
-	for attempt := 0; attempt < 3; attempt++ {
-		out, err := r.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
-			RequestItems: unprocessed,
+// deleteMentions takes msg out of the inbox of each user it mentions.
+func (r *DynamoDBRepository) deleteMentions(ctx context.Context, msg *message.Message) error {
+	for _, m := range mentionsOf(msg) {
+		_, err := r.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(MentionsTableName),
+			Key:       mentionItemKey(m),
 		})
 		if err != nil {
-			r.logger.Error("batch write failed",
-				slog.Int("items", len(unprocessed[TableName])),
-				slog.String("error", err.Error()))
-			return fmt.Errorf("batch write failed: %w", err)
-		}
-		unprocessed = out.UnprocessedItems
-		if len(unprocessed[TableName]) == 0 {
-			return nil
+			return fmt.Errorf("failed to delete mention: %w", err)
 		}
 	}
+	return nil
+}
 
-	return fmt.Errorf("batch write left %d unprocessed items after retries", len(unprocessed[TableName]))
+// mentionItemKey is the primary key of an inbox entry.
+func mentionItemKey(m *Mention) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		AttrUserID:     &types.AttributeValueMemberS{Value: m.UserID},
+		AttrMentionKey: &types.AttributeValueMemberS{Value: mentionKey(m)},
+	}
 }
 
 // GetRecentMessages retrieves the most recent messages for a given room
@@ -199,6 +315,7 @@ func (r *DynamoDBRepository) GetRecentMessages(ctx context.Context, roomID strin
 		ScanIndexForward: aws.Bool(false), // Descending order (newest first)
 		Limit:            aws.Int32(int32(limit)),
 	}
+	filterUnexpired(input, time.Now())
 
 	result, err := r.client.Query(ctx, input)
 	if err != nil {
@@ -276,66 +393,989 @@ func (r *DynamoDBRepository) GetMessagesByUser(ctx context.Context, userID strin
 	return messages, nil
 }
 
-// EnsureTable creates the DynamoDB table if it does not already exist
+// GetRoomMessagesPage retrieves one page of a room's history from the
+// RoomID-Timestamp index.
+func (r *DynamoDBRepository) GetRoomMessagesPage(ctx context.Context, roomID string, q PageQuery) (*Page, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	return r.queryPage(ctx, IndexRoomTimestamp, AttrRoomID, roomID, "", q)
+}
+
+// GetUserMessagesPage retrieves one page of a user's history from the
+// UserID-Timestamp index.
+func (r *DynamoDBRepository) GetUserMessagesPage(ctx context.Context, userID string, q PageQuery) (*Page, error) {
+	return r.queryPage(ctx, IndexUserTimestamp, AttrUserID, userID, "", q)
+}
+
+// GetMessage retrieves one message by its primary key.
+func (r *DynamoDBRepository) GetMessage(ctx context.Context, roomID, messageID string) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]types.AttributeValue{
+			AttrRoomID:    &types.AttributeValueMemberS{Value: roomID},
+			AttrMessageID: &types.AttributeValueMemberS{Value: messageID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	if out.Item == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	var msg message.Message
+	if err := attributevalue.UnmarshalMap(out.Item, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return &msg, nil
+}
+
+// GetThreadPage retrieves one page of a thread's replies from the
+// ParentMessageID-Timestamp index. Message IDs are only unique within a room,
+// so replies to a same-named message elsewhere are filtered out; a page may
+// then hold fewer than q.Limit replies even though NextCursor is set.
+func (r *DynamoDBRepository) GetThreadPage(ctx context.Context, roomID, parentMessageID string, q PageQuery) (*Page, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	return r.queryPage(ctx, IndexParentTimestamp, AttrParentMessageID, parentMessageID, roomID, q)
+}
+
+// queryPage runs a paginated query against a Timestamp-sorted GSI, keeping
+// only items in roomID when it is set. The cursor is the previous page's
+// LastEvaluatedKey and is passed back as ExclusiveStartKey, so DynamoDB
+// resumes exactly where the last page ended.
+func (r *DynamoDBRepository) queryPage(ctx context.Context, index, partitionAttr, partition, roomID string, q PageQuery) (*Page, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		IndexName:              aws.String(index),
+		KeyConditionExpression: aws.String("#pk = :pk"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk": partitionAttr,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk": &types.AttributeValueMemberS{Value: partition},
+		},
+		ScanIndexForward: aws.Bool(q.forward()),
+	}
+
+	// Restrict the Timestamp sort key to the requested time range.
+	if tsCond := timeRangeCondition(q, input.ExpressionAttributeValues); tsCond != "" {
+		input.KeyConditionExpression = aws.String("#pk = :pk AND " + tsCond)
+		input.ExpressionAttributeNames["#ts"] = AttrTimestamp
+	}
+	if q.Limit > 0 {
+		input.Limit = aws.Int32(int32(q.Limit))
+	}
+	if roomID != "" {
+		input.FilterExpression = aws.String("#roomId = :roomId")
+		input.ExpressionAttributeNames["#roomId"] = AttrRoomID
+		input.ExpressionAttributeValues[":roomId"] = &types.AttributeValueMemberS{Value: roomID}
+	}
+	filterUnexpired(input, time.Now())
+
+	if q.cursor() != "" {
+		key, err := decodeCursor(q, partitionAttr, partition)
+		if err != nil {
+			return nil, err
+		}
+		// The cursor's timestamp is rewritten in the stored form, so a
+		// cursor built by hand still lines up with the index.
+		ts, _, err := key.position()
+		if err != nil {
+			return nil, err
+		}
+		key[AttrTimestamp] = sortableTime(ts)
+		input.ExclusiveStartKey = make(map[string]types.AttributeValue, len(key))
+		for attr, value := range key {
+			input.ExclusiveStartKey[attr] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+
+	result, err := r.client.Query(ctx, input)
+	if err != nil {
+		r.logger.Error("failed to query message page",
+			slog.String("error", err.Error()),
+			slog.String("index", index),
+			slog.String("partition", partition))
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+
+	messages := make([]*message.Message, 0, len(result.Items))
+	for _, item := range result.Items {
+		var msg message.Message
+		if err := attributevalue.UnmarshalMap(item, &msg); err != nil {
+			r.logger.Error("failed to unmarshal message",
+				slog.String("error", err.Error()))
+			continue
+		}
+		messages = append(messages, &msg)
+	}
+
+	// Backward pages come back newest first; return them oldest first.
+	if !q.forward() {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+
+	next := make(cursorKey, len(result.LastEvaluatedKey))
+	for attr, value := range result.LastEvaluatedKey {
+		if s, ok := value.(*types.AttributeValueMemberS); ok {
+			next[attr] = s.Value
+		}
+	}
+
+	return &Page{Messages: messages, NextCursor: encodeCursor(next)}, nil
+}
+
+// timeRangeCondition builds the sort-key condition for q's Since/Until bounds,
+// adding the bound values to values. Stored Timestamps are fixed-width
+// strings (see marshalMessage), and the bounds are formatted the same way so
+// the string comparison is chronological. Returns an empty condition when the
+// range is open on both ends.
+func timeRangeCondition(q PageQuery, values map[string]types.AttributeValue) string {
+	var cond string
+	switch {
+	case !q.Since.IsZero() && !q.Until.IsZero():
+		cond = "#ts BETWEEN :since AND :until"
+	case !q.Since.IsZero():
+		cond = "#ts >= :since"
+	case !q.Until.IsZero():
+		cond = "#ts <= :until"
+	default:
+		return ""
+	}
+
+	if !q.Since.IsZero() {
+		values[":since"] = &types.AttributeValueMemberS{Value: sortableTime(q.Since)}
+	}
+	if !q.Until.IsZero() {
+		values[":until"] = &types.AttributeValueMemberS{Value: sortableTime(q.Until)}
+	}
+	return cond
+}
+
+// filterUnexpired adds a filter to input that drops messages whose ExpiresAt
+// has passed at now. TTL deletes expired items only eventually, often days
+// later, and until then queries would still return them. Like any filter it
+// applies after Limit, so a page may come back short.
+func filterUnexpired(input *dynamodb.QueryInput, now time.Time) {
+	cond := "(attribute_not_exists(#ttl) OR #ttl > :now)"
+	if input.FilterExpression != nil {
+		cond = *input.FilterExpression + " AND " + cond
+	}
+	input.FilterExpression = aws.String(cond)
+	input.ExpressionAttributeNames["#ttl"] = AttrExpiresAt
+	input.ExpressionAttributeValues[":now"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)}
+}
+
+// marshalMessage converts msg to a DynamoDB item. Timestamp, the sort key of
+// every timestamp index, is stored at the fixed width of sortableTime rather
+// than as RFC3339Nano, whose varying width does not sort chronologically.
+// Both forms unmarshal to the same time.
+func marshalMessage(msg *message.Message) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(msg)
+	if err != nil {
+		return nil, err
+	}
+	item[AttrTimestamp] = &types.AttributeValueMemberS{Value: sortableTime(msg.Timestamp)}
+	return item, nil
+}
+
+// EditMessage replaces a message's content with an optimistic conditional
+// update: the item is read, the edit applied locally (so the revision history
+// can be trimmed), and written back only if it is still authored by userID and
+// has not been edited concurrently. A lost race re-reads and retries.
+func (r *DynamoDBRepository) EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	key := map[string]types.AttributeValue{
+		AttrRoomID:    &types.AttributeValueMemberS{Value: roomID},
+		AttrMessageID: &types.AttributeValueMemberS{Value: messageID},
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(TableName),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		if out.Item == nil {
+			return nil, ErrMessageNotFound
+		}
+
+		var msg message.Message
+		if err := attributevalue.UnmarshalMap(out.Item, &msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		if msg.UserID != userID {
+			return nil, ErrNotAuthor
+		}
+		if msg.Deleted {
+			return nil, ErrMessageDeleted
+		}
+
+		// Guard on the EditedAt we read so concurrent edits can't interleave,
+		// and on the message not having been deleted in the meantime.
+		names := map[string]string{
+			"#userId":    AttrUserID,
+			"#content":   AttrContent,
+			"#editedAt":  AttrEditedAt,
+			"#revisions": AttrRevisions,
+			"#deleted":   AttrDeleted,
+		}
+		values := map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		}
+		condition := "#userId = :userId AND attribute_not_exists(#deleted) AND attribute_not_exists(#editedAt)"
+		if msg.EditedAt != nil {
+			prev, err := attributevalue.Marshal(*msg.EditedAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal editedAt: %w", err)
+			}
+			values[":prevEditedAt"] = prev
+			condition = "#userId = :userId AND attribute_not_exists(#deleted) AND #editedAt = :prevEditedAt"
+		}
+
+		msg.ApplyEdit(content, editedAt)
+		updates := map[string]any{
+			":content":   msg.Content,
+			":editedAt":  *msg.EditedAt,
+			":revisions": msg.Revisions,
+		}
+		for name, v := range updates {
+			av, err := attributevalue.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal %s: %w", name, err)
+			}
+			values[name] = av
+		}
+
+		_, err = r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(TableName),
+			Key:                       key,
+			UpdateExpression:          aws.String("SET #content = :content, #editedAt = :editedAt, #revisions = :revisions"),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			r.logger.Debug("concurrent edit detected, retrying",
+				slog.String("messageId", messageID),
+				slog.Int("attempt", attempt+1))
+			continue
+		}
+		if err != nil {
+			r.logger.Error("failed to edit message",
+				slog.String("error", err.Error()),
+				slog.String("messageId", messageID))
+			return nil, fmt.Errorf("failed to edit message: %w", err)
+		}
+
+		r.logger.Debug("message edited",
+			slog.String("messageId", messageID),
+			slog.String("roomId", roomID))
+		return &msg, nil
+	}
+
+	return nil, fmt.Errorf("failed to edit message %s: too many concurrent edits", messageID)
+}
+
+// ReactToMessage applies a reaction change as a read-modify-write guarded on
+// the Reactions value that was read, retrying if a concurrent reaction or
+// delete got there first.
+func (r *DynamoDBRepository) ReactToMessage(ctx context.Context, roomID, messageID, userID, emoji string, remove bool) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+	key := map[string]types.AttributeValue{
+		AttrRoomID:    &types.AttributeValueMemberS{Value: roomID},
+		AttrMessageID: &types.AttributeValueMemberS{Value: messageID},
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(TableName),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read message: %w", err)
+		}
+		if out.Item == nil {
+			return nil, ErrMessageNotFound
+		}
+
+		var msg message.Message
+		if err := attributevalue.UnmarshalMap(out.Item, &msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		if msg.Deleted {
+			return nil, ErrMessageDeleted
+		}
+
+		names := map[string]string{
+			"#messageId": AttrMessageID,
+			"#deleted":   AttrDeleted,
+			"#reactions": AttrReactions,
+		}
+		values := map[string]types.AttributeValue{}
+		condition := "attribute_exists(#messageId) AND attribute_not_exists(#deleted) AND attribute_not_exists(#reactions)"
+		if msg.Reactions != nil {
+			prev, err := attributevalue.Marshal(msg.Reactions)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal reactions: %w", err)
+			}
+			values[":prevReactions"] = prev
+			condition = "attribute_exists(#messageId) AND attribute_not_exists(#deleted) AND #reactions = :prevReactions"
+		}
+
+		if !msg.ApplyReaction(userID, emoji, remove) {
+			return &msg, nil
+		}
+		update := "REMOVE #reactions"
+		if msg.Reactions != nil {
+			av, err := attributevalue.Marshal(msg.Reactions)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal reactions: %w", err)
+			}
+			values[":reactions"] = av
+			update = "SET #reactions = :reactions"
+		}
+		if len(values) == 0 {
+			values = nil
+		}
+
+		_, err = r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(TableName),
+			Key:                       key,
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			r.logger.Debug("concurrent reaction detected, retrying",
+				slog.String("messageId", messageID),
+				slog.Int("attempt", attempt+1))
+			continue
+		}
+		if err != nil {
+			r.logger.Error("failed to react to message",
+				slog.String("error", err.Error()),
+				slog.String("messageId", messageID))
+			return nil, fmt.Errorf("failed to react to message: %w", err)
+		}
+		return &msg, nil
+	}
+
+	return nil, fmt.Errorf("failed to react to message %s: too many concurrent reactions", messageID)
+}
+
+// DeleteMessage replaces a message with a tombstone in one conditional update.
+// The condition requires the item to exist, not already be a tombstone and,
+// for non-moderators, to be authored by userID; on failure the old item is
+// returned so the cause can be reported precisely.
+func (r *DynamoDBRepository) DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	deletedAtAV, err := attributevalue.Marshal(deletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal deletedAt: %w", err)
+	}
+
+	names := map[string]string{
+		"#messageId":   AttrMessageID,
+		"#content":     AttrContent,
+		"#revisions":   AttrRevisions,
+		"#reactions":   AttrReactions,
+		"#attachments": AttrAttachments,
+		"#deleted":     AttrDeleted,
+		"#deletedAt":   AttrDeletedAt,
+		"#deletedBy":   AttrDeletedBy,
+	}
+	values := map[string]types.AttributeValue{
+		":empty":     &types.AttributeValueMemberS{Value: ""},
+		":true":      &types.AttributeValueMemberBOOL{Value: true},
+		":deletedAt": deletedAtAV,
+		":deletedBy": &types.AttributeValueMemberS{Value: userID},
+	}
+	condition := "attribute_exists(#messageId) AND attribute_not_exists(#deleted)"
+	if !moderator {
+		names["#userId"] = AttrUserID
+		values[":userId"] = &types.AttributeValueMemberS{Value: userID}
+		condition += " AND #userId = :userId"
+	}
+
+	out, err := r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(TableName),
+		Key: map[string]types.AttributeValue{
+			AttrRoomID:    &types.AttributeValueMemberS{Value: roomID},
+			AttrMessageID: &types.AttributeValueMemberS{Value: messageID},
+		},
+		UpdateExpression: aws.String("SET #content = :empty, #deleted = :true, " +
+			"#deletedAt = :deletedAt, #deletedBy = :deletedBy REMOVE #revisions, #reactions, #attachments"),
+		ConditionExpression:                 aws.String(condition),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		if len(failed.Item) == 0 {
+			return nil, ErrMessageNotFound
+		}
+		var old message.Message
+		if err := attributevalue.UnmarshalMap(failed.Item, &old); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+		}
+		if !moderator && old.UserID != userID {
+			return nil, ErrNotAuthor
+		}
+		// Already a tombstone: deleting again is a no-op.
+		return &old, nil
+	}
+	if err != nil {
+		r.logger.Error("failed to delete message",
+			slog.String("error", err.Error()),
+			slog.String("messageId", messageID))
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	var msg message.Message
+	if err := attributevalue.UnmarshalMap(out.Attributes, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	if err := r.deleteMentions(ctx, &msg); err != nil {
+		// The tombstone stands; GetMentions skips deleted messages.
+		r.logger.Warn("failed to delete mentions of deleted message",
+			slog.String("error", err.Error()),
+			slog.String("messageId", messageID))
+	}
+
+	r.logger.Debug("message deleted",
+		slog.String("messageId", messageID),
+		slog.String("roomId", roomID),
+		slog.String("deletedBy", userID))
+	return &msg, nil
+}
+
+// EnsureTable brings the DynamoDB schema up to date by applying any pending
+// schema migrations
 func (r *DynamoDBRepository) EnsureTable(ctx context.Context) error {
-	schema := GetTableSchema()
+	applied, err := NewMigrator(r.client, r.logger).Up(ctx)
+	if err != nil {
+		return err
+	}
+	if applied > 0 {
+		r.logger.Info("DynamoDB schema migrated", slog.Int("applied", applied))
+	}
+	return nil
+}
 
-	descCtx, descCancel := context.WithTimeout(ctx, 30*time.Second)
-	defer descCancel()
+// CreateRoom stores a new room, refusing to overwrite an existing ID
+func (r *DynamoDBRepository) CreateRoom(ctx context.Context, rm *room.Room) error {
+	item, err := attributevalue.MarshalMap(rm)
+	if err != nil {
+		return fmt.Errorf("failed to marshal room: %w", err)
+	}
 
-	_, err := r.client.DescribeTable(descCtx, &dynamodb.DescribeTableInput{
-		TableName: aws.String(schema.TableName),
+	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(RoomsTableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#roomId)"),
+		ExpressionAttributeNames: map[string]string{"#roomId": AttrRoomID},
 	})
-	if err == nil {
-		r.logger.Info("DynamoDB table already exists", slog.String("table", schema.TableName))
-		return nil
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return ErrRoomExists
+	}
+	if err != nil {
+		r.logger.Error("failed to create room",
+			slog.String("error", err.Error()),
+			slog.String("roomId", rm.ID))
+		return fmt.Errorf("failed to create room: %w", err)
+	}
+	return nil
+}
+
+// GetRoom retrieves a room by ID
+func (r *DynamoDBRepository) GetRoom(ctx context.Context, roomID string) (*room.Room, error) {
+	out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(RoomsTableName),
+		Key: map[string]types.AttributeValue{
+			AttrRoomID: &types.AttributeValueMemberS{Value: roomID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+	if len(out.Item) == 0 {
+		return nil, ErrRoomNotFound
+	}
+
+	var rm room.Room
+	if err := attributevalue.UnmarshalMap(out.Item, &rm); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal room: %w", err)
+	}
+	return &rm, nil
+}
+
+// ListRooms scans the room directory. The directory is small, so a paginated
+// Scan sorted in memory is sufficient.
+func (r *DynamoDBRepository) ListRooms(ctx context.Context) ([]*room.Room, error) {
+	var rooms []*room.Room
+	paginator := dynamodb.NewScanPaginator(r.client, &dynamodb.ScanInput{
+		TableName: aws.String(RoomsTableName),
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list rooms: %w", err)
+		}
+		for _, item := range out.Items {
+			var rm room.Room
+			if err := attributevalue.UnmarshalMap(item, &rm); err != nil {
+				r.logger.Error("failed to unmarshal room", slog.String("error", err.Error()))
+				continue
+			}
+			rooms = append(rooms, &rm)
+		}
+	}
+	sortRooms(rooms)
+	return rooms, nil
+}
+
+// PinMessage checks that the message exists and is not deleted, then adds
+// the pin to the room item.
+func (r *DynamoDBRepository) PinMessage(ctx context.Context, roomID, messageID, userID string, pinnedAt time.Time) (*room.Room, bool, error) {
+	msg, err := r.GetMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, false, err
+	}
+	if msg.Deleted {
+		return nil, false, ErrMessageDeleted
+	}
+	return r.updatePins(ctx, roomID, func(rm *room.Room) (bool, error) {
+		return rm.Pin(messageID, userID, pinnedAt)
+	})
+}
+
+// UnpinMessage removes a pin from the room item.
+func (r *DynamoDBRepository) UnpinMessage(ctx context.Context, roomID, messageID string) (*room.Room, bool, error) {
+	return r.updatePins(ctx, roomID, func(rm *room.Room) (bool, error) {
+		return rm.Unpin(messageID), nil
+	})
+}
+
+// updatePins applies fn to a room's pins as a read-modify-write guarded on
+// the Pins value that was read, retrying if a concurrent pin got there first.
+func (r *DynamoDBRepository) updatePins(ctx context.Context, roomID string, fn func(rm *room.Room) (bool, error)) (*room.Room, bool, error) {
+	key := map[string]types.AttributeValue{
+		AttrRoomID: &types.AttributeValueMemberS{Value: roomID},
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(RoomsTableName),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read room: %w", err)
+		}
+		if len(out.Item) == 0 {
+			return nil, false, ErrRoomNotFound
+		}
+
+		var rm room.Room
+		if err := attributevalue.UnmarshalMap(out.Item, &rm); err != nil {
+			return nil, false, fmt.Errorf("failed to unmarshal room: %w", err)
+		}
+
+		names := map[string]string{
+			"#roomId": AttrRoomID,
+			"#pins":   AttrPins,
+		}
+		values := map[string]types.AttributeValue{}
+		condition := "attribute_exists(#roomId) AND attribute_not_exists(#pins)"
+		if prev, ok := out.Item[AttrPins]; ok {
+			values[":prevPins"] = prev
+			condition = "attribute_exists(#roomId) AND #pins = :prevPins"
+		}
+
+		changed, err := fn(&rm)
+		if err != nil || !changed {
+			return &rm, false, err
+		}
+		update := "REMOVE #pins"
+		if rm.Pins != nil {
+			av, err := attributevalue.Marshal(rm.Pins)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to marshal pins: %w", err)
+			}
+			values[":pins"] = av
+			update = "SET #pins = :pins"
+		}
+		if len(values) == 0 {
+			values = nil
+		}
+
+		_, err = r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(RoomsTableName),
+			Key:                       key,
+			UpdateExpression:          aws.String(update),
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+		})
+		var conflict *types.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			r.logger.Debug("concurrent pin detected, retrying",
+				slog.String("roomId", roomID),
+				slog.Int("attempt", attempt+1))
+			continue
+		}
+		if err != nil {
+			r.logger.Error("failed to update pins",
+				slog.String("error", err.Error()),
+				slog.String("roomId", roomID))
+			return nil, false, fmt.Errorf("failed to update pins: %w", err)
+		}
+		return &rm, true, nil
+	}
+
+	return nil, false, fmt.Errorf("failed to update pins in room %s: too many concurrent pins", roomID)
+}
+
+// GetMentions queries a user's inbox newest first, attaching each mentioning
+// message. Entries whose message has expired or been deleted are skipped.
+func (r *DynamoDBRepository) GetMentions(ctx context.Context, userID string, limit int, unreadOnly bool) ([]*Mention, error) {
+	var mentions []*Mention
+	err := r.queryMentions(ctx, userID, unreadOnly, func(m *Mention) (bool, error) {
+		msg, err := r.GetMessage(ctx, m.RoomID, m.MessageID)
+		if errors.Is(err, ErrMessageNotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !msg.Deleted {
+			m.Message = msg
+			mentions = append(mentions, m)
+		}
+		return limit <= 0 || len(mentions) < limit, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mentions of %s: %w", userID, err)
+	}
+	return mentions, nil
+}
+
+// CountUnreadMentions counts a user's unread inbox entries with a COUNT
+// query.
+func (r *DynamoDBRepository) CountUnreadMentions(ctx context.Context, userID string) (int, error) {
+	input := mentionsQuery(userID, true)
+	input.Select = types.SelectCount
+
+	unread := 0
+	paginator := dynamodb.NewQueryPaginator(r.client, input)
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count mentions of %s: %w", userID, err)
+		}
+		unread += int(out.Count)
+	}
+	return unread, nil
+}
+
+// MarkMentionsRead sets ReadAt on each of a user's unread entries that
+// matches messageIDs, conditioned on it still being unread so concurrent
+// calls count each entry once.
+func (r *DynamoDBRepository) MarkMentionsRead(ctx context.Context, userID string, messageIDs []string, readAt time.Time) (int, error) {
+	readAtAV, err := attributevalue.Marshal(readAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal readAt: %w", err)
+	}
+
+	ids := idSet(messageIDs)
+	marked := 0
+	err = r.queryMentions(ctx, userID, true, func(m *Mention) (bool, error) {
+		if !markable(m, ids) {
+			return true, nil
+		}
+		_, err := r.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName:                 aws.String(MentionsTableName),
+			Key:                       mentionItemKey(m),
+			UpdateExpression:          aws.String("SET #readAt = :readAt"),
+			ConditionExpression:       aws.String("attribute_exists(#userId) AND attribute_not_exists(#readAt)"),
+			ExpressionAttributeNames:  map[string]string{"#userId": AttrUserID, "#readAt": AttrReadAt},
+			ExpressionAttributeValues: map[string]types.AttributeValue{":readAt": readAtAV},
+		})
+		var failed *types.ConditionalCheckFailedException
+		if errors.As(err, &failed) {
+			return true, nil // read or removed meanwhile
+		}
+		if err != nil {
+			return false, err
+		}
+		marked++
+		return true, nil
+	})
+	if err != nil {
+		return marked, fmt.Errorf("failed to mark mentions of %s read: %w", userID, err)
+	}
+	return marked, nil
+}
+
+// queryMentions calls fn with each of a user's inbox entries, newest first,
+// until it returns false or an error.
+func (r *DynamoDBRepository) queryMentions(ctx context.Context, userID string, unreadOnly bool, fn func(*Mention) (bool, error)) error {
+	paginator := dynamodb.NewQueryPaginator(r.client, mentionsQuery(userID, unreadOnly))
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			var m Mention
+			if err := attributevalue.UnmarshalMap(item, &m); err != nil {
+				return fmt.Errorf("failed to unmarshal mention: %w", err)
+			}
+			more, err := fn(&m)
+			if err != nil || !more {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mentionsQuery builds a newest-first query of a user's inbox, optionally
+// filtered to unread entries.
+func mentionsQuery(userID string, unreadOnly bool) *dynamodb.QueryInput {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(MentionsTableName),
+		KeyConditionExpression:   aws.String("#userId = :userId"),
+		ExpressionAttributeNames: map[string]string{"#userId": AttrUserID},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":userId": &types.AttributeValueMemberS{Value: userID},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if unreadOnly {
+		input.FilterExpression = aws.String("attribute_not_exists(#readAt)")
+		input.ExpressionAttributeNames["#readAt"] = AttrReadAt
+	}
+	return input
+}
+
+// SetReadMarker moves a user's read marker to a stored message with a
+// conditional put that only succeeds if the marker is new or behind it, so
+// concurrent reads from several connections can't move it backwards. The
+// position is kept in a fixed-width ReadPosition attribute for the
+// comparison.
+func (r *DynamoDBRepository) SetReadMarker(ctx context.Context, userID, roomID, messageID string, readAt time.Time) (*ReadMarker, bool, error) {
+	msg, err := r.GetMessage(ctx, roomID, messageID)
+	if err != nil {
+		return nil, false, err
 	}
 
-	r.logger.Info("creating DynamoDB table", slog.String("table", schema.TableName))
+	marker := newReadMarker(userID, msg, readAt)
+	item, err := attributevalue.MarshalMap(marker)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal read marker: %w", err)
+	}
+	item[AttrReadPosition] = &types.AttributeValueMemberS{Value: marker.position()}
 
-	createCtx, createCancel := context.WithTimeout(ctx, 30*time.Second)
-	defer createCancel()
+	_, err = r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                           aws.String(ReadMarkersTableName),
+		Item:                                item,
+		ConditionExpression:                 aws.String("attribute_not_exists(#userId) OR #position < :position"),
+		ExpressionAttributeNames:            map[string]string{"#userId": AttrUserID, "#position": AttrReadPosition},
+		ExpressionAttributeValues:           map[string]types.AttributeValue{":position": item[AttrReadPosition]},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		var current ReadMarker
+		if err := attributevalue.UnmarshalMap(failed.Item, &current); err != nil {
+			return nil, false, fmt.Errorf("failed to unmarshal read marker: %w", err)
+		}
+		return &current, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to set read marker: %w", err)
+	}
+	return marker, true, nil
+}
 
-	_, err = r.client.CreateTable(createCtx, &dynamodb.CreateTableInput{
-		TableName: aws.String(schema.TableName),
-		AttributeDefinitions: []types.AttributeDefinition{
-			{AttributeName: aws.String(schema.PartitionKey), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(schema.SortKey), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(schema.GSI1PartitionKey), AttributeType: types.ScalarAttributeTypeS},
-			{AttributeName: aws.String(schema.GSI1SortKey), AttributeType: types.ScalarAttributeTypeS},
+// GetReadMarkers queries a user's read markers, which the table sorts by
+// room ID.
+func (r *DynamoDBRepository) GetReadMarkers(ctx context.Context, userID string) ([]*ReadMarker, error) {
+	markers := []*ReadMarker{}
+	paginator := dynamodb.NewQueryPaginator(r.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(ReadMarkersTableName),
+		KeyConditionExpression:    aws.String("#userId = :userId"),
+		ExpressionAttributeNames:  map[string]string{"#userId": AttrUserID},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":userId": &types.AttributeValueMemberS{Value: userID}},
+	})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get read markers of %s: %w", userID, err)
+		}
+		for _, item := range out.Items {
+			var m ReadMarker
+			if err := attributevalue.UnmarshalMap(item, &m); err != nil {
+				r.logger.Error("failed to unmarshal read marker", slog.String("error", err.Error()))
+				continue
+			}
+			markers = append(markers, &m)
+		}
+	}
+	return markers, nil
+}
+
+// CountUnread queries the RoomID-Timestamp index from the user's read marker
+// on, projecting only what unreadBy needs.
+func (r *DynamoDBRepository) CountUnread(ctx context.Context, userID, roomID string) (int, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	out, err := r.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(ReadMarkersTableName),
+		Key: map[string]types.AttributeValue{
+			AttrUserID: &types.AttributeValueMemberS{Value: userID},
+			AttrRoomID: &types.AttributeValueMemberS{Value: roomID},
 		},
-		KeySchema: []types.KeySchemaElement{
-			{AttributeName: aws.String(schema.PartitionKey), KeyType: types.KeyTypeHash},
-			{AttributeName: aws.String(schema.SortKey), KeyType: types.KeyTypeRange},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read read marker: %w", err)
+	}
+	var marker *ReadMarker
+	if out.Item != nil {
+		marker = &ReadMarker{}
+		if err := attributevalue.UnmarshalMap(out.Item, marker); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal read marker: %w", err)
+		}
+	}
+
+	keyCond := "#roomId = :roomId"
+	values := map[string]types.AttributeValue{
+		":roomId": &types.AttributeValueMemberS{Value: roomID},
+	}
+	if marker != nil {
+		keyCond += " AND " + timeRangeCondition(PageQuery{Since: marker.Timestamp}, values)
+	}
+
+	unread := 0
+	paginator := dynamodb.NewQueryPaginator(r.client, &dynamodb.QueryInput{
+		TableName:              aws.String(TableName),
+		IndexName:              aws.String(IndexRoomTimestamp),
+		KeyConditionExpression: aws.String(keyCond),
+		ProjectionExpression:   aws.String("#messageId, #userId, #ts, #deleted"),
+		ExpressionAttributeNames: map[string]string{
+			"#roomId":    AttrRoomID,
+			"#messageId": AttrMessageID,
+			"#userId":    AttrUserID,
+			"#ts":        AttrTimestamp,
+			"#deleted":   AttrDeleted,
 		},
-		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
-			{
-				IndexName: aws.String(schema.GSI1Name),
-				KeySchema: []types.KeySchemaElement{
-					{AttributeName: aws.String(schema.GSI1PartitionKey), KeyType: types.KeyTypeHash},
-					{AttributeName: aws.String(schema.GSI1SortKey), KeyType: types.KeyTypeRange},
-				},
-				Projection:            &types.Projection{ProjectionType: types.ProjectionTypeAll},
-				ProvisionedThroughput: &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(5)},
-			},
-			{
-				IndexName: aws.String(schema.GSI2Name),
-				KeySchema: []types.KeySchemaElement{
-					{AttributeName: aws.String(schema.GSI2PartitionKey), KeyType: types.KeyTypeHash},
-					{AttributeName: aws.String(schema.GSI2SortKey), KeyType: types.KeyTypeRange},
-				},
-				Projection:            &types.Projection{ProjectionType: types.ProjectionTypeAll},
-				ProvisionedThroughput: &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(5)},
-			},
+		ExpressionAttributeValues: values,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count unread messages in %s: %w", roomID, err)
+		}
+		for _, item := range page.Items {
+			var msg message.Message
+			if err := attributevalue.UnmarshalMap(item, &msg); err != nil {
+				return 0, fmt.Errorf("failed to unmarshal message: %w", err)
+			}
+			if unreadBy(&msg, userID, marker) {
+				unread++
+			}
+		}
+	}
+	return unread, nil
+}
+
+// ClaimIdempotencyKey records messageID under userID's client message ID
+// until expiresAt with a conditional put, so exactly one server instance
+// claims each key. If an unexpired claim exists its message ID is returned
+// with claimed false. Expired claims are overwritten even before DynamoDB TTL
+// removes them.
+func (r *DynamoDBRepository) ClaimIdempotencyKey(ctx context.Context, userID, key, messageID string, expiresAt time.Time) (string, bool, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err := r.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(IdempotencyTableName),
+		Item: map[string]types.AttributeValue{
+			AttrUserID:          &types.AttributeValueMemberS{Value: userID},
+			AttrClientMessageID: &types.AttributeValueMemberS{Value: key},
+			AttrMessageID:       &types.AttributeValueMemberS{Value: messageID},
+			AttrExpiresAt:       &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
 		},
-		BillingMode:           types.BillingModeProvisioned,
-		ProvisionedThroughput: &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(5)},
+		ConditionExpression:                 aws.String("attribute_not_exists(#userId) OR #expiresAt <= :now"),
+		ExpressionAttributeNames:            map[string]string{"#userId": AttrUserID, "#expiresAt": AttrExpiresAt},
+		ExpressionAttributeValues:           map[string]types.AttributeValue{":now": &types.AttributeValueMemberN{Value: now}},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	})
+
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		if existing, ok := failed.Item[AttrMessageID].(*types.AttributeValueMemberS); ok {
+			return existing.Value, false, nil
+		}
+		return "", false, fmt.Errorf("idempotency claim for %q has no message ID", key)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
+		return "", false, fmt.Errorf("failed to claim idempotency key: %w", err)
 	}
+	return messageID, true, nil
+}
 
-	r.logger.Info("DynamoDB table created successfully", slog.String("table", schema.TableName))
+// ReleaseIdempotencyKey deletes userID's claim on key if it is still held for
+// messageID, so the key can be claimed again.
+func (r *DynamoDBRepository) ReleaseIdempotencyKey(ctx context.Context, userID, key, messageID string) error {
+	_, err := r.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(IdempotencyTableName),
+		Key: map[string]types.AttributeValue{
+			AttrUserID:          &types.AttributeValueMemberS{Value: userID},
+			AttrClientMessageID: &types.AttributeValueMemberS{Value: key},
+		},
+		ConditionExpression:       aws.String("#messageId = :messageId"),
+		ExpressionAttributeNames:  map[string]string{"#messageId": AttrMessageID},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":messageId": &types.AttributeValueMemberS{Value: messageID}},
+	})
+
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return nil // claimed for another message, or already gone
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
 	return nil
 }
 
@@ -2,6 +2,11 @@ package storage
 
 import (
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
 // Unit tests for storage package
@@ -27,6 +32,7 @@ func TestGetTableSchema(t *testing.T) {
 		{"GSI2 Name", IndexRoomTimestamp, schema.GSI2Name},
 		{"GSI2 PartitionKey", AttrRoomID, schema.GSI2PartitionKey},
 		{"GSI2 SortKey", AttrTimestamp, schema.GSI2SortKey},
+		{"TTLAttribute", AttrExpiresAt, schema.TTLAttribute},
 	}
 
 	for _, tt := range tests {
@@ -44,6 +50,9 @@ func TestSchemaConstants(t *testing.T) {
 		constant string
 	}{
 		{"TableName", TableName},
+		{"RoomsTableName", RoomsTableName},
+		{"MentionsTableName", MentionsTableName},
+		{"ReadMarkersTableName", ReadMarkersTableName},
 		{"AttrMessageID", AttrMessageID},
 		{"AttrRoomID", AttrRoomID},
 		{"AttrType", AttrType},
@@ -51,6 +60,15 @@ func TestSchemaConstants(t *testing.T) {
 		{"AttrUsername", AttrUsername},
 		{"AttrContent", AttrContent},
 		{"AttrTimestamp", AttrTimestamp},
+		{"AttrEditedAt", AttrEditedAt},
+		{"AttrRevisions", AttrRevisions},
+		{"AttrDeleted", AttrDeleted},
+		{"AttrDeletedAt", AttrDeletedAt},
+		{"AttrDeletedBy", AttrDeletedBy},
+		{"AttrExpiresAt", AttrExpiresAt},
+		{"AttrMentionKey", AttrMentionKey},
+		{"AttrPins", AttrPins},
+		{"AttrAttachments", AttrAttachments},
 		{"IndexUserTimestamp", IndexUserTimestamp},
 		{"IndexRoomTimestamp", IndexRoomTimestamp},
 		{"DefaultRoomID", DefaultRoomID},
@@ -70,3 +88,55 @@ func TestDefaultRoomID(t *testing.T) {
 		t.Errorf("DefaultRoomID should be 'global', got '%s'", DefaultRoomID)
 	}
 }
+
+func TestTimeRangeCondition(t *testing.T) {
+	whole := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	half := whole.Add(500 * time.Millisecond)
+
+	values := map[string]types.AttributeValue{}
+	if cond := timeRangeCondition(PageQuery{Since: whole, Until: half}, values); cond != "#ts BETWEEN :since AND :until" {
+		t.Errorf("unexpected condition %q", cond)
+	}
+	since := values[":since"].(*types.AttributeValueMemberS).Value
+	until := values[":until"].(*types.AttributeValueMemberS).Value
+	if len(since) != len(until) || since >= until {
+		t.Errorf("bounds %q and %q do not sort chronologically", since, until)
+	}
+	if cond := timeRangeCondition(PageQuery{}, values); cond != "" {
+		t.Errorf("expected no condition for an open range, got %q", cond)
+	}
+}
+
+func TestFilterUnexpired(t *testing.T) {
+	now := time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+	input := &dynamodb.QueryInput{
+		FilterExpression:          aws.String("#roomId = :roomId"),
+		ExpressionAttributeNames:  map[string]string{"#roomId": AttrRoomID},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":roomId": &types.AttributeValueMemberS{Value: "lobby"}},
+	}
+	filterUnexpired(input, now)
+
+	if got := aws.ToString(input.FilterExpression); got != "#roomId = :roomId AND (attribute_not_exists(#ttl) OR #ttl > :now)" {
+		t.Errorf("unexpected filter %q", got)
+	}
+	if input.ExpressionAttributeNames["#ttl"] != AttrExpiresAt {
+		t.Errorf("#ttl = %q, want %q", input.ExpressionAttributeNames["#ttl"], AttrExpiresAt)
+	}
+	if v, ok := input.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN); !ok || v.Value != "1781600400" {
+		t.Errorf(":now = %#v, want the unix seconds of now", input.ExpressionAttributeValues[":now"])
+	}
+}
+
+func TestFixedWidthTimestamp(t *testing.T) {
+	ts := time.Date(2026, 6, 16, 9, 0, 0, 500_000_000, time.UTC)
+	item := map[string]types.AttributeValue{AttrTimestamp: &types.AttributeValueMemberS{Value: ts.Format(time.RFC3339Nano)}}
+
+	set := fixedWidthTimestamp(item)
+	got, ok := set[AttrTimestamp].(*types.AttributeValueMemberS)
+	if !ok || got.Value != "2026-06-16T09:00:00.500000000Z" {
+		t.Fatalf("unexpected backfill %v", set)
+	}
+	if set := fixedWidthTimestamp(map[string]types.AttributeValue{AttrTimestamp: got}); set != nil {
+		t.Errorf("expected a fixed-width Timestamp to be left alone, got %v", set)
+	}
+}
@@ -2,14 +2,36 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+)
+
+var (
+	// ErrMessageNotFound is returned when a referenced message does not exist.
+	ErrMessageNotFound = errors.New("message not found")
+
+	// ErrNotAuthor is returned when a user modifies a message they did not send.
+	ErrNotAuthor = errors.New("user is not the message author")
+
+	// ErrMessageDeleted is returned when editing a message that was deleted.
+	ErrMessageDeleted = errors.New("message has been deleted")
+
+	// ErrRoomNotFound is returned when a referenced room does not exist.
+	ErrRoomNotFound = errors.New("room not found")
+
+	// ErrRoomExists is returned when creating a room whose ID is taken.
+	ErrRoomExists = errors.New("room already exists")
 )
 
 // MessageRepository defines the interface for message persistence operations.
 // Implementations should be safe for concurrent use.
 type MessageRepository interface {
-	// SaveMessage persists a message to storage.
+	// SaveMessage persists a message to storage unless one with the same
+	// room and message ID is already stored, which is left unchanged.
+	// Saving a new reply increments its parent's ReplyCount.
 	// Returns an error if the operation fails.
 	SaveMessage(ctx context.Context, msg *message.Message) error
 
@@ -27,6 +49,45 @@ type MessageRepository interface {
 	// The limit parameter controls the maximum number of messages to return.
 	GetMessagesByUser(ctx context.Context, userID string, limit int) ([]*message.Message, error)
 
+	// GetRoomMessagesPage retrieves one page of a room's history, walking
+	// backwards from the newest message unless q.After is set.
+	// Returns ErrInvalidCursor if the cursor is malformed, foreign or outside
+	// the time range.
+	GetRoomMessagesPage(ctx context.Context, roomID string, q PageQuery) (*Page, error)
+
+	// GetUserMessagesPage retrieves one page of a user's history, walking
+	// backwards from the newest message unless q.After is set.
+	// Returns ErrInvalidCursor if the cursor is malformed, foreign or outside
+	// the time range.
+	GetUserMessagesPage(ctx context.Context, userID string, q PageQuery) (*Page, error)
+
+	// GetMessage retrieves one message by room and message ID.
+	// Returns ErrMessageNotFound if absent.
+	GetMessage(ctx context.Context, roomID, messageID string) (*message.Message, error)
+
+	// GetThreadPage retrieves one page of the replies to parentMessageID in
+	// a room, walking backwards from the newest reply unless q.After is set.
+	// Returns ErrInvalidCursor if the cursor is malformed, foreign or outside
+	// the time range.
+	GetThreadPage(ctx context.Context, roomID, parentMessageID string, q PageQuery) (*Page, error)
+
+	// EditMessage replaces the content of a stored message, recording the old
+	// content as a revision. Only the original author (userID) may edit.
+	// Returns the updated message, ErrMessageNotFound, ErrNotAuthor or
+	// ErrMessageDeleted.
+	EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error)
+
+	// DeleteMessage replaces a stored message with a tombstone, keeping its
+	// MessageID and Timestamp. Only the author may delete unless moderator is
+	// set. Deleting a tombstone is a no-op that returns it unchanged.
+	// Returns the tombstone, ErrMessageNotFound or ErrNotAuthor.
+	DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error)
+
+	// ReactToMessage adds userID's emoji reaction to a stored message, or
+	// removes it when remove is set. Repeating a change is a no-op.
+	// Returns the updated message, ErrMessageNotFound or ErrMessageDeleted.
+	ReactToMessage(ctx context.Context, roomID, messageID, userID, emoji string, remove bool) (*message.Message, error)
+
 	// HealthCheck verifies the storage backend is accessible and operational.
 	// Returns an error if the storage backend is unavailable.
 	HealthCheck(ctx context.Context) error
@@ -35,3 +96,67 @@ type MessageRepository interface {
 	// After calling Close, the repository should not be used.
 	Close() error
 }
+
+// RoomRepository persists the room directory. Every MessageRepository
+// backend also implements it.
+type RoomRepository interface {
+	// CreateRoom stores a new room. Returns ErrRoomExists if the ID is taken.
+	CreateRoom(ctx context.Context, r *room.Room) error
+
+	// GetRoom retrieves a room by ID. Returns ErrRoomNotFound if absent.
+	GetRoom(ctx context.Context, roomID string) (*room.Room, error)
+
+	// ListRooms returns every room ordered by creation time (oldest first).
+	ListRooms(ctx context.Context) ([]*room.Room, error)
+
+	// PinMessage pins a message stored in the room on behalf of userID and
+	// returns the updated room, reporting whether the pin was added (false
+	// if it was already pinned). Returns ErrRoomNotFound, ErrMessageNotFound,
+	// ErrMessageDeleted or room.ErrTooManyPins.
+	PinMessage(ctx context.Context, roomID, messageID, userID string, pinnedAt time.Time) (*room.Room, bool, error)
+
+	// UnpinMessage removes a message's pin from the room and returns the
+	// updated room, reporting whether it was pinned. Returns ErrRoomNotFound
+	// if the room is absent.
+	UnpinMessage(ctx context.Context, roomID, messageID string) (*room.Room, bool, error)
+}
+
+// MentionRepository serves users' mention inboxes. Saving a message adds it
+// to the inbox of every user in its Mentions, once however often it is saved;
+// deleting or expiring the message takes it out again. Every
+// MessageRepository backend also implements it.
+type MentionRepository interface {
+	// GetMentions returns up to limit of a user's mentions, newest first,
+	// each with the mentioning message as currently stored. With unreadOnly
+	// set, mentions already read are left out.
+	GetMentions(ctx context.Context, userID string, limit int, unreadOnly bool) ([]*Mention, error)
+
+	// CountUnreadMentions returns how many of a user's mentions are unread.
+	CountUnreadMentions(ctx context.Context, userID string) (int, error)
+
+	// MarkMentionsRead marks a user's unread mentions by the given messages
+	// read at readAt, or all of them if messageIDs is empty, and returns how
+	// many it marked.
+	MarkMentionsRead(ctx context.Context, userID string, messageIDs []string, readAt time.Time) (int, error)
+}
+
+// ReadMarkerRepository records how far each user has read in each room, and
+// counts what they have not. Every MessageRepository backend also implements
+// it.
+type ReadMarkerRepository interface {
+	// SetReadMarker moves a user's read marker in a room to messageID, which
+	// must be stored there (ErrMessageNotFound otherwise), and reports
+	// whether it moved. Markers only move forward: marking an earlier
+	// message read leaves the marker where it is. Returns the marker as it
+	// stands afterwards.
+	SetReadMarker(ctx context.Context, userID, roomID, messageID string, readAt time.Time) (*ReadMarker, bool, error)
+
+	// GetReadMarkers returns a user's read markers, one per room, ordered by
+	// room ID.
+	GetReadMarkers(ctx context.Context, userID string) ([]*ReadMarker, error)
+
+	// CountUnread counts the messages in a room after the user's read
+	// marker, or all of them if they have none, leaving out the user's own
+	// messages and deleted ones.
+	CountUnread(ctx context.Context, userID, roomID string) (int, error)
+}
@@ -0,0 +1,747 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+	"github.com/google/uuid"
+)
+
+// ErrRepositoryClosed is returned by in-process repositories after Close.
+var ErrRepositoryClosed = errors.New("repository is closed")
+
+// messageKey mirrors the table's primary key (RoomID + MessageID).
+type messageKey struct {
+	roomID    string
+	messageID string
+}
+
+// MemoryRepository implements MessageRepository entirely in process memory.
+// It keeps per-room, per-user and per-thread indexes ordered by Timestamp,
+// mirroring the RoomID-Timestamp, UserID-Timestamp and
+// ParentMessageID-Timestamp GSIs, so it behaves like the DynamoDB backend for
+// local development and tests. Contents are lost on restart.
+type MemoryRepository struct {
+	mu       sync.RWMutex
+	messages map[messageKey]*message.Message
+	byRoom   map[string][]*message.Message     // each slice sorted by Timestamp, MessageID
+	byUser   map[string][]*message.Message     // each slice sorted by Timestamp, MessageID
+	byThread map[messageKey][]*message.Message // replies keyed by their parent, sorted likewise
+	mentions map[string][]*Mention             // each user's inbox, sorted by mentionKey
+	markers  map[string]map[string]*ReadMarker // read markers by user, then room
+	rooms    map[string]*room.Room
+	closed   bool
+	logger   *slog.Logger
+
+	retention RetentionPolicy
+	done      chan struct{} // closed by Close to stop the retention sweeper
+}
+
+// NewMemoryRepository creates an empty in-memory message repository.
+func NewMemoryRepository(logger *slog.Logger) *MemoryRepository {
+	return &MemoryRepository{
+		messages: make(map[messageKey]*message.Message),
+		byRoom:   make(map[string][]*message.Message),
+		byUser:   make(map[string][]*message.Message),
+		byThread: make(map[messageKey][]*message.Message),
+		mentions: make(map[string][]*Mention),
+		markers:  make(map[string]map[string]*ReadMarker),
+		rooms:    make(map[string]*room.Room),
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+}
+
+// SetRetention applies a retention policy to messages saved from now on and,
+// when the policy can expire anything, starts a sweeper that purges expired
+// messages every interval until Close. Call at most once, before use.
+func (r *MemoryRepository) SetRetention(policy RetentionPolicy, interval time.Duration) {
+	r.mu.Lock()
+	r.retention = policy
+	r.mu.Unlock()
+
+	if policy.Enabled() {
+		go runSweeper(r.done, interval, r.sweep, r.logger)
+	}
+}
+
+// SaveMessage stores a copy of the message unless one with the same room and
+// message ID is already stored.
+func (r *MemoryRepository) SaveMessage(ctx context.Context, msg *message.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRepositoryClosed
+	}
+	r.put(msg)
+	return nil
+}
+
+// BatchSaveMessages stores all messages under a single lock acquisition.
+func (r *MemoryRepository) BatchSaveMessages(ctx context.Context, msgs []*message.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRepositoryClosed
+	}
+	for _, msg := range msgs {
+		r.put(msg)
+	}
+	return nil
+}
+
+// put inserts a message and keeps the indexes ordered. A message already
+// stored under the same key is left as it is, so replaying one that was since
+// edited or deleted changes nothing. A new reply increments its parent's
+// ReplyCount. Must be called with r.mu held for writing.
+func (r *MemoryRepository) put(msg *message.Message) {
+	// Apply the same defaults as the DynamoDB backend.
+	if msg.MessageID == "" {
+		msg.MessageID = uuid.New().String()
+	}
+	if msg.RoomID == "" {
+		msg.RoomID = DefaultRoomID
+	}
+
+	key := messageKey{roomID: msg.RoomID, messageID: msg.MessageID}
+	if _, ok := r.messages[key]; ok {
+		return
+	}
+
+	r.retention.Stamp(msg)
+
+	stored := msg.Clone()
+	r.messages[key] = stored
+	r.byRoom[stored.RoomID] = insertMessage(r.byRoom[stored.RoomID], stored)
+	r.byUser[stored.UserID] = insertMessage(r.byUser[stored.UserID], stored)
+	if stored.ParentMessageID != "" {
+		thread := threadKey(stored)
+		r.byThread[thread] = insertMessage(r.byThread[thread], stored)
+		if parent, ok := r.messages[thread]; ok {
+			parent.ReplyCount++
+		}
+	}
+	r.addMentions(stored)
+}
+
+// unindex removes a stored message from every index it is in.
+// Must be called with r.mu held for writing.
+func (r *MemoryRepository) unindex(msg *message.Message) {
+	r.byRoom[msg.RoomID] = removeMessage(r.byRoom[msg.RoomID], msg)
+	r.byUser[msg.UserID] = removeMessage(r.byUser[msg.UserID], msg)
+	if msg.ParentMessageID != "" {
+		thread := threadKey(msg)
+		r.byThread[thread] = removeMessage(r.byThread[thread], msg)
+	}
+}
+
+// threadKey is the primary key of a reply's parent, which its thread is
+// indexed under.
+func threadKey(reply *message.Message) messageKey {
+	return messageKey{roomID: reply.RoomID, messageID: reply.ParentMessageID}
+}
+
+// GetRecentMessages returns up to limit of the newest messages in a room, in
+// chronological order (oldest first).
+func (r *MemoryRepository) GetRecentMessages(ctx context.Context, roomID string, limit int) ([]*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	index := r.byRoom[roomID]
+	start := 0
+	if limit > 0 && len(index) > limit {
+		start = len(index) - limit
+	}
+	return copyMessages(index[start:]), nil
+}
+
+// GetMessagesByUser returns up to limit of a user's messages in chronological
+// order (oldest first), matching the ascending UserID-Timestamp query.
+func (r *MemoryRepository) GetMessagesByUser(ctx context.Context, userID string, limit int) ([]*message.Message, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	index := r.byUser[userID]
+	end := len(index)
+	if limit > 0 && end > limit {
+		end = limit
+	}
+	return copyMessages(index[:end]), nil
+}
+
+// GetRoomMessagesPage retrieves one page of a room's history.
+func (r *MemoryRepository) GetRoomMessagesPage(ctx context.Context, roomID string, q PageQuery) (*Page, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+	return pageIndex(r.byRoom[roomID], AttrRoomID, roomID, q)
+}
+
+// GetUserMessagesPage retrieves one page of a user's history.
+func (r *MemoryRepository) GetUserMessagesPage(ctx context.Context, userID string, q PageQuery) (*Page, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+	return pageIndex(r.byUser[userID], AttrUserID, userID, q)
+}
+
+// GetMessage returns a copy of one message.
+func (r *MemoryRepository) GetMessage(ctx context.Context, roomID, messageID string) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	stored, ok := r.messages[messageKey{roomID: roomID, messageID: messageID}]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return stored.Clone(), nil
+}
+
+// GetThreadPage retrieves one page of a thread's replies.
+func (r *MemoryRepository) GetThreadPage(ctx context.Context, roomID, parentMessageID string, q PageQuery) (*Page, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+	thread := messageKey{roomID: roomID, messageID: parentMessageID}
+	return pageIndex(r.byThread[thread], AttrParentMessageID, parentMessageID, q)
+}
+
+// pageIndex slices one page out of an ordered index. Must be called with the
+// repository lock held.
+func pageIndex(index []*message.Message, partitionAttr, partition string, q PageQuery) (*Page, error) {
+	// Narrow to the Since/Until time range first; the cursor then narrows
+	// further within it.
+	lo, hi := 0, len(index)
+	if !q.Since.IsZero() {
+		lo = sort.Search(len(index), func(i int) bool {
+			return !index[i].Timestamp.Before(q.Since)
+		})
+	}
+	if !q.Until.IsZero() {
+		hi = sort.Search(len(index), func(i int) bool {
+			return index[i].Timestamp.After(q.Until)
+		})
+	}
+	if q.cursor() != "" {
+		key, err := decodeCursor(q, partitionAttr, partition)
+		if err != nil {
+			return nil, err
+		}
+		ts, messageID, err := key.position()
+		if err != nil {
+			return nil, err
+		}
+		// First index entry at or after the cursor position.
+		at := sort.Search(len(index), func(i int) bool {
+			return !messageBefore(index[i], ts, messageID)
+		})
+		if q.forward() {
+			if at < len(index) && index[at].Timestamp.Equal(ts) && index[at].MessageID == messageID {
+				at++
+			}
+			lo = max(lo, at)
+		} else {
+			hi = min(hi, at)
+		}
+	}
+	if lo > hi {
+		lo = hi
+	}
+
+	var (
+		start, end = lo, hi
+		boundary   *message.Message
+	)
+	if q.forward() {
+		if q.Limit > 0 && end-start > q.Limit {
+			end = start + q.Limit
+			boundary = index[end-1]
+		}
+	} else {
+		if q.Limit > 0 && end-start > q.Limit {
+			start = end - q.Limit
+			boundary = index[start]
+		}
+	}
+
+	page := &Page{Messages: copyMessages(index[start:end])}
+	if boundary != nil {
+		page.NextCursor = encodeCursor(messageCursor(boundary, partitionAttr))
+	}
+	return page, nil
+}
+
+// EditMessage replaces a message's content in place. The index entries are
+// untouched because the Timestamp does not change.
+func (r *MemoryRepository) EditMessage(ctx context.Context, roomID, messageID, userID, content string, editedAt time.Time) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	stored, ok := r.messages[messageKey{roomID: roomID, messageID: messageID}]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if stored.UserID != userID {
+		return nil, ErrNotAuthor
+	}
+	if stored.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	stored.ApplyEdit(content, editedAt)
+	return stored.Clone(), nil
+}
+
+// DeleteMessage replaces a message with a tombstone in place.
+func (r *MemoryRepository) DeleteMessage(ctx context.Context, roomID, messageID, userID string, moderator bool, deletedAt time.Time) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	stored, ok := r.messages[messageKey{roomID: roomID, messageID: messageID}]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if !moderator && stored.UserID != userID {
+		return nil, ErrNotAuthor
+	}
+	if !stored.Deleted {
+		stored.Tombstone(userID, deletedAt)
+		r.removeMentions(stored)
+	}
+	return stored.Clone(), nil
+}
+
+// ReactToMessage applies a reaction change in place.
+func (r *MemoryRepository) ReactToMessage(ctx context.Context, roomID, messageID, userID, emoji string, remove bool) (*message.Message, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	stored, ok := r.messages[messageKey{roomID: roomID, messageID: messageID}]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if stored.Deleted {
+		return nil, ErrMessageDeleted
+	}
+	stored.ApplyReaction(userID, emoji, remove)
+	return stored.Clone(), nil
+}
+
+// sweep removes every message whose retention period has elapsed at now,
+// uncounting swept replies from parents still stored, and returns how many
+// were removed.
+func (r *MemoryRepository) sweep(now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrRepositoryClosed
+	}
+
+	removed := 0
+	for key, msg := range r.messages {
+		if !expired(msg, now) {
+			continue
+		}
+		delete(r.messages, key)
+		r.unindex(msg)
+		r.removeMentions(msg)
+		if msg.ParentMessageID != "" {
+			if parent, ok := r.messages[threadKey(msg)]; ok && parent.ReplyCount > 0 {
+				parent.ReplyCount--
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// addMentions adds msg to the inbox of each user it mentions, leaving
+// existing entries (and their read state) alone.
+// Must be called with r.mu held for writing.
+func (r *MemoryRepository) addMentions(msg *message.Message) {
+	for _, m := range mentionsOf(msg) {
+		inbox := r.mentions[m.UserID]
+		i, found := findMention(inbox, mentionKey(m))
+		if found {
+			continue
+		}
+		inbox = append(inbox, nil)
+		copy(inbox[i+1:], inbox[i:])
+		inbox[i] = m
+		r.mentions[m.UserID] = inbox
+	}
+}
+
+// removeMentions takes msg out of the inbox of each user it mentions.
+// Must be called with r.mu held for writing.
+func (r *MemoryRepository) removeMentions(msg *message.Message) {
+	for _, m := range mentionsOf(msg) {
+		inbox := r.mentions[m.UserID]
+		if i, found := findMention(inbox, mentionKey(m)); found {
+			r.mentions[m.UserID] = append(inbox[:i], inbox[i+1:]...)
+		}
+	}
+}
+
+// findMention returns the position of key in an inbox sorted by mentionKey,
+// or where it would be inserted.
+func findMention(inbox []*Mention, key string) (int, bool) {
+	i := sort.Search(len(inbox), func(i int) bool { return mentionKey(inbox[i]) >= key })
+	return i, i < len(inbox) && mentionKey(inbox[i]) == key
+}
+
+// GetMentions returns copies of a user's newest mentions.
+func (r *MemoryRepository) GetMentions(ctx context.Context, userID string, limit int, unreadOnly bool) ([]*Mention, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	var mentions []*Mention
+	inbox := r.mentions[userID]
+	for i := len(inbox) - 1; i >= 0 && (limit <= 0 || len(mentions) < limit); i-- {
+		stored := inbox[i]
+		if unreadOnly && stored.ReadAt != nil {
+			continue
+		}
+		msg, ok := r.messages[messageKey{roomID: stored.RoomID, messageID: stored.MessageID}]
+		if !ok {
+			continue
+		}
+		m := *stored
+		m.Message = msg.Clone()
+		mentions = append(mentions, &m)
+	}
+	return mentions, nil
+}
+
+// CountUnreadMentions counts a user's unread mentions.
+func (r *MemoryRepository) CountUnreadMentions(ctx context.Context, userID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return 0, ErrRepositoryClosed
+	}
+
+	unread := 0
+	for _, m := range r.mentions[userID] {
+		if m.ReadAt == nil {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+// MarkMentionsRead marks a user's unread mentions read in place.
+func (r *MemoryRepository) MarkMentionsRead(ctx context.Context, userID string, messageIDs []string, readAt time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, ErrRepositoryClosed
+	}
+
+	ids := idSet(messageIDs)
+	marked := 0
+	for _, m := range r.mentions[userID] {
+		if markable(m, ids) {
+			m.ReadAt = &readAt
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+// SetReadMarker moves a user's read marker forward to a stored message.
+func (r *MemoryRepository) SetReadMarker(ctx context.Context, userID, roomID, messageID string, readAt time.Time) (*ReadMarker, bool, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false, ErrRepositoryClosed
+	}
+
+	msg, ok := r.messages[messageKey{roomID: roomID, messageID: messageID}]
+	if !ok {
+		return nil, false, ErrMessageNotFound
+	}
+	current := r.markers[userID][roomID]
+	next := newReadMarker(userID, msg, readAt)
+	if !advances(current, next) {
+		m := *current
+		return &m, false, nil
+	}
+	if r.markers[userID] == nil {
+		r.markers[userID] = make(map[string]*ReadMarker)
+	}
+	r.markers[userID][roomID] = next
+	m := *next
+	return &m, true, nil
+}
+
+// GetReadMarkers returns copies of a user's read markers.
+func (r *MemoryRepository) GetReadMarkers(ctx context.Context, userID string) ([]*ReadMarker, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+
+	markers := make([]*ReadMarker, 0, len(r.markers[userID]))
+	for _, stored := range r.markers[userID] {
+		m := *stored
+		markers = append(markers, &m)
+	}
+	sort.Slice(markers, func(i, j int) bool { return markers[i].RoomID < markers[j].RoomID })
+	return markers, nil
+}
+
+// CountUnread walks a room's index back from the newest message to the
+// user's read marker.
+func (r *MemoryRepository) CountUnread(ctx context.Context, userID, roomID string) (int, error) {
+	if roomID == "" {
+		roomID = DefaultRoomID
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return 0, ErrRepositoryClosed
+	}
+
+	marker := r.markers[userID][roomID]
+	msgs := r.byRoom[roomID]
+	unread := 0
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if marker != nil && readPosition(msgs[i].Timestamp, msgs[i].MessageID) <= marker.position() {
+			break
+		}
+		if unreadBy(msgs[i], userID, marker) {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+// CreateRoom stores a copy of a new room.
+func (r *MemoryRepository) CreateRoom(ctx context.Context, rm *room.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrRepositoryClosed
+	}
+	if _, ok := r.rooms[rm.ID]; ok {
+		return ErrRoomExists
+	}
+	r.rooms[rm.ID] = rm.Clone()
+	return nil
+}
+
+// GetRoom returns a copy of the room with the given ID.
+func (r *MemoryRepository) GetRoom(ctx context.Context, roomID string) (*room.Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+	stored, ok := r.rooms[roomID]
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+	return stored.Clone(), nil
+}
+
+// ListRooms returns copies of every room, oldest first.
+func (r *MemoryRepository) ListRooms(ctx context.Context) ([]*room.Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return nil, ErrRepositoryClosed
+	}
+	rooms := make([]*room.Room, 0, len(r.rooms))
+	for _, stored := range r.rooms {
+		rooms = append(rooms, stored.Clone())
+	}
+	sortRooms(rooms)
+	return rooms, nil
+}
+
+// HealthCheck reports an error only once the repository has been closed.
+func (r *MemoryRepository) HealthCheck(ctx context.Context) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return ErrRepositoryClosed
+	}
+	return nil
+}
+
+// Close discards all stored messages.
+func (r *MemoryRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		close(r.done)
+	}
+	r.closed = true
+	r.messages = nil
+	r.byRoom = nil
+	r.byUser = nil
+	r.byThread = nil
+	r.mentions = nil
+	r.markers = nil
+	r.rooms = nil
+	r.logger.Info("memory repository closed")
+	return nil
+}
+
+// messageBefore orders messages by Timestamp, breaking ties by MessageID so
+// the order (and therefore cursor positions) is deterministic.
+func messageBefore(m *message.Message, ts time.Time, messageID string) bool {
+	if !m.Timestamp.Equal(ts) {
+		return m.Timestamp.Before(ts)
+	}
+	return m.MessageID < messageID
+}
+
+// insertMessage inserts msg into a (Timestamp, MessageID)-ordered slice.
+func insertMessage(index []*message.Message, msg *message.Message) []*message.Message {
+	i := sort.Search(len(index), func(i int) bool {
+		return !messageBefore(index[i], msg.Timestamp, msg.MessageID)
+	})
+	index = append(index, nil)
+	copy(index[i+1:], index[i:])
+	index[i] = msg
+	return index
+}
+
+// removeMessage removes the exact pointer msg from an index slice.
+func removeMessage(index []*message.Message, msg *message.Message) []*message.Message {
+	for i, m := range index {
+		if m == msg {
+			return append(index[:i], index[i+1:]...)
+		}
+	}
+	return index
+}
+
+// copyMessages returns copies so callers cannot mutate stored messages.
+func copyMessages(src []*message.Message) []*message.Message {
+	out := make([]*message.Message, len(src))
+	for i, m := range src {
+		out[i] = m.Clone()
+	}
+	return out
+}
+
+// PinMessage pins a stored message to a room in place.
+func (r *MemoryRepository) PinMessage(ctx context.Context, roomID, messageID, userID string, pinnedAt time.Time) (*room.Room, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false, ErrRepositoryClosed
+	}
+
+	stored, ok := r.rooms[roomID]
+	if !ok {
+		return nil, false, ErrRoomNotFound
+	}
+	msg, ok := r.messages[messageKey{roomID: roomID, messageID: messageID}]
+	if !ok {
+		return nil, false, ErrMessageNotFound
+	}
+	if msg.Deleted {
+		return nil, false, ErrMessageDeleted
+	}
+	added, err := stored.Pin(messageID, userID, pinnedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	return stored.Clone(), added, nil
+}
+
+// UnpinMessage removes a pin from a room in place.
+func (r *MemoryRepository) UnpinMessage(ctx context.Context, roomID, messageID string) (*room.Room, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, false, ErrRepositoryClosed
+	}
+
+	stored, ok := r.rooms[roomID]
+	if !ok {
+		return nil, false, ErrRoomNotFound
+	}
+	removed := stored.Unpin(messageID)
+	return stored.Clone(), removed, nil
+}
+
+// sortRooms orders rooms by creation time, breaking ties by ID.
+func sortRooms(rooms []*room.Room) {
+	sort.Slice(rooms, func(i, j int) bool {
+		if !rooms[i].CreatedAt.Equal(rooms[j].CreatedAt) {
+			return rooms[i].CreatedAt.Before(rooms[j].CreatedAt)
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestMemoryRepo() *MemoryRepository {
+	return NewMemoryRepository(slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestMemoryRepository_Contract(t *testing.T) {
+	testRepositoryContract(t, func(t *testing.T) MessageRepository {
+		return newTestMemoryRepo()
+	})
+}
+
+func TestMemoryRepository_Close(t *testing.T) {
+	repo := newTestMemoryRepo()
+	ctx := context.Background()
+
+	if err := repo.HealthCheck(ctx); err != nil {
+		t.Fatalf("HealthCheck before close: %v", err)
+	}
+	repo.Close()
+
+	if err := repo.HealthCheck(ctx); !errors.Is(err, ErrRepositoryClosed) {
+		t.Errorf("expected ErrRepositoryClosed from HealthCheck, got %v", err)
+	}
+	if err := repo.SaveMessage(ctx, testMessage("m1", "lobby", "u1", time.Now())); !errors.Is(err, ErrRepositoryClosed) {
+		t.Errorf("expected ErrRepositoryClosed from SaveMessage, got %v", err)
+	}
+	if _, err := repo.GetRecentMessages(ctx, "lobby", 10); !errors.Is(err, ErrRepositoryClosed) {
+		t.Errorf("expected ErrRepositoryClosed from GetRecentMessages, got %v", err)
+	}
+}
+
+func TestMemoryRepository_Concurrent(t *testing.T) {
+	repo := newTestMemoryRepo()
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("m%02d", i)
+			repo.SaveMessage(ctx, testMessage(id, "lobby", "u1", base.Add(time.Duration(i)*time.Millisecond)))
+			repo.GetRecentMessages(ctx, "lobby", 10)
+		}(i)
+	}
+	wg.Wait()
+
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 100)
+	if len(msgs) != 50 {
+		t.Fatalf("expected 50 messages, got %d", len(msgs))
+	}
+	for i := 1; i < len(msgs); i++ {
+		if msgs[i].Timestamp.Before(msgs[i-1].Timestamp) {
+			t.Fatalf("messages out of order at %d", i)
+		}
+	}
+}
+
+func TestMemoryRepository_RetentionSweep(t *testing.T) {
+	repo := newTestMemoryRepo()
+	defer repo.Close()
+	testRetentionSweep(t, repo)
+}
+
+func TestMemoryRepository_RetentionSweepReplies(t *testing.T) {
+	repo := newTestMemoryRepo()
+	defer repo.Close()
+	testRetentionSweepReplies(t, repo)
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// Mention is an entry in a user's mention inbox: a message that mentioned
+// them, and whether they have read it.
+type Mention struct {
+	UserID    string     `json:"userId" dynamodbav:"UserID"`
+	RoomID    string     `json:"roomId" dynamodbav:"RoomID"`
+	MessageID string     `json:"messageId" dynamodbav:"MessageID"`
+	Timestamp time.Time  `json:"timestamp" dynamodbav:"Timestamp"`
+	ReadAt    *time.Time `json:"readAt,omitempty" dynamodbav:"ReadAt,omitempty"`
+
+	// ExpiresAt is copied from the message so DynamoDB TTL removes the
+	// entry along with it.
+	ExpiresAt *time.Time `json:"-" dynamodbav:"ExpiresAt,omitempty,unixtime"`
+
+	// Message is the mentioning message as stored when the inbox was read.
+	Message *message.Message `json:"message,omitempty" dynamodbav:"-"`
+}
+
+// mentionsOf returns the inbox entries a stored message adds, one per user
+// it mentions.
+func mentionsOf(msg *message.Message) []*Mention {
+	mentions := make([]*Mention, 0, len(msg.Mentions))
+	for _, userID := range msg.Mentions {
+		mentions = append(mentions, &Mention{
+			UserID:    userID,
+			RoomID:    msg.RoomID,
+			MessageID: msg.MessageID,
+			Timestamp: msg.Timestamp,
+			ExpiresAt: msg.ExpiresAt,
+		})
+	}
+	return mentions
+}
+
+// sortableTimeLayout formats Timestamps at a fixed width, so keys built from
+// them sort chronologically as strings. RFC3339Nano does not: it drops
+// trailing zeros, and "09:00:00.5Z" sorts before "09:00:00Z".
+const sortableTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sortableTime formats t in UTC with sortableTimeLayout.
+func sortableTime(t time.Time) string {
+	return t.UTC().Format(sortableTimeLayout)
+}
+
+// mentionKey orders a user's inbox entries by Timestamp and identifies each
+// by the mentioning message's primary key.
+func mentionKey(m *Mention) string {
+	return sortableTime(m.Timestamp) + "#" + m.RoomID + "#" + m.MessageID
+}
+
+// markable reports whether MarkMentionsRead should mark m: it is unread and
+// names one of messageIDs, or messageIDs is empty.
+func markable(m *Mention, messageIDs map[string]bool) bool {
+	return m.ReadAt == nil && (len(messageIDs) == 0 || messageIDs[m.MessageID])
+}
+
+// idSet builds a lookup set from a list of IDs.
+func idSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set
+}
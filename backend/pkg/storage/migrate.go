@@ -0,0 +1,415 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// MigrationsTableName is the DynamoDB table recording applied schema
+// migrations, keyed by AttrVersion.
+const MigrationsTableName = "chat-schema-migrations"
+
+// Migration bookkeeping attributes
+const (
+	AttrVersion     = "Version"
+	AttrDescription = "Description"
+	AttrAppliedAt   = "AppliedAt"
+)
+
+// MigrationAPI is the subset of the DynamoDB client used by migrations.
+type MigrationAPI interface {
+	DescribeTable(ctx context.Context, in *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	CreateTable(ctx context.Context, in *dynamodb.CreateTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	UpdateTable(ctx context.Context, in *dynamodb.UpdateTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, in *dynamodb.DescribeTimeToLiveInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTimeToLive(ctx context.Context, in *dynamodb.UpdateTimeToLiveInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+	Scan(ctx context.Context, in *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	PutItem(ctx context.Context, in *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// Migration is one versioned, forward-only schema change. Up must be safe to
+// re-run: the helpers on Migrator skip work that is already done, so a
+// migration interrupted before it was recorded can simply be applied again.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, m *Migrator) error
+}
+
+// MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time // nil while pending
+}
+
+// Migrator applies schema migrations to DynamoDB and records each applied
+// version in MigrationsTableName.
+type Migrator struct {
+	client     MigrationAPI
+	logger     *slog.Logger
+	migrations []Migration
+
+	// Time allowed for a table or index to become ACTIVE, and how often to
+	// poll while waiting.
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+}
+
+// NewMigrator creates a migrator for the schema migrations defined in
+// migrations.go.
+func NewMigrator(client MigrationAPI, logger *slog.Logger) *Migrator {
+	return newMigrator(client, logger, schemaMigrations)
+}
+
+func newMigrator(client MigrationAPI, logger *slog.Logger, migrations []Migration) *Migrator {
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return &Migrator{
+		client:       client,
+		logger:       logger,
+		migrations:   sorted,
+		waitTimeout:  5 * time.Minute,
+		pollInterval: 2 * time.Second,
+	}
+}
+
+// Status lists every known migration in version order with its applied time.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, mig := range m.migrations {
+		status[i] = MigrationStatus{Version: mig.Version, Description: mig.Description}
+		if at, ok := applied[mig.Version]; ok {
+			status[i].AppliedAt = &at
+		}
+	}
+	return status, nil
+}
+
+// Up applies every pending migration in version order, recording each one as
+// it succeeds, and returns how many were applied. It stops at the first
+// failure; later runs resume from there.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(MigrationsTableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String(AttrVersion), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(AttrVersion), KeyType: types.KeyTypeHash},
+		},
+		BillingMode:           types.BillingModeProvisioned,
+		ProvisionedThroughput: &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(1), WriteCapacityUnits: aws.Int64(1)},
+	}); err != nil {
+		return 0, err
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		m.logger.Info("applying schema migration",
+			slog.Int("version", mig.Version),
+			slog.String("description", mig.Description))
+		if err := mig.Up(ctx, m); err != nil {
+			return count, fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Description, err)
+		}
+		if err := m.record(ctx, mig); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// applied returns the applied migration versions and when they were applied.
+// A missing migrations table means nothing has been applied.
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	applied := make(map[int]time.Time)
+	input := &dynamodb.ScanInput{TableName: aws.String(MigrationsTableName)}
+	for {
+		out, err := m.client.Scan(ctx, input)
+		var missing *types.ResourceNotFoundException
+		if errors.As(err, &missing) {
+			return applied, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		for _, item := range out.Items {
+			version, ok := item[AttrVersion].(*types.AttributeValueMemberN)
+			if !ok {
+				continue
+			}
+			v, err := strconv.Atoi(version.Value)
+			if err != nil {
+				continue
+			}
+			var at time.Time
+			if s, ok := item[AttrAppliedAt].(*types.AttributeValueMemberS); ok {
+				at, _ = time.Parse(time.RFC3339, s.Value)
+			}
+			applied[v] = at
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return applied, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// record marks a migration as applied. A concurrent migrator recording the
+// same version first is not an error.
+func (m *Migrator) record(ctx context.Context, mig Migration) error {
+	_, err := m.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(MigrationsTableName),
+		Item: map[string]types.AttributeValue{
+			AttrVersion:     &types.AttributeValueMemberN{Value: strconv.Itoa(mig.Version)},
+			AttrDescription: &types.AttributeValueMemberS{Value: mig.Description},
+			AttrAppliedAt:   &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(#version)"),
+		ExpressionAttributeNames: map[string]string{"#version": AttrVersion},
+	})
+	var exists *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to record migration %d: %w", mig.Version, err)
+	}
+	return nil
+}
+
+// CreateTable creates a table unless it already exists, then waits for it to
+// become ACTIVE.
+func (m *Migrator) CreateTable(ctx context.Context, input *dynamodb.CreateTableInput) error {
+	table := aws.ToString(input.TableName)
+	if _, err := m.describe(ctx, table); err == nil {
+		return nil
+	} else if !isNotFound(err) {
+		return err
+	}
+
+	if _, err := m.client.CreateTable(ctx, input); err != nil {
+		var inUse *types.ResourceInUseException
+		if !errors.As(err, &inUse) {
+			return fmt.Errorf("failed to create table %s: %w", table, err)
+		}
+	}
+	m.logger.Info("DynamoDB table created", slog.String("table", table))
+	return m.waitFor(ctx, table, func(t *types.TableDescription) bool {
+		return t.TableStatus == types.TableStatusActive
+	})
+}
+
+// AddGSI adds a global secondary index to an existing table unless an index
+// with the same name exists, then waits for the index to finish backfilling.
+// attrs must define the index key attributes.
+func (m *Migrator) AddGSI(ctx context.Context, table string, attrs []types.AttributeDefinition, index types.GlobalSecondaryIndex) error {
+	name := aws.ToString(index.IndexName)
+	desc, err := m.describe(ctx, table)
+	if err != nil {
+		return err
+	}
+	if findGSI(desc, name) == nil {
+		create := &types.CreateGlobalSecondaryIndexAction{
+			IndexName:             index.IndexName,
+			KeySchema:             index.KeySchema,
+			Projection:            index.Projection,
+			ProvisionedThroughput: index.ProvisionedThroughput,
+		}
+		_, err := m.client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:                   aws.String(table),
+			AttributeDefinitions:        attrs,
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{Create: create}},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add index %s to %s: %w", name, table, err)
+		}
+		m.logger.Info("DynamoDB index added",
+			slog.String("table", table),
+			slog.String("index", name))
+	}
+	return m.waitFor(ctx, table, func(t *types.TableDescription) bool {
+		gsi := findGSI(t, name)
+		return gsi != nil && gsi.IndexStatus == types.IndexStatusActive
+	})
+}
+
+// EnableTTL turns on DynamoDB TTL for attr unless it is already enabled.
+func (m *Migrator) EnableTTL(ctx context.Context, table, attr string) error {
+	desc, err := m.client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to describe TTL for %s: %w", table, err)
+	}
+	if ttl := desc.TimeToLiveDescription; ttl != nil {
+		switch ttl.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if aws.ToString(ttl.AttributeName) != attr {
+				return fmt.Errorf("TTL on %s is enabled on %q, expected %q",
+					table, aws.ToString(ttl.AttributeName), attr)
+			}
+			return nil
+		}
+	}
+
+	_, err = m.client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(attr),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable TTL for %s: %w", table, err)
+	}
+	m.logger.Info("DynamoDB TTL enabled",
+		slog.String("table", table),
+		slog.String("attribute", attr))
+	return nil
+}
+
+// Backfill scans every item in table and sets the attributes fill returns for
+// it; a nil or empty result leaves the item untouched. Each update is
+// conditional on the item still existing so concurrent deletes are not
+// resurrected. Returns the number of items updated.
+func (m *Migrator) Backfill(ctx context.Context, table string, fill func(item map[string]types.AttributeValue) map[string]types.AttributeValue) (int, error) {
+	desc, err := m.describe(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	input := &dynamodb.ScanInput{TableName: aws.String(table)}
+	for {
+		out, err := m.client.Scan(ctx, input)
+		if err != nil {
+			return updated, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		for _, item := range out.Items {
+			set := fill(item)
+			if len(set) == 0 {
+				continue
+			}
+			if err := m.updateItem(ctx, desc, item, set); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+
+	m.logger.Info("DynamoDB backfill complete",
+		slog.String("table", table),
+		slog.Int("updated", updated))
+	return updated, nil
+}
+
+// updateItem sets attributes on one item, addressed by the table's key schema.
+func (m *Migrator) updateItem(ctx context.Context, desc *types.TableDescription, item, set map[string]types.AttributeValue) error {
+	key := make(map[string]types.AttributeValue, len(desc.KeySchema))
+	for _, k := range desc.KeySchema {
+		name := aws.ToString(k.AttributeName)
+		key[name] = item[name]
+	}
+
+	names := map[string]string{"#pk": aws.ToString(desc.KeySchema[0].AttributeName)}
+	values := make(map[string]types.AttributeValue, len(set))
+	expr := "SET "
+	attrs := make([]string, 0, len(set))
+	for attr := range set {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs) // deterministic expressions
+	for i, attr := range attrs {
+		if i > 0 {
+			expr += ", "
+		}
+		n, v := "#a"+strconv.Itoa(i), ":v"+strconv.Itoa(i)
+		names[n] = attr
+		values[v] = set[attr]
+		expr += n + " = " + v
+	}
+
+	_, err := m.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 desc.TableName,
+		Key:                       key,
+		UpdateExpression:          aws.String(expr),
+		ConditionExpression:       aws.String("attribute_exists(#pk)"),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	var gone *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &gone) {
+		return fmt.Errorf("failed to backfill item in %s: %w", aws.ToString(desc.TableName), err)
+	}
+	return nil
+}
+
+// describe returns a table's description.
+func (m *Migrator) describe(ctx context.Context, table string) (*types.TableDescription, error) {
+	out, err := m.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(table),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table %s: %w", table, err)
+	}
+	return out.Table, nil
+}
+
+// waitFor polls a table's description until ready reports true.
+func (m *Migrator) waitFor(ctx context.Context, table string, ready func(*types.TableDescription) bool) error {
+	ctx, cancel := context.WithTimeout(ctx, m.waitTimeout)
+	defer cancel()
+
+	for {
+		desc, err := m.describe(ctx, table)
+		if err == nil && ready(desc) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for table %s: %w", table, ctx.Err())
+		case <-time.After(m.pollInterval):
+		}
+	}
+}
+
+// findGSI returns the named global secondary index of a table, or nil.
+func findGSI(desc *types.TableDescription, name string) *types.GlobalSecondaryIndexDescription {
+	for i := range desc.GlobalSecondaryIndexes {
+		if aws.ToString(desc.GlobalSecondaryIndexes[i].IndexName) == name {
+			return &desc.GlobalSecondaryIndexes[i]
+		}
+	}
+	return nil
+}
+
+// isNotFound reports whether err means a table does not exist.
+func isNotFound(err error) bool {
+	var missing *types.ResourceNotFoundException
+	return errors.As(err, &missing)
+}
@@ -0,0 +1,463 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeTable is one table held by fakeDynamoDB.
+type fakeTable struct {
+	desc  types.TableDescription
+	ttl   *types.TimeToLiveDescription
+	items []map[string]types.AttributeValue
+}
+
+// fakeDynamoDB is an in-memory MigrationAPI. Tables and indexes become ACTIVE
+// immediately and Scan returns everything in one page.
+type fakeDynamoDB struct {
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+	calls  map[string]int
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{tables: make(map[string]*fakeTable), calls: make(map[string]int)}
+}
+
+func notFound() error {
+	return &types.ResourceNotFoundException{Message: aws.String("table not found")}
+}
+
+func (f *fakeDynamoDB) DescribeTable(_ context.Context, in *dynamodb.DescribeTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	desc := t.desc
+	return &dynamodb.DescribeTableOutput{Table: &desc}, nil
+}
+
+func (f *fakeDynamoDB) CreateTable(_ context.Context, in *dynamodb.CreateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["CreateTable"]++
+	name := aws.ToString(in.TableName)
+	if _, ok := f.tables[name]; ok {
+		return nil, &types.ResourceInUseException{Message: aws.String("table exists")}
+	}
+	desc := types.TableDescription{
+		TableName:   in.TableName,
+		TableStatus: types.TableStatusActive,
+		KeySchema:   in.KeySchema,
+	}
+	for _, gsi := range in.GlobalSecondaryIndexes {
+		desc.GlobalSecondaryIndexes = append(desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+			IndexName:   gsi.IndexName,
+			KeySchema:   gsi.KeySchema,
+			IndexStatus: types.IndexStatusActive,
+		})
+	}
+	f.tables[name] = &fakeTable{desc: desc}
+	return &dynamodb.CreateTableOutput{TableDescription: &desc}, nil
+}
+
+func (f *fakeDynamoDB) UpdateTable(_ context.Context, in *dynamodb.UpdateTableInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["UpdateTable"]++
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	for _, u := range in.GlobalSecondaryIndexUpdates {
+		if u.Create != nil {
+			t.desc.GlobalSecondaryIndexes = append(t.desc.GlobalSecondaryIndexes, types.GlobalSecondaryIndexDescription{
+				IndexName:   u.Create.IndexName,
+				KeySchema:   u.Create.KeySchema,
+				IndexStatus: types.IndexStatusActive,
+			})
+		}
+	}
+	return &dynamodb.UpdateTableOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DescribeTimeToLive(_ context.Context, in *dynamodb.DescribeTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	ttl := t.ttl
+	if ttl == nil {
+		ttl = &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: ttl}, nil
+}
+
+func (f *fakeDynamoDB) UpdateTimeToLive(_ context.Context, in *dynamodb.UpdateTimeToLiveInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["UpdateTimeToLive"]++
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	t.ttl = &types.TimeToLiveDescription{
+		AttributeName:    in.TimeToLiveSpecification.AttributeName,
+		TimeToLiveStatus: types.TimeToLiveStatusEnabled,
+	}
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func (f *fakeDynamoDB) Scan(_ context.Context, in *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	items := make([]map[string]types.AttributeValue, len(t.items))
+	for i, item := range t.items {
+		items[i] = copyItem(item)
+	}
+	return &dynamodb.ScanOutput{Items: items}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(_ context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	if i := t.find(in.Item); i >= 0 {
+		if in.ConditionExpression != nil {
+			return nil, &types.ConditionalCheckFailedException{Message: aws.String("exists")}
+		}
+		t.items[i] = copyItem(in.Item)
+		return &dynamodb.PutItemOutput{}, nil
+	}
+	t.items = append(t.items, copyItem(in.Item))
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// UpdateItem understands the "SET #a = :v, ..." expressions Backfill builds.
+func (f *fakeDynamoDB) UpdateItem(_ context.Context, in *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls["UpdateItem"]++
+	t, ok := f.tables[aws.ToString(in.TableName)]
+	if !ok {
+		return nil, notFound()
+	}
+	i := t.find(in.Key)
+	if i < 0 {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("missing")}
+	}
+	for _, assign := range strings.Split(strings.TrimPrefix(aws.ToString(in.UpdateExpression), "SET "), ", ") {
+		name, value, _ := strings.Cut(assign, " = ")
+		t.items[i][in.ExpressionAttributeNames[name]] = in.ExpressionAttributeValues[value]
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// find returns the index of the item with key's key attributes, or -1.
+func (t *fakeTable) find(key map[string]types.AttributeValue) int {
+	for i, item := range t.items {
+		match := true
+		for _, k := range t.desc.KeySchema {
+			name := aws.ToString(k.AttributeName)
+			if stringValue(item[name]) != stringValue(key[name]) {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func stringValue(v types.AttributeValue) string {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	}
+	return ""
+}
+
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	c := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+	return c
+}
+
+func newTestMigrator(client MigrationAPI, migrations []Migration) *Migrator {
+	m := newMigrator(client, slog.New(slog.NewTextHandler(io.Discard, nil)), migrations)
+	m.pollInterval = 0
+	return m
+}
+
+func TestMigratorUpCreatesSchema(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	m := newTestMigrator(db, schemaMigrations)
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if applied != len(schemaMigrations) {
+		t.Errorf("applied = %d, want %d", applied, len(schemaMigrations))
+	}
+
+	messages, ok := db.tables[TableName]
+	if !ok {
+		t.Fatalf("messages table not created")
+	}
+	for _, index := range []string{IndexUserTimestamp, IndexRoomTimestamp, IndexParentTimestamp} {
+		if findGSI(&messages.desc, index) == nil {
+			t.Errorf("index %s not created", index)
+		}
+	}
+	if messages.ttl == nil || aws.ToString(messages.ttl.AttributeName) != AttrExpiresAt {
+		t.Errorf("TTL not enabled on %s", AttrExpiresAt)
+	}
+	if _, ok := db.tables[RoomsTableName]; !ok {
+		t.Errorf("rooms table not created")
+	}
+	idem, ok := db.tables[IdempotencyTableName]
+	if !ok {
+		t.Errorf("idempotency table not created")
+	} else if idem.ttl == nil || aws.ToString(idem.ttl.AttributeName) != AttrExpiresAt {
+		t.Errorf("TTL not enabled on the idempotency table")
+	}
+	mentions, ok := db.tables[MentionsTableName]
+	if !ok {
+		t.Errorf("mentions table not created")
+	} else if mentions.ttl == nil || aws.ToString(mentions.ttl.AttributeName) != AttrExpiresAt {
+		t.Errorf("TTL not enabled on the mentions table")
+	}
+	if _, ok := db.tables[ReadMarkersTableName]; !ok {
+		t.Errorf("read markers table not created")
+	}
+
+	// A second run has nothing to do
+	calls := db.calls["CreateTable"]
+	applied, err = m.Up(ctx)
+	if err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	if applied != 0 {
+		t.Errorf("second Up applied = %d, want 0", applied)
+	}
+	if db.calls["CreateTable"] != calls {
+		t.Errorf("second Up created tables")
+	}
+}
+
+func TestMigratorAdoptsExistingTables(t *testing.T) {
+	// Tables created before migrations existed are adopted, not recreated
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	if err := newTestMigrator(db, nil).CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(TableName),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(AttrRoomID), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(AttrMessageID), KeyType: types.KeyTypeRange},
+		},
+	}); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	db.tables[TableName].items = append(db.tables[TableName].items, map[string]types.AttributeValue{
+		AttrRoomID:    &types.AttributeValueMemberS{Value: "global"},
+		AttrMessageID: &types.AttributeValueMemberS{Value: "m1"},
+	})
+
+	if _, err := newTestMigrator(db, schemaMigrations).Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	if got := len(db.tables[TableName].items); got != 1 {
+		t.Errorf("messages table has %d items, want 1", got)
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	noop := func(context.Context, *Migrator) error { return nil }
+	migrations := []Migration{
+		{Version: 2, Description: "second", Up: noop},
+		{Version: 1, Description: "first", Up: noop},
+	}
+
+	m := newTestMigrator(db, migrations[1:])
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status before Up: %v", err)
+	}
+	if len(status) != 1 || status[0].AppliedAt != nil {
+		t.Fatalf("status before Up = %+v, want one pending migration", status)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	// A later build knows about version 2 as well
+	status, err = newTestMigrator(db, migrations).Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(status) != 2 {
+		t.Fatalf("got %d statuses, want 2", len(status))
+	}
+	if status[0].Version != 1 || status[0].AppliedAt == nil {
+		t.Errorf("version 1 = %+v, want applied", status[0])
+	}
+	if status[1].Version != 2 || status[1].AppliedAt != nil {
+		t.Errorf("version 2 = %+v, want pending", status[1])
+	}
+}
+
+func TestMigratorStopsAtFailure(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	boom := errors.New("boom")
+	var ran []int
+	step := func(v int, err error) func(context.Context, *Migrator) error {
+		return func(context.Context, *Migrator) error {
+			ran = append(ran, v)
+			return err
+		}
+	}
+	m := newTestMigrator(db, []Migration{
+		{Version: 1, Description: "ok", Up: step(1, nil)},
+		{Version: 2, Description: "fails", Up: step(2, boom)},
+		{Version: 3, Description: "never", Up: step(3, nil)},
+	})
+
+	applied, err := m.Up(ctx)
+	if !errors.Is(err, boom) {
+		t.Fatalf("Up error = %v, want %v", err, boom)
+	}
+	if applied != 1 {
+		t.Errorf("applied = %d, want 1", applied)
+	}
+
+	// The failed migration is retried on the next run; version 1 is not
+	ran = nil
+	m.migrations[1].Up = step(2, nil)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("second Up: %v", err)
+	}
+	if len(ran) != 2 || ran[0] != 2 || ran[1] != 3 {
+		t.Errorf("second Up ran %v, want [2 3]", ran)
+	}
+}
+
+func TestMigratorAddGSI(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	m := newTestMigrator(db, schemaMigrations)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	attrs := []types.AttributeDefinition{
+		{AttributeName: aws.String(AttrUsername), AttributeType: types.ScalarAttributeTypeS},
+		{AttributeName: aws.String(AttrTimestamp), AttributeType: types.ScalarAttributeTypeS},
+	}
+	index := timestampIndex("Username-Timestamp-index", AttrUsername, AttrTimestamp)
+	calls := db.calls["UpdateTable"]
+	for i := 0; i < 2; i++ {
+		if err := m.AddGSI(ctx, TableName, attrs, index); err != nil {
+			t.Fatalf("AddGSI #%d: %v", i+1, err)
+		}
+	}
+	if got := db.calls["UpdateTable"] - calls; got != 1 {
+		t.Errorf("UpdateTable called %d times, want 1", got)
+	}
+	if findGSI(&db.tables[TableName].desc, "Username-Timestamp-index") == nil {
+		t.Errorf("index not added")
+	}
+}
+
+func TestMigratorBackfill(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	m := newTestMigrator(db, schemaMigrations)
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	table := db.tables[TableName]
+	for _, id := range []string{"m1", "m2", "m3"} {
+		item := map[string]types.AttributeValue{
+			AttrRoomID:    &types.AttributeValueMemberS{Value: "global"},
+			AttrMessageID: &types.AttributeValueMemberS{Value: id},
+		}
+		if id == "m2" {
+			item[AttrType] = &types.AttributeValueMemberS{Value: "system"}
+		}
+		table.items = append(table.items, item)
+	}
+
+	// Default Type on items written before it existed
+	updated, err := m.Backfill(ctx, TableName, func(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+		if _, ok := item[AttrType]; ok {
+			return nil
+		}
+		return map[string]types.AttributeValue{AttrType: &types.AttributeValueMemberS{Value: "chat"}}
+	})
+	if err != nil {
+		t.Fatalf("Backfill: %v", err)
+	}
+	if updated != 2 {
+		t.Errorf("updated = %d, want 2", updated)
+	}
+
+	want := map[string]string{"m1": "chat", "m2": "system", "m3": "chat"}
+	for _, item := range table.items {
+		id := stringValue(item[AttrMessageID])
+		if got := stringValue(item[AttrType]); got != want[id] {
+			t.Errorf("%s Type = %q, want %q", id, got, want[id])
+		}
+	}
+}
+
+func TestMigratorEnableTTLConflict(t *testing.T) {
+	ctx := context.Background()
+	db := newFakeDynamoDB()
+	m := newTestMigrator(db, schemaMigrations[:1])
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	db.tables[TableName].ttl = &types.TimeToLiveDescription{
+		AttributeName:    aws.String("OtherAttr"),
+		TimeToLiveStatus: types.TimeToLiveStatusEnabled,
+	}
+
+	if err := m.EnableTTL(ctx, TableName, AttrExpiresAt); err == nil {
+		t.Error("EnableTTL succeeded with TTL on another attribute")
+	}
+	if db.calls["UpdateTimeToLive"] != 0 {
+		t.Error("EnableTTL changed existing TTL attribute")
+	}
+}
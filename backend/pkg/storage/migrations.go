@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// schemaMigrations is the DynamoDB schema history. Append new migrations with
+// the next version number; never edit or renumber one that has shipped.
+// Adding an index to an existing table is m.AddGSI, adding an attribute that
+// existing items need is m.Backfill.
+var schemaMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "create messages table with user and room timestamp indexes",
+		Up: func(ctx context.Context, m *Migrator) error {
+			schema := GetTableSchema()
+			return m.CreateTable(ctx, &dynamodb.CreateTableInput{
+				TableName: aws.String(schema.TableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String(schema.PartitionKey), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String(schema.SortKey), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String(schema.GSI1PartitionKey), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String(schema.GSI1SortKey), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(schema.PartitionKey), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String(schema.SortKey), KeyType: types.KeyTypeRange},
+				},
+				GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+					timestampIndex(schema.GSI1Name, schema.GSI1PartitionKey, schema.GSI1SortKey),
+					timestampIndex(schema.GSI2Name, schema.GSI2PartitionKey, schema.GSI2SortKey),
+				},
+				BillingMode:           types.BillingModeProvisioned,
+				ProvisionedThroughput: defaultThroughput(),
+			})
+		},
+	},
+	{
+		Version:     2,
+		Description: "enable TTL on messages ExpiresAt",
+		Up: func(ctx context.Context, m *Migrator) error {
+			schema := GetTableSchema()
+			return m.EnableTTL(ctx, schema.TableName, schema.TTLAttribute)
+		},
+	},
+	{
+		Version:     3,
+		Description: "create rooms table",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.CreateTable(ctx, &dynamodb.CreateTableInput{
+				TableName: aws.String(RoomsTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String(AttrRoomID), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(AttrRoomID), KeyType: types.KeyTypeHash},
+				},
+				BillingMode:           types.BillingModeProvisioned,
+				ProvisionedThroughput: defaultThroughput(),
+			})
+		},
+	},
+	{
+		Version:     4,
+		Description: "create idempotency table with TTL on ExpiresAt",
+		Up: func(ctx context.Context, m *Migrator) error {
+			err := m.CreateTable(ctx, &dynamodb.CreateTableInput{
+				TableName: aws.String(IdempotencyTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String(AttrUserID), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String(AttrClientMessageID), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(AttrUserID), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String(AttrClientMessageID), KeyType: types.KeyTypeRange},
+				},
+				BillingMode:           types.BillingModeProvisioned,
+				ProvisionedThroughput: defaultThroughput(),
+			})
+			if err != nil {
+				return err
+			}
+			return m.EnableTTL(ctx, IdempotencyTableName, AttrExpiresAt)
+		},
+	},
+	{
+		Version:     5,
+		Description: "add parent message timestamp index for threads",
+		Up: func(ctx context.Context, m *Migrator) error {
+			attrs := []types.AttributeDefinition{
+				{AttributeName: aws.String(AttrParentMessageID), AttributeType: types.ScalarAttributeTypeS},
+				{AttributeName: aws.String(AttrTimestamp), AttributeType: types.ScalarAttributeTypeS},
+			}
+			return m.AddGSI(ctx, TableName, attrs, timestampIndex(IndexParentTimestamp, AttrParentMessageID, AttrTimestamp))
+		},
+	},
+	{
+		Version:     6,
+		Description: "create mentions table with TTL on ExpiresAt",
+		Up: func(ctx context.Context, m *Migrator) error {
+			err := m.CreateTable(ctx, &dynamodb.CreateTableInput{
+				TableName: aws.String(MentionsTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String(AttrUserID), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String(AttrMentionKey), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(AttrUserID), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String(AttrMentionKey), KeyType: types.KeyTypeRange},
+				},
+				BillingMode:           types.BillingModeProvisioned,
+				ProvisionedThroughput: defaultThroughput(),
+			})
+			if err != nil {
+				return err
+			}
+			return m.EnableTTL(ctx, MentionsTableName, AttrExpiresAt)
+		},
+	},
+	{
+		Version:     7,
+		Description: "create read markers table",
+		Up: func(ctx context.Context, m *Migrator) error {
+			return m.CreateTable(ctx, &dynamodb.CreateTableInput{
+				TableName: aws.String(ReadMarkersTableName),
+				AttributeDefinitions: []types.AttributeDefinition{
+					{AttributeName: aws.String(AttrUserID), AttributeType: types.ScalarAttributeTypeS},
+					{AttributeName: aws.String(AttrRoomID), AttributeType: types.ScalarAttributeTypeS},
+				},
+				KeySchema: []types.KeySchemaElement{
+					{AttributeName: aws.String(AttrUserID), KeyType: types.KeyTypeHash},
+					{AttributeName: aws.String(AttrRoomID), KeyType: types.KeyTypeRange},
+				},
+				BillingMode:           types.BillingModeProvisioned,
+				ProvisionedThroughput: defaultThroughput(),
+			})
+		},
+	},
+	{
+		Version:     8,
+		Description: "store message timestamps at a fixed width so they sort chronologically",
+		Up: func(ctx context.Context, m *Migrator) error {
+			_, err := m.Backfill(ctx, TableName, fixedWidthTimestamp)
+			return err
+		},
+	},
+}
+
+// fixedWidthTimestamp rewrites an item's RFC3339Nano Timestamp in the form
+// marshalMessage stores, leaving items already in that form alone.
+func fixedWidthTimestamp(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	s, ok := item[AttrTimestamp].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, s.Value)
+	if err != nil || sortableTime(ts) == s.Value {
+		return nil
+	}
+	return map[string]types.AttributeValue{AttrTimestamp: &types.AttributeValueMemberS{Value: sortableTime(ts)}}
+}
+
+// timestampIndex returns a global secondary index on partition and a
+// Timestamp sort key projecting every attribute.
+func timestampIndex(name, partition, sort string) types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(name),
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(partition), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(sort), KeyType: types.KeyTypeRange},
+		},
+		Projection:            &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		ProvisionedThroughput: defaultThroughput(),
+	}
+}
+
+// defaultThroughput is the provisioned capacity for tables and indexes.
+func defaultThroughput() *types.ProvisionedThroughput {
+	return &types.ProvisionedThroughput{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(5)}
+}
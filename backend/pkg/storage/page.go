@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded,
+// does not belong to the queried room or user, or falls outside the queried
+// time range.
+var ErrInvalidCursor = errors.New("invalid pagination cursor")
+
+// PageQuery selects one page of an index ordered by Timestamp. With neither
+// cursor set the newest page is returned. Before and After are mutually
+// exclusive; each takes a NextCursor from a previous page.
+type PageQuery struct {
+	// Limit is the maximum number of messages to return.
+	Limit int
+
+	// Before returns messages older than the cursor position.
+	Before string
+
+	// After returns messages newer than the cursor position.
+	After string
+
+	// Since and Until restrict the page to messages whose Timestamp falls in
+	// the inclusive range [Since, Until]. A zero value leaves that end open.
+	Since time.Time
+	Until time.Time
+}
+
+// forward reports whether the query walks the index oldest-to-newest.
+func (q PageQuery) forward() bool {
+	return q.After != ""
+}
+
+// cursor returns whichever cursor is set.
+func (q PageQuery) cursor() string {
+	if q.After != "" {
+		return q.After
+	}
+	return q.Before
+}
+
+// Page is one page of messages in chronological order (oldest first).
+type Page struct {
+	Messages []*message.Message
+
+	// NextCursor continues in the same direction as the query that produced
+	// the page (pass it back as Before or After respectively). Empty when
+	// there are no further messages.
+	NextCursor string
+}
+
+// cursorKey is the decoded form of a cursor: the string key attributes of
+// the boundary message, in the same shape as a DynamoDB LastEvaluatedKey so
+// the DynamoDB backend can pass it through as ExclusiveStartKey unchanged.
+type cursorKey map[string]string
+
+// encodeCursor serializes a cursor key into an opaque URL-safe token.
+func encodeCursor(key cursorKey) string {
+	if len(key) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses q's cursor and checks that it was issued for the given
+// index partition (e.g. AttrRoomID == roomID) and lies within q's time range.
+// A cursor is rejected unless it has exactly the attributes messageCursor
+// gives it, so a forged one never reaches DynamoDB as an ExclusiveStartKey it
+// would refuse.
+func decodeCursor(q PageQuery, partitionAttr, partition string) (cursorKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(q.cursor())
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var key cursorKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	want := []string{AttrRoomID, AttrMessageID, AttrTimestamp}
+	if partitionAttr != AttrRoomID {
+		want = append(want, partitionAttr)
+	}
+	if len(key) != len(want) || key[partitionAttr] != partition {
+		return nil, ErrInvalidCursor
+	}
+	for _, attr := range want {
+		if key[attr] == "" {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	ts, _, err := key.position()
+	if err != nil {
+		return nil, err
+	}
+	if (!q.Since.IsZero() && ts.Before(q.Since)) || (!q.Until.IsZero() && ts.After(q.Until)) {
+		return nil, ErrInvalidCursor
+	}
+	return key, nil
+}
+
+// messageCursor builds the cursor key for msg on the index partitioned by
+// partitionAttr, matching the LastEvaluatedKey DynamoDB would return.
+func messageCursor(msg *message.Message, partitionAttr string) cursorKey {
+	key := cursorKey{
+		AttrRoomID:    msg.RoomID,
+		AttrMessageID: msg.MessageID,
+		AttrTimestamp: sortableTime(msg.Timestamp),
+	}
+	switch partitionAttr {
+	case AttrUserID:
+		key[AttrUserID] = msg.UserID
+	case AttrParentMessageID:
+		key[AttrParentMessageID] = msg.ParentMessageID
+	}
+	return key
+}
+
+// position returns the (Timestamp, MessageID) ordering position of a cursor
+// key, for the in-process backends.
+func (k cursorKey) position() (time.Time, string, error) {
+	ts, err := time.Parse(time.RFC3339Nano, k[AttrTimestamp])
+	if err != nil {
+		return time.Time{}, "", ErrInvalidCursor
+	}
+	return ts, k[AttrMessageID], nil
+}
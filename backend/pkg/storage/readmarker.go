@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// ReadMarker records the last message a user has read in a room. Messages
+// after it, other than the user's own, are unread.
+type ReadMarker struct {
+	UserID    string `json:"userId" dynamodbav:"UserID"`
+	RoomID    string `json:"roomId" dynamodbav:"RoomID"`
+	MessageID string `json:"messageId" dynamodbav:"MessageID"`
+
+	// Timestamp is the read message's, which places the marker in the room.
+	Timestamp time.Time `json:"timestamp" dynamodbav:"Timestamp"`
+
+	// ReadAt is when the marker was last moved.
+	ReadAt time.Time `json:"readAt" dynamodbav:"ReadAt"`
+}
+
+// readPosition orders messages, and the read markers on them, by Timestamp
+// and then MessageID, like the room index.
+func readPosition(ts time.Time, messageID string) string {
+	return sortableTime(ts) + "#" + messageID
+}
+
+// position returns the marker's readPosition.
+func (m *ReadMarker) position() string {
+	return readPosition(m.Timestamp, m.MessageID)
+}
+
+// newReadMarker returns userID's marker on msg, read at readAt.
+func newReadMarker(userID string, msg *message.Message, readAt time.Time) *ReadMarker {
+	return &ReadMarker{
+		UserID:    userID,
+		RoomID:    msg.RoomID,
+		MessageID: msg.MessageID,
+		Timestamp: msg.Timestamp,
+		ReadAt:    readAt,
+	}
+}
+
+// advances reports whether next is further into the room than current, which
+// is nil for a user who has read nothing there yet.
+func advances(current, next *ReadMarker) bool {
+	return current == nil || next.position() > current.position()
+}
+
+// unreadBy reports whether msg is unread by the user with the given marker
+// (nil if they have none): it comes after the marker and is neither theirs
+// nor deleted.
+func unreadBy(msg *message.Message, userID string, marker *ReadMarker) bool {
+	if msg.UserID == userID || msg.Deleted {
+		return false
+	}
+	return marker == nil || readPosition(msg.Timestamp, msg.MessageID) > marker.position()
+}
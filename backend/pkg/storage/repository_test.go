@@ -0,0 +1,830 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+	"github.com/epw80/chat-analytics-platform/pkg/room"
+)
+
+// testRepositoryContract runs the behaviour every MessageRepository backend
+// must share. newRepo returns a fresh, empty repository for each subtest.
+func testRepositoryContract(t *testing.T, newRepo func(t *testing.T) MessageRepository) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, repo MessageRepository)
+	}{
+		{"GetRecentMessages", testContractGetRecentMessages},
+		{"EarlyTimestamps", testContractEarlyTimestamps},
+		{"GetMessagesByUser", testContractGetMessagesByUser},
+		{"Defaults", testContractDefaults},
+		{"KeepsExistingKey", testContractKeepsExistingKey},
+		{"ReplayAfterDelete", testContractReplayAfterDelete},
+		{"ReturnsCopies", testContractReturnsCopies},
+		{"RoomPagination", testContractRoomPagination},
+		{"UserPagination", testContractUserPagination},
+		{"InvalidCursor", testContractInvalidCursor},
+		{"TimeRange", testContractTimeRange},
+		{"TimeRangeBoundaries", testContractTimeRangeBoundaries},
+		{"EditMessage", testContractEditMessage},
+		{"DeleteMessage", testContractDeleteMessage},
+		{"Threads", testContractThreads},
+		{"Reactions", testContractReactions},
+		{"Mentions", testContractMentions},
+		{"ReadMarkers", testContractReadMarkers},
+		{"Rooms", testContractRooms},
+		{"Pins", testContractPins},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newRepo(t)
+			defer repo.Close()
+			tc.fn(t, repo)
+		})
+	}
+}
+
+func testMessage(id, room, user string, ts time.Time) *message.Message {
+	return &message.Message{
+		MessageID: id,
+		RoomID:    room,
+		Type:      message.TypeChat,
+		UserID:    user,
+		Username:  user,
+		Content:   "content " + id,
+		Timestamp: ts,
+	}
+}
+
+func testContractGetRecentMessages(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	// Saved out of order; the index must still be chronological.
+	repo.SaveMessage(ctx, testMessage("m3", "lobby", "u1", base.Add(3*time.Second)))
+	repo.SaveMessage(ctx, testMessage("m1", "lobby", "u1", base.Add(1*time.Second)))
+	repo.SaveMessage(ctx, testMessage("m2", "lobby", "u2", base.Add(2*time.Second)))
+	repo.SaveMessage(ctx, testMessage("x1", "other", "u1", base))
+
+	msgs, err := repo.GetRecentMessages(ctx, "lobby", 2)
+	if err != nil {
+		t.Fatalf("GetRecentMessages: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].MessageID != "m2" || msgs[1].MessageID != "m3" {
+		t.Errorf("expected newest two [m2 m3] oldest first, got %v", ids(msgs))
+	}
+
+	msgs, _ = repo.GetRecentMessages(ctx, "lobby", 10)
+	if len(msgs) != 3 || msgs[0].MessageID != "m1" {
+		t.Errorf("expected [m1 m2 m3], got %v", ids(msgs))
+	}
+}
+
+// The zero time and times before 1970 sort before every later message.
+func testContractEarlyTimestamps(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	repo.SaveMessage(ctx, testMessage("now", "lobby", "u1", seedBase))
+	repo.SaveMessage(ctx, testMessage("1969", "lobby", "u1", time.Date(1969, 7, 20, 20, 17, 0, 0, time.UTC)))
+	repo.SaveMessage(ctx, testMessage("zero", "lobby", "u1", time.Time{}))
+
+	msgs, err := repo.GetRecentMessages(ctx, "lobby", 10)
+	if err != nil {
+		t.Fatalf("GetRecentMessages: %v", err)
+	}
+	if got := ids(msgs); len(got) != 3 || got[0] != "zero" || got[1] != "1969" || got[2] != "now" {
+		t.Errorf("expected [zero 1969 now], got %v", got)
+	}
+
+	msgs, _ = repo.GetMessagesByUser(ctx, "u1", 10)
+	if got := ids(msgs); len(got) != 3 || got[0] != "zero" || got[2] != "now" {
+		t.Errorf("expected user messages [zero 1969 now], got %v", got)
+	}
+}
+
+func testContractGetMessagesByUser(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	repo.BatchSaveMessages(ctx, []*message.Message{
+		testMessage("m2", "a", "u1", base.Add(2*time.Second)),
+		testMessage("m1", "b", "u1", base.Add(1*time.Second)),
+		testMessage("m3", "a", "u1", base.Add(3*time.Second)),
+		testMessage("n1", "a", "u2", base),
+	})
+
+	msgs, err := repo.GetMessagesByUser(ctx, "u1", 2)
+	if err != nil {
+		t.Fatalf("GetMessagesByUser: %v", err)
+	}
+	// Matches the ascending UserID-Timestamp query: oldest first, first page.
+	if len(msgs) != 2 || msgs[0].MessageID != "m1" || msgs[1].MessageID != "m2" {
+		t.Errorf("expected [m1 m2], got %v", ids(msgs))
+	}
+}
+
+func testContractDefaults(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+
+	msg := &message.Message{UserID: "u1", Content: "hi", Timestamp: time.Now()}
+	if err := repo.SaveMessage(ctx, msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if msg.MessageID == "" {
+		t.Error("expected a generated MessageID")
+	}
+	if msg.RoomID != DefaultRoomID {
+		t.Errorf("expected default room %q, got %q", DefaultRoomID, msg.RoomID)
+	}
+
+	msgs, _ := repo.GetRecentMessages(ctx, "", 10)
+	if len(msgs) != 1 {
+		t.Errorf("expected empty roomID to read the default room, got %d messages", len(msgs))
+	}
+}
+
+func testContractKeepsExistingKey(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	base := time.Now().UTC()
+
+	repo.SaveMessage(ctx, testMessage("m1", "lobby", "u1", base))
+	again := testMessage("m1", "lobby", "u1", base.Add(time.Second))
+	again.Content = "resent"
+	if err := repo.SaveMessage(ctx, again); err != nil {
+		t.Fatalf("SaveMessage of a stored key: %v", err)
+	}
+
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if len(msgs) != 1 || msgs[0].Content != "content m1" || !msgs[0].Timestamp.Equal(base) {
+		t.Errorf("expected the first message to be kept, got %+v", msgs)
+	}
+	if byUser, _ := repo.GetMessagesByUser(ctx, "u1", 10); len(byUser) != 1 {
+		t.Errorf("expected user index to hold 1 message, got %d", len(byUser))
+	}
+}
+
+func testContractReplayAfterDelete(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	original := testMessage("m1", "lobby", "u1", seedBase)
+	original.Mentions = []string{"u2"}
+	if err := repo.SaveMessage(ctx, original.Clone()); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if _, err := repo.DeleteMessage(ctx, "lobby", "m1", "u1", false, seedBase.Add(time.Minute)); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+
+	// A WAL replay or redrive saves the original again.
+	if err := repo.BatchSaveMessages(ctx, []*message.Message{original.Clone()}); err != nil {
+		t.Fatalf("BatchSaveMessages replay: %v", err)
+	}
+
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if len(msgs) != 1 || !msgs[0].Deleted || msgs[0].Content != "" {
+		t.Errorf("expected the tombstone to survive the replay, got %+v", msgs)
+	}
+	if inbox, _ := repo.(MentionRepository).GetMentions(ctx, "u2", 0, false); len(inbox) != 0 {
+		t.Errorf("expected the replay not to restore mentions, got %v", mentionIDs(inbox))
+	}
+}
+
+func testContractReturnsCopies(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+
+	original := testMessage("m1", "lobby", "u1", time.Now())
+	repo.SaveMessage(ctx, original)
+	original.Content = "mutated after save"
+
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	msgs[0].Content = "mutated after read"
+
+	again, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if again[0].Content != "content m1" {
+		t.Errorf("stored message was mutated: %q", again[0].Content)
+	}
+}
+
+// seedRoom saves n messages m00..m(n-1) one second apart (starting at
+// seedBase) in room "lobby", all from user "u1".
+func seedRoom(t *testing.T, repo MessageRepository, n int) {
+	t.Helper()
+	base := seedBase
+	msgs := make([]*message.Message, n)
+	for i := range msgs {
+		msgs[i] = testMessage(fmt.Sprintf("m%02d", i), "lobby", "u1", base.Add(time.Duration(i)*time.Second))
+	}
+	if err := repo.BatchSaveMessages(context.Background(), msgs); err != nil {
+		t.Fatalf("BatchSaveMessages: %v", err)
+	}
+}
+
+func testContractRoomPagination(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 5)
+
+	// Newest page first, walking backwards.
+	page, err := repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m03 m04]" || page.NextCursor == "" {
+		t.Fatalf("first page = %v (cursor %q), want [m03 m04] with a cursor", got, page.NextCursor)
+	}
+
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2, Before: page.NextCursor})
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m01 m02]" {
+		t.Fatalf("second page = %v, want [m01 m02]", got)
+	}
+	older := page.NextCursor
+
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2, Before: older})
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m00]" {
+		t.Fatalf("last page = %v, want [m00]", got)
+	}
+	if page.NextCursor != "" {
+		// A backend may report a cursor when the last page is exactly full, but
+		// following it must yield nothing.
+		empty, _ := repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2, Before: page.NextCursor})
+		if len(empty.Messages) != 0 {
+			t.Errorf("expected no messages past the oldest, got %v", ids(empty.Messages))
+		}
+	}
+
+	// Walking forward from the m01 boundary returns strictly newer messages.
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2, After: older})
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m02 m03]" || page.NextCursor == "" {
+		t.Fatalf("forward page = %v (cursor %q), want [m02 m03] with a cursor", got, page.NextCursor)
+	}
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2, After: page.NextCursor})
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m04]" {
+		t.Fatalf("forward page = %v, want [m04]", got)
+	}
+}
+
+func testContractUserPagination(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 3)
+
+	page, err := repo.GetUserMessagesPage(ctx, "u1", PageQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m01 m02]" || page.NextCursor == "" {
+		t.Fatalf("first page = %v (cursor %q), want [m01 m02] with a cursor", got, page.NextCursor)
+	}
+	page, _ = repo.GetUserMessagesPage(ctx, "u1", PageQuery{Limit: 2, Before: page.NextCursor})
+	if got := ids(page.Messages); fmt.Sprint(got) != "[m00]" {
+		t.Fatalf("second page = %v, want [m00]", got)
+	}
+}
+
+func testContractInvalidCursor(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 3)
+
+	if _, err := repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 2, Before: "not-a-cursor!"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for garbage, got %v", err)
+	}
+
+	// A cursor issued for one room must not be accepted for another.
+	page, _ := repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 1})
+	if _, err := repo.GetRoomMessagesPage(ctx, "other", PageQuery{Limit: 1, Before: page.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for a foreign cursor, got %v", err)
+	}
+
+	// Forged cursors: each would be refused by DynamoDB as an ExclusiveStartKey.
+	valid := cursorKey{AttrRoomID: "lobby", AttrMessageID: "m01", AttrTimestamp: seedBase.Add(time.Second).Format(time.RFC3339Nano)}
+	forged := map[string]cursorKey{
+		"extra attribute":   {AttrRoomID: "lobby", AttrMessageID: "m01", AttrTimestamp: valid[AttrTimestamp], AttrContent: "x"},
+		"missing timestamp": {AttrRoomID: "lobby", AttrMessageID: "m01"},
+		"bad timestamp":     {AttrRoomID: "lobby", AttrMessageID: "m01", AttrTimestamp: "yesterday"},
+	}
+	for name, key := range forged {
+		if _, err := repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 1, Before: encodeCursor(key)}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected ErrInvalidCursor for %s, got %v", name, err)
+		}
+	}
+	if _, err := repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 1, Before: encodeCursor(valid)}); err != nil {
+		t.Errorf("expected a hand-built cursor to be accepted, got %v", err)
+	}
+
+	// A cursor outside the queried time range.
+	q := PageQuery{Limit: 1, Before: encodeCursor(valid), Since: seedBase.Add(2 * time.Second)}
+	if _, err := repo.GetRoomMessagesPage(ctx, "lobby", q); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor for a cursor before since, got %v", err)
+	}
+}
+
+var seedBase = time.Date(2026, 6, 16, 9, 0, 0, 0, time.UTC)
+
+func testContractTimeRange(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 6)
+
+	// Both bounds are inclusive.
+	q := PageQuery{Limit: 10, Since: seedBase.Add(1 * time.Second), Until: seedBase.Add(4 * time.Second)}
+	page, err := repo.GetRoomMessagesPage(ctx, "lobby", q)
+	if err != nil {
+		t.Fatalf("GetRoomMessagesPage: %v", err)
+	}
+	if got := fmt.Sprint(ids(page.Messages)); got != "[m01 m02 m03 m04]" {
+		t.Errorf("range page = %s, want [m01 m02 m03 m04]", got)
+	}
+
+	// Open-ended ranges.
+	page, _ = repo.GetUserMessagesPage(ctx, "u1", PageQuery{Limit: 10, Since: seedBase.Add(4 * time.Second)})
+	if got := fmt.Sprint(ids(page.Messages)); got != "[m04 m05]" {
+		t.Errorf("since page = %s, want [m04 m05]", got)
+	}
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", PageQuery{Limit: 10, Until: seedBase.Add(time.Second)})
+	if got := fmt.Sprint(ids(page.Messages)); got != "[m00 m01]" {
+		t.Errorf("until page = %s, want [m00 m01]", got)
+	}
+
+	// Cursors page within the range and stop at its edges.
+	q.Limit = 2
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", q)
+	if got := fmt.Sprint(ids(page.Messages)); got != "[m03 m04]" {
+		t.Fatalf("first range page = %s, want [m03 m04]", got)
+	}
+	q.Before = page.NextCursor
+	page, _ = repo.GetRoomMessagesPage(ctx, "lobby", q)
+	if got := fmt.Sprint(ids(page.Messages)); got != "[m01 m02]" {
+		t.Fatalf("second range page = %s, want [m01 m02]", got)
+	}
+	if page.NextCursor != "" {
+		q.Before = page.NextCursor
+		page, _ = repo.GetRoomMessagesPage(ctx, "lobby", q)
+		if len(page.Messages) != 0 {
+			t.Errorf("expected nothing before the range start, got %v", ids(page.Messages))
+		}
+	}
+}
+
+// Bounds that fall between whole seconds, or on a message whose timestamp
+// has no fractional part, are compared chronologically.
+func testContractTimeRangeBoundaries(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	repo.BatchSaveMessages(ctx, []*message.Message{
+		testMessage("a", "lobby", "u1", seedBase),
+		testMessage("b", "lobby", "u1", seedBase.Add(500*time.Millisecond)),
+		testMessage("c", "lobby", "u1", seedBase.Add(time.Second)),
+		testMessage("d", "lobby", "u1", seedBase.Add(time.Second+time.Nanosecond)),
+	})
+
+	tests := []struct {
+		since, until time.Duration
+		want         string
+	}{
+		{0, 0, "[a]"},
+		{0, 500 * time.Millisecond, "[a b]"},
+		{time.Millisecond, time.Second, "[b c]"},
+		{500 * time.Millisecond, time.Second, "[b c]"},
+		{time.Second, time.Second + time.Nanosecond, "[c d]"},
+		{time.Second + time.Nanosecond, time.Hour, "[d]"},
+	}
+	for _, tt := range tests {
+		q := PageQuery{Limit: 10, Since: seedBase.Add(tt.since), Until: seedBase.Add(tt.until)}
+		page, err := repo.GetRoomMessagesPage(ctx, "lobby", q)
+		if err != nil {
+			t.Fatalf("GetRoomMessagesPage: %v", err)
+		}
+		if got := fmt.Sprint(ids(page.Messages)); got != tt.want {
+			t.Errorf("[+%v, +%v] = %s, want %s", tt.since, tt.until, got, tt.want)
+		}
+	}
+}
+
+func testContractEditMessage(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 2)
+	editedAt := seedBase.Add(time.Minute)
+
+	edited, err := repo.EditMessage(ctx, "lobby", "m00", "u1", "fixed", editedAt)
+	if err != nil {
+		t.Fatalf("EditMessage: %v", err)
+	}
+	if edited.Content != "fixed" || edited.EditedAt == nil || !edited.EditedAt.Equal(editedAt) {
+		t.Errorf("unexpected edited message: %+v", edited)
+	}
+	if len(edited.Revisions) != 1 || edited.Revisions[0].Content != "content m00" {
+		t.Errorf("expected original content as a revision, got %+v", edited.Revisions)
+	}
+
+	// The stored copy is updated and keeps its place in the history.
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if len(msgs) != 2 || msgs[0].MessageID != "m00" || msgs[0].Content != "fixed" {
+		t.Errorf("expected edited m00 first in history, got %+v", msgs)
+	}
+
+	if _, err := repo.EditMessage(ctx, "lobby", "m01", "intruder", "hijack", editedAt); !errors.Is(err, ErrNotAuthor) {
+		t.Errorf("expected ErrNotAuthor, got %v", err)
+	}
+	if _, err := repo.EditMessage(ctx, "lobby", "missing", "u1", "x", editedAt); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	if _, err := repo.EditMessage(ctx, "other", "m00", "u1", "x", editedAt); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound for the wrong room, got %v", err)
+	}
+}
+
+func testContractDeleteMessage(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 3)
+	deletedAt := seedBase.Add(time.Minute)
+
+	if _, err := repo.DeleteMessage(ctx, "lobby", "m00", "intruder", false, deletedAt); !errors.Is(err, ErrNotAuthor) {
+		t.Errorf("expected ErrNotAuthor, got %v", err)
+	}
+	if _, err := repo.DeleteMessage(ctx, "lobby", "missing", "u1", true, deletedAt); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+
+	// The author may delete their own message.
+	tomb, err := repo.DeleteMessage(ctx, "lobby", "m00", "u1", false, deletedAt)
+	if err != nil {
+		t.Fatalf("DeleteMessage by author: %v", err)
+	}
+	if !tomb.Deleted || tomb.Content != "" || tomb.DeletedBy != "u1" {
+		t.Errorf("unexpected tombstone: %+v", tomb)
+	}
+
+	// A moderator may delete anyone's message.
+	if _, err := repo.DeleteMessage(ctx, "lobby", "m01", "mod", true, deletedAt); err != nil {
+		t.Fatalf("DeleteMessage by moderator: %v", err)
+	}
+
+	// Deleting again is a no-op that keeps the original deleter.
+	again, err := repo.DeleteMessage(ctx, "lobby", "m01", "mod2", true, deletedAt.Add(time.Minute))
+	if err != nil || again.DeletedBy != "mod" {
+		t.Errorf("expected idempotent delete keeping DeletedBy=mod, got %+v, %v", again, err)
+	}
+
+	// Tombstones stay in place in history and can no longer be edited.
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if got := fmt.Sprint(ids(msgs)); got != "[m00 m01 m02]" {
+		t.Fatalf("expected tombstones to keep their position, got %s", got)
+	}
+	if !msgs[0].Deleted || msgs[0].Content != "" || !msgs[0].Timestamp.Equal(seedBase) {
+		t.Errorf("unexpected stored tombstone: %+v", msgs[0])
+	}
+	if _, err := repo.EditMessage(ctx, "lobby", "m00", "u1", "revive", deletedAt); !errors.Is(err, ErrMessageDeleted) {
+		t.Errorf("expected ErrMessageDeleted when editing a tombstone, got %v", err)
+	}
+}
+
+func testContractRooms(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	rooms, ok := repo.(RoomRepository)
+	if !ok {
+		t.Fatal("repository does not implement RoomRepository")
+	}
+
+	if _, err := rooms.GetRoom(ctx, "lobby"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("expected ErrRoomNotFound, got %v", err)
+	}
+
+	lobby := &room.Room{ID: "lobby", Name: "Lobby", CreatedBy: "u1", CreatedAt: seedBase.Add(time.Minute), Visibility: room.VisibilityPublic}
+	ops := &room.Room{ID: "ops", Name: "Ops", Topic: "on-call", CreatedBy: "u2", CreatedAt: seedBase, Visibility: room.VisibilityPrivate}
+	for _, rm := range []*room.Room{lobby, ops} {
+		if err := rooms.CreateRoom(ctx, rm); err != nil {
+			t.Fatalf("CreateRoom(%s): %v", rm.ID, err)
+		}
+	}
+	if err := rooms.CreateRoom(ctx, &room.Room{ID: "lobby", Name: "Impostor"}); !errors.Is(err, ErrRoomExists) {
+		t.Errorf("expected ErrRoomExists, got %v", err)
+	}
+
+	got, err := rooms.GetRoom(ctx, "ops")
+	if err != nil {
+		t.Fatalf("GetRoom: %v", err)
+	}
+	if got.Name != "Ops" || got.Topic != "on-call" || got.CreatedBy != "u2" ||
+		got.Visibility != room.VisibilityPrivate || !got.CreatedAt.Equal(seedBase) {
+		t.Errorf("unexpected room: %+v", got)
+	}
+
+	list, err := rooms.ListRooms(ctx)
+	if err != nil {
+		t.Fatalf("ListRooms: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != "ops" || list[1].ID != "lobby" {
+		t.Errorf("expected rooms [ops lobby] oldest first, got %+v", list)
+	}
+}
+
+func testContractThreads(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 2)
+
+	// Three replies to m00, one of them saved twice, and a reply to a
+	// same-named message in another room.
+	var replies []*message.Message
+	for i := 0; i < 3; i++ {
+		reply := testMessage(fmt.Sprintf("r%d", i), "lobby", "u2", seedBase.Add(time.Minute+time.Duration(i)*time.Second))
+		reply.ParentMessageID = "m00"
+		replies = append(replies, reply)
+	}
+	elsewhere := testMessage("x0", "other", "u2", seedBase)
+	elsewhere.ParentMessageID = "m00"
+	if err := repo.BatchSaveMessages(ctx, append(replies, elsewhere)); err != nil {
+		t.Fatalf("BatchSaveMessages: %v", err)
+	}
+	if err := repo.SaveMessage(ctx, replies[0]); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	parent, err := repo.GetMessage(ctx, "lobby", "m00")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if parent.ReplyCount != 3 {
+		t.Errorf("ReplyCount = %d, want 3", parent.ReplyCount)
+	}
+	if _, err := repo.GetMessage(ctx, "lobby", "missing"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+
+	page, err := repo.GetThreadPage(ctx, "lobby", "m00", PageQuery{Limit: 2})
+	if err != nil {
+		t.Fatalf("GetThreadPage: %v", err)
+	}
+	if got := ids(page.Messages); fmt.Sprint(got) != "[r1 r2]" || page.NextCursor == "" {
+		t.Fatalf("first page = %v (cursor %q), want [r1 r2] with a cursor", got, page.NextCursor)
+	}
+	page, _ = repo.GetThreadPage(ctx, "lobby", "m00", PageQuery{Limit: 2, Before: page.NextCursor})
+	if got := ids(page.Messages); fmt.Sprint(got) != "[r0]" {
+		t.Fatalf("second page = %v, want [r0]", got)
+	}
+
+	// A message without replies has an empty thread.
+	page, err = repo.GetThreadPage(ctx, "lobby", "m01", PageQuery{})
+	if err != nil || len(page.Messages) != 0 {
+		t.Errorf("thread of m01 = %v, %v; want empty", page, err)
+	}
+}
+
+func testContractReactions(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	seedRoom(t, repo, 2)
+
+	changes := []struct {
+		userID, emoji string
+		remove        bool
+	}{
+		{"u2", "👍", false},
+		{"u3", "👍", false},
+		{"u2", "👍", false}, // repeat: no-op
+		{"u3", "🎉", false},
+		{"u3", "👍", true},
+	}
+	var reacted *message.Message
+	for _, c := range changes {
+		var err error
+		reacted, err = repo.ReactToMessage(ctx, "lobby", "m00", c.userID, c.emoji, c.remove)
+		if err != nil {
+			t.Fatalf("ReactToMessage(%s %s): %v", c.userID, c.emoji, err)
+		}
+	}
+	want := "[{👍 1 [u2]} {🎉 1 [u3]}]"
+	if got := fmt.Sprint(reacted.Reactions); got != want {
+		t.Errorf("returned reactions = %s, want %s", got, want)
+	}
+
+	// History carries the aggregate.
+	recent, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if got := fmt.Sprint(recent[0].Reactions); recent[0].MessageID != "m00" || got != want {
+		t.Errorf("history reactions on %s = %s, want %s", recent[0].MessageID, got, want)
+	}
+	if recent[1].Reactions != nil {
+		t.Errorf("unreacted message has reactions %v", recent[1].Reactions)
+	}
+
+	if _, err := repo.ReactToMessage(ctx, "lobby", "missing", "u2", "👍", false); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+
+	// Deleting drops the reactions, and tombstones can't be reacted to.
+	deleted, err := repo.DeleteMessage(ctx, "lobby", "m00", "u1", false, seedBase.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if deleted.Reactions != nil {
+		t.Errorf("tombstone kept reactions %v", deleted.Reactions)
+	}
+	if _, err := repo.ReactToMessage(ctx, "lobby", "m00", "u2", "👍", false); !errors.Is(err, ErrMessageDeleted) {
+		t.Errorf("expected ErrMessageDeleted, got %v", err)
+	}
+}
+
+func testContractMentions(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	mentions, ok := repo.(MentionRepository)
+	if !ok {
+		t.Fatal("repository does not implement MentionRepository")
+	}
+
+	// u2 is mentioned three times, once by a message saved twice; u3 once.
+	for i, mentioned := range [][]string{{"u2"}, {"u2", "u3"}, nil, {"u2"}} {
+		msg := testMessage(fmt.Sprintf("m%02d", i), "lobby", "u1", seedBase.Add(time.Duration(i)*time.Second))
+		msg.Mentions = mentioned
+		if err := repo.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+
+	inbox, err := mentions.GetMentions(ctx, "u2", 0, false)
+	if err != nil {
+		t.Fatalf("GetMentions: %v", err)
+	}
+	if got := mentionIDs(inbox); fmt.Sprint(got) != "[m03 m01 m00]" {
+		t.Errorf("expected u2's mentions [m03 m01 m00] newest first, got %v", got)
+	}
+	if inbox[0].Message == nil || inbox[0].Message.Content != "content m03" || inbox[0].RoomID != "lobby" {
+		t.Errorf("mention missing its message: %+v", inbox[0])
+	}
+	if limited, _ := mentions.GetMentions(ctx, "u2", 2, false); fmt.Sprint(mentionIDs(limited)) != "[m03 m01]" {
+		t.Errorf("expected limit 2 to keep [m03 m01], got %v", mentionIDs(limited))
+	}
+
+	readAt := seedBase.Add(time.Hour)
+	marked, err := mentions.MarkMentionsRead(ctx, "u2", []string{"m01", "missing"}, readAt)
+	if err != nil || marked != 1 {
+		t.Fatalf("MarkMentionsRead = %d, %v; want 1", marked, err)
+	}
+	if unread, _ := mentions.CountUnreadMentions(ctx, "u2"); unread != 2 {
+		t.Errorf("expected 2 unread, got %d", unread)
+	}
+	unread, _ := mentions.GetMentions(ctx, "u2", 0, true)
+	if got := mentionIDs(unread); fmt.Sprint(got) != "[m03 m00]" {
+		t.Errorf("expected unread [m03 m00], got %v", got)
+	}
+
+	// Saving again keeps the read state; u3's inbox is untouched.
+	resaved := testMessage("m01", "lobby", "u1", seedBase.Add(time.Second))
+	resaved.Mentions = []string{"u2", "u3"}
+	repo.SaveMessage(ctx, resaved)
+	if n, _ := mentions.CountUnreadMentions(ctx, "u2"); n != 2 {
+		t.Errorf("resaving reset read state: %d unread", n)
+	}
+	if n, _ := mentions.CountUnreadMentions(ctx, "u3"); n != 1 {
+		t.Errorf("expected u3 to have 1 unread, got %d", n)
+	}
+
+	// Deleting a message drops it from the inbox; marking with no IDs marks
+	// everything left.
+	if _, err := repo.DeleteMessage(ctx, "lobby", "m03", "u1", false, readAt); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	inbox, _ = mentions.GetMentions(ctx, "u2", 0, false)
+	if got := mentionIDs(inbox); fmt.Sprint(got) != "[m01 m00]" {
+		t.Errorf("expected [m01 m00] after delete, got %v", got)
+	}
+	if inbox[0].ReadAt == nil || !inbox[0].ReadAt.Equal(readAt) || inbox[1].ReadAt != nil {
+		t.Errorf("unexpected read state: %v, %v", inbox[0].ReadAt, inbox[1].ReadAt)
+	}
+	if marked, _ := mentions.MarkMentionsRead(ctx, "u2", nil, readAt); marked != 1 {
+		t.Errorf("expected marking all to mark 1, got %d", marked)
+	}
+	if n, _ := mentions.CountUnreadMentions(ctx, "u2"); n != 0 {
+		t.Errorf("expected 0 unread, got %d", n)
+	}
+}
+
+func testContractPins(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	rooms, ok := repo.(RoomRepository)
+	if !ok {
+		t.Fatal("repository does not implement RoomRepository")
+	}
+	seedRoom(t, repo, 3)
+	if err := rooms.CreateRoom(ctx, &room.Room{ID: "lobby", Name: "Lobby", CreatedBy: "u1", CreatedAt: seedBase}); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	pinnedAt := seedBase.Add(time.Hour)
+	for _, id := range []string{"m01", "m00"} {
+		if _, added, err := rooms.PinMessage(ctx, "lobby", id, "mod", pinnedAt); err != nil || !added {
+			t.Fatalf("PinMessage(%s) = %v, %v; want added", id, added, err)
+		}
+	}
+	rm, added, err := rooms.PinMessage(ctx, "lobby", "m01", "other", pinnedAt.Add(time.Minute))
+	if err != nil || added {
+		t.Errorf("re-pinning m01 = %v, %v; want no-op", added, err)
+	}
+	if len(rm.Pins) != 2 || rm.Pins[0].MessageID != "m01" || rm.Pins[0].PinnedBy != "mod" ||
+		!rm.Pins[0].PinnedAt.Equal(pinnedAt) || rm.Pins[1].MessageID != "m00" {
+		t.Errorf("unexpected pins: %+v", rm.Pins)
+	}
+
+	if _, _, err := rooms.PinMessage(ctx, "lobby", "missing", "mod", pinnedAt); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	if _, _, err := rooms.PinMessage(ctx, "nowhere", "m00", "mod", pinnedAt); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("expected ErrRoomNotFound, got %v", err)
+	}
+	if _, err := repo.DeleteMessage(ctx, "lobby", "m02", "u1", false, pinnedAt); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if _, _, err := rooms.PinMessage(ctx, "lobby", "m02", "mod", pinnedAt); !errors.Is(err, ErrMessageDeleted) {
+		t.Errorf("expected ErrMessageDeleted, got %v", err)
+	}
+
+	rm, removed, err := rooms.UnpinMessage(ctx, "lobby", "m01")
+	if err != nil || !removed || len(rm.Pins) != 1 || rm.Pins[0].MessageID != "m00" {
+		t.Errorf("UnpinMessage(m01) = %+v, %v, %v", rm, removed, err)
+	}
+	if _, removed, err := rooms.UnpinMessage(ctx, "lobby", "m01"); err != nil || removed {
+		t.Errorf("second UnpinMessage(m01) = %v, %v; want no-op", removed, err)
+	}
+	if _, _, err := rooms.UnpinMessage(ctx, "nowhere", "m00"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("expected ErrRoomNotFound, got %v", err)
+	}
+
+	// The last unpin clears the pins entirely, and GetRoom sees the result.
+	if _, _, err := rooms.UnpinMessage(ctx, "lobby", "m00"); err != nil {
+		t.Fatalf("UnpinMessage(m00): %v", err)
+	}
+	if got, err := rooms.GetRoom(ctx, "lobby"); err != nil || len(got.Pins) != 0 || got.Name != "Lobby" {
+		t.Errorf("GetRoom after unpinning = %+v, %v", got, err)
+	}
+}
+
+func testContractReadMarkers(t *testing.T, repo MessageRepository) {
+	ctx := context.Background()
+	markers, ok := repo.(ReadMarkerRepository)
+	if !ok {
+		t.Fatal("repository does not implement ReadMarkerRepository")
+	}
+	// m00..m04 from u1, then one of u2's own.
+	seedRoom(t, repo, 5)
+	repo.SaveMessage(ctx, testMessage("own", "lobby", "u2", seedBase.Add(time.Minute)))
+	repo.SaveMessage(ctx, testMessage("x1", "other", "u1", seedBase))
+
+	// Nothing read yet: everything but u2's own message is unread.
+	if n, err := markers.CountUnread(ctx, "u2", "lobby"); err != nil || n != 5 {
+		t.Fatalf("CountUnread before reading = %d, %v; want 5", n, err)
+	}
+
+	readAt := seedBase.Add(time.Hour)
+	marker, moved, err := markers.SetReadMarker(ctx, "u2", "lobby", "m02", readAt)
+	if err != nil || !moved {
+		t.Fatalf("SetReadMarker(m02) = %v, %v; want moved", moved, err)
+	}
+	if marker.MessageID != "m02" || marker.RoomID != "lobby" || !marker.Timestamp.Equal(seedBase.Add(2*time.Second)) || !marker.ReadAt.Equal(readAt) {
+		t.Errorf("unexpected marker: %+v", marker)
+	}
+	if n, _ := markers.CountUnread(ctx, "u2", "lobby"); n != 2 {
+		t.Errorf("expected 2 unread after m02, got %d", n)
+	}
+
+	// Markers never move backwards.
+	marker, moved, err = markers.SetReadMarker(ctx, "u2", "lobby", "m01", readAt.Add(time.Minute))
+	if err != nil || moved || marker.MessageID != "m02" {
+		t.Errorf("SetReadMarker(m01) = %+v, %v, %v; want m02 unmoved", marker, moved, err)
+	}
+	if _, _, err := markers.SetReadMarker(ctx, "u2", "lobby", "missing", readAt); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("expected ErrMessageNotFound, got %v", err)
+	}
+	if _, _, err := markers.SetReadMarker(ctx, "u2", "other", "x1", readAt); err != nil {
+		t.Fatalf("SetReadMarker(other): %v", err)
+	}
+
+	// Deleted messages don't count.
+	if _, err := repo.DeleteMessage(ctx, "lobby", "m04", "u1", false, readAt); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if n, _ := markers.CountUnread(ctx, "u2", "lobby"); n != 1 {
+		t.Errorf("expected 1 unread after delete, got %d", n)
+	}
+
+	list, err := markers.GetReadMarkers(ctx, "u2")
+	if err != nil {
+		t.Fatalf("GetReadMarkers: %v", err)
+	}
+	if len(list) != 2 || list[0].RoomID != "lobby" || list[0].MessageID != "m02" || list[1].RoomID != "other" {
+		t.Errorf("unexpected markers: %+v", list)
+	}
+	if list, _ := markers.GetReadMarkers(ctx, "u3"); len(list) != 0 {
+		t.Errorf("expected no markers for u3, got %+v", list)
+	}
+}
+
+func mentionIDs(mentions []*Mention) []string {
+	out := make([]string, len(mentions))
+	for i, m := range mentions {
+		out[i] = m.MessageID
+	}
+	return out
+}
+
+func ids(msgs []*message.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.MessageID
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"log/slog"
+	"time"
+
+	appconfig "github.com/epw80/chat-analytics-platform/pkg/config"
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+// DefaultSweepInterval is how often the in-process backends purge expired
+// messages.
+const DefaultSweepInterval = time.Minute
+
+// RetentionPolicy decides how long messages are kept. A zero duration keeps
+// messages forever.
+type RetentionPolicy struct {
+	// Default applies to every room without an entry in Rooms.
+	Default time.Duration
+
+	// Rooms overrides Default per room; a zero entry keeps that room's
+	// messages forever.
+	Rooms map[string]time.Duration
+}
+
+// NewRetentionPolicy builds the policy configured by RETENTION_DAYS and
+// ROOM_RETENTION_DAYS.
+func NewRetentionPolicy(cfg *appconfig.Config) RetentionPolicy {
+	p := RetentionPolicy{
+		Default: days(cfg.RetentionDays),
+		Rooms:   make(map[string]time.Duration, len(cfg.RoomRetentionDays)),
+	}
+	for room, n := range cfg.RoomRetentionDays {
+		p.Rooms[room] = days(n)
+	}
+	return p
+}
+
+// days converts a day count to a duration, treating negatives as zero.
+func days(n int) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// Enabled reports whether any room's messages can expire.
+func (p RetentionPolicy) Enabled() bool {
+	if p.Default > 0 {
+		return true
+	}
+	for _, d := range p.Rooms {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// For returns the retention period for a room, zero meaning forever.
+func (p RetentionPolicy) For(roomID string) time.Duration {
+	if d, ok := p.Rooms[roomID]; ok {
+		return d
+	}
+	return p.Default
+}
+
+// Stamp sets msg.ExpiresAt from its room's retention period, measured from the
+// message Timestamp. Messages in rooms kept forever are left without one,
+// whatever ExpiresAt they arrived with.
+func (p RetentionPolicy) Stamp(msg *message.Message) {
+	d := p.For(msg.RoomID)
+	if d <= 0 {
+		msg.ExpiresAt = nil
+		return
+	}
+	ts := msg.Timestamp
+	if ts.IsZero() {
+		ts = time.Now().UTC()
+	}
+	// DynamoDB TTL has second granularity; keep every backend on the same
+	// boundary.
+	expiresAt := ts.Add(d).Truncate(time.Second)
+	msg.ExpiresAt = &expiresAt
+}
+
+// expired reports whether msg's retention period has elapsed at now.
+func expired(msg *message.Message, now time.Time) bool {
+	return msg.ExpiresAt != nil && !msg.ExpiresAt.After(now)
+}
+
+// runSweeper calls sweep every interval until done is closed, logging how
+// many expired messages each pass removed.
+func runSweeper(done <-chan struct{}, interval time.Duration, sweep func(time.Time) (int, error), logger *slog.Logger) {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			removed, err := sweep(now.UTC())
+			if err != nil {
+				logger.Error("retention sweep failed", slog.String("error", err.Error()))
+				continue
+			}
+			if removed > 0 {
+				logger.Info("retention sweep removed expired messages", slog.Int("count", removed))
+			}
+		}
+	}
+}
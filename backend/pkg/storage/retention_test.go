@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	appconfig "github.com/epw80/chat-analytics-platform/pkg/config"
+	"github.com/epw80/chat-analytics-platform/pkg/message"
+)
+
+func TestNewRetentionPolicy(t *testing.T) {
+	p := NewRetentionPolicy(&appconfig.Config{
+		RetentionDays:     30,
+		RoomRetentionDays: map[string]int{"ephemeral": 1, "archive": 0},
+	})
+
+	tests := []struct {
+		room string
+		want time.Duration
+	}{
+		{"lobby", 30 * 24 * time.Hour},
+		{"ephemeral", 24 * time.Hour},
+		{"archive", 0},
+	}
+	for _, tt := range tests {
+		if got := p.For(tt.room); got != tt.want {
+			t.Errorf("For(%q) = %v, want %v", tt.room, got, tt.want)
+		}
+	}
+	if !p.Enabled() {
+		t.Error("expected policy to be enabled")
+	}
+	if NewRetentionPolicy(&appconfig.Config{}).Enabled() {
+		t.Error("expected empty policy to be disabled")
+	}
+}
+
+func TestRetentionPolicy_Stamp(t *testing.T) {
+	p := RetentionPolicy{Default: time.Hour, Rooms: map[string]time.Duration{"archive": 0}}
+	ts := time.Date(2026, 6, 16, 9, 0, 0, 500, time.UTC)
+
+	msg := testMessage("m1", "lobby", "u1", ts)
+	p.Stamp(msg)
+	if msg.ExpiresAt == nil || !msg.ExpiresAt.Equal(ts.Add(time.Hour).Truncate(time.Second)) {
+		t.Errorf("unexpected ExpiresAt %v", msg.ExpiresAt)
+	}
+
+	kept := testMessage("m2", "archive", "u1", ts)
+	forged := ts.Add(time.Minute)
+	kept.ExpiresAt = &forged
+	p.Stamp(kept)
+	if kept.ExpiresAt != nil {
+		t.Errorf("expected no ExpiresAt for a room kept forever, got %v", kept.ExpiresAt)
+	}
+}
+
+// sweepable is a repository with an in-process retention sweeper.
+type sweepable interface {
+	MessageRepository
+	SetRetention(policy RetentionPolicy, interval time.Duration)
+	sweep(now time.Time) (int, error)
+}
+
+// testRetentionSweep checks that a backend stamps expiry at write time and that
+// a sweep removes exactly the expired messages from every index.
+func testRetentionSweep(t *testing.T, repo sweepable) {
+	ctx := context.Background()
+	// A long interval keeps the background sweeper out of the way.
+	repo.SetRetention(RetentionPolicy{
+		Default: time.Hour,
+		Rooms:   map[string]time.Duration{"archive": 0},
+	}, time.Hour)
+
+	repo.SaveMessage(ctx, testMessage("old", "lobby", "u1", seedBase))
+	repo.SaveMessage(ctx, testMessage("new", "lobby", "u1", seedBase.Add(2*time.Hour)))
+	repo.BatchSaveMessages(ctx, []*message.Message{
+		testMessage("kept", "archive", "u1", seedBase),
+	})
+
+	removed, err := repo.sweep(seedBase.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 message swept, got %d", removed)
+	}
+
+	msgs, _ := repo.GetRecentMessages(ctx, "lobby", 10)
+	if got := fmt.Sprint(ids(msgs)); got != "[new]" {
+		t.Errorf("expected only [new] left in lobby, got %s", got)
+	}
+	if msgs[0].ExpiresAt == nil {
+		t.Error("expected stored message to carry ExpiresAt")
+	}
+	byUser, _ := repo.GetMessagesByUser(ctx, "u1", 10)
+	if got := fmt.Sprint(ids(byUser)); got != "[kept new]" {
+		t.Errorf("expected user index [kept new], got %s", got)
+	}
+
+	// Sweeping again at the same time finds nothing more.
+	if removed, _ := repo.sweep(seedBase.Add(90 * time.Minute)); removed != 0 {
+		t.Errorf("expected second sweep to remove nothing, got %d", removed)
+	}
+}
+
+// testRetentionSweepReplies checks that sweeping a reply uncounts it from a
+// parent that is still stored.
+func testRetentionSweepReplies(t *testing.T, repo sweepable) {
+	ctx := context.Background()
+	// Saved before retention is set, the root never expires.
+	repo.SaveMessage(ctx, testMessage("root", "lobby", "u1", seedBase.Add(-time.Hour)))
+	repo.SetRetention(RetentionPolicy{Default: time.Hour}, time.Hour)
+
+	reply := func(id, parent string, ts time.Time) *message.Message {
+		msg := testMessage(id, "lobby", "u1", ts)
+		msg.ParentMessageID = parent
+		return msg
+	}
+	repo.SaveMessage(ctx, reply("old", "root", seedBase))
+	repo.SaveMessage(ctx, reply("new", "root", seedBase.Add(2*time.Hour)))
+	if root, _ := repo.GetMessage(ctx, "lobby", "root"); root == nil || root.ReplyCount != 2 {
+		t.Fatalf("expected root to count 2 replies before the sweep, got %+v", root)
+	}
+
+	if removed, err := repo.sweep(seedBase.Add(90 * time.Minute)); err != nil || removed != 1 {
+		t.Fatalf("sweep = %d, %v; want 1 removed", removed, err)
+	}
+	root, err := repo.GetMessage(ctx, "lobby", "root")
+	if err != nil {
+		t.Fatalf("GetMessage(root): %v", err)
+	}
+	if root.ReplyCount != 1 {
+		t.Errorf("expected root to count 1 reply after the sweep, got %d", root.ReplyCount)
+	}
+}
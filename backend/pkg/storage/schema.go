@@ -4,6 +4,24 @@ const (
 	// TableName is the name of the DynamoDB table for storing chat messages
 	TableName = "chat-messages"
 
+	// RoomsTableName is the name of the DynamoDB table holding the room
+	// directory, keyed by AttrRoomID
+	RoomsTableName = "chat-rooms"
+
+	// IdempotencyTableName is the name of the DynamoDB table recording
+	// claimed client message IDs, keyed by AttrUserID and
+	// AttrClientMessageID and expired by TTL on AttrExpiresAt
+	IdempotencyTableName = "chat-idempotency"
+
+	// MentionsTableName is the name of the DynamoDB table holding each
+	// user's mention inbox, keyed by AttrUserID and AttrMentionKey and
+	// expired by TTL on AttrExpiresAt
+	MentionsTableName = "chat-mentions"
+
+	// ReadMarkersTableName is the name of the DynamoDB table holding each
+	// user's read marker per room, keyed by AttrUserID and AttrRoomID
+	ReadMarkersTableName = "chat-read-markers"
+
 	// Attribute names
 	AttrMessageID = "MessageID"
 	AttrRoomID    = "RoomID"
@@ -12,11 +30,31 @@ const (
 	AttrUsername  = "Username"
 	AttrContent   = "Content"
 	AttrTimestamp = "Timestamp"
+	AttrEditedAt  = "EditedAt"
+	AttrRevisions = "Revisions"
+	AttrReactions = "Reactions"
+	AttrDeleted   = "Deleted"
+	AttrDeletedAt = "DeletedAt"
+	AttrDeletedBy = "DeletedBy"
+	AttrExpiresAt = "ExpiresAt"
+
+	AttrClientMessageID = "ClientMessageID"
+	AttrParentMessageID = "ParentMessageID"
+	AttrReplyCount      = "ReplyCount"
+	AttrMentionKey      = "MentionKey"
+	AttrReadAt          = "ReadAt"
+	AttrReadPosition    = "ReadPosition"
+	AttrPins            = "Pins"
+	AttrAttachments     = "Attachments"
 
 	// Index names
 	IndexUserTimestamp = "UserID-Timestamp-index"
 	IndexRoomTimestamp = "RoomID-Timestamp-index"
 
+	// IndexParentTimestamp holds only replies, since messages without a
+	// ParentMessageID are absent from it
+	IndexParentTimestamp = "ParentMessageID-Timestamp-index"
+
 	// Default room ID (for single-room chat)
 	DefaultRoomID = "global"
 )
@@ -34,6 +72,8 @@ type TableSchema struct {
 	GSI2PartitionKey string
 	GSI2SortKey      string
 	GSI2Name         string
+	// TTL attribute (epoch seconds) DynamoDB expires items by
+	TTLAttribute string
 }
 
 // GetTableSchema returns the schema configuration for the messages table
@@ -48,5 +88,6 @@ func GetTableSchema() TableSchema {
 		GSI2PartitionKey: AttrRoomID,
 		GSI2SortKey:      AttrTimestamp,
 		GSI2Name:         IndexRoomTimestamp,
+		TTLAttribute:     AttrExpiresAt,
 	}
 }
@@ -1,6 +1,6 @@
 #!/bin/bash
 # Initialize DynamoDB tables
-# This script waits for DynamoDB to be ready and then creates the required tables
+# This script waits for DynamoDB to be ready and then applies the schema migrations
 
 set -e
 
@@ -23,16 +23,17 @@ echo "Step 1: Waiting for DynamoDB..."
 "$SCRIPT_DIR/wait-for-dynamodb.sh" "$DYNAMODB_HOST" "$DYNAMODB_PORT"
 echo ""
 
-# Set environment variables for Go script
+# Set environment variables for the migrate subcommand
 export DYNAMODB_ENDPOINT="$DYNAMODB_ENDPOINT"
 export DYNAMODB_REGION="${DYNAMODB_REGION:-us-east-1}"
 export AWS_ACCESS_KEY_ID="${AWS_ACCESS_KEY_ID:-dummy}"
 export AWS_SECRET_ACCESS_KEY="${AWS_SECRET_ACCESS_KEY:-dummy}"
 
-# Run the Go initialization script
-echo "Step 2: Creating tables..."
+# Apply pending schema migrations
+echo "Step 2: Applying schema migrations..."
 cd "$PROJECT_ROOT/backend"
-go run ./scripts/init-dynamodb.go
+go run ./cmd/server migrate up
+go run ./cmd/server migrate status
 
 echo ""
 echo "==================================="